@@ -0,0 +1,266 @@
+// Package client is a Go client for the KMFDDM v1 API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jessepeterson/kmfddm/http/api"
+)
+
+// DefaultUsername is the HTTP Basic username of the KMFDDM API.
+const DefaultUsername = "kmfddm"
+
+// Doer executes an HTTP request.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Error is an error response from the API.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"error"`
+
+	// Sets are the sets that prevented deleting a declaration.
+	Sets []string `json:"sets,omitempty"`
+
+	// FailedIDs are the enrollment IDs that failed to be notified.
+	FailedIDs []string `json:"failed_ids,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("HTTP status: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("HTTP status: %d: %s", e.StatusCode, e.Message)
+}
+
+// StatusCode returns the HTTP status code of an [*Error] in err.
+// Zero is returned if err does not contain an [*Error].
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// Client is a KMFDDM v1 API client.
+type Client struct {
+	client   Doer
+	base     *url.URL
+	username string
+	apiKey   string
+}
+
+// Option configures a client.
+type Option func(*Client)
+
+// WithClient sets the HTTP client used to send requests.
+func WithClient(client Doer) Option {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// WithUsername sets the HTTP Basic username.
+// The default is [DefaultUsername].
+func WithUsername(username string) Option {
+	return func(c *Client) {
+		c.username = username
+	}
+}
+
+// New creates a new client for the KMFDDM server at baseURL
+// (for example "https://kmfddm.example.com") that authenticates with apiKey.
+func New(baseURL, apiKey string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing URL: %w", err)
+	}
+	c := &Client{
+		client:   http.DefaultClient,
+		base:     base,
+		username: DefaultUsername,
+		apiKey:   apiKey,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// request holds the parameters of an API request.
+type request struct {
+	query  url.Values
+	header http.Header
+
+	etag       *string
+	respHeader *http.Header
+	dryRun     *api.DryRun
+}
+
+// RequestOption configures an API request.
+type RequestOption func(*request)
+
+// NoNotify disables notifying enrollments of a change.
+func NoNotify() RequestOption {
+	return func(r *request) {
+		r.query.Set("nonotify", "1")
+	}
+}
+
+// Cascade removes a declaration from any sets when deleting it.
+func Cascade() RequestOption {
+	return func(r *request) {
+		r.query.Set("cascade", "1")
+	}
+}
+
+// Confirm confirms syncing an empty desired state which removes every declaration.
+func Confirm() RequestOption {
+	return func(r *request) {
+		r.query.Set("confirm", "1")
+	}
+}
+
+// IfMatch only changes a resource if its current ETag is etag.
+// The etag "*" matches any existing resource.
+func IfMatch(etag string) RequestOption {
+	return func(r *request) {
+		r.header.Set("If-Match", etag)
+	}
+}
+
+// IfNoneMatch only changes a resource if its current ETag is not etag.
+// The etag "*" only changes a resource that does not exist.
+func IfNoneMatch(etag string) RequestOption {
+	return func(r *request) {
+		r.header.Set("If-None-Match", etag)
+	}
+}
+
+// ETag stores the ETag header of the response in etag.
+func ETag(etag *string) RequestOption {
+	return func(r *request) {
+		r.etag = etag
+	}
+}
+
+// DryRun only simulates a change and stores the result in dryRun.
+// No change is made and false is returned for the change.
+func DryRun(dryRun *api.DryRun) RequestOption {
+	return func(r *request) {
+		r.query.Set("dryrun", "1")
+		r.dryRun = dryRun
+	}
+}
+
+// do sends a request to path (unescaped) with body encoded as JSON (if not nil).
+// The request succeeds if the response status is one of ok.
+// If the response has a body and out is not nil it is decoded into out.
+// The response status is returned.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}, ok []int, opts ...RequestOption) (int, error) {
+	r := &request{query: query, header: make(http.Header)}
+	if r.query == nil {
+		r.query = make(url.Values)
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.dryRun != nil {
+		out = r.dryRun
+		ok = append([]int{http.StatusOK}, ok...)
+	}
+
+	var bodyReader io.Reader
+	if raw, isRaw := body.([]byte); isRaw {
+		bodyReader = bytes.NewReader(raw)
+	} else if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("encoding request body: %w", err)
+		}
+		bodyReader = bytes.NewReader(bodyBytes)
+	}
+
+	u := *c.base
+	u.Path += path
+	u.RawQuery = r.query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bodyReader)
+	if err != nil {
+		return 0, fmt.Errorf("creating request: %w", err)
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if bodyReader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.SetBasicAuth(c.username, c.apiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if r.etag != nil {
+		*r.etag = resp.Header.Get("ETag")
+	}
+	if r.respHeader != nil {
+		*r.respHeader = resp.Header
+	}
+
+	success := false
+	for _, status := range ok {
+		success = success || resp.StatusCode == status
+	}
+	if !success {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if bodyBytes, _ := io.ReadAll(resp.Body); len(bodyBytes) > 0 {
+			if json.Unmarshal(bodyBytes, apiErr) != nil {
+				apiErr.Message = strings.TrimSpace(string(bodyBytes))
+			}
+			// some endpoints return their regular response with errors
+			if out != nil {
+				_ = json.Unmarshal(bodyBytes, out)
+			}
+		}
+		return resp.StatusCode, apiErr
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified {
+		if raw, isRaw := out.(*[]byte); isRaw {
+			*raw, err = io.ReadAll(resp.Body)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(out)
+		}
+		if err != nil {
+			return resp.StatusCode, fmt.Errorf("decoding response body: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+var (
+	okJSON   = []int{http.StatusOK}
+	okGet    = []int{http.StatusOK, http.StatusNotModified}
+	okChange = []int{http.StatusNoContent, http.StatusNotModified}
+)
+
+// change sends a request that changes a resource and reports whether it changed.
+func (c *Client) change(ctx context.Context, method, path string, query url.Values, body interface{}, opts ...RequestOption) (bool, error) {
+	status, err := c.do(ctx, method, path, query, body, nil, okChange, opts...)
+	return status == http.StatusNoContent, err
+}
+
+// joinIDs joins IDs for use in a path.
+func joinIDs(ids []string) string {
+	return strings.Join(ids, ",")
+}
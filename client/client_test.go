@@ -0,0 +1,438 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jessepeterson/kmfddm/http/api"
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/jessepeterson/kmfddm/storage/inmem"
+	"github.com/jessepeterson/kmfddm/storage/kv"
+
+	"github.com/alexedwards/flow"
+	nanohttp "github.com/micromdm/nanolib/http"
+	"github.com/micromdm/nanolib/log"
+)
+
+const testAPIKey = "secret"
+
+type testNotifier struct {
+	declarations, sets, ids []string
+	err                     error
+}
+
+func (n *testNotifier) Changed(_ context.Context, declarations []string, sets []string, ids []string) error {
+	n.declarations = append(n.declarations, declarations...)
+	n.sets = append(n.sets, sets...)
+	n.ids = append(n.ids, ids...)
+	return n.err
+}
+
+func (n *testNotifier) clear() {
+	n.declarations, n.sets, n.ids = nil, nil, nil
+}
+
+type failedIDsError []string
+
+func (e failedIDsError) Error() string       { return "notification failed" }
+func (e failedIDsError) FailedIDs() []string { return e }
+
+func newTestClient(t *testing.T) (*Client, *testNotifier) {
+	t.Helper()
+	store := inmem.New(func() hash.Hash { return fnv.New128() }, kv.WithStatusHistory(0))
+	n := new(testNotifier)
+	mux := flow.New()
+	api.HandleAPIv1("/v1", mux, log.NopLogger, store, n)
+	srv := httptest.NewServer(nanohttp.NewSimpleBasicAuthHandler(mux, DefaultUsername, testAPIKey, "kmfddm"))
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL+"/", testAPIKey, WithClient(srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, n
+}
+
+func expectStrings(t *testing.T, name string, have, want []string) {
+	t.Helper()
+	have = append([]string(nil), have...)
+	sort.Strings(have)
+	if len(have) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("%s: have: %v, want: %v", name, have, want)
+	}
+}
+
+func expectStatus(t *testing.T, err error, want int) {
+	t.Helper()
+	if have := StatusCode(err); have != want {
+		t.Errorf("status code: have: %d, want: %d (err: %v)", have, want, err)
+	}
+}
+
+const (
+	testDecl1 = `{"Type":"com.apple.configuration.management.test","Identifier":"test_client_1","Payload":{"Echo":"one"}}`
+	testDecl2 = `{"Type":"com.apple.configuration.management.test","Identifier":"test_client_2","Payload":{"Echo":"two"}}`
+)
+
+func TestAuth(t *testing.T) {
+	c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	bad, err := New(c.base.String(), "wrong", WithClient(c.client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = bad.GetDeclarations(ctx)
+	expectStatus(t, err, http.StatusUnauthorized)
+
+	if _, err = c.GetDeclarations(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeclarations(t *testing.T) {
+	c, n := newTestClient(t)
+	ctx := context.Background()
+
+	changed, err := c.PutDeclaration(ctx, []byte(testDecl1))
+	if err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Error("expected changed")
+	}
+
+	changed, err = c.PutDeclaration(ctx, []byte(testDecl1))
+	if err != nil {
+		t.Fatal(err)
+	} else if changed {
+		t.Error("expected not changed")
+	}
+
+	_, err = c.PutDeclaration(ctx, []byte(`{"Type":"invalid"}`))
+	expectStatus(t, err, http.StatusBadRequest)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Message == "" {
+		t.Errorf("expected error message: %v", err)
+	}
+
+	ids, err := c.GetDeclarations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "declarations", ids, []string{"test_client_1"})
+
+	var etag string
+	d, err := c.GetDeclaration(ctx, "test_client_1", ETag(&etag))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := d.Identifier, "test_client_1"; have != want {
+		t.Errorf("identifier: have: %q, want: %q", have, want)
+	}
+	if have, want := etag, `"`+d.ServerToken+`"`; have != want {
+		t.Errorf("etag: have: %q, want: %q", have, want)
+	}
+
+	d, err = c.GetDeclaration(ctx, "test_client_1", IfNoneMatch(etag))
+	if err != nil {
+		t.Fatal(err)
+	} else if d != nil {
+		t.Error("expected nil declaration for matching If-None-Match")
+	}
+
+	_, err = c.GetDeclaration(ctx, "test_client_missing")
+	expectStatus(t, err, http.StatusNotFound)
+
+	_, err = c.PutDeclaration(ctx, []byte(testDecl1), IfMatch(`"stale"`))
+	expectStatus(t, err, http.StatusPreconditionFailed)
+
+	n.clear()
+	if err = c.TouchDeclaration(ctx, "test_client_1", NoNotify()); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.declarations) > 0 {
+		t.Errorf("expected no notification: %v", n.declarations)
+	}
+	if err = c.TouchDeclaration(ctx, "test_client_1"); err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "notified declarations", n.declarations, []string{"test_client_1"})
+
+	compliance, err := c.GetDeclarationCompliance(ctx, "test_client_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := compliance.Identifier, "test_client_1"; have != want {
+		t.Errorf("compliance identifier: have: %q, want: %q", have, want)
+	}
+
+	changed, err = c.DeleteDeclaration(ctx, "test_client_1")
+	if err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Error("expected changed")
+	}
+
+	changed, err = c.DeleteDeclaration(ctx, "test_client_1")
+	if err != nil {
+		t.Fatal(err)
+	} else if changed {
+		t.Error("expected not changed")
+	}
+}
+
+func TestSets(t *testing.T) {
+	c, n := newTestClient(t)
+	ctx := context.Background()
+
+	if _, err := c.PutDeclaration(ctx, []byte(testDecl1)); err != nil {
+		t.Fatal(err)
+	}
+
+	var dryRun api.DryRun
+	changed, err := c.PutSetDeclaration(ctx, "test_client_set", "test_client_1", DryRun(&dryRun))
+	if err != nil {
+		t.Fatal(err)
+	} else if changed {
+		t.Error("expected dry run to not change")
+	}
+	if !dryRun.Changed {
+		t.Error("expected dry run changed")
+	}
+
+	changed, err = c.PutSetDeclaration(ctx, "test_client_set", "test_client_1")
+	if err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Error("expected changed")
+	}
+	expectStrings(t, "notified sets", n.sets, []string{"test_client_set"})
+
+	sets, err := c.GetSets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "sets", sets, []string{"test_client_set"})
+
+	var etag string
+	ids, err := c.GetSetDeclarations(ctx, "test_client_set", ETag(&etag))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "set declarations", ids, []string{"test_client_1"})
+	if etag == "" {
+		t.Error("expected set etag")
+	}
+
+	sets, err = c.GetDeclarationSets(ctx, "test_client_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "declaration sets", sets, []string{"test_client_set"})
+
+	_, err = c.DeleteDeclaration(ctx, "test_client_1")
+	expectStatus(t, err, http.StatusConflict)
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		expectStrings(t, "in-use sets", apiErr.Sets, []string{"test_client_set"})
+	}
+
+	n.clear()
+	changed, err = c.DeleteSetDeclaration(ctx, "test_client_set", "test_client_1", NoNotify())
+	if err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Error("expected changed")
+	}
+	if len(n.sets) > 0 {
+		t.Errorf("expected no notification: %v", n.sets)
+	}
+}
+
+func TestEnrollmentSets(t *testing.T) {
+	c, n := newTestClient(t)
+	ctx := context.Background()
+
+	changed, err := c.PutEnrollmentSet(ctx, "test_client_enr", "test_client_set")
+	if err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Error("expected changed")
+	}
+	expectStrings(t, "notified ids", n.ids, []string{"test_client_enr"})
+
+	if _, err = c.PutEnrollmentSet(ctx, "test_client_enr", "test_client_set2"); err != nil {
+		t.Fatal(err)
+	}
+
+	sets, err := c.GetEnrollmentSets(ctx, "test_client_enr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "enrollment sets", sets, []string{"test_client_set", "test_client_set2"})
+
+	changed, err = c.DeleteEnrollmentSet(ctx, "test_client_enr", "test_client_set")
+	if err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Error("expected changed")
+	}
+
+	changed, err = c.DeleteAllEnrollmentSets(ctx, "test_client_enr")
+	if err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Error("expected changed")
+	}
+
+	sets, err = c.GetEnrollmentSets(ctx, "test_client_enr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "enrollment sets", sets, nil)
+}
+
+func TestStatus(t *testing.T) {
+	c, _ := newTestClient(t)
+	ctx := context.Background()
+	ids := []string{"test_client_enr1", "test_client_enr2"}
+
+	if _, err := c.GetDeclarationStatus(ctx, ids); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.GetDeclarationStatusHistory(ctx, ids, "test_client_1"); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.GetDeclarationStatusFlapping(ctx, ids, "", 2); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.GetStatusErrors(ctx, ids); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.GetStatusValues(ctx, ids, ".StatusItems.device"); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.GetStatusValueHistory(ctx, ids, ""); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.GetUnhandledStatusPaths(ctx); err != nil {
+		t.Error(err)
+	}
+
+	result, err := c.SearchStatusValues(ctx, storage.StatusValueSearch{Path: ".StatusItems.device.model.family", Value: "iPhone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.EnrollmentIDs) > 0 {
+		t.Errorf("expected no search results: %v", result.EnrollmentIDs)
+	}
+
+	_, err = c.SearchStatusValues(ctx, storage.StatusValueSearch{})
+	expectStatus(t, err, http.StatusBadRequest)
+
+	index := 0
+	_, err = c.GetStatusReport(ctx, storage.StatusReportQuery{EnrollmentID: "test_client_enr1", Index: &index})
+	if StatusCode(err) == 0 {
+		t.Errorf("expected API error for missing status report: %v", err)
+	}
+}
+
+func TestBatchAndState(t *testing.T) {
+	c, n := newTestClient(t)
+	ctx := context.Background()
+
+	resp, err := c.Batch(ctx, &api.BatchRequest{Operations: []api.BatchOperation{
+		{Op: api.BatchPutDeclaration, Declaration: []byte(testDecl1)},
+		{Op: api.BatchPutSetDeclaration, Set: "test_client_set", DeclarationID: "test_client_1"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Applied || !resp.Notified || len(resp.Results) != 2 {
+		t.Errorf("unexpected batch response: %+v", resp)
+	}
+
+	resp, err = c.Batch(ctx, &api.BatchRequest{Operations: []api.BatchOperation{{Op: "invalid"}}})
+	expectStatus(t, err, http.StatusBadRequest)
+	if len(resp.Results) != 1 || resp.Results[0].Error == "" {
+		t.Errorf("expected operation error: %+v", resp)
+	}
+
+	state := &api.State{
+		Declarations: []json.RawMessage{json.RawMessage(testDecl2)},
+		Sets:         map[string][]string{"test_client_set": {"test_client_2"}},
+	}
+	n.clear()
+	stateResp, err := c.PutState(ctx, state, "test_client_", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stateResp.Plan || stateResp.Applied {
+		t.Errorf("expected plan only: %+v", stateResp)
+	}
+	expectStrings(t, "planned added", stateResp.DeclarationsAdded, []string{"test_client_2"})
+	expectStrings(t, "planned removed", stateResp.DeclarationsRemoved, []string{"test_client_1"})
+
+	stateResp, err = c.PutState(ctx, state, "test_client_", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stateResp.Applied {
+		t.Errorf("expected applied: %+v", stateResp)
+	}
+	ids, err := c.GetDeclarations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "declarations", ids, []string{"test_client_2"})
+}
+
+func TestNotify(t *testing.T) {
+	c, n := newTestClient(t)
+	ctx := context.Background()
+
+	if err := c.Notify(ctx, nil, nil, []string{"test_client_enr"}); err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "notified ids", n.ids, []string{"test_client_enr"})
+
+	n.err = failedIDsError{"test_client_enr"}
+	err := c.Notify(ctx, nil, nil, []string{"test_client_enr"})
+	expectStatus(t, err, http.StatusInternalServerError)
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		expectStrings(t, "failed ids", apiErr.FailedIDs, []string{"test_client_enr"})
+	}
+
+	jobs, err := c.GetNotificationJobs(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(jobs) > 0 {
+		t.Errorf("expected no jobs: %v", jobs)
+	}
+	if _, err = c.GetNotificationJobs(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.GetNotificationJob(ctx, "test_client_job")
+	expectStatus(t, err, http.StatusNotFound)
+	err = c.ReplayNotificationJob(ctx, "test_client_job")
+	expectStatus(t, err, http.StatusNotFound)
+	if err = c.DeleteNotificationJob(ctx, "test_client_job"); err != nil && StatusCode(err) != http.StatusNotFound {
+		t.Error(err)
+	}
+
+	if _, err = c.GetUnsynced(ctx, []string{"test_client_enr"}, 0, 10); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+// apiPrefix is the path prefix of the v1 API.
+const apiPrefix = "/v1"
+
+// GetDeclarations retrieves the identifiers of all declarations.
+func (c *Client) GetDeclarations(ctx context.Context) ([]string, error) {
+	var ids []string
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/declarations", nil, nil, &ids, okJSON)
+	return ids, err
+}
+
+// PutDeclaration stores the raw JSON declaration and reports whether it changed.
+func (c *Client) PutDeclaration(ctx context.Context, declaration []byte, opts ...RequestOption) (bool, error) {
+	return c.change(ctx, http.MethodPut, apiPrefix+"/declarations", nil, declaration, opts...)
+}
+
+// GetDeclaration retrieves the declaration declarationID.
+// A nil declaration is returned if an [IfNoneMatch] option matches.
+func (c *Client) GetDeclaration(ctx context.Context, declarationID string, opts ...RequestOption) (*ddm.Declaration, error) {
+	var raw []byte
+	status, err := c.do(ctx, http.MethodGet, apiPrefix+"/declarations/"+declarationID, nil, nil, &raw, okGet, opts...)
+	if err != nil || status == http.StatusNotModified {
+		return nil, err
+	}
+	return ddm.ParseDeclaration(raw)
+}
+
+// DeleteDeclaration deletes the declaration declarationID and reports whether it changed.
+// Use the [Cascade] option to also remove it from any sets.
+// If the declaration is in sets then [Error] contains those sets.
+func (c *Client) DeleteDeclaration(ctx context.Context, declarationID string, opts ...RequestOption) (bool, error) {
+	return c.change(ctx, http.MethodDelete, apiPrefix+"/declarations/"+declarationID, nil, nil, opts...)
+}
+
+// GetDeclarationCompliance retrieves the aggregated status of declarationID.
+func (c *Client) GetDeclarationCompliance(ctx context.Context, declarationID string) (*storage.DeclarationCompliance, error) {
+	compliance := new(storage.DeclarationCompliance)
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/declarations/"+declarationID+"/compliance", nil, nil, compliance, okJSON)
+	if err != nil {
+		return nil, err
+	}
+	return compliance, nil
+}
+
+// TouchDeclaration changes the server token of declarationID.
+func (c *Client) TouchDeclaration(ctx context.Context, declarationID string, opts ...RequestOption) error {
+	_, err := c.do(ctx, http.MethodPost, apiPrefix+"/declarations/"+declarationID+"/touch", nil, nil, nil, []int{http.StatusNoContent}, opts...)
+	return err
+}
@@ -0,0 +1,31 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// GetEnrollmentSets retrieves the sets of enrollmentID.
+func (c *Client) GetEnrollmentSets(ctx context.Context, enrollmentID string) ([]string, error) {
+	var sets []string
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/enrollment-sets/"+enrollmentID, nil, nil, &sets, okJSON)
+	return sets, err
+}
+
+// PutEnrollmentSet associates setName with enrollmentID and reports whether it changed.
+func (c *Client) PutEnrollmentSet(ctx context.Context, enrollmentID, setName string, opts ...RequestOption) (bool, error) {
+	q := url.Values{"set": {setName}}
+	return c.change(ctx, http.MethodPut, apiPrefix+"/enrollment-sets/"+enrollmentID, q, nil, opts...)
+}
+
+// DeleteEnrollmentSet dissociates setName from enrollmentID and reports whether it changed.
+func (c *Client) DeleteEnrollmentSet(ctx context.Context, enrollmentID, setName string, opts ...RequestOption) (bool, error) {
+	q := url.Values{"set": {setName}}
+	return c.change(ctx, http.MethodDelete, apiPrefix+"/enrollment-sets/"+enrollmentID, q, nil, opts...)
+}
+
+// DeleteAllEnrollmentSets dissociates all sets from enrollmentID and reports whether it changed.
+func (c *Client) DeleteAllEnrollmentSets(ctx context.Context, enrollmentID string, opts ...RequestOption) (bool, error) {
+	return c.change(ctx, http.MethodDelete, apiPrefix+"/enrollment-sets-all/sets/"+enrollmentID, nil, nil, opts...)
+}
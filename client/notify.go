@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/http/api"
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+// Batch applies the operations of req.
+// If any operation fails the returned response describes the results of
+// each operation along with an [*Error].
+func (c *Client) Batch(ctx context.Context, req *api.BatchRequest, opts ...RequestOption) (*api.BatchResponse, error) {
+	resp := new(api.BatchResponse)
+	_, err := c.do(ctx, http.MethodPost, apiPrefix+"/batch", nil, req, resp, okJSON, opts...)
+	return resp, err
+}
+
+// PutState syncs the stored declarations and set declarations to state.
+// If prefix is not empty only sets with that prefix are synced.
+// If plan is true the changes are only returned and not applied.
+// If applying fails the returned response describes the changes along with an [*Error].
+func (c *Client) PutState(ctx context.Context, state *api.State, prefix string, plan bool, opts ...RequestOption) (*api.StateResponse, error) {
+	q := make(url.Values)
+	if prefix != "" {
+		q.Set("prefix", prefix)
+	}
+	if plan {
+		q.Set("plan", "1")
+	}
+	resp := new(api.StateResponse)
+	_, err := c.do(ctx, http.MethodPut, apiPrefix+"/state", q, state, resp, okJSON, opts...)
+	return resp, err
+}
+
+// Notify notifies the enrollments of declarations, sets, and enrollmentIDs
+// even if their declarations token has not changed.
+// If only some enrollments fail to be notified their IDs are in the [*Error].
+func (c *Client) Notify(ctx context.Context, declarations, sets, enrollmentIDs []string, opts ...RequestOption) error {
+	q := url.Values{"declaration": declarations, "set": sets, "id": enrollmentIDs}
+	_, err := c.do(ctx, http.MethodPost, apiPrefix+"/notify", q, nil, nil, []int{http.StatusNoContent}, opts...)
+	return err
+}
+
+// GetNotificationJobs retrieves the pending (or failed) queued notification jobs.
+func (c *Client) GetNotificationJobs(ctx context.Context, failed bool) ([]storage.NotificationJob, error) {
+	q := make(url.Values)
+	if failed {
+		q.Set("failed", "1")
+	}
+	var jobs []storage.NotificationJob
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/notifications", q, nil, &jobs, okJSON)
+	return jobs, err
+}
+
+// GetNotificationJob retrieves the queued notification job jobID.
+func (c *Client) GetNotificationJob(ctx context.Context, jobID string) (*storage.NotificationJob, error) {
+	job := new(storage.NotificationJob)
+	if _, err := c.do(ctx, http.MethodGet, apiPrefix+"/notifications/"+jobID, nil, nil, job, okJSON); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// DeleteNotificationJob deletes the queued notification job jobID.
+func (c *Client) DeleteNotificationJob(ctx context.Context, jobID string) error {
+	_, err := c.do(ctx, http.MethodDelete, apiPrefix+"/notifications/"+jobID, nil, nil, nil, []int{http.StatusNoContent})
+	return err
+}
+
+// ReplayNotificationJob attempts the queued notification job jobID again as soon as possible.
+func (c *Client) ReplayNotificationJob(ctx context.Context, jobID string) error {
+	_, err := c.do(ctx, http.MethodPost, apiPrefix+"/notifications/"+jobID+"/replay", nil, nil, nil, []int{http.StatusNoContent})
+	return err
+}
+
+// GetUnsynced retrieves the notified enrollments that have not yet synced.
+// Results are limited to enrollmentIDs (if any) notified longer than
+// olderThan ago (if not zero) and limit results (if not zero).
+func (c *Client) GetUnsynced(ctx context.Context, enrollmentIDs []string, olderThan time.Duration, limit int) ([]api.UnsyncedNotification, error) {
+	q := url.Values{"id": enrollmentIDs}
+	if olderThan > 0 {
+		q.Set("older_than", olderThan.String())
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var unsynced []api.UnsyncedNotification
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/unsynced", q, nil, &unsynced, okJSON)
+	return unsynced, err
+}
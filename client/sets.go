@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// GetSets retrieves the names of all sets.
+func (c *Client) GetSets(ctx context.Context) ([]string, error) {
+	var sets []string
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/sets", nil, nil, &sets, okJSON)
+	return sets, err
+}
+
+// GetSetDeclarations retrieves the declaration identifiers of setName.
+// A nil slice is returned if an [IfNoneMatch] option matches.
+func (c *Client) GetSetDeclarations(ctx context.Context, setName string, opts ...RequestOption) ([]string, error) {
+	var ids []string
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/set-declarations/"+setName, nil, nil, &ids, okGet, opts...)
+	return ids, err
+}
+
+// PutSetDeclaration associates declarationID with setName and reports whether it changed.
+func (c *Client) PutSetDeclaration(ctx context.Context, setName, declarationID string, opts ...RequestOption) (bool, error) {
+	q := url.Values{"declaration": {declarationID}}
+	return c.change(ctx, http.MethodPut, apiPrefix+"/set-declarations/"+setName, q, nil, opts...)
+}
+
+// DeleteSetDeclaration dissociates declarationID from setName and reports whether it changed.
+func (c *Client) DeleteSetDeclaration(ctx context.Context, setName, declarationID string, opts ...RequestOption) (bool, error) {
+	q := url.Values{"declaration": {declarationID}}
+	return c.change(ctx, http.MethodDelete, apiPrefix+"/set-declarations/"+setName, q, nil, opts...)
+}
+
+// GetDeclarationSets retrieves the sets that declarationID is in.
+func (c *Client) GetDeclarationSets(ctx context.Context, declarationID string) ([]string, error) {
+	var sets []string
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/declaration-sets/"+declarationID, nil, nil, &sets, okJSON)
+	return sets, err
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+// GetDeclarationStatus retrieves the declaration status of enrollmentIDs.
+func (c *Client) GetDeclarationStatus(ctx context.Context, enrollmentIDs []string) (map[string][]ddm.DeclarationQueryStatus, error) {
+	var status map[string][]ddm.DeclarationQueryStatus
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/declaration-status/"+joinIDs(enrollmentIDs), nil, nil, &status, okJSON)
+	return status, err
+}
+
+// GetDeclarationStatusHistory retrieves the declaration status history of enrollmentIDs.
+// If declarationID is not empty only its history is retrieved.
+func (c *Client) GetDeclarationStatusHistory(ctx context.Context, enrollmentIDs []string, declarationID string) (map[string][]storage.DeclarationStatusEvent, error) {
+	q := make(url.Values)
+	if declarationID != "" {
+		q.Set("declaration", declarationID)
+	}
+	var history map[string][]storage.DeclarationStatusEvent
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/declaration-status-history/"+joinIDs(enrollmentIDs), q, nil, &history, okJSON)
+	return history, err
+}
+
+// GetDeclarationStatusFlapping summarizes how often the declarations of
+// enrollmentIDs changed validity. If declarationID is not empty only it
+// is summarized. If threshold is zero the server default is used.
+func (c *Client) GetDeclarationStatusFlapping(ctx context.Context, enrollmentIDs []string, declarationID string, threshold int) (map[string][]storage.DeclarationStatusFlaps, error) {
+	q := make(url.Values)
+	if declarationID != "" {
+		q.Set("declaration", declarationID)
+	}
+	if threshold > 0 {
+		q.Set("threshold", strconv.Itoa(threshold))
+	}
+	var flaps map[string][]storage.DeclarationStatusFlaps
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/declaration-status-flapping/"+joinIDs(enrollmentIDs), q, nil, &flaps, okJSON)
+	return flaps, err
+}
+
+// GetStatusErrors retrieves the status errors of enrollmentIDs.
+func (c *Client) GetStatusErrors(ctx context.Context, enrollmentIDs []string) (map[string][]storage.StatusError, error) {
+	var errs map[string][]storage.StatusError
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/status-errors/"+joinIDs(enrollmentIDs), nil, nil, &errs, okJSON)
+	return errs, err
+}
+
+// GetStatusValues retrieves the status values of enrollmentIDs.
+// If pathPrefix is not empty only values with paths starting with it are retrieved.
+func (c *Client) GetStatusValues(ctx context.Context, enrollmentIDs []string, pathPrefix string) (map[string][]storage.StatusValue, error) {
+	q := make(url.Values)
+	if pathPrefix != "" {
+		q.Set("prefix", pathPrefix)
+	}
+	var values map[string][]storage.StatusValue
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/status-values/"+joinIDs(enrollmentIDs), q, nil, &values, okJSON)
+	return values, err
+}
+
+// SearchStatusValues finds enrollments by their reported status values.
+// Zero values of search use the server defaults.
+func (c *Client) SearchStatusValues(ctx context.Context, search storage.StatusValueSearch) (*storage.StatusValueSearchResult, error) {
+	q := url.Values{"path": {search.Path}}
+	if search.Operator != "" {
+		q.Set("op", search.Operator)
+	}
+	if search.Value != "" {
+		q.Set("value", search.Value)
+	}
+	if search.After != "" {
+		q.Set("after", search.After)
+	}
+	if search.Limit > 0 {
+		q.Set("limit", strconv.Itoa(search.Limit))
+	}
+	result := new(storage.StatusValueSearchResult)
+	if _, err := c.do(ctx, http.MethodGet, apiPrefix+"/status-search", q, nil, result, okJSON); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetStatusValueHistory retrieves the status value changes of enrollmentIDs.
+// If path is not empty only changes of that path are retrieved.
+func (c *Client) GetStatusValueHistory(ctx context.Context, enrollmentIDs []string, path string) (map[string][]storage.StatusValueChange, error) {
+	q := make(url.Values)
+	if path != "" {
+		q.Set("path", path)
+	}
+	var history map[string][]storage.StatusValueChange
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/status-history/"+joinIDs(enrollmentIDs), q, nil, &history, okJSON)
+	return history, err
+}
+
+// GetUnhandledStatusPaths retrieves the status report paths that were not handled.
+func (c *Client) GetUnhandledStatusPaths(ctx context.Context) ([]storage.UnhandledStatusPath, error) {
+	var paths []storage.UnhandledStatusPath
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/status-unhandled", nil, nil, &paths, okJSON)
+	return paths, err
+}
+
+// GetStatusReport retrieves a raw status report of an enrollment.
+func (c *Client) GetStatusReport(ctx context.Context, q storage.StatusReportQuery) (*storage.StoredStatusReport, error) {
+	query := make(url.Values)
+	if q.Index != nil {
+		query.Set("index", strconv.Itoa(*q.Index))
+	}
+	if q.StatusID != nil {
+		query.Set("status_id", *q.StatusID)
+	}
+	var header http.Header
+	report := new(storage.StoredStatusReport)
+	_, err := c.do(ctx, http.MethodGet, apiPrefix+"/status-report/"+q.EnrollmentID, query, nil, &report.Raw, okJSON, func(r *request) {
+		r.respHeader = &header
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.StatusID = header.Get("X-Status-Report-ID")
+	if v := header.Get("X-Status-Report-Index"); v != "" {
+		if report.Index, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("parsing status report index: %w", err)
+		}
+	}
+	if v := header.Get("Last-Modified"); v != "" {
+		if report.Timestamp, err = time.Parse(http.TimeFormat, v); err != nil {
+			return nil, fmt.Errorf("parsing status report timestamp: %w", err)
+		}
+	}
+	return report, nil
+}
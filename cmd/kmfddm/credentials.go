@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	apihttp "github.com/jessepeterson/kmfddm/http/api"
+)
+
+// defaultCredentialName is the credential name of the API key of the
+// -api flag (or the tenant "api_key").
+const defaultCredentialName = "default"
+
+// credentialsConfig is the API credentials configuration file.
+type credentialsConfig struct {
+	Credentials []*apihttp.Credential `json:"credentials"`
+}
+
+// loadCredentialsConfig reads the API credentials JSON from filename.
+func loadCredentialsConfig(filename string) ([]*apihttp.Credential, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg := new(credentialsConfig)
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("decoding credentials config: %w", err)
+	}
+	if len(cfg.Credentials) < 1 {
+		return nil, errors.New("no credentials configured")
+	}
+	return cfg.Credentials, nil
+}
+
+// credentials returns the API credentials of t. The API key of t is
+// an admin credential named "default".
+func (t *tenantConfig) credentials() []*apihttp.Credential {
+	var creds []*apihttp.Credential
+	if t.APIKey != "" {
+		creds = append(creds, &apihttp.Credential{
+			Name:   defaultCredentialName,
+			APIKey: t.APIKey,
+			Role:   apihttp.RoleAdmin,
+		})
+	}
+	return append(creds, t.Credentials...)
+}
+
+// validateCredentials checks the API credentials of t for unique
+// names and unique API keys (amongst keys).
+func (t *tenantConfig) validateCredentials(keys map[string]struct{}) error {
+	names := make(map[string]struct{})
+	for i, c := range t.credentials() {
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("credential %d: %w", i, err)
+		}
+		if _, ok := names[c.Name]; ok {
+			return fmt.Errorf("credential %s: duplicate name", c.Name)
+		}
+		names[c.Name] = struct{}{}
+		if _, ok := keys[c.APIKey]; ok {
+			return fmt.Errorf("credential %s: duplicate API key", c.Name)
+		}
+		keys[c.APIKey] = struct{}{}
+	}
+	return nil
+}
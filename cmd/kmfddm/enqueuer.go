@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jessepeterson/kmfddm/notifier/templated"
+)
+
+// enqueueConfig configures the templated HTTP enqueuer for MDM servers
+// that do not follow NanoMDM or MicroMDM API conventions.
+type enqueueConfig struct {
+	URL          string            `json:"url"`
+	Method       string            `json:"method"`
+	Headers      map[string]string `json:"headers"`
+	Auth         string            `json:"auth"`
+	Username     string            `json:"username"`
+	Body         string            `json:"body"`
+	BodyTemplate string            `json:"body_template"`
+	ContentType  string            `json:"content_type"`
+	MaxIDs       int               `json:"max_ids"`
+}
+
+// loadEnqueueConfig reads the enqueue configuration JSON from filename.
+func loadEnqueueConfig(filename string) (*enqueueConfig, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg := new(enqueueConfig)
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("decoding enqueue config: %w", err)
+	}
+	return cfg, nil
+}
+
+// options returns the templated enqueuer options of c.
+// The enqueue key is used as the HTTP Basic password or bearer token.
+func (c *enqueueConfig) options(key string) ([]templated.Option, error) {
+	if c.URL == "" {
+		return nil, errors.New("empty URL")
+	}
+	var opts []templated.Option
+	if c.Method != "" {
+		opts = append(opts, templated.WithMethod(c.Method))
+	}
+	for k, v := range c.Headers {
+		opts = append(opts, templated.WithHeader(k, v))
+	}
+	switch c.Auth {
+	case "", templated.AuthNone:
+	case templated.AuthBasic:
+		opts = append(opts, templated.WithBasicAuth(c.Username, key))
+	case templated.AuthBearer:
+		opts = append(opts, templated.WithBearerToken(key))
+	default:
+		return nil, fmt.Errorf("invalid auth: %s", c.Auth)
+	}
+	if c.BodyTemplate != "" {
+		if c.Body != "" {
+			return nil, errors.New("body and body template both configured")
+		}
+		opts = append(opts, templated.WithBodyTemplate(c.BodyTemplate, c.ContentType))
+	} else if c.Body != "" {
+		opts = append(opts, templated.WithBody(c.Body))
+	}
+	if c.MaxIDs != 0 {
+		opts = append(opts, templated.WithMaxIDs(c.MaxIDs))
+	}
+	return opts, nil
+}
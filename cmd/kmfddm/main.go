@@ -1,27 +1,41 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	httpddm "github.com/jessepeterson/kmfddm/http"
 	apihttp "github.com/jessepeterson/kmfddm/http/api"
 	ddmhttp "github.com/jessepeterson/kmfddm/http/ddm"
+	tenanthttp "github.com/jessepeterson/kmfddm/http/tenant"
 	"github.com/jessepeterson/kmfddm/logkeys"
 	"github.com/jessepeterson/kmfddm/notifier"
+	"github.com/jessepeterson/kmfddm/notifier/coalesce"
 	"github.com/jessepeterson/kmfddm/notifier/foss"
+	"github.com/jessepeterson/kmfddm/notifier/queue"
+	"github.com/jessepeterson/kmfddm/notifier/renotify"
+	"github.com/jessepeterson/kmfddm/notifier/templated"
 	"github.com/jessepeterson/kmfddm/storage"
 	"github.com/jessepeterson/kmfddm/storage/shard"
+	"github.com/jessepeterson/kmfddm/tenant"
+	"github.com/jessepeterson/kmfddm/webhook"
 
 	"github.com/alexedwards/flow"
 	"github.com/micromdm/nanolib/envflag"
 	nanohttp "github.com/micromdm/nanolib/http"
 	"github.com/micromdm/nanolib/http/trace"
+	"github.com/micromdm/nanolib/log"
 	"github.com/micromdm/nanolib/log/stdlogfmt"
 )
 
@@ -31,6 +45,9 @@ var version = "unknown"
 const (
 	apiUsername = "kmfddm"
 	apiRealm    = "kmfddm"
+
+	// maximum time to wait for in-flight requests and pending notifications when shutting down
+	shutdownTimeout = 30 * time.Second
 )
 
 func main() {
@@ -38,6 +55,7 @@ func main() {
 		flDebug   = flag.Bool("debug", false, "log debug messages")
 		flListen  = flag.String("listen", ":9002", "HTTP listen address")
 		flAPIKey  = flag.String("api", "", "API key for API endpoints")
+		flAPIKeys = flag.String("api-keys", "", "path to API credentials JSON config file")
 		flVersion = flag.Bool("version", false, "print version and exit")
 		flStorage = flag.String("storage", "filekv", "storage backend")
 		flDSN     = flag.String("storage-dsn", "", "storage data source name")
@@ -51,6 +69,35 @@ func main() {
 		flEnqueueKey = flag.String("enqueue-key", "", "MDM server enqueue API key")
 		flCORSOrigin = flag.String("cors-origin", "", "CORS Origin; for browser-based API access")
 		flMicro      = flag.Bool("micromdm", false, "Use MicroMDM command API calling conventions")
+
+		flEnqueueConfig = flag.String("enqueue-config", "", "path to templated MDM server enqueue JSON config file")
+
+		flEnqueueConcurrency = flag.Int("enqueue-concurrency", 4, "maximum concurrent MDM server enqueue requests")
+		flEnqueueTimeout     = flag.Duration("enqueue-timeout", 30*time.Second, "MDM server enqueue request timeout")
+
+		flTenants = flag.String("tenants", "", "path to multi-tenant JSON config file")
+
+		flQueue         = flag.Bool("queue", false, "queue notifications in storage and retry failures")
+		flQueueAttempts = flag.Int("queue-attempts", queue.DefaultMaxAttempts, "notification attempts before marking failed (0 retries forever)")
+
+		flNotifyDelay    = flag.Duration("notify-delay", 0, "accumulate changes for this long before notifying")
+		flNotifySuppress = flag.Bool("notify-suppress", false, "skip notifying enrollments whose declarations token is unchanged")
+
+		flPushURL    = flag.String("push-url", "", "URL of MDM server push endpoint")
+		flNotifyMode = flag.String("notify-mode", notifier.ModeEnqueue, "notification mode: enqueue, push, or hybrid")
+
+		flNotifyTrack   = flag.Bool("notify-track", false, "track whether notified enrollments synced their declarations")
+		flRenotifyAfter = flag.Duration("renotify-after", 0, "notify enrollments again that have not synced for this long (implies -notify-track)")
+		flRenotifyMax   = flag.Int("renotify-max", renotify.DefaultMaxNotifications, "stop renotifying unsynced enrollments after this many notifications (0 for no limit)")
+
+		flWebhooks        = flag.String("webhooks", "", "path to webhook subscriptions JSON config file")
+		flWebhookAttempts = flag.Int("webhook-attempts", webhook.DefaultMaxAttempts, "webhook delivery attempts before giving up")
+
+		flAPIListen   = flag.String("api-listen", "", "separate HTTP listen address for API endpoints")
+		flAPIMaxBody  = flag.Int64("api-max-body", 10<<20, "maximum API request body size in bytes (0 for no limit)")
+		flTLSCert     = flag.String("tls-cert", "", "path to TLS certificate; serves HTTPS")
+		flTLSKey      = flag.String("tls-key", "", "path to TLS private key")
+		flTLSClientCA = flag.String("tls-client-ca", "", "path to CA certificates that API clients must present certificates from")
 	)
 	envflag.Parse("KMFDDM_", []string{"version"})
 
@@ -61,68 +108,136 @@ func main() {
 
 	logger := stdlogfmt.New(stdlogfmt.WithDebugFlag(*flDebug))
 
-	if *flAPIKey == "" {
-		logger.Info(logkeys.Message, "empty API key; API disabled")
-	}
-
-	var store allStorage
+	var tenants *tenantsConfig
 	var err error
-	store, err = setupStorage(*flStorage, *flDSN, *flOptions, logger)
-	if err != nil {
-		logger.Info(logkeys.Message, "init storage", "name", *flStorage, logkeys.Error, err)
-		os.Exit(1)
+	if *flTenants != "" {
+		if *flAPIKey != "" || *flAPIKeys != "" || *flEnqueueURL != "" || *flEnqueueKey != "" || *flMicro || *flPushURL != "" || *flEnqueueConfig != "" {
+			logger.Info(logkeys.Message, "API and enqueue flags are configured per-tenant when using tenants")
+			os.Exit(1)
+		}
+		if tenants, err = loadTenantsConfig(*flTenants); err == nil {
+			err = tenants.fill(*flStorage, *flDSN, *flOptions)
+		}
+		if err != nil {
+			logger.Info(logkeys.Message, "loading tenants", "path", *flTenants, logkeys.Error, err)
+			os.Exit(1)
+		}
+	} else {
+		// a single unnamed tenant is equivalent to the non-tenant configuration
+		tenants = &tenantsConfig{Tenants: []tenantConfig{{
+			APIKey:         *flAPIKey,
+			Enqueue:        *flEnqueueURL,
+			EnqueueKey:     *flEnqueueKey,
+			Push:           *flPushURL,
+			MicroMDM:       *flMicro,
+			Storage:        *flStorage,
+			StorageDSN:     *flDSN,
+			StorageOptions: *flOptions,
+		}}}
+		if *flEnqueueConfig != "" {
+			if tenants.Tenants[0].EnqueueConfig, err = loadEnqueueConfig(*flEnqueueConfig); err != nil {
+				logger.Info(logkeys.Message, "loading enqueue config", "path", *flEnqueueConfig, logkeys.Error, err)
+				os.Exit(1)
+			}
+		}
+		if *flAPIKeys != "" {
+			if tenants.Tenants[0].Credentials, err = loadCredentialsConfig(*flAPIKeys); err == nil {
+				err = tenants.Tenants[0].validateCredentials(make(map[string]struct{}))
+			}
+			if err != nil {
+				logger.Info(logkeys.Message, "loading credentials", "path", *flAPIKeys, logkeys.Error, err)
+				os.Exit(1)
+			}
+		}
 	}
 
-	nOpts := []foss.Option{
-		foss.WithLogger(logger.With("service", "notifier-foss")),
+	if (*flTLSCert == "") != (*flTLSKey == "") {
+		logger.Info(logkeys.Message, "both -tls-cert and -tls-key are required for TLS")
+		os.Exit(1)
 	}
-	if *flMicro {
-		nOpts = append(nOpts, foss.WithMicroMDM())
+	if *flTLSClientCA != "" && *flTLSCert == "" {
+		logger.Info(logkeys.Message, "-tls-client-ca requires -tls-cert and -tls-key")
+		os.Exit(1)
 	}
-	fossNotif, err := foss.NewFossMDM(*flEnqueueURL, *flEnqueueKey, nOpts...)
-	if err != nil {
-		logger.Info(logkeys.Message, "creating notifier", logkeys.Error, err)
+	if *flAPIListen != "" && *flAPIListen == *flListen {
+		logger.Info(logkeys.Message, "-api-listen must differ from -listen")
 		os.Exit(1)
 	}
+	var certs *certReloader
+	if *flTLSCert != "" {
+		if certs, err = newCertReloader(*flTLSCert, *flTLSKey, logger.With("service", "tls")); err != nil {
+			logger.Info(logkeys.Message, "loading TLS certificate", "path", *flTLSCert, logkeys.Error, err)
+			os.Exit(1)
+		}
+	}
+	var clientCAs *x509.CertPool
+	if *flTLSClientCA != "" {
+		if clientCAs, err = loadCertPool(*flTLSClientCA); err != nil {
+			logger.Info(logkeys.Message, "loading TLS client CA", "path", *flTLSClientCA, logkeys.Error, err)
+			os.Exit(1)
+		}
+	}
 
-	var ddmStore storage.EnrollmentDeclarationStorage = store
+	var webhookSubs []webhook.Subscription
+	if *flWebhooks != "" {
+		if webhookSubs, err = loadWebhooksConfig(*flWebhooks); err != nil {
+			logger.Info(logkeys.Message, "loading webhooks", "path", *flWebhooks, logkeys.Error, err)
+			os.Exit(1)
+		}
+	}
 
-	if *flShard {
-		// compose DDM storage out of shard storage and the underlying storage
-		ddmStore = storage.NewJSONAdapt(storage.NewMulti(shard.NewShardStorage(), store), hasher)
+	svcConfig := serviceConfig{
+		shard:              *flShard,
+		enqueueConcurrency: *flEnqueueConcurrency,
+		enqueueTimeout:     *flEnqueueTimeout,
+		notifySuppress:     *flNotifySuppress,
+		notifyMode:         *flNotifyMode,
+		notifyTrack:        *flNotifyTrack || *flRenotifyAfter > 0,
+		webhooks:           webhookSubs,
+		webhookAttempts:    *flWebhookAttempts,
 	}
 
-	nanoNotif, err := notifier.New(fossNotif, store, notifier.WithLogger(logger.With("service", "notifier")))
-	if err != nil {
-		logger.Info(logkeys.Message, "creating notifier", logkeys.Error, err)
-		os.Exit(1)
+	var services []*service
+	for _, t := range tenants.Tenants {
+		var tLogger log.Logger = logger
+		if t.Name != "" {
+			tLogger = logger.With(logkeys.Tenant, t.Name)
+		}
+		if t.APIKey == "" && len(t.Credentials) < 1 {
+			tLogger.Info(logkeys.Message, "no API credentials; API disabled")
+		}
+		svc, err := newService(t, svcConfig, logger)
+		if err != nil {
+			tLogger.Info(logkeys.Message, "creating service", logkeys.Error, err)
+			os.Exit(1)
+		}
+		if svc.hook != nil {
+			svc.sendWebhooks()
+		}
+		if *flQueue {
+			svc.queueNotifications(*flQueueAttempts, logger)
+		}
+		if *flNotifyDelay > 0 {
+			svc.coalesceNotifications(*flNotifyDelay, logger)
+		}
+		if *flRenotifyAfter > 0 {
+			svc.renotifyUnsynced(*flRenotifyAfter, *flRenotifyMax, logger)
+		}
+		services = append(services, svc)
 	}
 
 	mux := flow.New()
 
 	mux.Handle("/version", nanohttp.NewJSONVersionHandler(version))
 
-	mux.Handle(
-		"/declaration-items",
-		ddmhttp.TokensOrDeclarationItemsHandler(ddmStore, false, logger.With(logkeys.Handler, "declaration-items")),
-		"GET",
-	)
-
-	mux.Handle(
-		"/tokens",
-		ddmhttp.TokensOrDeclarationItemsHandler(ddmStore, true, logger.With(logkeys.Handler, "tokens")),
-		"GET",
-	)
-
-	mux.Handle(
-		"/declaration/:type/:id",
-		http.StripPrefix("/declaration/",
-			ddmhttp.DeclarationHandler(ddmStore, logger.With(logkeys.Handler, "declaration")),
-		),
-		"GET",
-	)
+	// the API endpoints are served by mux unless they have their own listener
+	apiMux := mux
+	if *flAPIListen != "" {
+		apiMux = flow.New()
+		apiMux.Handle("/version", nanohttp.NewJSONVersionHandler(version))
+	}
 
-	var statusHandler http.Handler = ddmhttp.StatusReportHandler(store, logger.With(logkeys.Handler, "status"))
+	var dumpOutput io.Writer
 	if *flDumpStatus != "" {
 		f := os.Stdout
 		if *flDumpStatus != "-" {
@@ -133,33 +248,140 @@ func main() {
 			defer f.Close()
 			logger.Debug(logkeys.Message, "dump status", "path", *flDumpStatus)
 		}
-		statusHandler = DumpHandler(statusHandler, f)
+		dumpOutput = f
 	}
-	mux.Handle("/status", statusHandler, "PUT")
 
-	if *flAPIKey != "" {
-		if *flCORSOrigin != "" {
-			// for middleware to work on the OPTIONS method using flow router
-			// we must define a middleware on the "root" mux
-			mux.Use(func(h http.Handler) http.Handler {
-				return httpddm.CORSMiddleware(h, *flCORSOrigin)
+	var apiEnabled bool
+	for _, svc := range services {
+		apiEnabled = apiEnabled || len(svc.creds) > 0
+	}
+
+	if apiEnabled && *flCORSOrigin != "" {
+		// for middleware to work on the OPTIONS method using flow router
+		// we must define a middleware on the "root" mux
+		apiMux.Use(func(h http.Handler) http.Handler {
+			return httpddm.CORSMiddleware(h, *flCORSOrigin)
+		})
+	}
+
+	if *flTenants == "" {
+		svc := services[0]
+		handleDDM(mux, svc, dumpOutput, logger)
+		if len(svc.creds) > 0 {
+			apiMux.Group(func(mux *flow.Mux) {
+				if clientCAs != nil {
+					mux.Use(func(h http.Handler) http.Handler {
+						return clientCertHandler(h)
+					})
+				}
+				mux.Use(func(h http.Handler) http.Handler {
+					return maxBodyHandler(h, *flAPIMaxBody)
+				})
+				mux.Use(func(h http.Handler) http.Handler {
+					return apihttp.CredentialsHandler(h, apiUsername, apiRealm, svc.creds)
+				})
+
+				apihttp.HandleAPIv1("/v1", mux, logger, svc.store, svc.notifier)
+				mux.Handle("/debug/vars", adminHandler(expvar.Handler()), "GET")
 			})
 		}
+	} else {
+		resolver := tenant.NewSetResolver(tenants.Default)
+		ddmHandlers := make(map[string]http.Handler)
+		apiHandlers := make(map[string]http.Handler)
+		apiCreds := make(map[string][]*apihttp.Credential)
+		for _, svc := range services {
+			resolver.Add(svc.name, svc.store)
 
-		mux.Group(func(mux *flow.Mux) {
-			mux.Use(func(h http.Handler) http.Handler {
-				return nanohttp.NewSimpleBasicAuthHandler(h, apiUsername, *flAPIKey, apiRealm)
-			})
+			tMux := flow.New()
+			handleDDM(tMux, svc, dumpOutput, logger)
+			ddmHandlers[svc.name] = tMux
 
-			apihttp.HandleAPIv1("/v1", mux, logger, store, nanoNotif)
-		})
+			if len(svc.creds) > 0 {
+				tMux = flow.New()
+				apihttp.HandleAPIv1("/v1", tMux, logger, svc.store, svc.notifier)
+				apiHandlers[svc.name] = tMux
+				apiCreds[svc.name] = svc.creds
+			}
+		}
+
+		ddmHandler := tenanthttp.EnrollmentHandler(resolver, ddmHandlers, logger.With(logkeys.Handler, "tenant-ddm"))
+		mux.Handle("/declaration-items", ddmHandler, "GET")
+		mux.Handle("/tokens", ddmHandler, "GET")
+		mux.Handle("/declaration/:type/:id", ddmHandler, "GET")
+		mux.Handle("/status", ddmHandler, "PUT")
+
+		if len(apiCreds) > 0 {
+			var apiHandler http.Handler = tenanthttp.CredentialsHandler(apiUsername, apiRealm, apiCreds, apiHandlers)
+			apiHandler = maxBodyHandler(apiHandler, *flAPIMaxBody)
+			if clientCAs != nil {
+				apiHandler = clientCertHandler(apiHandler)
+			}
+			apiMux.Handle("/v1/...", apiHandler, "GET", "PUT", "POST", "DELETE")
+		}
 	}
 
+	// DeclarativeManagement check-ins are dispatched to the DDM endpoints of mux
+	mux.Handle("/checkin", ddmhttp.CheckInHandler(mux, logger.With(logkeys.Handler, "checkin")), "PUT", "POST")
+
 	// init for newTraceID()
 	rand.Seed(time.Now().UnixNano())
 
-	logger.Info(logkeys.Message, "starting server", "listen", *flListen)
-	err = http.ListenAndServe(*flListen, trace.NewTraceLoggingHandler(mux, logger.With(logkeys.Handler, "log"), newTraceID))
+	newServer := func(addr string, h http.Handler, clientCAs *x509.CertPool, requireClient bool) *http.Server {
+		srv := &http.Server{
+			Addr:    addr,
+			Handler: trace.NewTraceLoggingHandler(h, logger.With(logkeys.Handler, "log"), newTraceID),
+		}
+		if certs != nil {
+			srv.TLSConfig = newTLSConfig(certs, clientCAs, requireClient)
+		}
+		return srv
+	}
+	var servers []*http.Server
+	if *flAPIListen != "" {
+		// client certificates are only requested (and required) on the API listener
+		servers = append(servers, newServer(*flListen, mux, nil, false), newServer(*flAPIListen, apiMux, clientCAs, true))
+	} else {
+		servers = append(servers, newServer(*flListen, mux, clientCAs, false))
+	}
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		logger.Info(logkeys.Message, "starting server", "listen", srv.Addr, "tls", srv.TLSConfig != nil)
+		go func(srv *http.Server) {
+			if srv.TLSConfig != nil {
+				errs <- srv.ListenAndServeTLS("", "")
+			} else {
+				errs <- srv.ListenAndServe()
+			}
+		}(srv)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	select {
+	case sig := <-sigs:
+		logger.Info(logkeys.Message, "shutting down server", "signal", sig.String())
+	case err = <-errs:
+		// a server failed (e.g. to listen): shut down the others
+	}
+
+	// wait for in-flight requests to finish
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	for _, srv := range servers {
+		if sErr := srv.Shutdown(ctx); sErr != nil {
+			logger.Info(logkeys.Message, "shutting down server", "listen", srv.Addr, logkeys.Error, sErr)
+		}
+	}
+	cancel()
+
+	// notify any pending changes before exiting
+	ctx, cancel = context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, svc := range services {
+		svc.close(ctx, logger)
+	}
+
 	logs := []interface{}{logkeys.Message, "server shutdown"}
 	if err != nil {
 		logs = append(logs, logkeys.Error, err)
@@ -177,6 +399,304 @@ func newTraceID(_ *http.Request) string {
 	return fmt.Sprintf("%x", b)
 }
 
+// service contains the components for a single tenant.
+type service struct {
+	name     string
+	creds    []*apihttp.Credential
+	store    allStorage
+	ddmStore storage.EnrollmentDeclarationStorage
+	notifier apihttp.Notifier
+
+	// base is the notifier underlying any queueing or coalescing
+	base *notifier.Notifier
+
+	// track marks notified enrollments as synced from their status reports
+	track bool
+
+	// hook sends events to webhook subscriptions
+	hook *webhook.Webhook
+
+	// closers are called in reverse order when shutting down
+	closers []func(context.Context) error
+}
+
+// close calls the closers of svc in reverse order.
+func (svc *service) close(ctx context.Context, logger log.Logger) {
+	if svc.name != "" {
+		logger = logger.With(logkeys.Tenant, svc.name)
+	}
+	for i := len(svc.closers) - 1; i >= 0; i-- {
+		if err := svc.closers[i](ctx); err != nil {
+			logger.Info(logkeys.Message, "closing service", logkeys.Error, err)
+		}
+	}
+}
+
+// notifierStats contains the notifier statistics of each tenant.
+var notifierStats = expvar.NewMap("notifier")
+
+// webhookStats contains the webhook statistics of each tenant.
+var webhookStats = expvar.NewMap("webhook")
+
+// serviceConfig contains the configuration shared by all tenants.
+type serviceConfig struct {
+	shard              bool
+	enqueueConcurrency int
+	enqueueTimeout     time.Duration
+	notifySuppress     bool
+	notifyMode         string
+	notifyTrack        bool
+	webhooks           []webhook.Subscription
+	webhookAttempts    int
+}
+
+// statsName returns the name of the statistics of tenant t.
+func statsName(t tenantConfig) string {
+	if t.Name == "" {
+		return "default"
+	}
+	return t.Name
+}
+
+// newService creates the storage and notifier for tenant t.
+// Note the tenant name is only added to logger for setup. Otherwise
+// request loggers will include the tenant from the request context.
+func newService(t tenantConfig, cfg serviceConfig, logger log.Logger) (*service, error) {
+	setupLogger := logger
+	if t.Name != "" {
+		setupLogger = logger.With(logkeys.Tenant, t.Name)
+	}
+	store, err := setupStorage(t.Storage, t.StorageDSN, t.StorageOptions, setupLogger)
+	if err != nil {
+		return nil, fmt.Errorf("init storage %s: %w", t.Storage, err)
+	}
+
+	nOpts := []foss.Option{
+		foss.WithLogger(logger.With("service", "notifier-foss")),
+		foss.WithConcurrency(cfg.enqueueConcurrency),
+		foss.WithTimeout(cfg.enqueueTimeout),
+	}
+	if t.MicroMDM {
+		nOpts = append(nOpts, foss.WithMicroMDM())
+	}
+	if t.Push != "" {
+		nOpts = append(nOpts, foss.WithPush(t.Push))
+	} else if cfg.notifyMode != notifier.ModeEnqueue {
+		return nil, fmt.Errorf("notification mode %s requires a push URL", cfg.notifyMode)
+	}
+	fossNotif, err := foss.NewFossMDM(t.Enqueue, t.EnqueueKey, nOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating foss notifier: %w", err)
+	}
+
+	var enqueuer notifier.Enqueuer = fossNotif
+	if t.EnqueueConfig != nil {
+		if t.Enqueue != "" || t.MicroMDM {
+			return nil, errors.New("enqueue URL and MicroMDM not allowed with enqueue config")
+		}
+		tOpts, err := t.EnqueueConfig.options(t.EnqueueKey)
+		if err != nil {
+			return nil, fmt.Errorf("enqueue config: %w", err)
+		}
+		tOpts = append(
+			tOpts,
+			templated.WithLogger(logger.With("service", "notifier-templated")),
+			templated.WithConcurrency(cfg.enqueueConcurrency),
+			templated.WithTimeout(cfg.enqueueTimeout),
+		)
+		if enqueuer, err = templated.New(t.EnqueueConfig.URL, tOpts...); err != nil {
+			return nil, fmt.Errorf("creating templated notifier: %w", err)
+		}
+	}
+
+	var ddmStore storage.EnrollmentDeclarationStorage = store
+
+	if cfg.shard {
+		// compose DDM storage out of shard storage and the underlying storage
+		ddmStore = storage.NewJSONAdapt(storage.NewMulti(shard.NewShardStorage(), store), hasher)
+	}
+
+	notifOpts := []notifier.Option{
+		notifier.WithLogger(logger.With("service", "notifier")),
+		notifier.WithConcurrency(cfg.enqueueConcurrency),
+		notifier.WithPush(cfg.notifyMode, fossNotif),
+	}
+	if cfg.notifySuppress {
+		notifOpts = append(notifOpts, notifier.WithUnchangedSuppression())
+	}
+	if cfg.notifyTrack {
+		notifOpts = append(notifOpts, notifier.WithTracking(store))
+	}
+	var hook *webhook.Webhook
+	if len(cfg.webhooks) > 0 {
+		hOpts := []webhook.Option{
+			webhook.WithLogger(logger.With("service", "webhook")),
+			webhook.WithMaxAttempts(cfg.webhookAttempts),
+		}
+		if t.Name != "" {
+			hOpts = append(hOpts, webhook.WithTenant(t.Name))
+		}
+		if hook, err = webhook.New(cfg.webhooks, store, hOpts...); err != nil {
+			return nil, fmt.Errorf("creating webhook: %w", err)
+		}
+		notifOpts = append(notifOpts, notifier.WithSentHook(webhook.NewTrackedNotificationStorer(hook)))
+		webhookStats.Set(statsName(t), expvar.Func(func() interface{} { return map[string]uint64{"dropped": hook.Dropped()} }))
+	}
+	nanoNotif, err := notifier.New(enqueuer, store, notifOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating notifier: %w", err)
+	}
+
+	// publish the notified, suppressed, and pushed counts
+	notifierStats.Set(statsName(t), expvar.Func(func() interface{} { return nanoNotif.Stats() }))
+
+	return &service{
+		name:     t.Name,
+		creds:    t.credentials(),
+		store:    store,
+		ddmStore: ddmStore,
+		notifier: nanoNotif,
+		base:     nanoNotif,
+		track:    cfg.notifyTrack,
+		hook:     hook,
+	}, nil
+}
+
+// queueNotifications places a durable notification queue between the
+// API and the notifier of svc and starts processing it in the background.
+func (svc *service) queueNotifications(maxAttempts int, logger log.Logger) {
+	qLogger := logger.With("service", "notifier-queue")
+	if svc.name != "" {
+		qLogger = qLogger.With(logkeys.Tenant, svc.name)
+	}
+	q := queue.New(
+		svc.store,
+		svc.notifier,
+		queue.WithLogger(qLogger),
+		queue.WithMaxAttempts(maxAttempts),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	go q.Run(ctx)
+	svc.notifier = q
+	svc.closers = append(svc.closers, func(context.Context) error {
+		cancel()
+		return nil
+	})
+}
+
+// coalesceNotifications accumulates changes for delay before notifying
+// them with the notifier of svc. Pending changes are flushed when svc is closed.
+func (svc *service) coalesceNotifications(delay time.Duration, logger log.Logger) {
+	cLogger := logger.With("service", "notifier-coalesce")
+	if svc.name != "" {
+		cLogger = cLogger.With(logkeys.Tenant, svc.name)
+	}
+	c := coalesce.New(svc.notifier, delay, coalesce.WithLogger(cLogger))
+	svc.notifier = c
+	svc.closers = append(svc.closers, c.Flush)
+}
+
+// renotifyUnsynced notifies enrollments again with the notifier of svc
+// that have not synced for after since they were notified.
+func (svc *service) renotifyUnsynced(after time.Duration, max int, logger log.Logger) {
+	rLogger := logger.With("service", "notifier-renotify")
+	if svc.name != "" {
+		rLogger = rLogger.With(logkeys.Tenant, svc.name)
+	}
+	r := renotify.New(
+		svc.store,
+		svc.notifier,
+		after,
+		renotify.WithLogger(rLogger),
+		renotify.WithMaxNotifications(max),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	go r.Run(ctx)
+	svc.closers = append(svc.closers, func(context.Context) error {
+		cancel()
+		return nil
+	})
+}
+
+// sendWebhooks delivers the webhook events of svc in the background.
+// Pending deliveries are drained when svc is closed.
+func (svc *service) sendWebhooks() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go svc.hook.Run(ctx, webhook.DefaultConcurrency)
+	svc.closers = append(svc.closers, func(ctx context.Context) error {
+		defer cancel()
+		return svc.hook.Close(ctx)
+	})
+}
+
+// handleDDM registers the DDM protocol handlers for svc on mux.
+// If dumpOutput is not nil then status reports are dumped to it.
+func handleDDM(mux *flow.Mux, svc *service, dumpOutput io.Writer, logger log.Logger) {
+	mux.Handle(
+		"/declaration-items",
+		svc.syncedHandler(ddmhttp.TokensOrDeclarationItemsHandler(svc.ddmStore, false, logger.With(logkeys.Handler, "declaration-items"))),
+		"GET",
+	)
+
+	mux.Handle(
+		"/tokens",
+		svc.syncedHandler(ddmhttp.TokensOrDeclarationItemsHandler(svc.ddmStore, true, logger.With(logkeys.Handler, "tokens"))),
+		"GET",
+	)
+
+	mux.Handle(
+		"/declaration/:type/:id",
+		http.StripPrefix("/declaration/",
+			ddmhttp.DeclarationHandler(svc.ddmStore, logger.With(logkeys.Handler, "declaration")),
+		),
+		"GET",
+	)
+
+	var statusStore storage.StatusStorer = svc.store
+	if svc.track {
+		statusStore = notifier.NewSyncStatusStorer(svc.store, svc.ddmStore, svc.store, logger.With("service", "notifier-sync"))
+	}
+	if svc.hook != nil {
+		statusStore = webhook.NewStatusStorer(statusStore, svc.store, svc.hook)
+	}
+	var statusHandler http.Handler = ddmhttp.StatusReportHandler(statusStore, logger.With(logkeys.Handler, "status"))
+	if dumpOutput != nil {
+		statusHandler = DumpHandler(statusHandler, dumpOutput)
+	}
+	mux.Handle("/status", statusHandler, "PUT")
+}
+
+// syncedHandler tells the notifier of svc that the enrollment has
+// synchronized (e.g. processed its pending DeclarativeManagement command).
+func (svc *service) syncedHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get(ddmhttp.EnrollmentIDHeader); id != "" {
+			svc.base.Synced(id)
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// maxBodyHandler limits the request bodies of next to n bytes.
+// Zero or less means no limit.
+func maxBodyHandler(next http.Handler, n int64) http.Handler {
+	if n <= 0 {
+		return next
+	}
+	return http.MaxBytesHandler(next, n)
+}
+
+// adminHandler only allows requests with an admin API credential.
+func adminHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c := apihttp.FromContext(r.Context()); c == nil || c.Role != apihttp.RoleAdmin {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
 func DumpHandler(next http.Handler, output io.Writer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		respBytes, _ := httpddm.ReadAllAndReplaceBody(r)
@@ -13,6 +13,7 @@ import (
 	"github.com/jessepeterson/kmfddm/storage/diskv"
 	"github.com/jessepeterson/kmfddm/storage/file"
 	"github.com/jessepeterson/kmfddm/storage/inmem"
+	"github.com/jessepeterson/kmfddm/storage/kv"
 	"github.com/jessepeterson/kmfddm/storage/mysql"
 
 	"github.com/cespare/xxhash"
@@ -20,8 +21,6 @@ import (
 	"github.com/micromdm/nanolib/log"
 )
 
-var ErrOptionsNotSupported = errors.New("storage options not supported")
-
 type allStorage interface {
 	storage.DeclarationAPIStorage
 	storage.EnrollmentIDRetriever
@@ -32,6 +31,8 @@ type allStorage interface {
 	storage.EnrollmentSetStorage
 	storage.StatusAPIStorage
 	storage.EnrollmentDeclarationDataStorage
+	storage.NotificationQueueStorage
+	storage.NotificationTrackingStorage
 }
 
 var hasher func() hash.Hash = func() hash.Hash { return xxhash.New() }
@@ -47,17 +48,19 @@ func setupStorage(name, dsn, options string, logger log.Logger) (allStorage, err
 		if dsn == "" {
 			dsn = "dbkv"
 		}
-		if options != "" {
-			return nil, ErrOptionsNotSupported
+		opts, err := kvOptions(mapOptions, logger)
+		if err != nil {
+			return nil, err
 		}
-		return diskv.New(dsn, hasher), nil
+		return diskv.New(dsn, hasher, opts...), nil
 	case "mysql":
 		return setupMySQLStorage(dsn, mapOptions, logger)
 	case "inmem":
-		if options != "" {
-			return nil, ErrOptionsNotSupported
+		opts, err := kvOptions(mapOptions, logger)
+		if err != nil {
+			return nil, err
 		}
-		return inmem.New(hasher), nil
+		return inmem.New(hasher, opts...), nil
 	case "file":
 		if options != "enable_deprecated=1" {
 			return nil, errors.New("file backend is deprecated; specify storage options to force enable")
@@ -72,7 +75,7 @@ func setupStorage(name, dsn, options string, logger log.Logger) (allStorage, err
 }
 
 func setupMySQLStorage(dsn string, options map[string]string, logger log.Logger) (allStorage, error) {
-	opts := []mysql.Option{mysql.WithDSN(dsn)}
+	opts := []mysql.Option{mysql.WithDSN(dsn), mysql.WithLogger(logger)}
 	for k, v := range options {
 		switch k {
 		case "delete_errors":
@@ -91,6 +94,12 @@ func setupMySQLStorage(dsn string, options map[string]string, logger log.Logger)
 			}
 			opts = append(opts, mysql.WithStatusReportDeletion(uint(n)))
 			logger.Debug(logkeys.Message, reportDeleteOption, logkeys.GenericCount, int(n))
+		case "status_history":
+			n, err := parseStatusHistoryOption(v, logger)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, mysql.WithStatusHistory(n))
 		case "conn_max_lifetime":
 			const connMaxLifetimeOption = "connection max lifetime option"
 			d, err := time.ParseDuration(v)
@@ -114,6 +123,35 @@ func setupMySQLStorage(dsn string, options map[string]string, logger log.Logger)
 	return mysql.New(hasher, opts...)
 }
 
+// kvOptions parses the options for the key-value storage backends.
+func kvOptions(options map[string]string, logger log.Logger) ([]kv.Option, error) {
+	var opts []kv.Option
+	for k, v := range options {
+		switch k {
+		case "status_history":
+			n, err := parseStatusHistoryOption(v, logger)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, kv.WithStatusHistory(n))
+		default:
+			return nil, fmt.Errorf("invalid option: %q", k)
+		}
+	}
+	return opts, nil
+}
+
+// parseStatusHistoryOption parses the status history retention count.
+func parseStatusHistoryOption(v string, logger log.Logger) (uint, error) {
+	const statusHistoryOption = "status history option"
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %w", statusHistoryOption, err)
+	}
+	logger.Debug(logkeys.Message, statusHistoryOption, logkeys.GenericCount, int(n))
+	return uint(n), nil
+}
+
 func splitOptions(s string) map[string]string {
 	out := make(map[string]string)
 	opts := strings.Split(s, ",")
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	apihttp "github.com/jessepeterson/kmfddm/http/api"
+)
+
+// tenantConfig configures a single tenant.
+// Empty storage fields are inherited from the storage command line
+// flags (see tenantsConfig.fill).
+type tenantConfig struct {
+	Name           string `json:"name"`
+	APIKey         string `json:"api_key"`
+	Enqueue        string `json:"enqueue"`
+	EnqueueKey     string `json:"enqueue_key"`
+	Push           string `json:"push"`
+	MicroMDM       bool   `json:"micromdm"`
+	Storage        string `json:"storage"`
+	StorageDSN     string `json:"storage_dsn"`
+	StorageOptions string `json:"storage_options"`
+
+	// EnqueueConfig configures the templated enqueuer instead of Enqueue.
+	EnqueueConfig *enqueueConfig `json:"enqueue_config"`
+
+	// Credentials are API credentials in addition to APIKey.
+	Credentials []*apihttp.Credential `json:"credentials"`
+}
+
+// tenantsConfig is the multi-tenant configuration file.
+type tenantsConfig struct {
+	// Default is the tenant name that enrollments resolve to when
+	// they are not associated with sets in any tenant.
+	Default string         `json:"default"`
+	Tenants []tenantConfig `json:"tenants"`
+}
+
+var tenantNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// loadTenantsConfig reads the multi-tenant configuration JSON from filename.
+func loadTenantsConfig(filename string) (*tenantsConfig, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg := new(tenantsConfig)
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("decoding tenants config: %w", err)
+	}
+	return cfg, cfg.validate()
+}
+
+// validate performs sanity checks on the tenant configuration.
+func (c *tenantsConfig) validate() error {
+	if len(c.Tenants) < 1 {
+		return errors.New("no tenants configured")
+	}
+	names := make(map[string]struct{})
+	keys := make(map[string]struct{})
+	for i, t := range c.Tenants {
+		if !tenantNameRe.MatchString(t.Name) {
+			return fmt.Errorf("tenant %d: invalid name: %q", i, t.Name)
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("tenant %s: duplicate name", t.Name)
+		}
+		names[t.Name] = struct{}{}
+		if err := t.validateCredentials(keys); err != nil {
+			return fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+	}
+	if c.Default != "" {
+		if _, ok := names[c.Default]; !ok {
+			return fmt.Errorf("default tenant not found: %s", c.Default)
+		}
+	}
+	return nil
+}
+
+// fill populates empty tenant storage settings from the storage
+// command line flags. The filekv backend gets a per-tenant
+// sub-directory of the DSN. Backends that can't be isolated this way
+// (i.e. mysql) require an explicit per-tenant storage configuration.
+func (c *tenantsConfig) fill(storage, dsn, options string) error {
+	for i := range c.Tenants {
+		t := &c.Tenants[i]
+		if t.Storage != "" {
+			continue
+		}
+		t.Storage = storage
+		t.StorageOptions = options
+		switch storage {
+		case "filekv":
+			if dsn == "" {
+				dsn = "dbkv"
+			}
+			t.StorageDSN = filepath.Join(dsn, t.Name)
+		case "inmem":
+			// every tenant gets its own in-memory store
+		default:
+			return fmt.Errorf("tenant %s: storage %s requires an explicit per-tenant storage DSN", t.Name, storage)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/logkeys"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// certCheckInterval is the minimum time between checking the
+// certificate and key files for changes.
+const certCheckInterval = 10 * time.Second
+
+// certReloader loads a TLS certificate and key from files and reloads
+// them when the files change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   log.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// newCertReloader loads the certificate and key from certFile and keyFile.
+func newCertReloader(certFile, keyFile string, logger log.Logger) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime returns the most recent modification time of the
+// certificate and key files.
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load reads the certificate and key files.
+// The caller must hold the lock if r is in use.
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetCertificate returns the current certificate.
+// The certificate is reloaded if the files changed since it was loaded.
+// If reloading fails the previous certificate continues to be used.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.lastCheck) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.lastCheck = time.Now()
+	modTime, err := r.latestModTime()
+	if err == nil && modTime.Equal(r.modTime) {
+		return r.cert, nil
+	} else if err == nil {
+		err = r.load(modTime)
+	}
+	if err != nil {
+		r.logger.Info(logkeys.Message, "reloading certificate", logkeys.Error, err)
+	} else {
+		r.logger.Info(logkeys.Message, "reloaded certificate", "path", r.certFile)
+	}
+	return r.cert, nil
+}
+
+// loadCertPool reads PEM encoded certificates from filename.
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	pemBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, errors.New("no certificates found")
+	}
+	return pool, nil
+}
+
+// newTLSConfig creates the TLS config for a listener that serves the
+// certificate of r. If clientCAs is not nil then client certificates
+// are verified against it. Client certificates are required if
+// requireClient is true; otherwise they are only verified if given.
+func newTLSConfig(r *certReloader, clientCAs *x509.CertPool, requireClient bool) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+	if clientCAs != nil {
+		cfg.ClientCAs = clientCAs
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if requireClient {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return cfg
+}
+
+// clientCertHandler only allows requests with a verified TLS client certificate.
+func clientCertHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) < 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jessepeterson/kmfddm/webhook"
+)
+
+// webhooksConfig is the webhook subscriptions configuration file.
+type webhooksConfig struct {
+	Subscriptions []webhook.Subscription `json:"subscriptions"`
+}
+
+// loadWebhooksConfig reads the webhook subscriptions JSON from filename.
+func loadWebhooksConfig(filename string) ([]webhook.Subscription, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg := new(webhooksConfig)
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("decoding webhooks config: %w", err)
+	}
+	if len(cfg.Subscriptions) < 1 {
+		return nil, errors.New("no subscriptions configured")
+	}
+	for i := range cfg.Subscriptions {
+		if err = cfg.Subscriptions[i].Validate(); err != nil {
+			return nil, fmt.Errorf("subscription %d: %w", i, err)
+		}
+	}
+	return cfg.Subscriptions, nil
+}
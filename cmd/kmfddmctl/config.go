@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// profile is a KMFDDM server and its API key.
+type profile struct {
+	URL    string `json:"url"`
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// config is the kmfddmctl config file.
+type config struct {
+	// Default is the name of the profile used if none is specified.
+	Default  string              `json:"default,omitempty"`
+	Profiles map[string]*profile `json:"profiles,omitempty"`
+}
+
+// profileListing is the output of listing profiles.
+type profileListing struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Default bool   `json:"default"`
+}
+
+// defaultConfigPath returns the default path of the config file.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "kmfddmctl.json"
+	}
+	return filepath.Join(dir, "kmfddmctl", "config.json")
+}
+
+// loadConfig loads the config file at path.
+// An empty config is returned if path does not exist.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{Profiles: make(map[string]*profile)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]*profile)
+	}
+	return cfg, nil
+}
+
+// save writes the config file to path.
+// The file is only readable by the current user as it contains API keys.
+func (cfg *config) save(path string) error {
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0600)
+}
+
+// normalizeURL removes any trailing slash and API path from serverURL.
+func normalizeURL(serverURL string) string {
+	serverURL = strings.TrimSuffix(serverURL, "/")
+	return strings.TrimSuffix(serverURL, "/v1")
+}
+
+// resolve returns the profile name (or the default profile if empty)
+// with its URL and API key overridden if not empty.
+func (cfg *config) resolve(name, serverURL, apiKey string) (*profile, error) {
+	p := new(profile)
+	if name == "" {
+		name = cfg.Default
+	} else if _, ok := cfg.Profiles[name]; !ok {
+		return nil, fmt.Errorf("profile not found: %s", name)
+	}
+	if cfgProfile, ok := cfg.Profiles[name]; ok {
+		*p = *cfgProfile
+	}
+	if serverURL != "" {
+		p.URL = serverURL
+	}
+	if apiKey != "" {
+		p.APIKey = apiKey
+	}
+	p.URL = normalizeURL(p.URL)
+	return p, nil
+}
+
+func configCommand() *command {
+	return &command{
+		name:  "config",
+		short: "manage config profiles",
+		subcommands: []*command{
+			{
+				name:  "list",
+				short: "list config profiles",
+				setup: noFlags(func(_ context.Context, e *env, args []string) error {
+					if len(args) > 0 {
+						return errUsage
+					}
+					// API keys are not output
+					listings := []profileListing{}
+					for name, p := range e.cfg.Profiles {
+						listings = append(listings, profileListing{Name: name, URL: p.URL, Default: name == e.cfg.Default})
+					}
+					sort.Slice(listings, func(i, j int) bool { return listings[i].Name < listings[j].Name })
+					return e.out.print(listings, func(w *tabwriter.Writer) {
+						fmt.Fprintln(w, "NAME\tURL\tDEFAULT")
+						for _, l := range listings {
+							fmt.Fprintf(w, "%s\t%s\t%s\n", l.Name, l.URL, yesOrEmpty(l.Default))
+						}
+					})
+				}),
+			},
+			{
+				name:  "set",
+				args:  "<name>",
+				short: "create or update a config profile",
+				setup: func(fs *flag.FlagSet) runFunc {
+					var (
+						flURL     = fs.String("url", "", "KMFDDM server URL")
+						flAPIKey  = fs.String("api-key", "", "KMFDDM API key")
+						flDefault = fs.Bool("default", false, "make this the default profile")
+					)
+					return func(_ context.Context, e *env, args []string) error {
+						if len(args) != 1 || args[0] == "" {
+							return errUsage
+						}
+						p, ok := e.cfg.Profiles[args[0]]
+						if !ok {
+							p = new(profile)
+							e.cfg.Profiles[args[0]] = p
+						}
+						if *flURL != "" {
+							p.URL = normalizeURL(*flURL)
+						}
+						if *flAPIKey != "" {
+							p.APIKey = *flAPIKey
+						}
+						if p.URL == "" {
+							return errors.New("profile has no URL: use -url")
+						}
+						if *flDefault || len(e.cfg.Profiles) == 1 {
+							e.cfg.Default = args[0]
+						}
+						return e.cfg.save(e.cfgPath)
+					}
+				},
+			},
+			{
+				name:  "use",
+				args:  "<name>",
+				short: "set the default config profile",
+				setup: noFlags(func(_ context.Context, e *env, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					if _, ok := e.cfg.Profiles[args[0]]; !ok {
+						return fmt.Errorf("profile not found: %s", args[0])
+					}
+					e.cfg.Default = args[0]
+					return e.cfg.save(e.cfgPath)
+				}),
+			},
+			{
+				name:  "delete",
+				args:  "<name>",
+				short: "delete a config profile",
+				setup: noFlags(func(_ context.Context, e *env, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					if _, ok := e.cfg.Profiles[args[0]]; !ok {
+						return fmt.Errorf("profile not found: %s", args[0])
+					}
+					delete(e.cfg.Profiles, args[0])
+					if e.cfg.Default == args[0] {
+						e.cfg.Default = ""
+					}
+					return e.cfg.save(e.cfgPath)
+				}),
+			},
+		},
+	}
+}
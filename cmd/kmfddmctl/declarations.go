@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jessepeterson/kmfddm/client"
+	"github.com/jessepeterson/kmfddm/ddm"
+)
+
+// changeFlags defines the flags common to commands that change resources.
+// The returned function returns the request options of the flags.
+func changeFlags(fs *flag.FlagSet) func() []client.RequestOption {
+	flNoNotify := fs.Bool("nonotify", false, "do not notify enrollments of changes")
+	return func() (opts []client.RequestOption) {
+		if *flNoNotify {
+			opts = append(opts, client.NoNotify())
+		}
+		return
+	}
+}
+
+// readFileOrStdin reads path or stdin if path is "-".
+func readFileOrStdin(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func declarationsCommand() *command {
+	return &command{
+		name:  "declarations",
+		short: "manage declarations",
+		subcommands: []*command{
+			{
+				name:  "list",
+				short: "list declaration identifiers",
+				setup: noFlags(func(ctx context.Context, e *env, args []string) error {
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					ids, err := c.GetDeclarations(ctx)
+					if err != nil {
+						return err
+					}
+					return e.out.printList("IDENTIFIER", ids)
+				}),
+			},
+			{
+				name:  "get",
+				args:  "<identifier>",
+				short: "get a declaration",
+				setup: noFlags(func(ctx context.Context, e *env, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					d, err := c.GetDeclaration(ctx, args[0])
+					if err != nil {
+						return err
+					}
+					return e.out.printRaw(d.Raw)
+				}),
+			},
+			{
+				name:  "put",
+				args:  "<file>...",
+				short: "upload declarations from files (\"-\" for stdin)",
+				setup: func(fs *flag.FlagSet) runFunc {
+					opts := changeFlags(fs)
+					flIfMatch := fs.String("if-match", "", "only upload if the current ETag matches")
+					return func(ctx context.Context, e *env, args []string) error {
+						if len(args) < 1 {
+							return errUsage
+						}
+						c, err := e.Client()
+						if err != nil {
+							return err
+						}
+						reqOpts := opts()
+						if *flIfMatch != "" {
+							reqOpts = append(reqOpts, client.IfMatch(*flIfMatch))
+						}
+						var results []changeResult
+						for _, path := range args {
+							raw, err := readFileOrStdin(path)
+							if err != nil {
+								return err
+							}
+							d, err := ddm.ParseDeclaration(raw)
+							if err != nil {
+								return fmt.Errorf("parsing %s: %w", path, err)
+							}
+							changed, err := c.PutDeclaration(ctx, raw, reqOpts...)
+							if err != nil {
+								return fmt.Errorf("uploading %s: %w", path, err)
+							}
+							results = append(results, changeResult{Resource: d.Identifier, Changed: changed})
+						}
+						return e.out.printChanges(results)
+					}
+				},
+			},
+			{
+				name:  "delete",
+				args:  "<identifier>...",
+				short: "delete declarations",
+				setup: func(fs *flag.FlagSet) runFunc {
+					opts := changeFlags(fs)
+					flCascade := fs.Bool("cascade", false, "also remove the declarations from any sets")
+					return func(ctx context.Context, e *env, args []string) error {
+						if len(args) < 1 {
+							return errUsage
+						}
+						c, err := e.Client()
+						if err != nil {
+							return err
+						}
+						reqOpts := opts()
+						if *flCascade {
+							reqOpts = append(reqOpts, client.Cascade())
+						}
+						var results []changeResult
+						for _, id := range args {
+							changed, err := c.DeleteDeclaration(ctx, id, reqOpts...)
+							var apiErr *client.Error
+							if errors.As(err, &apiErr) && len(apiErr.Sets) > 0 {
+								return fmt.Errorf("deleting %s: %w (use -cascade to remove from sets)", id, err)
+							} else if err != nil {
+								return fmt.Errorf("deleting %s: %w", id, err)
+							}
+							results = append(results, changeResult{Resource: id, Changed: changed})
+						}
+						return e.out.printChanges(results)
+					}
+				},
+			},
+			{
+				name:  "touch",
+				args:  "<identifier>...",
+				short: "change the server token of declarations",
+				setup: func(fs *flag.FlagSet) runFunc {
+					opts := changeFlags(fs)
+					return func(ctx context.Context, e *env, args []string) error {
+						if len(args) < 1 {
+							return errUsage
+						}
+						c, err := e.Client()
+						if err != nil {
+							return err
+						}
+						var results []changeResult
+						for _, id := range args {
+							if err = c.TouchDeclaration(ctx, id, opts()...); err != nil {
+								return fmt.Errorf("touching %s: %w", id, err)
+							}
+							results = append(results, changeResult{Resource: id, Changed: true})
+						}
+						return e.out.printChanges(results)
+					}
+				},
+			},
+			{
+				name:  "compliance",
+				args:  "<identifier>",
+				short: "summarize the reported status of a declaration",
+				setup: noFlags(func(ctx context.Context, e *env, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					cmp, err := c.GetDeclarationCompliance(ctx, args[0])
+					if err != nil {
+						return err
+					}
+					return e.out.print(cmp, func(w *tabwriter.Writer) {
+						fmt.Fprintf(w, "Identifier:\t%s\n", cmp.Identifier)
+						fmt.Fprintf(w, "Server token:\t%s\n", cmp.ServerToken)
+						fmt.Fprintf(w, "Enrollments:\t%d\n", cmp.Enrollments)
+						fmt.Fprintf(w, "Active:\t%d\n", cmp.Active.Count)
+						fmt.Fprintf(w, "Inactive:\t%d\n", cmp.Inactive.Count)
+						fmt.Fprintf(w, "Valid:\t%d\n", cmp.Valid.Count)
+						fmt.Fprintf(w, "Invalid:\t%d\n", cmp.Invalid.Count)
+						fmt.Fprintf(w, "Unknown:\t%d\n", cmp.Unknown.Count)
+						fmt.Fprintf(w, "Stale:\t%d\n", cmp.Stale.Count)
+						fmt.Fprintf(w, "No status:\t%d\n", cmp.NoStatus.Count)
+					})
+				}),
+			},
+			{
+				name:  "sets",
+				args:  "<identifier>",
+				short: "list the sets of a declaration",
+				setup: noFlags(func(ctx context.Context, e *env, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					sets, err := c.GetDeclarationSets(ctx, args[0])
+					if err != nil {
+						return err
+					}
+					return e.out.printList("SET", sets)
+				}),
+			},
+		},
+	}
+}
+
+func setsCommand() *command {
+	return &command{
+		name:  "sets",
+		short: "manage sets and their declarations",
+		subcommands: []*command{
+			{
+				name:  "list",
+				short: "list sets",
+				setup: noFlags(func(ctx context.Context, e *env, args []string) error {
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					sets, err := c.GetSets(ctx)
+					if err != nil {
+						return err
+					}
+					return e.out.printList("SET", sets)
+				}),
+			},
+			{
+				name:  "get",
+				args:  "<set>",
+				short: "list the declarations of a set",
+				setup: noFlags(func(ctx context.Context, e *env, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					ids, err := c.GetSetDeclarations(ctx, args[0])
+					if err != nil {
+						return err
+					}
+					return e.out.printList("IDENTIFIER", ids)
+				}),
+			},
+			{
+				name:  "add",
+				args:  "<set> <identifier>...",
+				short: "add declarations to a set",
+				setup: func(fs *flag.FlagSet) runFunc {
+					opts := changeFlags(fs)
+					return func(ctx context.Context, e *env, args []string) error {
+						return changeSetDeclarations(ctx, e, args, opts(), (*client.Client).PutSetDeclaration)
+					}
+				},
+			},
+			{
+				name:  "remove",
+				args:  "<set> <identifier>...",
+				short: "remove declarations from a set",
+				setup: func(fs *flag.FlagSet) runFunc {
+					opts := changeFlags(fs)
+					return func(ctx context.Context, e *env, args []string) error {
+						return changeSetDeclarations(ctx, e, args, opts(), (*client.Client).DeleteSetDeclaration)
+					}
+				},
+			},
+		},
+	}
+}
+
+type changeFunc func(c *client.Client, ctx context.Context, a, b string, opts ...client.RequestOption) (bool, error)
+
+// changeSetDeclarations changes the declarations in args[1:] of the set in args[0].
+func changeSetDeclarations(ctx context.Context, e *env, args []string, opts []client.RequestOption, change changeFunc) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	c, err := e.Client()
+	if err != nil {
+		return err
+	}
+	var results []changeResult
+	for _, id := range args[1:] {
+		changed, err := change(c, ctx, args[0], id, opts...)
+		if err != nil {
+			return fmt.Errorf("set %s: declaration %s: %w", args[0], id, err)
+		}
+		results = append(results, changeResult{Resource: args[0] + "/" + id, Changed: changed})
+	}
+	return e.out.printChanges(results)
+}
+
+func enrollmentsCommand() *command {
+	return &command{
+		name:  "enrollments",
+		short: "manage the sets of enrollments",
+		subcommands: []*command{
+			{
+				name:  "sets",
+				args:  "<enrollment-id>",
+				short: "list the sets of an enrollment",
+				setup: noFlags(func(ctx context.Context, e *env, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					sets, err := c.GetEnrollmentSets(ctx, args[0])
+					if err != nil {
+						return err
+					}
+					return e.out.printList("SET", sets)
+				}),
+			},
+			{
+				name:  "add",
+				args:  "<enrollment-id> <set>...",
+				short: "add sets to an enrollment",
+				setup: func(fs *flag.FlagSet) runFunc {
+					opts := changeFlags(fs)
+					return func(ctx context.Context, e *env, args []string) error {
+						return changeEnrollmentSets(ctx, e, args, opts(), (*client.Client).PutEnrollmentSet)
+					}
+				},
+			},
+			{
+				name:  "remove",
+				args:  "<enrollment-id> <set>...",
+				short: "remove sets from an enrollment",
+				setup: func(fs *flag.FlagSet) runFunc {
+					opts := changeFlags(fs)
+					return func(ctx context.Context, e *env, args []string) error {
+						return changeEnrollmentSets(ctx, e, args, opts(), (*client.Client).DeleteEnrollmentSet)
+					}
+				},
+			},
+			{
+				name:  "remove-all",
+				args:  "<enrollment-id>...",
+				short: "remove all sets from enrollments",
+				setup: func(fs *flag.FlagSet) runFunc {
+					opts := changeFlags(fs)
+					return func(ctx context.Context, e *env, args []string) error {
+						if len(args) < 1 {
+							return errUsage
+						}
+						c, err := e.Client()
+						if err != nil {
+							return err
+						}
+						var results []changeResult
+						for _, id := range args {
+							changed, err := c.DeleteAllEnrollmentSets(ctx, id, opts()...)
+							if err != nil {
+								return fmt.Errorf("enrollment %s: %w", id, err)
+							}
+							results = append(results, changeResult{Resource: id, Changed: changed})
+						}
+						return e.out.printChanges(results)
+					}
+				},
+			},
+		},
+	}
+}
+
+// changeEnrollmentSets changes the sets in args[1:] of the enrollment in args[0].
+func changeEnrollmentSets(ctx context.Context, e *env, args []string, opts []client.RequestOption, change changeFunc) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	c, err := e.Client()
+	if err != nil {
+		return err
+	}
+	var results []changeResult
+	for _, setName := range args[1:] {
+		changed, err := change(c, ctx, args[0], setName, opts...)
+		if err != nil {
+			return fmt.Errorf("enrollment %s: set %s: %w", args[0], setName, err)
+		}
+		results = append(results, changeResult{Resource: args[0] + "/" + setName, Changed: changed})
+	}
+	return e.out.printChanges(results)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+)
+
+// setFilePattern matches set files. The submatch is the set name.
+var setFilePattern = regexp.MustCompile(`^set\.(.+)\.txt$`)
+
+// localDeclaration is a declaration file.
+type localDeclaration struct {
+	path string
+	raw  []byte
+	d    *ddm.Declaration
+}
+
+// localSet is a set file.
+// Each line of a set file is a declaration identifier to add to the set.
+// Lines starting with a minus ("-") are declarations to remove from the
+// set and lines starting with an octothorp ("#") are comments.
+type localSet struct {
+	path   string
+	name   string
+	add    []string
+	remove []string
+}
+
+// localDir contains the declaration and set files of a directory.
+type localDir struct {
+	declarations []*localDeclaration
+	sets         []*localSet
+}
+
+// declaration returns the declaration with identifier or nil if not found.
+func (l *localDir) declaration(identifier string) *localDeclaration {
+	for _, d := range l.declarations {
+		if d.d.Identifier == identifier {
+			return d
+		}
+	}
+	return nil
+}
+
+// Problem severities.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// problem is an issue found in a declaration or set file.
+type problem struct {
+	Path     string `json:"path"`
+	Line     int    `json:"line,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (p problem) String() string {
+	path := p.Path
+	if p.Line > 0 {
+		path = fmt.Sprintf("%s:%d", p.Path, p.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s", path, p.Severity, p.Message)
+}
+
+// problems accumulates problems.
+type problems []problem
+
+func (p *problems) add(path string, line int, severity, format string, a ...interface{}) {
+	*p = append(*p, problem{Path: path, Line: line, Severity: severity, Message: fmt.Sprintf(format, a...)})
+}
+
+// errors returns the count of error problems.
+func (p problems) errors() (n int) {
+	for _, v := range p {
+		if v.Severity == severityError {
+			n++
+		}
+	}
+	return
+}
+
+// manifestTypes are the known manifest types of Apple declaration types.
+var manifestTypes = []string{"activation", "asset", "configuration", "management"}
+
+// lintDeclaration checks a parsed declaration file.
+func lintDeclaration(probs *problems, path string, d *ddm.Declaration) {
+	if d.Identifier == "" {
+		probs.add(path, 0, severityError, "missing Identifier")
+	}
+	if d.Type == "" {
+		probs.add(path, 0, severityError, "missing Type")
+	} else if !strings.HasPrefix(d.Type, "com.apple.") {
+		probs.add(path, 0, severityWarning, "Type is not an Apple declaration type: %s", d.Type)
+	} else if mt := ddm.ManifestType(d.Type); !contains(manifestTypes, mt) {
+		probs.add(path, 0, severityWarning, "unknown manifest type %q of Type: %s", mt, d.Type)
+	}
+	payload := bytes.TrimSpace(d.Payload)
+	if len(payload) < 1 {
+		probs.add(path, 0, severityError, "missing Payload")
+	} else if payload[0] != '{' {
+		probs.add(path, 0, severityError, "Payload is not an object")
+	}
+	if d.ServerToken != "" {
+		probs.add(path, 0, severityWarning, "ServerToken is replaced by the server")
+	}
+}
+
+// contains returns true if s is in a.
+func contains(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// loadDeclaration reads and checks the declaration file at path.
+// A nil declaration is returned if it cannot be parsed.
+func loadDeclaration(probs *problems, path string) (*localDeclaration, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(raw) {
+		var v interface{}
+		probs.add(path, 0, severityError, "invalid JSON: %v", json.Unmarshal(raw, &v))
+		return nil, nil
+	}
+	d, err := ddm.ParseDeclaration(raw)
+	if err != nil {
+		probs.add(path, 0, severityError, "invalid declaration: %v", err)
+		return nil, nil
+	}
+	lintDeclaration(probs, path, d)
+	if !d.Valid() {
+		return nil, nil
+	}
+	return &localDeclaration{path: path, raw: raw, d: d}, nil
+}
+
+// loadSet reads and checks the set file at path.
+func loadSet(probs *problems, path, name string) (*localSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := &localSet{path: path, name: name}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" || id[0] == '#' {
+			continue
+		}
+		remove := id[0] == '-'
+		if remove {
+			id = strings.TrimSpace(id[1:])
+		}
+		if id == "" {
+			probs.add(path, line, severityError, "missing declaration identifier")
+		} else if contains(s.add, id) || contains(s.remove, id) {
+			probs.add(path, line, severityError, "duplicate declaration: %s", id)
+		} else if remove {
+			s.remove = append(s.remove, id)
+		} else {
+			s.add = append(s.add, id)
+		}
+	}
+	return s, scanner.Err()
+}
+
+// loadDir walks root for declaration (".json") and set ("set.NAME.txt")
+// files and checks them. Declarations and set files with errors are
+// not included in the returned directory.
+func loadDir(root string) (*localDir, problems, error) {
+	l := new(localDir)
+	var probs problems
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(entry.Name(), ".json") {
+			d, err := loadDeclaration(&probs, path)
+			if err != nil || d == nil {
+				return err
+			}
+			if other := l.declaration(d.d.Identifier); other != nil {
+				probs.add(path, 0, severityError, "duplicate declaration %s: also in %s", d.d.Identifier, other.path)
+				return nil
+			}
+			l.declarations = append(l.declarations, d)
+		} else if m := setFilePattern.FindStringSubmatch(entry.Name()); m != nil {
+			s, err := loadSet(&probs, path, m[1])
+			if err != nil {
+				return err
+			}
+			for _, other := range l.sets {
+				if other.name == s.name {
+					probs.add(path, 0, severityError, "duplicate set %s: also in %s", s.name, other.path)
+					return nil
+				}
+			}
+			l.sets = append(l.sets, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// check references to declarations
+	for _, s := range l.sets {
+		for _, id := range s.add {
+			if l.declaration(id) == nil {
+				probs.add(s.path, 0, severityWarning, "declaration not in directory: %s", id)
+			}
+		}
+	}
+	for _, d := range l.declarations {
+		if d.d.Type != "com.apple.activation.simple" {
+			continue
+		}
+		var payload struct {
+			StandardConfigurations []string
+		}
+		if err = json.Unmarshal(d.d.Payload, &payload); err != nil {
+			probs.add(d.path, 0, severityError, "invalid activation payload: %v", err)
+			continue
+		}
+		for _, id := range payload.StandardConfigurations {
+			if l.declaration(id) == nil {
+				probs.add(d.path, 0, severityWarning, "activated configuration not in directory: %s", id)
+			}
+		}
+	}
+	return l, probs, nil
+}
+
+func lintCommand() *command {
+	return &command{
+		name:  "lint",
+		args:  "<path>...",
+		short: "check local declaration and set files",
+		setup: noFlags(func(_ context.Context, e *env, args []string) error {
+			if len(args) < 1 {
+				return errUsage
+			}
+			var probs problems
+			for _, path := range args {
+				info, err := os.Stat(path)
+				if err != nil {
+					return err
+				}
+				if info.IsDir() {
+					_, dirProbs, err := loadDir(path)
+					if err != nil {
+						return err
+					}
+					probs = append(probs, dirProbs...)
+					continue
+				}
+				// individual files are checked as declarations
+				if _, err = loadDeclaration(&probs, path); err != nil {
+					return err
+				}
+			}
+			if probs == nil {
+				probs = problems{}
+			}
+			if err := e.out.print(probs, func(w *tabwriter.Writer) {
+				for _, p := range probs {
+					fmt.Fprintln(w, p)
+				}
+			}); err != nil {
+				return err
+			}
+			if n := probs.errors(); n > 0 {
+				return fmt.Errorf("%d error(s) found", n)
+			}
+			return nil
+		}),
+	}
+}
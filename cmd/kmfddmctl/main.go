@@ -0,0 +1,192 @@
+// Command kmfddmctl manages a KMFDDM server using its v1 API.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jessepeterson/kmfddm/client"
+
+	"github.com/micromdm/nanolib/envflag"
+)
+
+// overridden by -ldflags -X
+var version = "unknown"
+
+// errUsage indicates a command was given invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+// env is the environment commands run in.
+type env struct {
+	out *printer
+
+	cfg     *config
+	cfgPath string
+
+	profile *profile
+	client  *client.Client
+}
+
+// Client returns the API client of the selected profile.
+func (e *env) Client() (*client.Client, error) {
+	if e.client != nil {
+		return e.client, nil
+	}
+	if e.profile.URL == "" {
+		return nil, errors.New("no server URL: use -url or configure a profile")
+	}
+	var err error
+	e.client, err = client.New(e.profile.URL, e.profile.APIKey)
+	return e.client, err
+}
+
+// command is a (sub)command of kmfddmctl.
+// Commands either run or have subcommands.
+type command struct {
+	name  string
+	args  string // synopsis of arguments
+	short string // short description
+
+	// setup defines the flags of the command in fs and returns the
+	// function that runs the command with the remaining arguments.
+	setup func(fs *flag.FlagSet) runFunc
+
+	subcommands []*command
+}
+
+type runFunc func(ctx context.Context, e *env, args []string) error
+
+// noFlags is the setup of commands without flags.
+func noFlags(run runFunc) func(*flag.FlagSet) runFunc {
+	return func(*flag.FlagSet) runFunc { return run }
+}
+
+func commands() []*command {
+	return []*command{
+		configCommand(),
+		declarationsCommand(),
+		setsCommand(),
+		enrollmentsCommand(),
+		statusCommand(),
+		notifyCommand(),
+		notificationsCommand(),
+		unsyncedCommand(),
+		batchCommand(),
+		stateCommand(),
+		syncCommand(),
+		lintCommand(),
+	}
+}
+
+func printCommands(path string, cmds []*command) {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [args]\n\ncommands:\n", path)
+	for _, c := range cmds {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.short)
+	}
+}
+
+// runCommand finds the command in args and runs it.
+func runCommand(ctx context.Context, e *env, path string, cmds []*command, args []string) error {
+	if len(args) < 1 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		printCommands(path, cmds)
+		return errUsage
+	}
+	for _, c := range cmds {
+		if c.name != args[0] {
+			continue
+		}
+		path := path + " " + c.name
+		if len(c.subcommands) > 0 {
+			return runCommand(ctx, e, path, c.subcommands, args[1:])
+		}
+		fs := flag.NewFlagSet(path, flag.ExitOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "usage: %s [flags] %s\n\n%s\n", path, c.args, c.short)
+			fs.PrintDefaults()
+		}
+		run := c.setup(fs)
+		fs.Parse(args[1:])
+		err := run(ctx, e, fs.Args())
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+	printCommands(path, cmds)
+	return errUsage
+}
+
+func main() {
+	var (
+		flVersion = flag.Bool("version", false, "print version and exit")
+		flConfig  = flag.String("config", "", "path to config file (default "+defaultConfigPath()+")")
+		flProfile = flag.String("profile", "", "config profile to use (default is the config default profile)")
+		flURL     = flag.String("url", "", "KMFDDM server URL (overrides profile)")
+		flAPIKey  = flag.String("api-key", "", "KMFDDM API key (overrides profile)")
+		flOutput  = flag.String("o", "table", "output format: table or json")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <command> [flags] [args]\n\nflags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output())
+		printCommands(os.Args[0], commands())
+	}
+	envflag.Parse("KMFDDMCTL_", []string{"version", "o"})
+
+	if *flVersion {
+		fmt.Println(version)
+		return
+	}
+
+	if *flOutput != "table" && *flOutput != "json" {
+		fmt.Fprintf(os.Stderr, "invalid output format: %s\n", *flOutput)
+		os.Exit(2)
+	}
+
+	configPath := *flConfig
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loading config: %v\n", err)
+		os.Exit(1)
+	}
+	p, err := cfg.resolve(*flProfile, *flURL, *flAPIKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	e := &env{
+		out:     &printer{w: os.Stdout, json: *flOutput == "json"},
+		cfg:     cfg,
+		cfgPath: configPath,
+		profile: p,
+	}
+
+	err = runCommand(context.Background(), e, os.Args[0], commands(), flag.Args())
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// splitIDs splits comma-separated IDs in args.
+func splitIDs(args []string) (ids []string) {
+	for _, arg := range args {
+		for _, id := range strings.Split(arg, ",") {
+			if id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/client"
+	"github.com/jessepeterson/kmfddm/http/api"
+)
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func notifyCommand() *command {
+	return &command{
+		name:  "notify",
+		short: "notify enrollments of declarations, sets, or enrollment IDs",
+		setup: func(fs *flag.FlagSet) runFunc {
+			var declarations, sets, ids stringsFlag
+			fs.Var(&declarations, "declaration", "notify enrollments of this declaration (repeatable)")
+			fs.Var(&sets, "set", "notify enrollments of this set (repeatable)")
+			fs.Var(&ids, "id", "notify this enrollment ID (repeatable)")
+			return func(ctx context.Context, e *env, args []string) error {
+				if len(args) > 0 || (len(declarations) < 1 && len(sets) < 1 && len(ids) < 1) {
+					return errUsage
+				}
+				c, err := e.Client()
+				if err != nil {
+					return err
+				}
+				err = c.Notify(ctx, declarations, sets, ids)
+				var apiErr *client.Error
+				if errors.As(err, &apiErr) && len(apiErr.FailedIDs) > 0 {
+					return fmt.Errorf("%w: failed enrollment IDs: %s", err, strings.Join(apiErr.FailedIDs, ", "))
+				}
+				return err
+			}
+		},
+	}
+}
+
+func notificationsCommand() *command {
+	return &command{
+		name:  "notifications",
+		short: "manage queued notification jobs",
+		subcommands: []*command{
+			{
+				name:  "list",
+				short: "list pending (or failed) notification jobs",
+				setup: func(fs *flag.FlagSet) runFunc {
+					flFailed := fs.Bool("failed", false, "list failed notification jobs")
+					return func(ctx context.Context, e *env, args []string) error {
+						c, err := e.Client()
+						if err != nil {
+							return err
+						}
+						jobs, err := c.GetNotificationJobs(ctx, *flFailed)
+						if err != nil {
+							return err
+						}
+						return e.out.print(jobs, func(w *tabwriter.Writer) {
+							fmt.Fprintln(w, "ID\tCREATED\tATTEMPTS\tNEXT ATTEMPT\tFAILED\tLAST ERROR")
+							for _, j := range jobs {
+								fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+									j.ID,
+									formatTime(j.CreatedAt),
+									j.Attempts,
+									formatTime(j.NextAttempt),
+									yesOrEmpty(j.Failed),
+									j.LastError,
+								)
+							}
+						})
+					}
+				},
+			},
+			{
+				name:  "get",
+				args:  "<job-id>",
+				short: "show a notification job",
+				setup: noFlags(func(ctx context.Context, e *env, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					j, err := c.GetNotificationJob(ctx, args[0])
+					if err != nil {
+						return err
+					}
+					return e.out.print(j, func(w *tabwriter.Writer) {
+						fmt.Fprintf(w, "ID:\t%s\n", j.ID)
+						fmt.Fprintf(w, "Declarations:\t%s\n", strings.Join(j.Declarations, ", "))
+						fmt.Fprintf(w, "Sets:\t%s\n", strings.Join(j.Sets, ", "))
+						fmt.Fprintf(w, "Enrollment IDs:\t%s\n", strings.Join(j.EnrollmentIDs, ", "))
+						fmt.Fprintf(w, "Force:\t%t\n", j.Force)
+						fmt.Fprintf(w, "Created:\t%s\n", formatTime(j.CreatedAt))
+						fmt.Fprintf(w, "Attempts:\t%d\n", j.Attempts)
+						fmt.Fprintf(w, "Next attempt:\t%s\n", formatTime(j.NextAttempt))
+						fmt.Fprintf(w, "Failed:\t%t\n", j.Failed)
+						fmt.Fprintf(w, "Last error:\t%s\n", orDash(j.LastError))
+					})
+				}),
+			},
+			{
+				name:  "delete",
+				args:  "<job-id>...",
+				short: "delete notification jobs",
+				setup: noFlags(func(ctx context.Context, e *env, args []string) error {
+					return eachJob(ctx, e, args, (*client.Client).DeleteNotificationJob)
+				}),
+			},
+			{
+				name:  "replay",
+				args:  "<job-id>...",
+				short: "attempt notification jobs again as soon as possible",
+				setup: noFlags(func(ctx context.Context, e *env, args []string) error {
+					return eachJob(ctx, e, args, (*client.Client).ReplayNotificationJob)
+				}),
+			},
+		},
+	}
+}
+
+// eachJob calls fn for each notification job ID in args.
+func eachJob(ctx context.Context, e *env, args []string, fn func(*client.Client, context.Context, string) error) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	c, err := e.Client()
+	if err != nil {
+		return err
+	}
+	var results []changeResult
+	for _, jobID := range args {
+		if err = fn(c, ctx, jobID); err != nil {
+			return fmt.Errorf("notification job %s: %w", jobID, err)
+		}
+		results = append(results, changeResult{Resource: jobID, Changed: true})
+	}
+	return e.out.printChanges(results)
+}
+
+func unsyncedCommand() *command {
+	return &command{
+		name:  "unsynced",
+		short: "list notified enrollments that have not synced",
+		setup: func(fs *flag.FlagSet) runFunc {
+			var ids stringsFlag
+			fs.Var(&ids, "id", "only this enrollment ID (repeatable)")
+			flOlderThan := fs.Duration("older-than", 0, "only enrollments notified longer ago than this")
+			flLimit := fs.Int("limit", 0, "maximum number of enrollments")
+			return func(ctx context.Context, e *env, args []string) error {
+				if len(args) > 0 {
+					return errUsage
+				}
+				c, err := e.Client()
+				if err != nil {
+					return err
+				}
+				unsynced, err := c.GetUnsynced(ctx, ids, *flOlderThan, *flLimit)
+				if err != nil {
+					return err
+				}
+				return e.out.print(unsynced, func(w *tabwriter.Writer) {
+					fmt.Fprintln(w, "ENROLLMENT\tNOTIFIED\tAGE\tNOTIFICATIONS\tCOMMAND UUID")
+					for _, n := range unsynced {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n",
+							n.EnrollmentID,
+							formatTime(n.NotifiedAt),
+							time.Duration(n.AgeSeconds)*time.Second,
+							n.Notifications,
+							orDash(n.CommandUUID),
+						)
+					}
+				})
+			}
+		},
+	}
+}
+
+func batchCommand() *command {
+	return &command{
+		name:  "batch",
+		args:  "<file>",
+		short: "apply batch operations from a JSON file (\"-\" for stdin)",
+		setup: func(fs *flag.FlagSet) runFunc {
+			opts := changeFlags(fs)
+			return func(ctx context.Context, e *env, args []string) error {
+				if len(args) != 1 {
+					return errUsage
+				}
+				raw, err := readFileOrStdin(args[0])
+				if err != nil {
+					return err
+				}
+				req := new(api.BatchRequest)
+				if err = json.Unmarshal(raw, req); err != nil {
+					return fmt.Errorf("decoding %s: %w", args[0], err)
+				}
+				c, err := e.Client()
+				if err != nil {
+					return err
+				}
+				resp, err := c.Batch(ctx, req, opts()...)
+				if resp != nil && len(resp.Results) > 0 {
+					if pErr := printBatchResponse(e.out, req, resp); pErr != nil {
+						return pErr
+					}
+				}
+				return err
+			}
+		},
+	}
+}
+
+// printBatchResponse outputs the results of the operations of req.
+func printBatchResponse(out *printer, req *api.BatchRequest, resp *api.BatchResponse) error {
+	return out.print(resp, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "OP\tRESOURCE\tRESULT")
+		for i, r := range resp.Results {
+			resource := ""
+			if i < len(req.Operations) {
+				op := req.Operations[i]
+				var parts []string
+				for _, v := range []string{op.EnrollmentID, op.Set, op.DeclarationID} {
+					if v != "" {
+						parts = append(parts, v)
+					}
+				}
+				resource = strings.Join(parts, "/")
+			}
+			result := "unchanged"
+			switch {
+			case r.Error != "":
+				result = "error: " + r.Error
+			case r.Skipped:
+				result = "skipped"
+			case r.Changed:
+				result = "changed"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.Op, orDash(resource), result)
+		}
+		fmt.Fprintf(w, "\napplied: %t, atomic: %t, notified: %t\n", resp.Applied, resp.Atomic, resp.Notified)
+	})
+}
+
+func stateCommand() *command {
+	return &command{
+		name:  "state",
+		args:  "<file>",
+		short: "sync the server to a desired state JSON file (\"-\" for stdin)",
+		setup: func(fs *flag.FlagSet) runFunc {
+			opts := changeFlags(fs)
+			flPrefix := fs.String("prefix", "", "only sync sets with this prefix")
+			flPlan := fs.Bool("plan", false, "only show the changes")
+			flConfirm := fs.Bool("confirm", false, "allow an empty state to remove every declaration")
+			return func(ctx context.Context, e *env, args []string) error {
+				if len(args) != 1 {
+					return errUsage
+				}
+				raw, err := readFileOrStdin(args[0])
+				if err != nil {
+					return err
+				}
+				state := new(api.State)
+				if err = json.Unmarshal(raw, state); err != nil {
+					return fmt.Errorf("decoding %s: %w", args[0], err)
+				}
+				c, err := e.Client()
+				if err != nil {
+					return err
+				}
+				opts := opts()
+				if *flConfirm {
+					opts = append(opts, client.Confirm())
+				}
+				resp, err := c.PutState(ctx, state, *flPrefix, *flPlan, opts...)
+				if err != nil && resp.Error == "" {
+					return err
+				}
+				if pErr := printStateResponse(e.out, resp); pErr != nil {
+					return pErr
+				}
+				return err
+			}
+		},
+	}
+}
+
+// printStateResponse outputs the changes of a state sync.
+func printStateResponse(out *printer, resp *api.StateResponse) error {
+	return out.print(resp, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "CHANGE\tRESOURCE")
+		for _, id := range resp.DeclarationsAdded {
+			fmt.Fprintf(w, "add declaration\t%s\n", id)
+		}
+		for _, id := range resp.DeclarationsUpdated {
+			fmt.Fprintf(w, "update declaration\t%s\n", id)
+		}
+		for _, setName := range sortedKeys(resp.SetDeclarationsAdded) {
+			for _, id := range resp.SetDeclarationsAdded[setName] {
+				fmt.Fprintf(w, "add set declaration\t%s/%s\n", setName, id)
+			}
+		}
+		for _, setName := range sortedKeys(resp.SetDeclarationsRemoved) {
+			for _, id := range resp.SetDeclarationsRemoved[setName] {
+				fmt.Fprintf(w, "remove set declaration\t%s/%s\n", setName, id)
+			}
+		}
+		for _, id := range resp.DeclarationsRemoved {
+			fmt.Fprintf(w, "remove declaration\t%s\n", id)
+		}
+		if resp.Plan {
+			fmt.Fprintln(w, "\nplan only: no changes applied")
+		} else {
+			fmt.Fprintf(w, "\napplied: %t, atomic: %t, notified: %t\n", resp.Applied, resp.Atomic, resp.Notified)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// printer outputs command results as tables or JSON.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+// print outputs v as JSON or, if table is not nil, as a table written by table.
+func (p *printer) print(v interface{}, table func(w *tabwriter.Writer)) error {
+	if p.json || table == nil {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// printRaw outputs raw JSON (such as declarations) indented.
+// Raw JSON is output the same for any output format.
+func (p *printer) printRaw(raw []byte) error {
+	buf := new(bytes.Buffer)
+	if err := json.Indent(buf, raw, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := buf.WriteTo(p.w)
+	return err
+}
+
+// changeResult is the result of a change to a resource.
+type changeResult struct {
+	Resource string `json:"resource"`
+	Changed  bool   `json:"changed"`
+}
+
+// printChanges outputs the results of changes.
+func (p *printer) printChanges(results []changeResult) error {
+	return p.print(results, func(w *tabwriter.Writer) {
+		for _, r := range results {
+			if r.Changed {
+				fmt.Fprintf(w, "%s\tchanged\n", r.Resource)
+			} else {
+				fmt.Fprintf(w, "%s\tunchanged\n", r.Resource)
+			}
+		}
+	})
+}
+
+// printList outputs a list of strings one per line.
+func (p *printer) printList(header string, list []string) error {
+	if list == nil {
+		list = []string{}
+	}
+	return p.print(list, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, header)
+		for _, v := range list {
+			fmt.Fprintln(w, v)
+		}
+	})
+}
+
+// yesOrEmpty returns "yes" if b is true.
+func yesOrEmpty(b bool) string {
+	if b {
+		return "yes"
+	}
+	return ""
+}
+
+// formatTime formats t for tables.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// compactJSON formats v as single-line JSON for tables.
+func compactJSON(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.ReplaceAll(string(b), "\t", " ")
+}
+
+// orDash returns s or "-" if s is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+// sortedKeys returns the sorted enrollment IDs of m.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// enrollmentIDsCommand returns a status command that takes enrollment IDs.
+// The IDs can be separated by commas or given as separate arguments.
+func enrollmentIDsCommand(name, short string, setup func(fs *flag.FlagSet) func(ctx context.Context, e *env, ids []string) error) *command {
+	return &command{
+		name:  name,
+		args:  "<enrollment-id>...",
+		short: short,
+		setup: func(fs *flag.FlagSet) runFunc {
+			run := setup(fs)
+			return func(ctx context.Context, e *env, args []string) error {
+				ids := splitIDs(args)
+				if len(ids) < 1 {
+					return errUsage
+				}
+				return run(ctx, e, ids)
+			}
+		},
+	}
+}
+
+func statusCommand() *command {
+	return &command{
+		name:  "status",
+		short: "query the reported status of enrollments",
+		subcommands: []*command{
+			enrollmentIDsCommand("declarations", "show the declaration status of enrollments", func(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+				return func(ctx context.Context, e *env, ids []string) error {
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					status, err := c.GetDeclarationStatus(ctx, ids)
+					if err != nil {
+						return err
+					}
+					return e.out.print(status, func(w *tabwriter.Writer) {
+						fmt.Fprintln(w, "ENROLLMENT\tIDENTIFIER\tACTIVE\tVALID\tCURRENT\tRECEIVED\tREASONS")
+						for _, id := range sortedKeys(status) {
+							for _, s := range status[id] {
+								fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%t\t%s\t%s\n",
+									id,
+									s.Identifier,
+									s.Active,
+									s.Valid,
+									s.Current,
+									formatTime(s.StatusReceived),
+									compactJSON(s.Reasons),
+								)
+							}
+						}
+					})
+				}
+			}),
+			enrollmentIDsCommand("errors", "show the status errors of enrollments", func(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+				return func(ctx context.Context, e *env, ids []string) error {
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					errs, err := c.GetStatusErrors(ctx, ids)
+					if err != nil {
+						return err
+					}
+					return e.out.print(errs, func(w *tabwriter.Writer) {
+						fmt.Fprintln(w, "ENROLLMENT\tTIMESTAMP\tPATH\tERROR")
+						for _, id := range sortedKeys(errs) {
+							for _, statusErr := range errs[id] {
+								fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+									id,
+									formatTime(statusErr.Timestamp),
+									statusErr.Path,
+									compactJSON(statusErr.Error),
+								)
+							}
+						}
+					})
+				}
+			}),
+			enrollmentIDsCommand("values", "show the status values of enrollments", func(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+				flPrefix := fs.String("prefix", "", "only show status paths with this prefix")
+				return func(ctx context.Context, e *env, ids []string) error {
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					values, err := c.GetStatusValues(ctx, ids, *flPrefix)
+					if err != nil {
+						return err
+					}
+					return e.out.print(values, func(w *tabwriter.Writer) {
+						fmt.Fprintln(w, "ENROLLMENT\tPATH\tVALUE\tTIMESTAMP")
+						for _, id := range sortedKeys(values) {
+							for _, v := range values[id] {
+								fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", id, v.Path, v.Value, formatTime(v.Timestamp))
+							}
+						}
+					})
+				}
+			}),
+			enrollmentIDsCommand("history", "show the declaration status history of enrollments", func(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+				flDeclaration := fs.String("declaration", "", "only show the history of this declaration")
+				return func(ctx context.Context, e *env, ids []string) error {
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					history, err := c.GetDeclarationStatusHistory(ctx, ids, *flDeclaration)
+					if err != nil {
+						return err
+					}
+					return e.out.print(history, func(w *tabwriter.Writer) {
+						fmt.Fprintln(w, "ENROLLMENT\tTIMESTAMP\tIDENTIFIER\tACTIVE\tVALID\tSERVER TOKEN")
+						for _, id := range sortedKeys(history) {
+							for _, ev := range history[id] {
+								fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\n",
+									id,
+									formatTime(ev.Timestamp),
+									ev.Identifier,
+									ev.Active,
+									ev.Valid,
+									ev.ServerToken,
+								)
+							}
+						}
+					})
+				}
+			}),
+			enrollmentIDsCommand("flapping", "show how often declarations of enrollments changed validity", func(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+				flDeclaration := fs.String("declaration", "", "only show this declaration")
+				flThreshold := fs.Int("threshold", 0, "validity changes at which a declaration is flapping (default server default)")
+				return func(ctx context.Context, e *env, ids []string) error {
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					flaps, err := c.GetDeclarationStatusFlapping(ctx, ids, *flDeclaration, *flThreshold)
+					if err != nil {
+						return err
+					}
+					return e.out.print(flaps, func(w *tabwriter.Writer) {
+						fmt.Fprintln(w, "ENROLLMENT\tIDENTIFIER\tFLIPS\tFLAPPING")
+						for _, id := range sortedKeys(flaps) {
+							for _, f := range flaps[id] {
+								fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", id, f.Identifier, f.Flips, yesOrEmpty(f.Flapping))
+							}
+						}
+					})
+				}
+			}),
+			enrollmentIDsCommand("value-history", "show the status value changes of enrollments", func(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+				flPath := fs.String("path", "", "only show changes of this status path")
+				return func(ctx context.Context, e *env, ids []string) error {
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					history, err := c.GetStatusValueHistory(ctx, ids, *flPath)
+					if err != nil {
+						return err
+					}
+					return e.out.print(history, func(w *tabwriter.Writer) {
+						fmt.Fprintln(w, "ENROLLMENT\tTIMESTAMP\tPATH\tOLD VALUE\tNEW VALUE")
+						for _, id := range sortedKeys(history) {
+							for _, ch := range history[id] {
+								fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+									id,
+									formatTime(ch.Timestamp),
+									ch.Path,
+									orDash(ch.OldValue),
+									ch.NewValue,
+								)
+							}
+						}
+					})
+				}
+			}),
+			{
+				name:  "search",
+				short: "find enrollments by status value",
+				setup: func(fs *flag.FlagSet) runFunc {
+					var (
+						flPath  = fs.String("path", "", "status value path (required)")
+						flOp    = fs.String("op", "", "search operator (default eq)")
+						flValue = fs.String("value", "", "status value to search for")
+						flAfter = fs.String("after", "", "enrollment ID to search after (for pagination)")
+						flLimit = fs.Int("limit", 0, "maximum number of enrollment IDs (default server default)")
+					)
+					return func(ctx context.Context, e *env, args []string) error {
+						if len(args) > 0 || *flPath == "" {
+							return errUsage
+						}
+						c, err := e.Client()
+						if err != nil {
+							return err
+						}
+						result, err := c.SearchStatusValues(ctx, storage.StatusValueSearch{
+							Path:     *flPath,
+							Operator: *flOp,
+							Value:    *flValue,
+							After:    *flAfter,
+							Limit:    *flLimit,
+						})
+						if err != nil {
+							return err
+						}
+						return e.out.print(result, func(w *tabwriter.Writer) {
+							fmt.Fprintln(w, "ENROLLMENT")
+							for _, id := range result.EnrollmentIDs {
+								fmt.Fprintln(w, id)
+							}
+							if result.Next != "" {
+								fmt.Fprintf(w, "\nmore results: use -after %s\n", result.Next)
+							}
+						})
+					}
+				},
+			},
+			{
+				name:  "unhandled",
+				short: "show status report paths that were not handled",
+				setup: noFlags(func(ctx context.Context, e *env, args []string) error {
+					c, err := e.Client()
+					if err != nil {
+						return err
+					}
+					paths, err := c.GetUnhandledStatusPaths(ctx)
+					if err != nil {
+						return err
+					}
+					return e.out.print(paths, func(w *tabwriter.Writer) {
+						fmt.Fprintln(w, "PATH\tCOUNT\tFIRST SEEN\tLAST SEEN")
+						for _, p := range paths {
+							fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", p.Path, p.Count, formatTime(p.FirstSeen), formatTime(p.LastSeen))
+						}
+					})
+				}),
+			},
+			{
+				name:  "report",
+				args:  "<enrollment-id>",
+				short: "show a raw status report of an enrollment",
+				setup: func(fs *flag.FlagSet) runFunc {
+					var (
+						flIndex    = fs.Int("index", 0, "index of the status report (0 is the latest)")
+						flStatusID = fs.String("status-id", "", "status ID of the status report (overrides -index)")
+					)
+					return func(ctx context.Context, e *env, args []string) error {
+						if len(args) != 1 {
+							return errUsage
+						}
+						c, err := e.Client()
+						if err != nil {
+							return err
+						}
+						q := storage.StatusReportQuery{EnrollmentID: args[0]}
+						if *flStatusID != "" {
+							q.StatusID = flStatusID
+						} else {
+							q.Index = flIndex
+						}
+						report, err := c.GetStatusReport(ctx, q)
+						if err != nil {
+							return err
+						}
+						return e.out.printRaw(report.Raw)
+					}
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jessepeterson/kmfddm/client"
+	"github.com/jessepeterson/kmfddm/http/api"
+)
+
+// batchRequest returns the batch operations that upload the declarations
+// of l and add (or remove) the declarations of each set.
+func (l *localDir) batchRequest() *api.BatchRequest {
+	req := new(api.BatchRequest)
+	for _, d := range l.declarations {
+		req.Operations = append(req.Operations, api.BatchOperation{
+			Op:          api.BatchPutDeclaration,
+			Declaration: d.raw,
+		})
+	}
+	for _, s := range l.sets {
+		for _, id := range s.add {
+			req.Operations = append(req.Operations, api.BatchOperation{
+				Op:            api.BatchPutSetDeclaration,
+				Set:           s.name,
+				DeclarationID: id,
+			})
+		}
+		for _, id := range s.remove {
+			req.Operations = append(req.Operations, api.BatchOperation{
+				Op:            api.BatchDeleteSetDeclaration,
+				Set:           s.name,
+				DeclarationID: id,
+			})
+		}
+	}
+	return req
+}
+
+// state returns the desired state of l.
+// Set declarations to remove are simply not part of the desired state.
+func (l *localDir) state() *api.State {
+	state := &api.State{
+		Declarations: []json.RawMessage{},
+		Sets:         make(map[string][]string),
+	}
+	for _, d := range l.declarations {
+		state.Declarations = append(state.Declarations, d.raw)
+	}
+	for _, s := range l.sets {
+		state.Sets[s.name] = append([]string{}, s.add...)
+	}
+	return state
+}
+
+func syncCommand() *command {
+	return &command{
+		name:  "sync",
+		args:  "<dir>",
+		short: "sync declaration and set files in a directory to the server",
+		setup: func(fs *flag.FlagSet) runFunc {
+			opts := changeFlags(fs)
+			var (
+				flDelete  = fs.Bool("delete", false, "also remove declarations and set declarations not in the directory")
+				flPrefix  = fs.String("prefix", "", "with -delete only sync sets with this prefix (and their declarations)")
+				flPlan    = fs.Bool("plan", false, "with -delete only show the changes")
+				flConfirm = fs.Bool("confirm", false, "with -delete allow an empty directory to remove every declaration")
+			)
+			return func(ctx context.Context, e *env, args []string) error {
+				if len(args) != 1 {
+					return errUsage
+				}
+				if !*flDelete && (*flPrefix != "" || *flPlan || *flConfirm) {
+					return errors.New("-prefix, -plan, and -confirm require -delete")
+				}
+				l, probs, err := loadDir(args[0])
+				if err != nil {
+					return err
+				}
+				for _, p := range probs {
+					fmt.Fprintln(os.Stderr, p)
+				}
+				if n := probs.errors(); n > 0 {
+					return fmt.Errorf("%d error(s) found: not syncing", n)
+				}
+				c, err := e.Client()
+				if err != nil {
+					return err
+				}
+				if *flDelete {
+					opts := opts()
+					if *flConfirm {
+						opts = append(opts, client.Confirm())
+					}
+					return syncState(ctx, e, c, l, *flPrefix, *flPlan, opts)
+				}
+				req := l.batchRequest()
+				if len(req.Operations) < 1 {
+					return errors.New("no declaration or set files found")
+				}
+				resp, err := c.Batch(ctx, req, opts()...)
+				if len(resp.Results) > 0 {
+					if pErr := printBatchResponse(e.out, req, resp); pErr != nil {
+						return pErr
+					}
+				}
+				return err
+			}
+		},
+	}
+}
+
+// syncState replaces the declarations and sets on the server with l.
+func syncState(ctx context.Context, e *env, c *client.Client, l *localDir, prefix string, plan bool, opts []client.RequestOption) error {
+	resp, err := c.PutState(ctx, l.state(), prefix, plan, opts...)
+	if err != nil && resp.Error == "" {
+		return err
+	}
+	if pErr := printStateResponse(e.out, resp); pErr != nil {
+		return pErr
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"hash"
+	"hash/fnv"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jessepeterson/kmfddm/http/api"
+	"github.com/jessepeterson/kmfddm/storage/inmem"
+
+	"github.com/alexedwards/flow"
+	"github.com/micromdm/nanolib/log"
+)
+
+type nopNotifier struct{}
+
+func (nopNotifier) Changed(context.Context, []string, []string, []string) error { return nil }
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testDecl(id string) string {
+	return `{"Type":"com.apple.configuration.management.test","Identifier":"` + id + `","Payload":{"Echo":"` + id + `"}}`
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a/test1.json":      testDecl("test1"),
+		"a/test1-dup.json":  testDecl("test1"),
+		"b/invalid.json":    `{"Type":`,
+		"b/nopayload.json":  `{"Type":"com.apple.configuration.management.test","Identifier":"test2"}`,
+		"b/notapple.json":   `{"Type":"com.example.test","Identifier":"test3","Payload":{}}`,
+		"c/set.default.txt": "# comment\ntest1\n\n-test2\ntest4\ntest1\n",
+		"c/readme.txt":      "ignored",
+	})
+
+	l, probs, err := loadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var have []string
+	for _, p := range probs {
+		have = append(have, strings.TrimPrefix(p.String(), dir+string(filepath.Separator)))
+	}
+	sort.Strings(have)
+	want := []string{
+		"a/test1.json: error: duplicate declaration test1: also in " + filepath.Join(dir, "a/test1-dup.json"),
+		"b/invalid.json: error: invalid JSON: unexpected end of JSON input",
+		"b/nopayload.json: error: missing Payload",
+		"b/notapple.json: warning: Type is not an Apple declaration type: com.example.test",
+		"c/set.default.txt: warning: declaration not in directory: test4",
+		"c/set.default.txt:6: error: duplicate declaration: test1",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("problems:\nhave: %q\nwant: %q", have, want)
+	}
+	if have, want := probs.errors(), 4; have != want {
+		t.Errorf("errors: have: %d, want: %d", have, want)
+	}
+
+	if have, want := len(l.declarations), 2; have != want {
+		t.Fatalf("declarations: have: %d, want: %d", have, want)
+	}
+	if have, want := len(l.sets), 1; have != want {
+		t.Fatalf("sets: have: %d, want: %d", have, want)
+	}
+	s := l.sets[0]
+	if s.name != "default" || !reflect.DeepEqual(s.add, []string{"test1", "test4"}) || !reflect.DeepEqual(s.remove, []string{"test2"}) {
+		t.Errorf("unexpected set: %+v", s)
+	}
+}
+
+func TestSync(t *testing.T) {
+	store := inmem.New(func() hash.Hash { return fnv.New128() })
+	mux := flow.New()
+	api.HandleAPIv1("/v1", mux, log.NopLogger, store, nopNotifier{})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	out := new(bytes.Buffer)
+	e := &env{
+		out:     &printer{w: out, json: true},
+		profile: &profile{URL: srv.URL},
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"test_sync_1.json":        testDecl("test_sync_1"),
+		"test_sync_2.json":        testDecl("test_sync_2"),
+		"set.test_sync_set.txt":   "test_sync_1\ntest_sync_2\n",
+		"set.test_sync_other.txt": "test_sync_2\n",
+	})
+	if err := runCommand(ctx, e, "kmfddmctl", commands(), []string{"sync", dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := store.RetrieveSetDeclarations(ctx, "test_sync_set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(ids)
+	if have, want := ids, []string{"test_sync_1", "test_sync_2"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("set declarations: have: %v, want: %v", have, want)
+	}
+
+	// remove a declaration and a set file and sync with deletion
+	if err = os.Remove(filepath.Join(dir, "test_sync_1.json")); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{"set.test_sync_set.txt": "test_sync_2\n"})
+	if err = os.Remove(filepath.Join(dir, "set.test_sync_other.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	// plan first; nothing should change
+	if err = runCommand(ctx, e, "kmfddmctl", commands(), []string{"sync", "-delete", "-plan", dir}); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err = store.RetrieveDeclarations(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 2 {
+		t.Errorf("declarations changed by plan: %v", ids)
+	}
+
+	if err = runCommand(ctx, e, "kmfddmctl", commands(), []string{"sync", "-delete", dir}); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err = store.RetrieveDeclarations(ctx); err != nil {
+		t.Fatal(err)
+	} else if have, want := ids, []string{"test_sync_2"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("declarations: have: %v, want: %v", have, want)
+	}
+	if ids, err = store.RetrieveSetDeclarations(ctx, "test_sync_other"); err != nil {
+		t.Fatal(err)
+	} else if len(ids) > 0 {
+		t.Errorf("expected removed set declarations: %v", ids)
+	}
+}
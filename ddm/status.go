@@ -64,6 +64,9 @@ type StatusReport struct {
 	// the "raw" status report values not otherwise parsed
 	Values []StatusValue
 
+	// status report paths that no handler parsed
+	Unhandled []string
+
 	// the raw JSON bytes of the status report
 	Raw []byte
 }
@@ -228,5 +231,6 @@ func ParseStatus(raw []byte) ([]string, *StatusReport, error) {
 	mux := jsonpath.NewPathMux()
 	RegisterStatusHandlers(mux, s)
 	unhandled, err := ParseStatusUsingMux(s.Raw, mux)
+	s.Unhandled = unhandled
 	return unhandled, s, err
 }
@@ -4,14 +4,18 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
 
 	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/storage"
 
 	"github.com/alexedwards/flow"
 	"github.com/micromdm/nanolib/log"
@@ -61,6 +65,43 @@ func jsonErrorAndLog(w http.ResponseWriter, status int, err error, msg string, l
 	}
 }
 
+// readAndReplaceBody reads the request body and replaces it so that
+// it can be read again by the next handler.
+func readAndReplaceBody(r *http.Request) ([]byte, error) {
+	b, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	return b, err
+}
+
+// decodeJSONBody decodes the JSON request body into v.
+// Unlike a streaming decoder any data after the JSON value is an error.
+// Decoding errors wrap errInvalidBody. The body can be read again by the next handler.
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	b, err := readAndReplaceBody(r)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidBody, err)
+	}
+	return nil
+}
+
+// tooLarge reports whether err is from reading a request body
+// larger than allowed by [http.MaxBytesReader].
+func tooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// bodyErrorStatus returns the HTTP status for err from reading or decoding the request body.
+func bodyErrorStatus(err error) int {
+	if tooLarge(err) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
 func boolish(s string) bool {
 	switch strings.ToLower(s) {
 	case "", "0", "false", "no", "off":
@@ -95,7 +136,10 @@ func simpleJSONResourceHandler(logger log.Logger, dataFn dataFunc) http.HandlerF
 			return
 		}
 		data, err := dataFn(r.Context(), resource, r.URL)
-		if err != nil {
+		if errors.Is(err, storage.ErrStatusHistoryDisabled) {
+			jsonErrorAndLog(w, http.StatusNotFound, err, "retrieving data", logger)
+			return
+		} else if err != nil {
 			jsonErrorAndLog(w, http.StatusInternalServerError, err, "retrieving data", logger)
 			return
 		}
@@ -0,0 +1,458 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+var (
+	ErrForbidden = errors.New("forbidden")
+
+	// errInvalidBody is returned by scope functions that cannot parse the request body.
+	errInvalidBody = errors.New("invalid request body")
+)
+
+// Role is the role of an API credential.
+type Role string
+
+const (
+	// RoleReadOnly can read declarations, sets, enrollment sets, status, and notifications.
+	RoleReadOnly Role = "read-only"
+
+	// RoleStatusReader can only read status.
+	RoleStatusReader Role = "status-reader"
+
+	// RoleDeclarationEditor can read and change declarations, sets, and enrollment sets.
+	RoleDeclarationEditor Role = "declaration-editor"
+
+	// RoleAdmin can use every API endpoint.
+	RoleAdmin Role = "admin"
+)
+
+// Permission is what an API endpoint requires of the role of a credential.
+type Permission int
+
+const (
+	// PermissionRead reads declarations, sets, enrollment sets, and notifications.
+	PermissionRead Permission = iota
+
+	// PermissionStatus reads status.
+	PermissionStatus
+
+	// PermissionEdit changes declarations, sets, and enrollment sets.
+	PermissionEdit
+
+	// PermissionNotify notifies enrollments and manages the notification queue.
+	PermissionNotify
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermissionRead:
+		return "read"
+	case PermissionStatus:
+		return "status"
+	case PermissionEdit:
+		return "edit"
+	case PermissionNotify:
+		return "notify"
+	}
+	return fmt.Sprintf("Permission(%d)", int(p))
+}
+
+// Valid returns true if r is a known role.
+func (r Role) Valid() bool {
+	switch r {
+	case RoleReadOnly, RoleStatusReader, RoleDeclarationEditor, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// Allows returns true if r has permission p.
+func (r Role) Allows(p Permission) bool {
+	switch r {
+	case RoleAdmin:
+		return true
+	case RoleDeclarationEditor:
+		return p == PermissionRead || p == PermissionStatus || p == PermissionEdit
+	case RoleReadOnly:
+		return p == PermissionRead || p == PermissionStatus
+	case RoleStatusReader:
+		return p == PermissionStatus
+	}
+	return false
+}
+
+// Credential is a named API key with a role.
+type Credential struct {
+	Name   string `json:"name"`
+	APIKey string `json:"api_key"`
+	Role   Role   `json:"role"`
+
+	// SetPrefixes limits changes to sets with any of these prefixes.
+	// Changes to declarations are limited to declarations that are
+	// only in those sets. Reads are not limited.
+	SetPrefixes []string `json:"set_prefixes,omitempty"`
+}
+
+// Validate checks c for a name, API key, and a valid role.
+func (c *Credential) Validate() error {
+	if c.Name == "" {
+		return errors.New("empty name")
+	}
+	if c.APIKey == "" {
+		return errors.New("empty API key")
+	}
+	if !c.Role.Valid() {
+		return fmt.Errorf("invalid role: %q", c.Role)
+	}
+	for _, prefix := range c.SetPrefixes {
+		if prefix == "" {
+			return errors.New("empty set prefix")
+		}
+	}
+	return nil
+}
+
+// Scoped returns true if c is limited to set prefixes.
+func (c *Credential) Scoped() bool {
+	return len(c.SetPrefixes) > 0
+}
+
+// InScope returns true if setName has one of the set prefixes of c.
+// All sets are in scope if c is not scoped.
+func (c *Credential) InScope(setName string) bool {
+	if !c.Scoped() {
+		return true
+	}
+	for _, prefix := range c.SetPrefixes {
+		if strings.HasPrefix(setName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type credentialCtxKey struct{}
+
+// NewContext returns a new context with the API credential c attached.
+// The credential name is added to context logging.
+func NewContext(ctx context.Context, c *Credential) context.Context {
+	ctx = context.WithValue(ctx, credentialCtxKey{}, c)
+	return ctxlog.AddFunc(ctx, func(ctx context.Context) []interface{} {
+		if c := FromContext(ctx); c != nil {
+			return []interface{}{logkeys.Credential, c.Name}
+		}
+		return nil
+	})
+}
+
+// FromContext returns the API credential attached to ctx or nil if none.
+func FromContext(ctx context.Context) *Credential {
+	c, _ := ctx.Value(credentialCtxKey{}).(*Credential)
+	return c
+}
+
+// MatchCredential returns the index of the credential whose API key is
+// password or -1 if none match. Every credential is compared in constant
+// time to avoid leaking which credential matched by timing.
+func MatchCredential(creds []*Credential, password string) int {
+	pbc := []byte(password)
+	idx := -1
+	for i, c := range creds {
+		if c.APIKey == "" {
+			// never allow empty API keys to authenticate
+			continue
+		}
+		if subtle.ConstantTimeCompare(pbc, []byte(c.APIKey)) == 1 {
+			idx = i
+		}
+	}
+	return idx
+}
+
+// CredentialsHandler creates a handler that authenticates HTTP Basic
+// requests against the API keys of creds. The matching credential is
+// attached to the request context for authorization by the API handlers.
+func CredentialsHandler(next http.Handler, username, realm string, creds []*Credential) http.HandlerFunc {
+	if next == nil {
+		panic("nil handler")
+	}
+	ubc := []byte(username)
+	rc := `Basic realm="` + realm + `"`
+	return func(w http.ResponseWriter, r *http.Request) {
+		idx := -1
+		if u, p, ok := r.BasicAuth(); ok && subtle.ConstantTimeCompare([]byte(u), ubc) == 1 {
+			idx = MatchCredential(creds, p)
+		}
+		if idx < 0 {
+			w.Header().Set("Www-Authenticate", rc)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), creds[idx])))
+	}
+}
+
+// scopeFunc returns the set names that a request changes.
+// Errors wrapping ErrForbidden deny the request and errors wrapping
+// errInvalidBody reject it.
+type scopeFunc func(r *http.Request, c *Credential) ([]string, error)
+
+// authorize returns a handler that checks the request's credential
+// has permission p before calling next.
+// If the credential is scoped to set prefixes then changes must be
+// in scope as determined by scope. Scoped credentials are denied
+// changes to endpoints without a scope.
+// Authorized changes are logged with the credential name for auditing.
+// Requests without a credential in the context are not checked: the
+// API may be protected by other authentication.
+func authorize(p Permission, scope scopeFunc, next http.Handler, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := FromContext(r.Context())
+		if c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logger := ctxlog.Logger(r.Context(), logger).With(
+			logkeys.Role, c.Role,
+			"method", r.Method,
+			"path", r.URL.Path,
+		)
+		if !c.Role.Allows(p) {
+			jsonErrorAndLog(w, http.StatusForbidden, fmt.Errorf("%w: role %s lacks %s permission", ErrForbidden, c.Role, p), "authorizing", logger)
+			return
+		}
+		if p == PermissionEdit || p == PermissionNotify {
+			if c.Scoped() {
+				if scope == nil {
+					jsonErrorAndLog(w, http.StatusForbidden, fmt.Errorf("%w: endpoint not available to set prefix scoped credentials", ErrForbidden), "authorizing", logger)
+					return
+				}
+				setNames, err := scope(r, c)
+				if err == nil {
+					for _, setName := range setNames {
+						if !c.InScope(setName) {
+							err = fmt.Errorf("%w: set not in scope: %s", ErrForbidden, setName)
+							break
+						}
+					}
+				}
+				if errors.Is(err, ErrForbidden) {
+					jsonErrorAndLog(w, http.StatusForbidden, err, "authorizing", logger)
+					return
+				} else if tooLarge(err) {
+					jsonErrorAndLog(w, http.StatusRequestEntityTooLarge, err, "authorizing", logger)
+					return
+				} else if errors.Is(err, errInvalidBody) {
+					jsonErrorAndLog(w, http.StatusBadRequest, err, "authorizing", logger)
+					return
+				} else if err != nil {
+					jsonErrorAndLog(w, 0, err, "authorizing", logger)
+					return
+				}
+			}
+			logger.Info(logkeys.Message, "authorized change", "query", r.URL.RawQuery)
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// scopeStorage is required for scoping changes to sets.
+type scopeStorage interface {
+	storage.DeclarationAPIRetriever
+	storage.DeclarationSetRetriever
+	storage.EnrollmentSetsRetriever
+}
+
+// declarationSets retrieves the sets of each declaration.
+func declarationSets(ctx context.Context, store storage.DeclarationSetRetriever, declarationIDs ...string) ([]string, error) {
+	var setNames []string
+	for _, declarationID := range declarationIDs {
+		declSets, err := store.RetrieveDeclarationSets(ctx, declarationID)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving declaration sets: %w", err)
+		}
+		setNames = append(setNames, declSets...)
+	}
+	return setNames, nil
+}
+
+// changeDeclarationSets retrieves the sets of each declaration to be changed.
+// Existing declarations that are in no sets are in no scope and are
+// forbidden. Declarations that do not exist yet may be created.
+func changeDeclarationSets(ctx context.Context, store scopeStorage, declarationIDs ...string) ([]string, error) {
+	var setNames []string
+	for _, declarationID := range declarationIDs {
+		declSets, err := declarationSets(ctx, store, declarationID)
+		if err != nil {
+			return nil, err
+		}
+		if len(declSets) < 1 {
+			_, err = store.RetrieveDeclaration(ctx, declarationID)
+			if err == nil {
+				return nil, fmt.Errorf("%w: declaration not in any set: %s", ErrForbidden, declarationID)
+			} else if !errors.Is(err, storage.ErrDeclarationNotFound) {
+				return nil, fmt.Errorf("retrieving declaration: %w", err)
+			}
+		}
+		setNames = append(setNames, declSets...)
+	}
+	return setNames, nil
+}
+
+// enrollmentSets retrieves the sets of enrollment ID id.
+func enrollmentSets(ctx context.Context, store storage.EnrollmentSetsRetriever, id string) ([]string, error) {
+	setNames, err := store.RetrieveEnrollmentSets(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving enrollment sets: %w", err)
+	}
+	return setNames, nil
+}
+
+// setDeclarationScope is the set of the resource ID of the request and
+// the sets of the declaration of the "declaration" query parameter.
+func setDeclarationScope(store scopeStorage) scopeFunc {
+	return func(r *http.Request, _ *Credential) ([]string, error) {
+		setNames, err := changeDeclarationSets(r.Context(), store, r.URL.Query().Get("declaration"))
+		if err != nil {
+			return nil, err
+		}
+		return append(setNames, getResourceID(r)), nil
+	}
+}
+
+// enrollmentSetScope is the set of the "set" query parameter of the
+// request and the sets of the enrollment of the resource ID.
+func enrollmentSetScope(store storage.EnrollmentSetsRetriever) scopeFunc {
+	return func(r *http.Request, _ *Credential) ([]string, error) {
+		setNames, err := enrollmentSets(r.Context(), store, getResourceID(r))
+		if err != nil {
+			return nil, err
+		}
+		return append(setNames, r.URL.Query().Get("set")), nil
+	}
+}
+
+// declarationScope is the sets of the declaration of the resource ID of the request.
+func declarationScope(store scopeStorage) scopeFunc {
+	return func(r *http.Request, _ *Credential) ([]string, error) {
+		return changeDeclarationSets(r.Context(), store, getResourceID(r))
+	}
+}
+
+// declarationBodyScope is the sets of the declaration in the request body.
+func declarationBodyScope(store scopeStorage) scopeFunc {
+	return func(r *http.Request, _ *Credential) ([]string, error) {
+		b, err := readAndReplaceBody(r)
+		if err != nil {
+			return nil, err
+		}
+		d, err := ddm.ParseDeclaration(b)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidBody, err)
+		}
+		return changeDeclarationSets(r.Context(), store, d.Identifier)
+	}
+}
+
+// enrollmentScope is the sets of the enrollment of the resource ID of the request.
+func enrollmentScope(store storage.EnrollmentSetsRetriever) scopeFunc {
+	return func(r *http.Request, _ *Credential) ([]string, error) {
+		return enrollmentSets(r.Context(), store, getResourceID(r))
+	}
+}
+
+// batchScope is the sets changed by each operation in the batch request body.
+func batchScope(store scopeStorage) scopeFunc {
+	return func(r *http.Request, _ *Credential) ([]string, error) {
+		req := new(BatchRequest)
+		if err := decodeJSONBody(r, req); err != nil {
+			return nil, err
+		}
+		var setNames []string
+		for _, op := range req.Operations {
+			var opSets []string
+			var err error
+			switch op.Op {
+			case BatchPutDeclaration:
+				var d *ddm.Declaration
+				if d, err = ddm.ParseDeclaration(op.Declaration); err != nil {
+					return nil, fmt.Errorf("%w: %v", errInvalidBody, err)
+				}
+				opSets, err = changeDeclarationSets(r.Context(), store, d.Identifier)
+			case BatchDeleteDeclaration, BatchTouchDeclaration:
+				opSets, err = changeDeclarationSets(r.Context(), store, op.DeclarationID)
+			case BatchPutSetDeclaration, BatchDeleteSetDeclaration:
+				opSets, err = changeDeclarationSets(r.Context(), store, op.DeclarationID)
+				opSets = append(opSets, op.Set)
+			case BatchPutEnrollmentSet, BatchDeleteEnrollmentSet:
+				opSets, err = enrollmentSets(r.Context(), store, op.EnrollmentID)
+				opSets = append(opSets, op.Set)
+			case BatchDeleteAllEnrollmentSets:
+				opSets, err = enrollmentSets(r.Context(), store, op.EnrollmentID)
+			}
+			if err != nil {
+				return nil, err
+			}
+			setNames = append(setNames, opSets...)
+		}
+		return setNames, nil
+	}
+}
+
+// stateScope requires a "prefix" query parameter in scope and is the
+// sets of the declarations in the desired state request body.
+func stateScope(store scopeStorage) scopeFunc {
+	return func(r *http.Request, c *Credential) ([]string, error) {
+		prefix := r.URL.Query().Get("prefix")
+		inScope := false
+		for _, scopePrefix := range c.SetPrefixes {
+			inScope = inScope || strings.HasPrefix(prefix, scopePrefix)
+		}
+		if !inScope {
+			return nil, fmt.Errorf("%w: prefix not in scope: %q", ErrForbidden, prefix)
+		}
+		state := new(State)
+		if err := decodeJSONBody(r, state); err != nil {
+			return nil, err
+		}
+		var declarationIDs []string
+		for _, raw := range state.Declarations {
+			d, err := ddm.ParseDeclaration(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", errInvalidBody, err)
+			}
+			declarationIDs = append(declarationIDs, d.Identifier)
+		}
+		return changeDeclarationSets(r.Context(), store, declarationIDs...)
+	}
+}
+
+// notifyScope is the sets and the sets of the declarations to notify.
+// Notifying enrollment IDs is not scoped.
+func notifyScope(store storage.DeclarationSetRetriever) scopeFunc {
+	return func(r *http.Request, _ *Credential) ([]string, error) {
+		q := r.URL.Query()
+		if len(q["id"]) > 0 {
+			return nil, fmt.Errorf("%w: enrollment IDs not available to set prefix scoped credentials", ErrForbidden)
+		}
+		setNames, err := declarationSets(r.Context(), store, q["declaration"]...)
+		if err != nil {
+			return nil, err
+		}
+		return append(setNames, q["set"]...), nil
+	}
+}
@@ -0,0 +1,314 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Batch operations.
+const (
+	BatchPutDeclaration          = "put-declaration"
+	BatchDeleteDeclaration       = "delete-declaration"
+	BatchTouchDeclaration        = "touch-declaration"
+	BatchPutSetDeclaration       = "put-set-declaration"
+	BatchDeleteSetDeclaration    = "delete-set-declaration"
+	BatchPutEnrollmentSet        = "put-enrollment-set"
+	BatchDeleteEnrollmentSet     = "delete-enrollment-set"
+	BatchDeleteAllEnrollmentSets = "delete-all-enrollment-sets"
+)
+
+var ErrInvalidBatchOp = errors.New("invalid batch operation")
+
+// BatchOperation is a single operation of a batch request.
+type BatchOperation struct {
+	Op string `json:"op"`
+
+	// Declaration is the declaration of the put-declaration operation.
+	Declaration json.RawMessage `json:"declaration,omitempty"`
+
+	DeclarationID string `json:"declaration_id,omitempty"`
+	Set           string `json:"set,omitempty"`
+	EnrollmentID  string `json:"enrollment_id,omitempty"`
+
+	// Cascade removes the declaration of the delete-declaration
+	// operation from any sets before deleting it.
+	Cascade bool `json:"cascade,omitempty"`
+
+	d *ddm.Declaration // parsed declaration
+}
+
+// BatchRequest is the request body of the batch handler.
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchResult is the result of a single batch operation.
+type BatchResult struct {
+	Op      string   `json:"op"`
+	Changed bool     `json:"changed"`
+	Skipped bool     `json:"skipped,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Sets    []string `json:"sets,omitempty"`
+}
+
+// BatchResponse is the response body of the batch handler.
+type BatchResponse struct {
+	// Atomic is true if the operations were applied in a single transaction.
+	// If false then the operations before a failed operation remain applied.
+	Atomic bool `json:"atomic"`
+
+	// Applied is true if the changes of the operations are in storage.
+	Applied bool `json:"applied"`
+
+	Notified bool          `json:"notified"`
+	Results  []BatchResult `json:"results"`
+}
+
+// validate checks that op has the fields required for its operation
+// and parses its declaration.
+func (op *BatchOperation) validate() (err error) {
+	switch op.Op {
+	case BatchPutDeclaration:
+		op.d, err = ddm.ParseDeclaration(op.Declaration)
+		if err != nil {
+			return fmt.Errorf("parsing declaration: %w", err)
+		} else if !op.d.Valid() {
+			return ddm.ErrInvalidDeclaration
+		}
+		return nil
+	case BatchDeleteDeclaration, BatchTouchDeclaration:
+		if op.DeclarationID == "" {
+			return errors.New("empty declaration identifier")
+		}
+		return nil
+	case BatchPutSetDeclaration, BatchDeleteSetDeclaration:
+		if op.Set == "" || op.DeclarationID == "" {
+			return errors.New("empty set or declaration identifier")
+		}
+		return nil
+	case BatchPutEnrollmentSet, BatchDeleteEnrollmentSet:
+		if op.EnrollmentID == "" || op.Set == "" {
+			return errors.New("empty enrollment ID or set")
+		}
+		return nil
+	case BatchDeleteAllEnrollmentSets:
+		if op.EnrollmentID == "" {
+			return errors.New("empty enrollment ID")
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidBatchOp, op.Op)
+}
+
+// batchChanges accumulates the changes of applied batch operations for notification.
+type batchChanges struct {
+	declarations []string
+	sets         []string
+	ids          []string
+
+	// applied is true if any operation was applied.
+	applied bool
+}
+
+func (c *batchChanges) empty() bool {
+	return len(c.declarations) < 1 && len(c.sets) < 1 && len(c.ids) < 1
+}
+
+// notify notifies the enrollments affected by c in a single call.
+func (c *batchChanges) notify(ctx context.Context, notifier Notifier) error {
+	return notifier.Changed(
+		ctx,
+		uniqueSorted(c.declarations),
+		uniqueSorted(c.sets),
+		uniqueSorted(c.ids),
+	)
+}
+
+// applyBatch applies the validated ops to store and records their results.
+// If store implements [storage.Transactor] then ops are applied in a
+// single transaction. Otherwise ops are applied in order until one fails.
+// Operations after a failed operation are marked skipped.
+// The returned changes are empty if nothing remains applied.
+func applyBatch(ctx context.Context, store storage.BatchStorage, ops []BatchOperation, results []BatchResult) (*batchChanges, error) {
+	var changes *batchChanges
+	failed := -1
+	applyAll := func(ctx context.Context, store storage.BatchStorage) error {
+		changes = new(batchChanges)
+		for i := range ops {
+			results[i] = BatchResult{Op: ops[i].Op}
+			if err := changes.apply(ctx, store, &ops[i], &results[i]); err != nil {
+				failed = i
+				results[i].Error = err.Error()
+				return fmt.Errorf("operation %d: %w", i, err)
+			}
+			changes.applied = true
+		}
+		return nil
+	}
+	var err error
+	txStore, atomic := store.(storage.Transactor)
+	if atomic {
+		err = txStore.InTx(ctx, applyAll)
+	} else {
+		err = applyAll(ctx, store)
+	}
+	if err == nil {
+		return changes, nil
+	}
+	if changes == nil || atomic {
+		// nothing was applied
+		changes = new(batchChanges)
+	}
+	if failed < 0 {
+		// the transaction itself failed
+		failed = len(ops)
+	}
+	for i := failed + 1; i < len(ops); i++ {
+		results[i].Skipped = true
+	}
+	return changes, err
+}
+
+// apply applies op to store and records its changes in c.
+func (c *batchChanges) apply(ctx context.Context, store storage.BatchStorage, op *BatchOperation, result *BatchResult) (err error) {
+	switch op.Op {
+	case BatchPutDeclaration:
+		if result.Changed, err = store.StoreDeclaration(ctx, op.d); err == nil && result.Changed {
+			c.declarations = append(c.declarations, op.d.Identifier)
+		}
+	case BatchDeleteDeclaration:
+		if op.Cascade {
+			result.Sets, result.Changed, err = store.DeleteDeclarationCascade(ctx, op.DeclarationID)
+			if err == nil && result.Changed {
+				c.sets = append(c.sets, result.Sets...)
+			}
+		} else {
+			result.Changed, err = store.DeleteDeclaration(ctx, op.DeclarationID)
+		}
+	case BatchTouchDeclaration:
+		if err = store.TouchDeclaration(ctx, op.DeclarationID); err == nil {
+			result.Changed = true
+			c.declarations = append(c.declarations, op.DeclarationID)
+		}
+	case BatchPutSetDeclaration:
+		if result.Changed, err = store.StoreSetDeclaration(ctx, op.Set, op.DeclarationID); err == nil && result.Changed {
+			c.sets = append(c.sets, op.Set)
+		}
+	case BatchDeleteSetDeclaration:
+		if result.Changed, err = store.RemoveSetDeclaration(ctx, op.Set, op.DeclarationID); err == nil && result.Changed {
+			c.sets = append(c.sets, op.Set)
+		}
+	case BatchPutEnrollmentSet:
+		if result.Changed, err = store.StoreEnrollmentSet(ctx, op.EnrollmentID, op.Set); err == nil && result.Changed {
+			c.ids = append(c.ids, op.EnrollmentID)
+		}
+	case BatchDeleteEnrollmentSet:
+		if result.Changed, err = store.RemoveEnrollmentSet(ctx, op.EnrollmentID, op.Set); err == nil && result.Changed {
+			c.ids = append(c.ids, op.EnrollmentID)
+		}
+	case BatchDeleteAllEnrollmentSets:
+		if result.Changed, err = store.RemoveAllEnrollmentSets(ctx, op.EnrollmentID); err == nil && result.Changed {
+			c.ids = append(c.ids, op.EnrollmentID)
+		}
+	default:
+		err = fmt.Errorf("%w: %q", ErrInvalidBatchOp, op.Op)
+	}
+	return
+}
+
+// batchErrorStatus returns the HTTP status for a failed batch operation.
+func batchErrorStatus(err error) int {
+	if errors.Is(err, storage.ErrDeclarationInUse) {
+		return http.StatusConflict
+	} else if errors.Is(err, storage.ErrDeclarationNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// BatchHandler returns a handler that applies a list of operations
+// on declarations, set declarations, and enrollment sets.
+// If store implements [storage.Transactor] then the operations are
+// applied in a single transaction and none of them are applied if any
+// fail. Otherwise the operations are applied in order until one fails.
+// The enrollments affected by the applied changes are notified once.
+func BatchHandler(store storage.BatchStorage, notifier Notifier, logger log.Logger) http.HandlerFunc {
+	if store == nil || notifier == nil || logger == nil {
+		panic("nil store or notifier or logger")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		req := new(BatchRequest)
+		if err := decodeJSONBody(r, req); err != nil {
+			jsonErrorAndLog(w, bodyErrorStatus(err), err, "decoding body", logger)
+			return
+		}
+		if len(req.Operations) < 1 {
+			jsonErrorAndLog(w, http.StatusBadRequest, errors.New("no operations"), "validating input", logger)
+			return
+		}
+
+		_, atomic := store.(storage.Transactor)
+		resp := &BatchResponse{
+			Atomic:  atomic,
+			Results: make([]BatchResult, len(req.Operations)),
+		}
+		var invalid error
+		for i := range req.Operations {
+			resp.Results[i].Op = req.Operations[i].Op
+			if err := req.Operations[i].validate(); err != nil {
+				resp.Results[i].Error = err.Error()
+				if invalid == nil {
+					invalid = fmt.Errorf("operation %d: %w", i, err)
+				}
+			}
+		}
+		if invalid != nil {
+			logger.Info(logkeys.Message, "validating input", logkeys.Error, invalid)
+			if err := jsonResponse(w, http.StatusBadRequest, resp); err != nil {
+				logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+			}
+			return
+		}
+
+		changes, err := applyBatch(r.Context(), store, req.Operations, resp.Results)
+		resp.Applied = err == nil || (!atomic && changes.applied)
+		status := http.StatusOK
+		if err != nil {
+			logger.Info(logkeys.Message, "applying batch", logkeys.Error, err)
+			status = batchErrorStatus(err)
+		}
+
+		notify := shouldNotify(r.URL) && !changes.empty()
+		logger.Debug(
+			logkeys.Message, "applied batch",
+			logkeys.GenericCount, len(req.Operations),
+			"atomic", atomic,
+			logkeys.Notify, notify,
+		)
+		if notify {
+			if nErr := changes.notify(r.Context(), notifier); nErr != nil {
+				logger.Info(logkeys.Message, "notifying", logkeys.Error, nErr)
+				if err == nil {
+					jsonErrorAndLog(w, 0, nErr, "notifying", logger)
+					return
+				}
+			} else {
+				resp.Notified = true
+			}
+		}
+		if err = jsonResponse(w, status, resp); err != nil {
+			logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+		}
+	}
+}
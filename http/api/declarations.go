@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -14,15 +15,40 @@ import (
 	"github.com/micromdm/nanolib/log/ctxlog"
 )
 
+// PutDeclarationStorage is required for storing declarations.
+type PutDeclarationStorage interface {
+	storage.DeclarationStorer
+	storage.DeclarationCASStorer
+	DryRunStorage
+}
+
+// retrieveDeclarationETag retrieves the entity tag of a declaration.
+// An empty entity tag and server token is returned if the declaration does not exist.
+func retrieveDeclarationETag(ctx context.Context, store storage.DeclarationAPIRetriever, declarationID string) (etag string, serverToken string, err error) {
+	d, err := store.RetrieveDeclaration(ctx, declarationID)
+	if errors.Is(err, storage.ErrDeclarationNotFound) {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+	return declarationETag(d.ServerToken), d.ServerToken, nil
+}
+
 // PutDeclarationHandler returns a handler that stores a declaration.
-func PutDeclarationHandler(store storage.DeclarationStorer, notifier Notifier, logger log.Logger) http.HandlerFunc {
+// If the "dryrun" query parameter is set then the change is only simulated.
+// If the If-Match or If-None-Match headers are set then the declaration
+// is only stored if they match the ETag of the stored declaration.
+func PutDeclarationHandler(store PutDeclarationStorage, notifier Notifier, logger log.Logger) http.HandlerFunc {
 	if store == nil || notifier == nil || logger == nil {
 		panic("nil store or notifier or logger")
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger := ctxlog.Logger(r.Context(), logger)
 		bodyBytes, err := io.ReadAll(r.Body)
-		if err != nil {
+		if tooLarge(err) {
+			jsonErrorAndLog(w, http.StatusRequestEntityTooLarge, err, "reading body", logger)
+			return
+		} else if err != nil {
 			jsonErrorAndLog(w, 0, err, "reading body", logger)
 			return
 		}
@@ -39,8 +65,34 @@ func PutDeclarationHandler(store storage.DeclarationStorer, notifier Notifier, l
 			logkeys.DeclarationID, d.Identifier,
 			logkeys.DeclarationType, d.Type,
 		)
-		changed, err := store.StoreDeclaration(r.Context(), d)
-		if err != nil {
+		if dryRun(r.URL) {
+			result, err := dryRunDeclaration(r.Context(), store, d, shouldNotify(r.URL))
+			if err != nil {
+				jsonErrorAndLog(w, 0, err, "dry run", logger)
+				return
+			}
+			dryRunResponse(w, result, logger)
+			return
+		}
+		var changed bool
+		if conditional(r) {
+			etag, serverToken, err := retrieveDeclarationETag(r.Context(), store, d.Identifier)
+			if err != nil {
+				jsonErrorAndLog(w, 0, err, "retrieving declaration", logger)
+				return
+			}
+			if err = checkPreconditions(r, etag); err != nil {
+				jsonErrorAndLog(w, http.StatusPreconditionFailed, err, "checking preconditions", logger)
+				return
+			}
+			changed, err = store.StoreDeclarationCAS(r.Context(), d, serverToken)
+		} else {
+			changed, err = store.StoreDeclaration(r.Context(), d)
+		}
+		if errors.Is(err, storage.ErrServerTokenMismatch) {
+			jsonErrorAndLog(w, http.StatusPreconditionFailed, err, "storing declaration", logger)
+			return
+		} else if err != nil {
 			jsonErrorAndLog(w, 0, err, "storing declaration", logger)
 			return
 		}
@@ -67,6 +119,8 @@ func PutDeclarationHandler(store storage.DeclarationStorer, notifier Notifier, l
 }
 
 // GetDeclarationHandler retrieves a declaration by its identifier.
+// An ETag header derived from the ServerToken is included and the
+// If-None-Match header is honored.
 // The entire request URL path is assumed to contain the declaration identifier.
 // This implies the handler should have the path prefix stripped before use.
 func GetDeclarationHandler(store storage.DeclarationAPIRetriever, logger log.Logger) http.HandlerFunc {
@@ -91,6 +145,12 @@ func GetDeclarationHandler(store storage.DeclarationAPIRetriever, logger log.Log
 			return
 		}
 		logger.Debug(logkeys.Message, "retrieved declaration")
+		etag := declarationETag(d.ServerToken)
+		w.Header().Set("ETag", etag)
+		if notModified(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 		w.Header().Set("Content-Type", jsonContentType)
 		_, err = w.Write(d.Raw)
 		if err != nil {
@@ -100,14 +160,34 @@ func GetDeclarationHandler(store storage.DeclarationAPIRetriever, logger log.Log
 	}
 }
 
+// declarationInUseErrorStruct is encoded and output when deleting a declaration that is in sets.
+type declarationInUseErrorStruct struct {
+	Err  string   `json:"error"`
+	Sets []string `json:"sets"`
+}
+
+// DeleteDeclarationStorage is required for deleting declarations.
+type DeleteDeclarationStorage interface {
+	storage.DeclarationDeleter
+	storage.DeclarationCascadeDeleter
+	storage.DeclarationAPIRetriever
+}
+
 // DeleteDeclarationHandler deletes a declaration by its identifier.
-// We assume that any declaration deleted has no dependant delcarations
-// and is not in any other sets (and so we perform no notifications).
+// A declaration that is in any sets is not deleted and the sets are
+// included in the error response. If the "cascade" query parameter is
+// set then the declaration is first removed from its sets and the
+// enrollments of those sets are notified.
+// If the If-Match or If-None-Match headers are set then the declaration
+// is only deleted if they match the ETag of the stored declaration.
+// Unlike storing declarations this check is best-effort: the ETag is
+// checked before and not atomically with the delete so a concurrent
+// change between the two is not detected.
 // The entire request URL path is assumed to contain the declaration identifier.
 // This implies the handler should have the path prefix stripped before use.
-func DeleteDeclarationHandler(store storage.DeclarationDeleter, logger log.Logger) http.HandlerFunc {
-	if store == nil || logger == nil {
-		panic("nil store or logger")
+func DeleteDeclarationHandler(store DeleteDeclarationStorage, notifier Notifier, logger log.Logger) http.HandlerFunc {
+	if store == nil || notifier == nil || logger == nil {
+		panic("nil store or notifier or logger")
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger := ctxlog.Logger(r.Context(), logger)
@@ -117,19 +197,55 @@ func DeleteDeclarationHandler(store storage.DeclarationDeleter, logger log.Logge
 			return
 		}
 		logger = logger.With(logkeys.DeclarationID, declarationID)
-		changed, err := store.DeleteDeclaration(r.Context(), declarationID)
-		if err != nil {
+		if conditional(r) {
+			etag, _, err := retrieveDeclarationETag(r.Context(), store, declarationID)
+			if err != nil {
+				jsonErrorAndLog(w, 0, err, "retrieving declaration", logger)
+				return
+			}
+			if err = checkPreconditions(r, etag); err != nil {
+				jsonErrorAndLog(w, http.StatusPreconditionFailed, err, "checking preconditions", logger)
+				return
+			}
+		}
+		var sets []string
+		var changed bool
+		var err error
+		if boolish(r.URL.Query().Get("cascade")) {
+			sets, changed, err = store.DeleteDeclarationCascade(r.Context(), declarationID)
+		} else {
+			changed, err = store.DeleteDeclaration(r.Context(), declarationID)
+		}
+		var inUseErr *storage.DeclarationInUseError
+		if errors.As(err, &inUseErr) {
+			logger.Info(logkeys.Message, "deleting declaration", logkeys.Error, err)
+			if err = jsonResponse(w, http.StatusConflict, &declarationInUseErrorStruct{
+				Err:  inUseErr.Error(),
+				Sets: inUseErr.Sets,
+			}); err != nil {
+				logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+			}
+			return
+		} else if err != nil {
 			jsonErrorAndLog(w, 0, err, "deleting declaration", logger)
 			return
 		}
-		logger.Debug(logkeys.Message, "deleted declaration")
-		status := http.StatusNoContent
+		logger.Debug(
+			logkeys.Message, "deleted declaration",
+			logkeys.Changed, changed,
+			"set_count", len(sets),
+		)
 		if !changed {
-			status = http.StatusNotModified
+			w.WriteHeader(http.StatusNotModified)
+			return
 		}
-		w.WriteHeader(status)
-		// TODO: if storage allows any kind of cascaded deletion from in-use
-		// sets then we'll need to notify the associated clients.
+		if len(sets) > 0 && shouldNotify(r.URL) {
+			if err = notifier.Changed(r.Context(), nil, sets, nil); err != nil {
+				jsonErrorAndLog(w, 0, err, "notifying", logger)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
@@ -189,3 +305,32 @@ func TouchDeclarationHandler(store storage.Toucher, notifier Notifier, logger lo
 		}
 	}
 }
+
+// GetDeclarationComplianceHandler returns a handler that aggregates the reported status of a declaration across enrollments.
+func GetDeclarationComplianceHandler(store storage.DeclarationComplianceRetriever, logger log.Logger) http.HandlerFunc {
+	if store == nil || logger == nil {
+		panic("nil store or logger")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		declarationID := getResourceID(r)
+		if declarationID == "" {
+			jsonErrorAndLog(w, http.StatusBadRequest, ErrEmptyResourceID, "validating input", logger)
+			return
+		}
+		logger = logger.With(logkeys.DeclarationID, declarationID)
+		c, err := store.RetrieveDeclarationCompliance(r.Context(), declarationID)
+		if err != nil {
+			statusCode := 0
+			if errors.Is(err, storage.ErrDeclarationNotFound) {
+				statusCode = 404
+			}
+			jsonErrorAndLog(w, statusCode, err, "retrieving declaration compliance", logger)
+			return
+		}
+		logger.Debug(logkeys.Message, "retrieved declaration compliance", logkeys.GenericCount, c.Enrollments)
+		if err = jsonResponse(w, 0, c); err != nil {
+			logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// DryRunStorage is the storage required to simulate changes without making them.
+type DryRunStorage interface {
+	storage.EnrollmentIDRetriever
+	storage.EnrollmentSetsRetriever
+	storage.SetDeclarationsRetriever
+	storage.DeclarationAPIRetriever
+
+	// RetrieveDeclarationItems retrieves the declarations for enrollmentID.
+	RetrieveDeclarationItems(ctx context.Context, enrollmentID string) ([]*ddm.Declaration, error)
+}
+
+// Declaration item changes.
+const (
+	DeclarationAdded   = "added"
+	DeclarationRemoved = "removed"
+	DeclarationChanged = "changed"
+)
+
+// DeclarationChange is a change to the declaration items of an enrollment.
+type DeclarationChange struct {
+	Identifier string `json:"identifier"`
+	Change     string `json:"change"`
+
+	// server tokens before and after the change.
+	// the new server token of a changed declaration is not known until stored.
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// DryRun is the result of simulating a change.
+type DryRun struct {
+	// Changed indicates the stored item would change.
+	Changed bool `json:"changed"`
+
+	// Notify indicates the enrollment IDs would be notified.
+	Notify bool `json:"notify"`
+
+	// EnrollmentIDs are the enrollment IDs affected by the change.
+	EnrollmentIDs []string `json:"enrollment_ids"`
+
+	// Changes are the declaration item changes of each enrollment ID.
+	// Enrollments whose declaration items do not change are not included.
+	Changes map[string][]DeclarationChange `json:"changes,omitempty"`
+}
+
+// uniqueSorted returns the sorted unique strings of s.
+func uniqueSorted(s []string) []string {
+	seen := make(map[string]struct{}, len(s))
+	r := make([]string, 0, len(s))
+	for _, v := range s {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			r = append(r, v)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+func dryRun(u *url.URL) bool {
+	return boolish(u.Query().Get("dryrun"))
+}
+
+// dryRunResponse logs and writes the dry run result to w.
+func dryRunResponse(w http.ResponseWriter, result *DryRun, logger log.Logger) {
+	result.EnrollmentIDs = uniqueSorted(result.EnrollmentIDs)
+	logger.Debug(
+		logkeys.Message, "dry run",
+		logkeys.Changed, result.Changed,
+		logkeys.Notify, result.Notify,
+		logkeys.GenericCount, len(result.EnrollmentIDs),
+	)
+	if err := jsonResponse(w, 0, result); err != nil {
+		logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+	}
+}
+
+type dryRunFunc func(context.Context, string, *url.URL, bool) (*DryRun, error)
+
+// dryRunOrChangeResourceHandler simulates the change with dryFn if the
+// "dryrun" query parameter is set. Otherwise the change is made with chgFn.
+func dryRunOrChangeResourceHandler(logger log.Logger, dryFn dryRunFunc, chgFn changeFunc) http.HandlerFunc {
+	chgHandler := simpleChangeResourceHandler(logger, chgFn)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !dryRun(r.URL) {
+			chgHandler(w, r)
+			return
+		}
+		logger := ctxlog.Logger(r.Context(), logger)
+		resource := getResourceID(r)
+		if resource == "" {
+			jsonErrorAndLog(w, http.StatusBadRequest, ErrEmptyResourceID, "validating input", logger)
+			return
+		}
+		logger = logger.With("resource", resource)
+		result, err := dryFn(r.Context(), resource, r.URL, shouldNotify(r.URL))
+		if err != nil {
+			jsonErrorAndLog(w, 0, err, "dry run", logger)
+			return
+		}
+		dryRunResponse(w, result, logger)
+	}
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEqual reports whether the JSON documents a and b are semantically equal.
+func jsonEqual(a, b []byte) bool {
+	var av, bv interface{}
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+// simulation computes the declaration items of enrollments as if the
+// sets of one enrollment or the declarations of one set were changed.
+type simulation struct {
+	store DryRunStorage
+
+	// replacement sets of enrollmentID
+	enrollmentID   string
+	enrollmentSets []string
+
+	// declarations of each set, including any replacement
+	setCache   map[string][]string
+	tokenCache map[string]string
+}
+
+func newSimulation(store DryRunStorage) *simulation {
+	return &simulation{
+		store:      store,
+		setCache:   make(map[string][]string),
+		tokenCache: make(map[string]string),
+	}
+}
+
+// withEnrollmentSets replaces the sets of enrollmentID with sets.
+func (s *simulation) withEnrollmentSets(enrollmentID string, sets []string) *simulation {
+	s.enrollmentID = enrollmentID
+	s.enrollmentSets = sets
+	return s
+}
+
+// withSetDeclarations replaces the declarations of setName with declarations.
+func (s *simulation) withSetDeclarations(setName string, declarations []string) *simulation {
+	s.setCache[setName] = declarations
+	return s
+}
+
+func (s *simulation) declarations(ctx context.Context, setName string) ([]string, error) {
+	if declarations, ok := s.setCache[setName]; ok {
+		return declarations, nil
+	}
+	declarations, err := s.store.RetrieveSetDeclarations(ctx, setName)
+	if err != nil {
+		return nil, err
+	}
+	s.setCache[setName] = declarations
+	return declarations, nil
+}
+
+// after returns the declaration identifiers of enrollmentID after the change.
+func (s *simulation) after(ctx context.Context, enrollmentID string) (map[string]struct{}, error) {
+	var sets []string
+	if s.enrollmentID != "" && enrollmentID == s.enrollmentID {
+		sets = s.enrollmentSets
+	} else {
+		var err error
+		if sets, err = s.store.RetrieveEnrollmentSets(ctx, enrollmentID); err != nil {
+			return nil, err
+		}
+	}
+	identifiers := make(map[string]struct{})
+	for _, setName := range sets {
+		declarations, err := s.declarations(ctx, setName)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range declarations {
+			identifiers[d] = struct{}{}
+		}
+	}
+	return identifiers, nil
+}
+
+// serverToken returns the current server token of declarationID.
+func (s *simulation) serverToken(ctx context.Context, declarationID string) (string, error) {
+	if token, ok := s.tokenCache[declarationID]; ok {
+		return token, nil
+	}
+	d, err := s.store.RetrieveDeclaration(ctx, declarationID)
+	if errors.Is(err, storage.ErrDeclarationNotFound) {
+		// the change would likely fail but there is no token to report
+	} else if err != nil {
+		return "", err
+	} else {
+		s.tokenCache[declarationID] = d.ServerToken
+	}
+	return s.tokenCache[declarationID], nil
+}
+
+// changes returns the declaration item changes of each enrollment in ids.
+func (s *simulation) changes(ctx context.Context, ids []string) (map[string][]DeclarationChange, error) {
+	changes := make(map[string][]DeclarationChange)
+	for _, id := range ids {
+		items, err := s.store.RetrieveDeclarationItems(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		before := make(map[string]string, len(items))
+		for _, d := range items {
+			before[d.Identifier] = d.ServerToken
+		}
+		after, err := s.after(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		var idChanges []DeclarationChange
+		for d := range after {
+			if _, ok := before[d]; ok {
+				continue
+			}
+			token, err := s.serverToken(ctx, d)
+			if err != nil {
+				return nil, err
+			}
+			idChanges = append(idChanges, DeclarationChange{Identifier: d, Change: DeclarationAdded, After: token})
+		}
+		for d, token := range before {
+			if _, ok := after[d]; !ok {
+				idChanges = append(idChanges, DeclarationChange{Identifier: d, Change: DeclarationRemoved, Before: token})
+			}
+		}
+		if len(idChanges) > 0 {
+			sort.Slice(idChanges, func(i, j int) bool { return idChanges[i].Identifier < idChanges[j].Identifier })
+			changes[id] = idChanges
+		}
+	}
+	return changes, nil
+}
+
+// without returns s without v.
+func without(s []string, v string) []string {
+	r := make([]string, 0, len(s))
+	for _, e := range s {
+		if e != v {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// dryRunDeclaration simulates storing d.
+func dryRunDeclaration(ctx context.Context, store DryRunStorage, d *ddm.Declaration, notify bool) (*DryRun, error) {
+	result := new(DryRun)
+	existing, err := store.RetrieveDeclaration(ctx, d.Identifier)
+	if errors.Is(err, storage.ErrDeclarationNotFound) {
+		result.Changed = true
+	} else if err != nil {
+		return nil, err
+	} else {
+		result.Changed = existing.Type != d.Type || !jsonEqual(existing.Payload, d.Payload)
+	}
+	result.Notify = result.Changed && notify
+	if result.EnrollmentIDs, err = store.RetrieveEnrollmentIDs(ctx, []string{d.Identifier}, nil, nil); err != nil {
+		return nil, err
+	}
+	if result.Changed && existing != nil && len(result.EnrollmentIDs) > 0 {
+		result.Changes = make(map[string][]DeclarationChange)
+		for _, id := range result.EnrollmentIDs {
+			result.Changes[id] = []DeclarationChange{{
+				Identifier: d.Identifier,
+				Change:     DeclarationChanged,
+				Before:     existing.ServerToken,
+			}}
+		}
+	}
+	return result, nil
+}
+
+// dryRunSetDeclaration simulates associating (or dissociating if remove is true) declarationID and setName.
+func dryRunSetDeclaration(ctx context.Context, store DryRunStorage, setName, declarationID string, remove, notify bool) (*DryRun, error) {
+	declarations, err := store.RetrieveSetDeclarations(ctx, setName)
+	if err != nil {
+		return nil, err
+	}
+	result := &DryRun{Changed: contains(declarations, declarationID) == remove}
+	result.Notify = result.Changed && notify
+	if result.EnrollmentIDs, err = store.RetrieveEnrollmentIDs(ctx, nil, []string{setName}, nil); err != nil {
+		return nil, err
+	}
+	if !result.Changed {
+		return result, nil
+	}
+	if remove {
+		declarations = without(declarations, declarationID)
+	} else {
+		declarations = append(declarations, declarationID)
+	}
+	result.Changes, err = newSimulation(store).withSetDeclarations(setName, declarations).changes(ctx, result.EnrollmentIDs)
+	return result, err
+}
+
+// dryRunEnrollmentSet simulates associating (or dissociating if remove is true) setName and enrollmentID.
+// An empty setName with remove true simulates dissociating all sets.
+func dryRunEnrollmentSet(ctx context.Context, store DryRunStorage, enrollmentID, setName string, remove, notify bool) (*DryRun, error) {
+	sets, err := store.RetrieveEnrollmentSets(ctx, enrollmentID)
+	if err != nil {
+		return nil, err
+	}
+	result := new(DryRun)
+	switch {
+	case remove && setName == "":
+		result.Changed = len(sets) > 0
+		sets = nil
+	case remove:
+		result.Changed = contains(sets, setName)
+		sets = without(sets, setName)
+	default:
+		result.Changed = !contains(sets, setName)
+		sets = append(sets, setName)
+	}
+	result.Notify = result.Changed && notify
+	if result.EnrollmentIDs, err = store.RetrieveEnrollmentIDs(ctx, nil, nil, []string{enrollmentID}); err != nil {
+		return nil, err
+	}
+	if !result.Changed {
+		return result, nil
+	}
+	result.Changes, err = newSimulation(store).withEnrollmentSets(enrollmentID, sets).changes(ctx, []string{enrollmentID})
+	return result, err
+}
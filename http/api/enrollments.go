@@ -25,13 +25,27 @@ func GetEnrollmentSetsHandler(store storage.EnrollmentSetsRetriever, logger log.
 	)
 }
 
+// PutEnrollmentSetStorage is required for associating sets to enrollments.
+type PutEnrollmentSetStorage interface {
+	storage.EnrollmentSetStorer
+	DryRunStorage
+}
+
 // PutEnrollmentSetHandler returns a handler that associates a set to an enrollment.
-func PutEnrollmentSetHandler(store storage.EnrollmentSetStorer, notifier Notifier, logger log.Logger) http.HandlerFunc {
+// If the "dryrun" query parameter is set then the change is only simulated.
+func PutEnrollmentSetHandler(store PutEnrollmentSetStorage, notifier Notifier, logger log.Logger) http.HandlerFunc {
 	if store == nil || notifier == nil || logger == nil {
 		panic("nil store or notifier or logger")
 	}
-	return simpleChangeResourceHandler(
+	return dryRunOrChangeResourceHandler(
 		logger,
+		func(ctx context.Context, resource string, u *url.URL, notify bool) (*DryRun, error) {
+			setName := u.Query().Get("set")
+			if setName == "" {
+				return nil, errors.New("empty set name")
+			}
+			return dryRunEnrollmentSet(ctx, store, resource, setName, false, notify)
+		},
 		func(ctx context.Context, resource string, u *url.URL, notify bool) (bool, string, error) {
 			setName := u.Query().Get("set")
 			if setName == "" {
@@ -49,13 +63,27 @@ func PutEnrollmentSetHandler(store storage.EnrollmentSetStorer, notifier Notifie
 	)
 }
 
+// DeleteEnrollmentSetStorage is required for dissociating sets from enrollments.
+type DeleteEnrollmentSetStorage interface {
+	storage.EnrollmentSetRemover
+	DryRunStorage
+}
+
 // DeleteEnrollmentSetHandler returns a handler that dissociates a set from an enrollment.
-func DeleteEnrollmentSetHandler(store storage.EnrollmentSetRemover, notifier Notifier, logger log.Logger) http.HandlerFunc {
+// If the "dryrun" query parameter is set then the change is only simulated.
+func DeleteEnrollmentSetHandler(store DeleteEnrollmentSetStorage, notifier Notifier, logger log.Logger) http.HandlerFunc {
 	if store == nil || notifier == nil || logger == nil {
 		panic("nil store or notifier or logger")
 	}
-	return simpleChangeResourceHandler(
+	return dryRunOrChangeResourceHandler(
 		logger,
+		func(ctx context.Context, resource string, u *url.URL, notify bool) (*DryRun, error) {
+			setName := u.Query().Get("set")
+			if setName == "" {
+				return nil, errors.New("empty set name")
+			}
+			return dryRunEnrollmentSet(ctx, store, resource, setName, true, notify)
+		},
 		func(ctx context.Context, resource string, u *url.URL, notify bool) (bool, string, error) {
 			setName := u.Query().Get("set")
 			if setName == "" {
@@ -74,12 +102,16 @@ func DeleteEnrollmentSetHandler(store storage.EnrollmentSetRemover, notifier Not
 }
 
 // DeleteAllEnrollmentSetsHandler returns a handler that dissociates all sets from an enrollment.
-func DeleteAllEnrollmentSetsHandler(store storage.EnrollmentSetRemover, notifier Notifier, logger log.Logger) http.HandlerFunc {
+// If the "dryrun" query parameter is set then the change is only simulated.
+func DeleteAllEnrollmentSetsHandler(store DeleteEnrollmentSetStorage, notifier Notifier, logger log.Logger) http.HandlerFunc {
 	if store == nil || notifier == nil || logger == nil {
 		panic("nil store or notifier or logger")
 	}
-	return simpleChangeResourceHandler(
+	return dryRunOrChangeResourceHandler(
 		logger,
+		func(ctx context.Context, resource string, _ *url.URL, notify bool) (*DryRun, error) {
+			return dryRunEnrollmentSet(ctx, store, resource, "", true, notify)
+		},
 		func(ctx context.Context, resource string, u *url.URL, notify bool) (bool, string, error) {
 			changed, err := store.RemoveAllEnrollmentSets(ctx, resource)
 			if err == nil && changed && notify {
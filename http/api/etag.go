@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// ErrPreconditionFailed is returned when an If-Match or If-None-Match
+// request header does not match the current resource.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// declarationETag returns the entity tag of a declaration with serverToken.
+func declarationETag(serverToken string) string {
+	return `"` + serverToken + `"`
+}
+
+// setETag returns the entity tag of a set with declarationIDs.
+func setETag(declarationIDs []string) string {
+	ids := append([]string(nil), declarationIDs...)
+	sort.Strings(ids)
+	return fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(strings.Join(ids, "\n"))))
+}
+
+// conditional reports whether r has an If-Match or If-None-Match header.
+func conditional(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != ""
+}
+
+// etagsMatch reports whether the comma-separated list of entity tags in
+// header contains etag or "*". Weak comparison is used.
+func etagsMatch(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions checks the If-Match and If-None-Match headers of r
+// against the current etag of a resource for a change request.
+// An empty etag means the resource does not exist.
+func checkPreconditions(r *http.Request, etag string) error {
+	if h := r.Header.Get("If-Match"); h != "" && (etag == "" || !etagsMatch(h, etag)) {
+		return fmt.Errorf("%w: If-Match", ErrPreconditionFailed)
+	}
+	if h := r.Header.Get("If-None-Match"); h != "" && etag != "" && etagsMatch(h, etag) {
+		return fmt.Errorf("%w: If-None-Match", ErrPreconditionFailed)
+	}
+	return nil
+}
+
+// notModified reports whether the If-None-Match header of a GET request
+// r matches etag.
+func notModified(r *http.Request, etag string) bool {
+	h := r.Header.Get("If-None-Match")
+	return h != "" && etagsMatch(h, etag)
+}
+
+// getSetETag retrieves the entity tag of setName.
+// An empty entity tag is returned for sets without declarations.
+func getSetETag(ctx context.Context, store storage.SetDeclarationsRetriever, setName string) (string, error) {
+	declarationIDs, err := store.RetrieveSetDeclarations(ctx, setName)
+	if err != nil || len(declarationIDs) < 1 {
+		return "", err
+	}
+	return setETag(declarationIDs), nil
+}
+
+// setPreconditionHandler checks the If-Match and If-None-Match headers
+// of requests against the set in the URL path before calling next.
+// Dry runs are not checked. The check is best-effort: it is not atomic
+// with the change made by next so a concurrent change of the set
+// between the two is not detected.
+func setPreconditionHandler(store storage.SetDeclarationsRetriever, logger log.Logger, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !conditional(r) || dryRun(r.URL) {
+			next(w, r)
+			return
+		}
+		logger := ctxlog.Logger(r.Context(), logger)
+		setName := getResourceID(r)
+		if setName == "" {
+			jsonErrorAndLog(w, http.StatusBadRequest, ErrEmptyResourceID, "validating input", logger)
+			return
+		}
+		etag, err := getSetETag(r.Context(), store, setName)
+		if err != nil {
+			jsonErrorAndLog(w, 0, err, "retrieving set declarations", logger)
+			return
+		}
+		if err = checkPreconditions(r, etag); err != nil {
+			jsonErrorAndLog(w, http.StatusPreconditionFailed, err, "checking preconditions", logger.With("resource", setName))
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// GetNotificationJobsHandler returns a handler that retrieves the queued notification jobs.
+// Pending jobs are returned unless the "failed" query parameter is set.
+func GetNotificationJobsHandler(store storage.NotificationJobRetriever, logger log.Logger) http.HandlerFunc {
+	if store == nil || logger == nil {
+		panic("nil store or logger")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		filter := storage.NotificationJobFilter{Failed: boolish(r.URL.Query().Get("failed"))}
+		jobs, err := store.RetrieveNotificationJobs(r.Context(), filter)
+		if err != nil {
+			jsonErrorAndLog(w, 0, err, "retrieving notification jobs", logger)
+			return
+		}
+		if jobs == nil {
+			jobs = []storage.NotificationJob{}
+		}
+		logger.Debug(
+			logkeys.Message, "retrieved notification jobs",
+			"failed", filter.Failed,
+			logkeys.GenericCount, len(jobs),
+		)
+		if err = jsonResponse(w, 0, jobs); err != nil {
+			logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+		}
+	}
+}
+
+// notificationJobErrorStatus returns the HTTP status for err.
+func notificationJobErrorStatus(err error) int {
+	if errors.Is(err, storage.ErrNotificationJobNotFound) {
+		return http.StatusNotFound
+	}
+	return 0
+}
+
+// GetNotificationJobHandler returns a handler that retrieves a queued notification job.
+func GetNotificationJobHandler(store storage.NotificationJobRetriever, logger log.Logger) http.HandlerFunc {
+	if store == nil || logger == nil {
+		panic("nil store or logger")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		jobID := getResourceID(r)
+		if jobID == "" {
+			jsonErrorAndLog(w, http.StatusBadRequest, ErrEmptyResourceID, "validating input", logger)
+			return
+		}
+		logger = logger.With(logkeys.NotificationJobID, jobID)
+		job, err := store.RetrieveNotificationJob(r.Context(), jobID)
+		if err != nil {
+			jsonErrorAndLog(w, notificationJobErrorStatus(err), err, "retrieving notification job", logger)
+			return
+		}
+		if err = jsonResponse(w, 0, job); err != nil {
+			logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+		}
+	}
+}
+
+// ReplayNotificationJobStorage is required for replaying notification jobs.
+type ReplayNotificationJobStorage interface {
+	storage.NotificationJobRetriever
+	storage.NotificationJobStorer
+}
+
+// ReplayNotificationJobHandler returns a handler that resets a pending or
+// failed notification job so that it is attempted again as soon as possible.
+func ReplayNotificationJobHandler(store ReplayNotificationJobStorage, logger log.Logger) http.HandlerFunc {
+	if store == nil || logger == nil {
+		panic("nil store or logger")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		jobID := getResourceID(r)
+		if jobID == "" {
+			jsonErrorAndLog(w, http.StatusBadRequest, ErrEmptyResourceID, "validating input", logger)
+			return
+		}
+		logger = logger.With(logkeys.NotificationJobID, jobID)
+		job, err := store.RetrieveNotificationJob(r.Context(), jobID)
+		if err != nil {
+			jsonErrorAndLog(w, notificationJobErrorStatus(err), err, "retrieving notification job", logger)
+			return
+		}
+		job.Replay(time.Now())
+		if err = store.StoreNotificationJob(r.Context(), job); err != nil {
+			jsonErrorAndLog(w, 0, err, "storing notification job", logger)
+			return
+		}
+		logger.Debug(logkeys.Message, "replayed notification job")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeleteNotificationJobHandler returns a handler that deletes a queued notification job.
+func DeleteNotificationJobHandler(store storage.NotificationJobDeleter, logger log.Logger) http.HandlerFunc {
+	if store == nil || logger == nil {
+		panic("nil store or logger")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		jobID := getResourceID(r)
+		if jobID == "" {
+			jsonErrorAndLog(w, http.StatusBadRequest, ErrEmptyResourceID, "validating input", logger)
+			return
+		}
+		logger = logger.With(logkeys.NotificationJobID, jobID)
+		if err := store.DeleteNotificationJob(r.Context(), jobID); err != nil {
+			jsonErrorAndLog(w, 0, err, "deleting notification job", logger)
+			return
+		}
+		logger.Debug(logkeys.Message, "deleted notification job")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -1,25 +1,74 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/jessepeterson/kmfddm/logkeys"
+	kmfnotifier "github.com/jessepeterson/kmfddm/notifier"
+	"github.com/jessepeterson/kmfddm/storage"
+
 	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
 )
 
+// failedIDer is implemented by errors that know which enrollment IDs failed.
+type failedIDer interface {
+	FailedIDs() []string
+}
+
+// notifyErrorStruct is encoded and output for notification errors.
+type notifyErrorStruct struct {
+	Err       string   `json:"error"`
+	FailedIDs []string `json:"failed_ids,omitempty"`
+}
+
 // NotifyHandler notifies enrollment IDs.
-func NotifyHandler(notifier Notifier, logger log.Logger) http.HandlerFunc {
-	if notifier == nil || logger == nil {
-		panic("nil notifier or logger")
+// Enrollments are notified even if their declarations token has not changed.
+// If only some enrollments fail to be notified their IDs are included in the error response.
+// If the "dryrun" query parameter is set then the enrollment IDs that
+// would be notified are returned instead.
+func NotifyHandler(store storage.EnrollmentIDRetriever, notifier Notifier, logger log.Logger) http.HandlerFunc {
+	if store == nil || notifier == nil || logger == nil {
+		panic("nil store or notifier or logger")
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		if dryRun(r.URL) {
+			ids, err := store.RetrieveEnrollmentIDs(
+				r.Context(),
+				r.URL.Query()["declaration"],
+				r.URL.Query()["set"],
+				r.URL.Query()["id"],
+			)
+			if err != nil {
+				jsonErrorAndLog(w, 0, err, "retrieving enrollment IDs", logger)
+				return
+			}
+			dryRunResponse(w, &DryRun{Notify: true, EnrollmentIDs: ids}, logger)
+			return
+		}
 		err := notifier.Changed(
-			r.Context(),
+			kmfnotifier.WithForce(r.Context()),
 			r.URL.Query()["declaration"],
 			r.URL.Query()["set"],
 			r.URL.Query()["id"],
 		)
 		if err != nil {
-			jsonErrorAndLog(w, http.StatusInternalServerError, err, "notify changed", logger)
+			resp := &notifyErrorStruct{Err: err.Error()}
+			var f failedIDer
+			if errors.As(err, &f) {
+				resp.FailedIDs = f.FailedIDs()
+			}
+			logger.Info(
+				logkeys.Message, "notify changed",
+				logkeys.Error, err,
+				"failed_count", len(resp.FailedIDs),
+			)
+			if err = jsonResponse(w, http.StatusInternalServerError, resp); err != nil {
+				logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+			}
+			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 	}
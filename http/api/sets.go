@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/jessepeterson/kmfddm/logkeys"
 	"github.com/jessepeterson/kmfddm/storage"
 
 	"github.com/micromdm/nanolib/log"
@@ -30,29 +31,65 @@ func GetDeclarationSetsHandler(store storage.DeclarationSetRetriever, logger log
 }
 
 // GetSetDeclarationsHandler retrieves the list of declarations in a set.
+// An ETag header derived from the declarations is included and the
+// If-None-Match header is honored.
 // The entire request URL path is assumed to contain the set name.
 // This implies the handler should have the path prefix stripped before use.
 func GetSetDeclarationsHandler(store storage.SetDeclarationsRetriever, logger log.Logger) http.HandlerFunc {
 	if store == nil || logger == nil {
 		panic("nil store or logger")
 	}
-	return simpleJSONResourceHandler(
-		logger,
-		func(ctx context.Context, resource string, _ *url.URL) (interface{}, error) {
-			return store.RetrieveSetDeclarations(ctx, resource)
-		},
-	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		setName := getResourceID(r)
+		if setName == "" {
+			jsonErrorAndLog(w, http.StatusBadRequest, ErrEmptyResourceID, "validating input", logger)
+			return
+		}
+		logger = logger.With("resource", setName)
+		declarationIDs, err := store.RetrieveSetDeclarations(r.Context(), setName)
+		if err != nil {
+			jsonErrorAndLog(w, 0, err, "retrieving data", logger)
+			return
+		}
+		if len(declarationIDs) > 0 {
+			etag := setETag(declarationIDs)
+			w.Header().Set("ETag", etag)
+			if notModified(r, etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		if err = jsonResponse(w, 0, declarationIDs); err != nil {
+			logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+		}
+	}
+}
+
+// PutSetDeclarationStorage is required for associating declarations to sets.
+type PutSetDeclarationStorage interface {
+	storage.SetDeclarationStorer
+	DryRunStorage
 }
 
 // PutSetDeclarationHandler associates declarations to a set.
+// If the "dryrun" query parameter is set then the change is only simulated.
+// The If-Match and If-None-Match headers are checked against the set's ETag.
 // The entire request URL path is assumed to contain the set name.
 // This implies the handler should have the path prefix stripped before use.
-func PutSetDeclarationHandler(store storage.SetDeclarationStorer, notifier Notifier, logger log.Logger) http.HandlerFunc {
+func PutSetDeclarationHandler(store PutSetDeclarationStorage, notifier Notifier, logger log.Logger) http.HandlerFunc {
 	if store == nil || notifier == nil || logger == nil {
 		panic("nil store or notifier or logger")
 	}
-	return simpleChangeResourceHandler(
+	return setPreconditionHandler(store, logger, dryRunOrChangeResourceHandler(
 		logger,
+		func(ctx context.Context, resource string, u *url.URL, notify bool) (*DryRun, error) {
+			declarationID := u.Query().Get("declaration")
+			if declarationID == "" {
+				return nil, errors.New("empty declaration")
+			}
+			return dryRunSetDeclaration(ctx, store, resource, declarationID, false, notify)
+		},
 		func(ctx context.Context, resource string, u *url.URL, notify bool) (bool, string, error) {
 			declarationID := u.Query().Get("declaration")
 			if declarationID == "" {
@@ -67,18 +104,33 @@ func PutSetDeclarationHandler(store storage.SetDeclarationStorer, notifier Notif
 			}
 			return changed, "store set declaration", err
 		},
-	)
+	))
+}
+
+// DeleteSetDeclarationStorage is required for dissociating declarations from sets.
+type DeleteSetDeclarationStorage interface {
+	storage.SetDeclarationRemover
+	DryRunStorage
 }
 
 // DeleteSetDeclarationHandler dissociates declarations from a set.
+// If the "dryrun" query parameter is set then the change is only simulated.
+// The If-Match and If-None-Match headers are checked against the set's ETag.
 // The entire request URL path is assumed to contain the set name.
 // This implies the handler should have the path prefix stripped before use.
-func DeleteSetDeclarationHandler(store storage.SetDeclarationRemover, notifier Notifier, logger log.Logger) http.HandlerFunc {
+func DeleteSetDeclarationHandler(store DeleteSetDeclarationStorage, notifier Notifier, logger log.Logger) http.HandlerFunc {
 	if store == nil || notifier == nil || logger == nil {
 		panic("nil store or notifier or logger")
 	}
-	return simpleChangeResourceHandler(
+	return setPreconditionHandler(store, logger, dryRunOrChangeResourceHandler(
 		logger,
+		func(ctx context.Context, resource string, u *url.URL, notify bool) (*DryRun, error) {
+			declarationID := u.Query().Get("declaration")
+			if declarationID == "" {
+				return nil, errors.New("empty declaration")
+			}
+			return dryRunSetDeclaration(ctx, store, resource, declarationID, true, notify)
+		},
 		func(ctx context.Context, resource string, u *url.URL, notify bool) (bool, string, error) {
 			declarationID := u.Query().Get("declaration")
 			if declarationID == "" {
@@ -93,7 +145,7 @@ func DeleteSetDeclarationHandler(store storage.SetDeclarationRemover, notifier N
 			}
 			return changed, "remove set declaration", err
 		},
-	)
+	))
 }
 
 // GetSetsHandler returns a handler that retrieves the list of sets.
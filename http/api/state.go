@@ -0,0 +1,364 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+var (
+	ErrInvalidState = errors.New("invalid state")
+
+	// ErrUnconfirmedState is returned when applying an empty complete
+	// desired state (removing every declaration) without confirmation.
+	ErrUnconfirmedState = errors.New("empty state requires confirmation")
+)
+
+// State is the complete desired state of declarations and set declarations.
+type State struct {
+	Declarations []json.RawMessage `json:"declarations"`
+
+	// Sets are the declaration IDs of each set.
+	// Declarations IDs must be included in Declarations.
+	Sets map[string][]string `json:"sets"`
+}
+
+// StateDiff are the changes that make the stored state the desired state.
+type StateDiff struct {
+	DeclarationsAdded   []string `json:"declarations_added,omitempty"`
+	DeclarationsUpdated []string `json:"declarations_updated,omitempty"`
+	DeclarationsRemoved []string `json:"declarations_removed,omitempty"`
+
+	// SetDeclarationsAdded are the declaration IDs added to each set.
+	SetDeclarationsAdded map[string][]string `json:"set_declarations_added,omitempty"`
+
+	// SetDeclarationsRemoved are the declaration IDs removed from each set.
+	SetDeclarationsRemoved map[string][]string `json:"set_declarations_removed,omitempty"`
+}
+
+// StateResponse is the response body of the state handler.
+type StateResponse struct {
+	StateDiff
+
+	// Plan is true if the changes were only planned and not applied.
+	Plan bool `json:"plan"`
+
+	// Atomic is true if the changes were applied in a single transaction.
+	Atomic   bool   `json:"atomic"`
+	Applied  bool   `json:"applied"`
+	Notified bool   `json:"notified"`
+	Error    string `json:"error,omitempty"`
+}
+
+// StateStorage is required for syncing the desired state.
+type StateStorage interface {
+	storage.BatchStorage
+	storage.DeclarationAPIRetriever
+	storage.DeclarationsRetriever
+	storage.DeclarationSetRetriever
+	storage.SetDeclarationsRetriever
+	storage.SetRetreiver
+}
+
+// difference returns the strings of a that are not in b.
+func difference(a []string, b []string) (r []string) {
+	for _, v := range a {
+		if !contains(b, v) {
+			r = append(r, v)
+		}
+	}
+	return
+}
+
+// parseState parses and validates the declarations of state.
+// The sets of state must have prefix.
+func parseState(state *State, prefix string) (map[string]*ddm.Declaration, error) {
+	declarations := make(map[string]*ddm.Declaration, len(state.Declarations))
+	for i, raw := range state.Declarations {
+		d, err := ddm.ParseDeclaration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: declaration %d: %v", ErrInvalidState, i, err)
+		} else if !d.Valid() {
+			return nil, fmt.Errorf("%w: declaration %d: %v", ErrInvalidState, i, ddm.ErrInvalidDeclaration)
+		} else if _, ok := declarations[d.Identifier]; ok {
+			return nil, fmt.Errorf("%w: duplicate declaration: %s", ErrInvalidState, d.Identifier)
+		}
+		declarations[d.Identifier] = d
+	}
+	for setName, declarationIDs := range state.Sets {
+		if setName == "" || !strings.HasPrefix(setName, prefix) {
+			return nil, fmt.Errorf("%w: set not in prefix: %q", ErrInvalidState, setName)
+		}
+		for _, declarationID := range declarationIDs {
+			if _, ok := declarations[declarationID]; !ok {
+				return nil, fmt.Errorf("%w: set %s: unknown declaration: %s", ErrInvalidState, setName, declarationID)
+			}
+		}
+	}
+	return declarations, nil
+}
+
+// planState computes the changes and batch operations that make the
+// stored state the desired state. Only sets with prefix are changed.
+// If prefix is empty then declarations not in state are removed.
+// Otherwise only declarations that are exclusively in sets with prefix
+// are removed and declarations that are in sets without prefix can not
+// be updated.
+func planState(ctx context.Context, store StateStorage, state *State, prefix string) (*StateDiff, []BatchOperation, error) {
+	declarations, err := parseState(state, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	diff := &StateDiff{
+		SetDeclarationsAdded:   make(map[string][]string),
+		SetDeclarationsRemoved: make(map[string][]string),
+	}
+	var ops []BatchOperation
+
+	var declarationIDs []string
+	for declarationID := range declarations {
+		declarationIDs = append(declarationIDs, declarationID)
+	}
+	sort.Strings(declarationIDs)
+	for _, declarationID := range declarationIDs {
+		d := declarations[declarationID]
+		existing, err := store.RetrieveDeclaration(ctx, declarationID)
+		if errors.Is(err, storage.ErrDeclarationNotFound) {
+			diff.DeclarationsAdded = append(diff.DeclarationsAdded, declarationID)
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("retrieving declaration: %w", err)
+		} else if existing.Type != d.Type || !jsonEqual(existing.Payload, d.Payload) {
+			if prefix != "" {
+				if err = checkPrefixSets(ctx, store, declarationID, prefix); err != nil {
+					return nil, nil, err
+				}
+			}
+			diff.DeclarationsUpdated = append(diff.DeclarationsUpdated, declarationID)
+		} else {
+			continue
+		}
+		ops = append(ops, BatchOperation{Op: BatchPutDeclaration, DeclarationID: declarationID, d: d})
+	}
+
+	// the managed sets are the stored sets with prefix and the desired sets
+	storedSets, err := store.RetrieveSets(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("retrieving sets: %w", err)
+	}
+	var setNames []string
+	for _, setName := range storedSets {
+		if strings.HasPrefix(setName, prefix) {
+			setNames = append(setNames, setName)
+		}
+	}
+	for setName := range state.Sets {
+		setNames = append(setNames, setName)
+	}
+	setNames = uniqueSorted(setNames)
+
+	var removeOps []BatchOperation
+	var candidates []string // declarations that may be removed
+	for _, setName := range setNames {
+		stored, err := store.RetrieveSetDeclarations(ctx, setName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("retrieving set declarations: %w", err)
+		}
+		candidates = append(candidates, stored...)
+		desired := uniqueSorted(state.Sets[setName])
+		for _, declarationID := range difference(desired, stored) {
+			diff.SetDeclarationsAdded[setName] = append(diff.SetDeclarationsAdded[setName], declarationID)
+			ops = append(ops, BatchOperation{Op: BatchPutSetDeclaration, Set: setName, DeclarationID: declarationID})
+		}
+		stored = uniqueSorted(stored)
+		for _, declarationID := range difference(stored, desired) {
+			diff.SetDeclarationsRemoved[setName] = append(diff.SetDeclarationsRemoved[setName], declarationID)
+			removeOps = append(removeOps, BatchOperation{Op: BatchDeleteSetDeclaration, Set: setName, DeclarationID: declarationID})
+		}
+	}
+	ops = append(ops, removeOps...)
+
+	if prefix == "" {
+		if candidates, err = store.RetrieveDeclarations(ctx); err != nil {
+			return nil, nil, fmt.Errorf("retrieving declarations: %w", err)
+		}
+	}
+	for _, declarationID := range uniqueSorted(candidates) {
+		if _, ok := declarations[declarationID]; ok {
+			continue
+		}
+		if prefix != "" {
+			// keep declarations that are also in sets we do not manage
+			sets, err := store.RetrieveDeclarationSets(ctx, declarationID)
+			if err != nil {
+				return nil, nil, fmt.Errorf("retrieving declaration sets: %w", err)
+			}
+			unmanaged := false
+			for _, setName := range sets {
+				unmanaged = unmanaged || !strings.HasPrefix(setName, prefix)
+			}
+			if unmanaged {
+				continue
+			}
+		}
+		diff.DeclarationsRemoved = append(diff.DeclarationsRemoved, declarationID)
+		ops = append(ops, BatchOperation{Op: BatchDeleteDeclaration, DeclarationID: declarationID})
+	}
+	return diff, ops, nil
+}
+
+// checkPrefixSets returns an error if declarationID is in any sets without prefix.
+func checkPrefixSets(ctx context.Context, store storage.DeclarationSetRetriever, declarationID, prefix string) error {
+	sets, err := store.RetrieveDeclarationSets(ctx, declarationID)
+	if err != nil {
+		return fmt.Errorf("retrieving declaration sets: %w", err)
+	}
+	for _, setName := range sets {
+		if !strings.HasPrefix(setName, prefix) {
+			return fmt.Errorf("%w: declaration %s in set not in prefix: %q", ErrInvalidState, declarationID, setName)
+		}
+	}
+	return nil
+}
+
+// StateHandler returns a handler that syncs the stored declarations and
+// set declarations to the complete desired state in the request body.
+// Declarations and set declarations not in the desired state are removed.
+// If the "prefix" query parameter is set then only sets with that
+// prefix are synced, only declarations exclusively in those sets
+// are removed, and declarations also in other sets can not be updated.
+// Otherwise an empty desired state (which removes every declaration)
+// requires the "confirm" query parameter to be set. If the "plan" query
+// parameter is set then the changes are only returned and not applied.
+// Otherwise the changes are applied like a batch (see [BatchHandler])
+// and notified once. If store implements [storage.Transactor] then the
+// changes are planned and applied in a single transaction.
+func StateHandler(store StateStorage, notifier Notifier, logger log.Logger) http.HandlerFunc {
+	if store == nil || notifier == nil || logger == nil {
+		panic("nil store or notifier or logger")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		state := new(State)
+		if err := decodeJSONBody(r, state); err != nil {
+			jsonErrorAndLog(w, bodyErrorStatus(err), err, "decoding body", logger)
+			return
+		}
+		q := r.URL.Query()
+		prefix := q.Get("prefix")
+		if prefix != "" {
+			logger = logger.With("prefix", prefix)
+		}
+		plan := boolish(q.Get("plan"))
+		if !plan && prefix == "" && len(state.Declarations) < 1 && !boolish(q.Get("confirm")) {
+			jsonErrorAndLog(w, http.StatusBadRequest, ErrUnconfirmedState, "validating input", logger)
+			return
+		}
+
+		var (
+			diff     *StateDiff
+			ops      []BatchOperation
+			changes  *batchChanges
+			planErr  error
+			applyErr error
+		)
+		syncState := func(ctx context.Context, store StateStorage) error {
+			if diff, ops, planErr = planState(ctx, store, state, prefix); planErr != nil {
+				return planErr
+			}
+			if plan || len(ops) < 1 {
+				return nil
+			}
+			changes, applyErr = applyBatch(ctx, store, ops, make([]BatchResult, len(ops)))
+			return applyErr
+		}
+		txStore, atomic := store.(storage.Transactor)
+		var err error
+		if atomic {
+			err = txStore.InTx(r.Context(), func(ctx context.Context, txStore storage.BatchStorage) error {
+				stateStore, ok := txStore.(StateStorage)
+				if !ok {
+					return errors.New("transaction storage does not support state")
+				}
+				return syncState(ctx, stateStore)
+			})
+		} else {
+			err = syncState(r.Context(), store)
+		}
+		if err != nil && planErr == nil && applyErr == nil {
+			// the transaction itself failed
+			if changes == nil {
+				planErr = err
+			} else {
+				applyErr = err
+				changes = new(batchChanges)
+			}
+		}
+		if errors.Is(planErr, ErrInvalidState) {
+			jsonErrorAndLog(w, http.StatusBadRequest, planErr, "validating input", logger)
+			return
+		} else if planErr != nil {
+			jsonErrorAndLog(w, 0, planErr, "planning state", logger)
+			return
+		}
+
+		resp := &StateResponse{
+			StateDiff: *diff,
+			Plan:      plan,
+			Atomic:    atomic,
+		}
+		if resp.Plan || len(ops) < 1 {
+			resp.Applied = !resp.Plan
+			logger.Debug(
+				logkeys.Message, "planned state",
+				logkeys.GenericCount, len(ops),
+				"plan", resp.Plan,
+			)
+			if err = jsonResponse(w, 0, resp); err != nil {
+				logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+			}
+			return
+		}
+
+		err = applyErr
+		resp.Applied = err == nil || (!atomic && changes.applied)
+		status := http.StatusOK
+		if err != nil {
+			logger.Info(logkeys.Message, "applying state", logkeys.Error, err)
+			status = batchErrorStatus(err)
+			resp.Error = err.Error()
+		}
+
+		notify := shouldNotify(r.URL) && !changes.empty()
+		logger.Debug(
+			logkeys.Message, "applied state",
+			logkeys.GenericCount, len(ops),
+			"atomic", atomic,
+			logkeys.Notify, notify,
+		)
+		if notify {
+			if nErr := changes.notify(r.Context(), notifier); nErr != nil {
+				logger.Info(logkeys.Message, "notifying", logkeys.Error, nErr)
+				if err == nil {
+					jsonErrorAndLog(w, 0, nErr, "notifying", logger)
+					return
+				}
+			} else {
+				resp.Notified = true
+			}
+		}
+		if err = jsonResponse(w, status, resp); err != nil {
+			logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/jessepeterson/kmfddm/logkeys"
 	"github.com/jessepeterson/kmfddm/storage"
 
 	"github.com/micromdm/nanolib/log"
@@ -51,6 +52,163 @@ func GetStatusValuesHandler(store storage.StatusValuesRetriever, logger log.Logg
 	)
 }
 
+// GetStatusValueHistoryHandler returns a handler that retrieves the recorded status value changes for an enrollment.
+func GetStatusValueHistoryHandler(store storage.StatusValueHistoryRetriever, logger log.Logger) http.HandlerFunc {
+	return simpleJSONResourceHandler(
+		logger,
+		func(ctx context.Context, resource string, u *url.URL) (interface{}, error) {
+			if store == nil {
+				return nil, errors.New("nil storage")
+			}
+			return store.RetrieveStatusValueHistory(ctx, strings.Split(resource, ","), u.Query().Get("path"))
+		},
+	)
+}
+
+// GetDeclarationStatusHistoryHandler returns a handler that retrieves the recorded declaration status changes for an enrollment.
+func GetDeclarationStatusHistoryHandler(store storage.DeclarationStatusHistoryRetriever, logger log.Logger) http.HandlerFunc {
+	return simpleJSONResourceHandler(
+		logger,
+		func(ctx context.Context, resource string, u *url.URL) (interface{}, error) {
+			if store == nil {
+				return nil, errors.New("nil storage")
+			}
+			return store.RetrieveDeclarationStatusHistory(ctx, strings.Split(resource, ","), u.Query().Get("declaration"))
+		},
+	)
+}
+
+// DefaultFlapThreshold is the default number of changes between valid
+// and invalid at which a declaration is considered flapping.
+const DefaultFlapThreshold = 3
+
+// GetDeclarationStatusFlappingHandler returns a handler that summarizes how often declarations changed validity for an enrollment.
+// Declarations are flagged as flapping if they change validity at least as many times as the "threshold" query parameter.
+func GetDeclarationStatusFlappingHandler(store storage.DeclarationStatusHistoryRetriever, logger log.Logger) http.HandlerFunc {
+	if store == nil {
+		panic("nil store")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		resource := getResourceID(r)
+		if resource == "" {
+			jsonErrorAndLog(w, http.StatusBadRequest, ErrEmptyResourceID, "validating input", logger)
+			return
+		}
+		logger = logger.With("resource", resource)
+		threshold := DefaultFlapThreshold
+		if v := r.URL.Query().Get("threshold"); v != "" {
+			var err error
+			threshold, err = strconv.Atoi(v)
+			if err != nil {
+				jsonErrorAndLog(w, http.StatusBadRequest, err, "parsing threshold", logger)
+				return
+			}
+		}
+		history, err := store.RetrieveDeclarationStatusHistory(r.Context(), strings.Split(resource, ","), r.URL.Query().Get("declaration"))
+		if errors.Is(err, storage.ErrStatusHistoryDisabled) {
+			jsonErrorAndLog(w, http.StatusNotFound, err, "retrieving declaration status history", logger)
+			return
+		} else if err != nil {
+			jsonErrorAndLog(w, 0, err, "retrieving declaration status history", logger)
+			return
+		}
+		flaps := make(map[string][]storage.DeclarationStatusFlaps)
+		for id, events := range history {
+			flaps[id] = storage.FlappingDeclarations(events, threshold)
+		}
+		if err = jsonResponse(w, 0, flaps); err != nil {
+			logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+		}
+	}
+}
+
+const (
+	// DefaultSearchLimit is the default number of enrollment IDs returned per page of search results.
+	DefaultSearchLimit = 100
+	// MaxSearchLimit is the maximum number of enrollment IDs returned per page of search results.
+	MaxSearchLimit = 1000
+)
+
+// SearchStatusValuesHandler returns a handler that finds enrollments by their reported status values.
+// The search is specified with the "path", "op", and "value" query parameters.
+// Results are paginated with the "after" and "limit" query parameters.
+func SearchStatusValuesHandler(store storage.StatusValueSearcher, logger log.Logger) http.HandlerFunc {
+	if store == nil {
+		panic("nil store")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		q := storage.StatusValueSearch{
+			Path:     r.URL.Query().Get("path"),
+			Operator: r.URL.Query().Get("op"),
+			Value:    r.URL.Query().Get("value"),
+			After:    r.URL.Query().Get("after"),
+			Limit:    DefaultSearchLimit,
+		}
+		if q.Operator == "" {
+			q.Operator = storage.SearchOpEqual
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			if q.Limit, err = strconv.Atoi(v); err != nil {
+				jsonErrorAndLog(w, http.StatusBadRequest, err, "parsing limit", logger)
+				return
+			}
+			if q.Limit > MaxSearchLimit {
+				q.Limit = MaxSearchLimit
+			}
+		}
+		if err := q.Valid(); err != nil {
+			jsonErrorAndLog(w, http.StatusBadRequest, err, "validating search", logger)
+			return
+		}
+		result, err := store.SearchStatusValues(r.Context(), q)
+		if err != nil {
+			jsonErrorAndLog(w, 0, err, "searching status values", logger)
+			return
+		}
+		if result.EnrollmentIDs == nil {
+			// always encode an empty result as an empty JSON array
+			result.EnrollmentIDs = []string{}
+		}
+		logger.Debug(
+			logkeys.Message, "searched status values",
+			"path", q.Path,
+			"op", q.Operator,
+			logkeys.GenericCount, len(result.EnrollmentIDs),
+		)
+		if err = jsonResponse(w, 0, result); err != nil {
+			logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+		}
+	}
+}
+
+// GetUnhandledStatusPathsHandler returns a handler that retrieves the status report paths that were not handled.
+func GetUnhandledStatusPathsHandler(store storage.UnhandledStatusRetriever, logger log.Logger) http.HandlerFunc {
+	if store == nil {
+		panic("nil store")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		paths, err := store.RetrieveUnhandledStatusPaths(r.Context())
+		if err != nil {
+			jsonErrorAndLog(w, 0, err, "retrieving unhandled status paths", logger)
+			return
+		}
+		if paths == nil {
+			paths = []storage.UnhandledStatusPath{}
+		}
+		logger.Debug(
+			logkeys.Message, "retrieved unhandled status paths",
+			logkeys.GenericCount, len(paths),
+		)
+		if err = jsonResponse(w, 0, paths); err != nil {
+			logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+		}
+	}
+}
+
 // GetStatusReportHandler returns a handler that retrieves a status report for en enrollment.
 func GetStatusReportHandler(store storage.StatusReportRetriever, logger log.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -71,8 +229,10 @@ func GetStatusReportHandler(store storage.StatusReportRetriever, logger log.Logg
 		report, err := store.RetrieveStatusReport(r.Context(), q)
 		statusCode := 0
 		if err == nil && report == nil {
-			err = errors.New("status report not found")
-			statusCode = 404
+			err = storage.ErrStatusReportNotFound
+		}
+		if errors.Is(err, storage.ErrStatusReportNotFound) {
+			statusCode = http.StatusNotFound
 		}
 		if err != nil {
 			jsonErrorAndLog(w, statusCode, err, "retrieving status report", logger)
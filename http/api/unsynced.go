@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// UnsyncedNotification is a tracked notification with its age.
+type UnsyncedNotification struct {
+	storage.TrackedNotification
+	AgeSeconds int64 `json:"age_seconds"`
+
+	// shadows the always-zero synced time of unsynced notifications
+	SyncedAt *time.Time `json:"synced_at,omitempty"`
+}
+
+// GetUnsyncedHandler returns a handler that retrieves the enrollments
+// that were notified but have not yet synced their declarations.
+// Results can be limited with the "id", "older_than" (a duration), and "limit" query parameters.
+func GetUnsyncedHandler(store storage.UnsyncedNotificationRetriever, logger log.Logger) http.HandlerFunc {
+	if store == nil || logger == nil {
+		panic("nil store or logger")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		now := time.Now()
+		filter := storage.UnsyncedFilter{EnrollmentIDs: r.URL.Query()["id"]}
+		if v := r.URL.Query().Get("older_than"); v != "" {
+			olderThan, err := time.ParseDuration(v)
+			if err != nil {
+				jsonErrorAndLog(w, http.StatusBadRequest, err, "parsing older_than", logger)
+				return
+			}
+			filter.NotifiedBefore = now.Add(-olderThan)
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			if filter.Limit, err = strconv.Atoi(v); err != nil {
+				jsonErrorAndLog(w, http.StatusBadRequest, err, "parsing limit", logger)
+				return
+			}
+		}
+		notifications, err := store.RetrieveUnsyncedNotifications(r.Context(), filter)
+		if err != nil {
+			jsonErrorAndLog(w, 0, err, "retrieving unsynced notifications", logger)
+			return
+		}
+		unsynced := make([]UnsyncedNotification, len(notifications))
+		for i, n := range notifications {
+			unsynced[i] = UnsyncedNotification{
+				TrackedNotification: n,
+				AgeSeconds:          int64(now.Sub(n.NotifiedAt) / time.Second),
+			}
+		}
+		logger.Debug(
+			logkeys.Message, "retrieved unsynced notifications",
+			logkeys.GenericCount, len(unsynced),
+		)
+		if err = jsonResponse(w, 0, unsynced); err != nil {
+			logger.Info(logkeys.Message, "encoding response body", logkeys.Error, err)
+		}
+	}
+}
@@ -22,6 +22,9 @@ type APIStorage interface {
 	storage.SetRetreiver
 	storage.StatusAPIStorage
 	storage.EnrollmentSetStorage
+	storage.NotificationQueueStorage
+	storage.UnsyncedNotificationRetriever
+	DryRunStorage
 }
 
 // func handlerName(endpoint string) string {
@@ -32,6 +35,10 @@ type APIStorage interface {
 // API endpoint paths are prepended with prefix.
 // Authentication or any other layered handlers are not present.
 // They are assumed to be layered with mux, possibly at the Handel call.
+// If authentication attaches a Credential to the request context (see
+// CredentialsHandler) then each endpoint authorizes the role and any
+// set prefix scope of the credential and changes are logged with the
+// credential name.
 // If prefix is empty and these handlers are used in sub-paths then
 // handlers should have that sub-path stripped from the request.
 // The logger is adorned with a "handler" key of the endpoint name.
@@ -39,121 +46,203 @@ func HandleAPIv1(prefix string, mux Mux, logger log.Logger, store APIStorage, no
 	// declarations
 	mux.Handle(
 		prefix+"/declarations",
-		GetDeclarationsHandler(store, logger.With("get-declarations")),
+		authorize(PermissionRead, nil, GetDeclarationsHandler(store, logger.With("get-declarations")), logger),
 		"GET",
 	)
 
 	mux.Handle(
 		prefix+"/declarations",
-		PutDeclarationHandler(store, notifier, logger.With(logkeys.Handler, "put-declaration")),
+		authorize(PermissionEdit, declarationBodyScope(store), PutDeclarationHandler(store, notifier, logger.With(logkeys.Handler, "put-declaration")), logger),
 		"PUT",
 	)
 
 	mux.Handle(
 		prefix+"/declarations/:id",
-		GetDeclarationHandler(store, logger.With(logkeys.Handler, "get-declaration")),
+		authorize(PermissionRead, nil, GetDeclarationHandler(store, logger.With(logkeys.Handler, "get-declaration")), logger),
 		"GET",
 	)
 
 	mux.Handle(
 		prefix+"/declarations/:id",
-		DeleteDeclarationHandler(store, logger.With(logkeys.Handler, "delete-declaration")),
+		authorize(PermissionEdit, declarationScope(store), DeleteDeclarationHandler(store, notifier, logger.With(logkeys.Handler, "delete-declaration")), logger),
 		"DELETE",
 	)
 
+	mux.Handle(
+		prefix+"/declarations/:id/compliance",
+		authorize(PermissionStatus, nil, GetDeclarationComplianceHandler(store, logger.With(logkeys.Handler, "get-declaration-compliance")), logger),
+		"GET",
+	)
+
 	mux.Handle(
 		prefix+"/declarations/:id/touch",
-		TouchDeclarationHandler(store, notifier, logger.With(logkeys.Handler, "touch-declaration")),
+		authorize(PermissionEdit, declarationScope(store), TouchDeclarationHandler(store, notifier, logger.With(logkeys.Handler, "touch-declaration")), logger),
 		"POST",
 	)
 
 	// sets
 	mux.Handle(
 		prefix+"/sets",
-		GetSetsHandler(store, logger.With("get-sets")),
+		authorize(PermissionRead, nil, GetSetsHandler(store, logger.With("get-sets")), logger),
 		"GET",
 	)
 
 	// set declarations
 	mux.Handle(
 		prefix+"/set-declarations/:id",
-		GetSetDeclarationsHandler(store, logger.With(logkeys.Handler, "get-set-declarations")),
+		authorize(PermissionRead, nil, GetSetDeclarationsHandler(store, logger.With(logkeys.Handler, "get-set-declarations")), logger),
 		"GET",
 	)
 
 	mux.Handle(
 		prefix+"/set-declarations/:id",
-		PutSetDeclarationHandler(store, notifier, logger.With(logkeys.Handler, "put-set-declarations")),
+		authorize(PermissionEdit, setDeclarationScope(store), PutSetDeclarationHandler(store, notifier, logger.With(logkeys.Handler, "put-set-declarations")), logger),
 		"PUT",
 	)
 
 	mux.Handle(
 		prefix+"/set-declarations/:id",
-		DeleteSetDeclarationHandler(store, notifier, logger.With(logkeys.Handler, "delete-set-delcarations")),
+		authorize(PermissionEdit, setDeclarationScope(store), DeleteSetDeclarationHandler(store, notifier, logger.With(logkeys.Handler, "delete-set-delcarations")), logger),
 		"DELETE",
 	)
 
 	// enrollment sets
 	mux.Handle(
 		prefix+"/enrollment-sets/:id",
-		GetEnrollmentSetsHandler(store, logger.With(logkeys.Handler, "get-enrollment-sets")),
+		authorize(PermissionRead, nil, GetEnrollmentSetsHandler(store, logger.With(logkeys.Handler, "get-enrollment-sets")), logger),
 		"GET",
 	)
 
 	mux.Handle(
 		prefix+"/enrollment-sets/:id",
-		PutEnrollmentSetHandler(store, notifier, logger.With(logkeys.Handler, "put-enrollment-sets")),
+		authorize(PermissionEdit, enrollmentSetScope(store), PutEnrollmentSetHandler(store, notifier, logger.With(logkeys.Handler, "put-enrollment-sets")), logger),
 		"PUT",
 	)
 
 	mux.Handle(
 		prefix+"/enrollment-sets/:id",
-		DeleteEnrollmentSetHandler(store, notifier, logger.With(logkeys.Handler, "delete-enrollment-sets")),
+		authorize(PermissionEdit, enrollmentSetScope(store), DeleteEnrollmentSetHandler(store, notifier, logger.With(logkeys.Handler, "delete-enrollment-sets")), logger),
 		"DELETE",
 	)
 
 	mux.Handle(
 		prefix+"/enrollment-sets-all/sets/:id",
-		DeleteAllEnrollmentSetsHandler(store, notifier, logger.With(logkeys.Handler, "delete-all-enrollment-sets")),
+		authorize(PermissionEdit, enrollmentScope(store), DeleteAllEnrollmentSetsHandler(store, notifier, logger.With(logkeys.Handler, "delete-all-enrollment-sets")), logger),
 		"DELETE",
 	)
 
 	// declarations sets
 	mux.Handle(
 		prefix+"/declaration-sets/:id",
-		GetDeclarationSetsHandler(store, logger.With(logkeys.Handler, "get-declaration-sets")),
+		authorize(PermissionRead, nil, GetDeclarationSetsHandler(store, logger.With(logkeys.Handler, "get-declaration-sets")), logger),
 		"GET",
 	)
 
 	// status queries
 	mux.Handle(
 		prefix+"/declaration-status/:id",
-		GetDeclarationStatusHandler(store, logger.With(logkeys.Handler, "get-declaration-status")),
+		authorize(PermissionStatus, nil, GetDeclarationStatusHandler(store, logger.With(logkeys.Handler, "get-declaration-status")), logger),
+		"GET",
+	)
+
+	mux.Handle(
+		prefix+"/declaration-status-history/:id",
+		authorize(PermissionStatus, nil, GetDeclarationStatusHistoryHandler(store, logger.With(logkeys.Handler, "get-declaration-status-history")), logger),
+		"GET",
+	)
+
+	mux.Handle(
+		prefix+"/declaration-status-flapping/:id",
+		authorize(PermissionStatus, nil, GetDeclarationStatusFlappingHandler(store, logger.With(logkeys.Handler, "get-declaration-status-flapping")), logger),
 		"GET",
 	)
 
 	mux.Handle(
 		prefix+"/status-errors/:id",
-		GetStatusErrorsHandler(store, logger.With(logkeys.Handler, "get-status-errors")),
+		authorize(PermissionStatus, nil, GetStatusErrorsHandler(store, logger.With(logkeys.Handler, "get-status-errors")), logger),
 		"GET",
 	)
 
 	mux.Handle(
 		prefix+"/status-values/:id",
-		GetStatusValuesHandler(store, logger.With(logkeys.Handler, "get-status-values")),
+		authorize(PermissionStatus, nil, GetStatusValuesHandler(store, logger.With(logkeys.Handler, "get-status-values")), logger),
+		"GET",
+	)
+
+	mux.Handle(
+		prefix+"/status-search",
+		authorize(PermissionStatus, nil, SearchStatusValuesHandler(store, logger.With(logkeys.Handler, "search-status-values")), logger),
+		"GET",
+	)
+
+	mux.Handle(
+		prefix+"/status-history/:id",
+		authorize(PermissionStatus, nil, GetStatusValueHistoryHandler(store, logger.With(logkeys.Handler, "get-status-history")), logger),
+		"GET",
+	)
+
+	mux.Handle(
+		prefix+"/status-unhandled",
+		authorize(PermissionStatus, nil, GetUnhandledStatusPathsHandler(store, logger.With(logkeys.Handler, "get-status-unhandled")), logger),
 		"GET",
 	)
 
 	mux.Handle(
 		prefix+"/status-report/:id",
-		GetStatusReportHandler(store, logger.With(logkeys.Handler, "get-status-report")),
+		authorize(PermissionStatus, nil, GetStatusReportHandler(store, logger.With(logkeys.Handler, "get-status-report")), logger),
 		"GET",
 	)
 
+	// batch
+	mux.Handle(
+		prefix+"/batch",
+		authorize(PermissionEdit, batchScope(store), BatchHandler(store, notifier, logger.With(logkeys.Handler, "batch")), logger),
+		"POST",
+	)
+
+	// desired state
+	mux.Handle(
+		prefix+"/state",
+		authorize(PermissionEdit, stateScope(store), StateHandler(store, notifier, logger.With(logkeys.Handler, "put-state")), logger),
+		"PUT",
+	)
+
 	// notifier
 	mux.Handle(
 		prefix+"/notify",
-		NotifyHandler(notifier, logger.With(logkeys.Handler, "notify")),
+		authorize(PermissionNotify, notifyScope(store), NotifyHandler(store, notifier, logger.With(logkeys.Handler, "notify")), logger),
+		"POST",
+	)
+
+	// notification queue
+	mux.Handle(
+		prefix+"/notifications",
+		authorize(PermissionRead, nil, GetNotificationJobsHandler(store, logger.With(logkeys.Handler, "get-notifications")), logger),
+		"GET",
+	)
+
+	mux.Handle(
+		prefix+"/notifications/:id",
+		authorize(PermissionRead, nil, GetNotificationJobHandler(store, logger.With(logkeys.Handler, "get-notification")), logger),
+		"GET",
+	)
+
+	mux.Handle(
+		prefix+"/notifications/:id",
+		authorize(PermissionNotify, nil, DeleteNotificationJobHandler(store, logger.With(logkeys.Handler, "delete-notification")), logger),
+		"DELETE",
+	)
+
+	mux.Handle(
+		prefix+"/notifications/:id/replay",
+		authorize(PermissionNotify, nil, ReplayNotificationJobHandler(store, logger.With(logkeys.Handler, "replay-notification")), logger),
 		"POST",
 	)
+
+	// notification tracking
+	mux.Handle(
+		prefix+"/unsynced",
+		authorize(PermissionStatus, nil, GetUnsyncedHandler(store, logger.With(logkeys.Handler, "get-unsynced")), logger),
+		"GET",
+	)
 }
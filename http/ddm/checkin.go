@@ -0,0 +1,147 @@
+package ddm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/jessepeterson/kmfddm/logkeys"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+	"github.com/micromdm/plist"
+)
+
+var (
+	ErrNotDeclarativeManagement = errors.New("not a DeclarativeManagement check-in")
+	ErrInvalidEndpoint          = errors.New("invalid endpoint")
+)
+
+// CheckIn is a DeclarativeManagement check-in message.
+type CheckIn struct {
+	MessageType      string
+	UDID             string `plist:",omitempty"`
+	UserID           string `plist:",omitempty"`
+	EnrollmentID     string `plist:",omitempty"`
+	EnrollmentUserID string `plist:",omitempty"`
+	Endpoint         string
+	Data             []byte `plist:",omitempty"`
+}
+
+// ID returns the enrollment ID of c.
+// Like NanoMDM the enrollment ID is the UDID (or EnrollmentID for User
+// Enrollments) of the device channel. For the user channel the UserID
+// (or EnrollmentUserID) is appended separated by a colon.
+func (c *CheckIn) ID() string {
+	id, userID := c.UDID, c.UserID
+	if id == "" {
+		id, userID = c.EnrollmentID, c.EnrollmentUserID
+	}
+	if id != "" && userID != "" {
+		return id + ":" + userID
+	}
+	return id
+}
+
+// webhookEvent is a NanoMDM-style webhook event.
+type webhookEvent struct {
+	Topic        string `json:"topic"`
+	CheckinEvent *struct {
+		RawPayload []byte `json:"raw_payload"`
+	} `json:"checkin_event"`
+}
+
+// ParseCheckIn parses a DeclarativeManagement check-in from body.
+// The body is either the raw check-in plist or the JSON body of a
+// NanoMDM-style webhook check-in event.
+func ParseCheckIn(body []byte) (*CheckIn, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		ev := new(webhookEvent)
+		if err := json.Unmarshal(body, ev); err != nil {
+			return nil, fmt.Errorf("parsing webhook event: %w", err)
+		}
+		if ev.CheckinEvent == nil {
+			return nil, fmt.Errorf("%w: webhook event topic: %s", ErrNotDeclarativeManagement, ev.Topic)
+		}
+		body = ev.CheckinEvent.RawPayload
+	}
+	c := new(CheckIn)
+	if err := plist.Unmarshal(body, c); err != nil {
+		return nil, fmt.Errorf("parsing check-in: %w", err)
+	}
+	if c.MessageType != "DeclarativeManagement" {
+		return nil, fmt.Errorf("%w: message type: %s", ErrNotDeclarativeManagement, c.MessageType)
+	}
+	return c, nil
+}
+
+// CheckInHandler creates a handler that handles DeclarativeManagement
+// check-ins (see ParseCheckIn) directly rather than via an MDM server
+// that proxies them to the DDM endpoints.
+// The check-in is dispatched on its Endpoint to next as a request to
+// the corresponding DDM endpoint (e.g. "/tokens") whose response is
+// the check-in response. The enrollment ID is taken from the
+// enrollment ID header if present otherwise from the check-in.
+func CheckInHandler(next http.Handler, hLogger log.Logger) http.HandlerFunc {
+	if next == nil || hLogger == nil {
+		panic("nil handler or logger")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), hLogger)
+		bodyBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			ErrorAndLog(w, http.StatusInternalServerError, logger, "reading body", err)
+			return
+		}
+		c, err := ParseCheckIn(bodyBytes)
+		if err != nil {
+			ErrorAndLog(w, http.StatusBadRequest, logger, "parsing check-in", err)
+			return
+		}
+		id := r.Header.Get(EnrollmentIDHeader)
+		if id == "" {
+			id = c.ID()
+		}
+		if id == "" {
+			ErrorAndLog(w, http.StatusBadRequest, logger, "getting enrollment id", ErrEmptyEnrollmentID)
+			return
+		}
+		logger = logger.With(logkeys.EnrollmentID, id, "endpoint", c.Endpoint)
+
+		method := http.MethodGet
+		var data []byte
+		endpoint := path.Clean("/" + c.Endpoint)
+		switch {
+		case endpoint == "/tokens" || endpoint == "/declaration-items":
+		case strings.HasPrefix(endpoint, "/declaration/"):
+		case endpoint == "/status":
+			if len(c.Data) < 1 {
+				ErrorAndLog(w, http.StatusBadRequest, logger, "status check-in", errors.New("empty data"))
+				return
+			}
+			method = http.MethodPut
+			data = c.Data
+		default:
+			ErrorAndLog(w, http.StatusBadRequest, logger, "dispatching check-in", ErrInvalidEndpoint)
+			return
+		}
+
+		req := r.Clone(r.Context())
+		req.Method = method
+		req.URL = &url.URL{Path: endpoint}
+		req.RequestURI = req.URL.Path
+		req.Header = make(http.Header)
+		req.Header.Set(EnrollmentIDHeader, id)
+		req.Body = io.NopCloser(bytes.NewReader(data))
+		req.ContentLength = int64(len(data))
+		logger.Debug(logkeys.Message, "dispatching check-in")
+		next.ServeHTTP(w, req)
+	}
+}
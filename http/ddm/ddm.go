@@ -3,9 +3,11 @@ package ddm
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/jessepeterson/kmfddm/ddm"
 	"github.com/jessepeterson/kmfddm/logkeys"
@@ -30,6 +32,43 @@ func ErrorAndLog(w http.ResponseWriter, status int, logger log.Logger, msg strin
 	http.Error(w, http.StatusText(status), status)
 }
 
+// etag returns the quoted entity tag of token.
+func etag(token string) string {
+	return `"` + token + `"`
+}
+
+// notModified reports whether the If-None-Match header of r matches etag.
+func notModified(r *http.Request, etag string) bool {
+	h := r.Header.Get("If-None-Match")
+	if h == "" {
+		return false
+	}
+	for _, v := range strings.Split(h, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON writes rawJSON to w with an ETag of token.
+// If the request matches the ETag then only a Not Modified status is written.
+// The responses vary by enrollment ID which proxies should take into account.
+func writeJSON(w http.ResponseWriter, r *http.Request, token string, rawJSON []byte) {
+	w.Header().Set("Vary", EnrollmentIDHeader)
+	if token != "" {
+		tag := etag(token)
+		w.Header().Set("ETag", tag)
+		if notModified(r, tag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Write(rawJSON)
+}
+
 type enrollmentIDContextKey struct{}
 
 // contextEnrollmentID extracts the enrollment ID and sets up the context.
@@ -47,6 +86,8 @@ func contextEnrollmentID(r *http.Request, logger log.Logger) (context.Context, l
 }
 
 // DeclarationHandler creates a handler that fetches and returns a single declaration.
+// An ETag header of the declaration's ServerToken is included and the
+// If-None-Match header is honored.
 // The request URL path is assumed to contain the declaration type and identifier.
 // This probably requires the handler to have the path prefix stripped before use.
 func DeclarationHandler(store storage.DeclarationJSONRetriever, hLogger log.Logger) http.HandlerFunc {
@@ -77,13 +118,18 @@ func DeclarationHandler(store storage.DeclarationJSONRetriever, hLogger log.Logg
 			return
 		}
 		logger.Debug(logkeys.Message, "retrieved declaration")
-		w.Header().Set("Content-Type", jsonContentType)
-		w.Write(rawDecl)
+		var d struct{ ServerToken string }
+		if err = json.Unmarshal(rawDecl, &d); err != nil {
+			logger.Info(logkeys.Message, "parsing server token", logkeys.Error, err)
+		}
+		writeJSON(w, r, d.ServerToken, rawDecl)
 	}
 }
 
 // TokensOrDeclarationItemsHandler creates a handler that fetchs and returns either
 // the tokens or declaration items JSON for an erollment ID depending on tokens.
+// An ETag header of the DeclarationsToken is included and the
+// If-None-Match header is honored.
 func TokensOrDeclarationItemsHandler(store storage.TokensDeclarationItemsStorage, tokens bool, hLogger log.Logger) http.HandlerFunc {
 	if store == nil || hLogger == nil {
 		panic("nil store or logger")
@@ -96,20 +142,34 @@ func TokensOrDeclarationItemsHandler(store storage.TokensDeclarationItemsStorage
 		}
 		var op string
 		var rawJSON []byte
+		var token string
 		if tokens {
 			op = "tokens"
 			rawJSON, err = store.RetrieveTokensJSON(ctx, enrollmentID)
+			if err == nil {
+				var t ddm.TokensResponse
+				if jErr := json.Unmarshal(rawJSON, &t); jErr != nil {
+					logger.Info(logkeys.Message, "parsing declarations token", logkeys.Error, jErr)
+				}
+				token = t.SyncTokens.DeclarationsToken
+			}
 		} else {
 			op = "declaration items"
 			rawJSON, err = store.RetrieveDeclarationItemsJSON(ctx, enrollmentID)
+			if err == nil {
+				var di ddm.DeclarationItems
+				if jErr := json.Unmarshal(rawJSON, &di); jErr != nil {
+					logger.Info(logkeys.Message, "parsing declarations token", logkeys.Error, jErr)
+				}
+				token = di.DeclarationsToken
+			}
 		}
 		if err != nil {
 			ErrorAndLog(w, http.StatusInternalServerError, logger, "retrieving "+op, err)
 			return
 		}
 		logger.Debug("msg", "retrieved "+op)
-		w.Header().Set("Content-Type", jsonContentType)
-		w.Write(rawJSON)
+		writeJSON(w, r, token, rawJSON)
 	}
 }
 
@@ -0,0 +1,94 @@
+// Package tenant contains HTTP handlers for dispatching requests to tenants.
+package tenant
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	apihttp "github.com/jessepeterson/kmfddm/http/api"
+	ddmhttp "github.com/jessepeterson/kmfddm/http/ddm"
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/tenant"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// newContext attaches the tenant name to ctx and sets up context logging of it.
+func newContext(ctx context.Context, name string) context.Context {
+	ctx = tenant.NewContext(ctx, name)
+	return ctxlog.AddFunc(ctx, func(ctx context.Context) []interface{} {
+		return []interface{}{logkeys.Tenant, tenant.FromContext(ctx)}
+	})
+}
+
+// CredentialsHandler creates a handler that authenticates HTTP Basic
+// requests against the per-tenant API credentials and dispatches to
+// that tenant's handler with the matching credential attached to the
+// request context. The creds map is of tenant names to credentials and
+// the handlers map is of tenant names to handlers.
+func CredentialsHandler(username, realm string, creds map[string][]*apihttp.Credential, handlers map[string]http.Handler) http.HandlerFunc {
+	var allCreds []*apihttp.Credential
+	var names []string
+	for name, tenantCreds := range creds {
+		if _, ok := handlers[name]; !ok {
+			panic("no handler for tenant: " + name)
+		}
+		for _, c := range tenantCreds {
+			allCreds = append(allCreds, c)
+			names = append(names, name)
+		}
+	}
+	ubc := []byte(username)
+	rc := `Basic realm="` + realm + `"`
+	return func(w http.ResponseWriter, r *http.Request) {
+		idx := -1
+		if u, p, ok := r.BasicAuth(); ok && subtle.ConstantTimeCompare([]byte(u), ubc) == 1 {
+			idx = apihttp.MatchCredential(allCreds, p)
+		}
+		if idx < 0 {
+			w.Header().Set("Www-Authenticate", rc)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		ctx := apihttp.NewContext(newContext(r.Context(), names[idx]), allCreds[idx])
+		handlers[names[idx]].ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// EnrollmentHandler creates a handler that resolves the tenant of the
+// enrollment ID in the request and dispatches to that tenant's handler.
+// The handlers map is of tenant names to handlers.
+func EnrollmentHandler(resolver tenant.EnrollmentResolver, handlers map[string]http.Handler, logger log.Logger) http.HandlerFunc {
+	if resolver == nil || logger == nil {
+		panic("nil resolver or logger")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		id := r.Header.Get(ddmhttp.EnrollmentIDHeader)
+		if id == "" {
+			ddmhttp.ErrorAndLog(w, http.StatusBadRequest, logger, "getting enrollment id", ddmhttp.ErrEmptyEnrollmentID)
+			return
+		}
+		logger = logger.With(logkeys.EnrollmentID, id)
+		name, err := resolver.ResolveEnrollment(r.Context(), id)
+		if errors.Is(err, tenant.ErrTenantNotFound) {
+			ddmhttp.ErrorAndLog(w, http.StatusNotFound, logger, "resolving tenant", err)
+			return
+		} else if errors.Is(err, tenant.ErrTenantAmbiguous) {
+			ddmhttp.ErrorAndLog(w, http.StatusConflict, logger, "resolving tenant", err)
+			return
+		} else if err != nil {
+			ddmhttp.ErrorAndLog(w, http.StatusInternalServerError, logger, "resolving tenant", err)
+			return
+		}
+		h, ok := handlers[name]
+		if !ok {
+			ddmhttp.ErrorAndLog(w, http.StatusInternalServerError, logger.With(logkeys.Tenant, name), "dispatching to tenant", errors.New("no handler for tenant"))
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(newContext(r.Context(), name)))
+	}
+}
@@ -27,6 +27,16 @@ const (
 	ErrorCount       = "error_count"       // type: int
 	ValueCount       = "value_count"       // type: int
 
+	// ID of a queued notification job
+	NotificationJobID = "job_id" // type: string
+
+	// name of a tenant in multi-tenant configurations
+	Tenant = "tenant" // type: string
+
+	// name and role of an API credential
+	Credential = "credential" // type: string
+	Role       = "role"       // type: string
+
 	// HTTP handler
 	Handler = "handler" // type: string
 
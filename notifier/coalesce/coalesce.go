@@ -0,0 +1,130 @@
+// Package coalesce combines notifications that happen close together in time.
+package coalesce
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/notifier"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// Notifier notifies enrollments of changes.
+type Notifier interface {
+	Changed(ctx context.Context, declarations []string, sets []string, ids []string) error
+}
+
+// Coalescer accumulates changed declarations, sets, and enrollment IDs
+// for a delay after the first change and then notifies them all at once.
+// This way enrollments affected by many changes in a short time (e.g.
+// syncing a directory of declarations) are only notified once.
+type Coalescer struct {
+	next   Notifier
+	delay  time.Duration
+	logger log.Logger
+
+	mu           sync.Mutex
+	declarations map[string]struct{}
+	sets         map[string]struct{}
+	ids          map[string]struct{}
+	timer        *time.Timer
+}
+
+type Option func(*Coalescer)
+
+func WithLogger(logger log.Logger) Option {
+	return func(c *Coalescer) {
+		c.logger = logger
+	}
+}
+
+// New creates a new Coalescer that notifies next delay after the first accumulated change.
+func New(next Notifier, delay time.Duration, opts ...Option) *Coalescer {
+	if next == nil {
+		panic("nil notifier")
+	}
+	c := &Coalescer{
+		next:   next,
+		delay:  delay,
+		logger: log.NopLogger,
+
+		declarations: make(map[string]struct{}),
+		sets:         make(map[string]struct{}),
+		ids:          make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func add(m map[string]struct{}, s []string) {
+	for _, v := range s {
+		m[v] = struct{}{}
+	}
+}
+
+// take returns the sorted keys of m and empties it.
+func take(m map[string]struct{}) []string {
+	if len(m) < 1 {
+		return nil
+	}
+	r := make([]string, 0, len(m))
+	for k := range m {
+		r = append(r, k)
+		delete(m, k)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Changed accumulates the changes to be notified later.
+// Errors notifying the changes are logged rather than returned.
+// Forced notifications (see [notifier.WithForce]) are not accumulated
+// and are notified immediately instead.
+func (c *Coalescer) Changed(ctx context.Context, declarations []string, sets []string, ids []string) error {
+	if notifier.Forced(ctx) {
+		return c.next.Changed(ctx, declarations, sets, ids)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	add(c.declarations, declarations)
+	add(c.sets, sets)
+	add(c.ids, ids)
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.delay, func() {
+			if err := c.Flush(context.Background()); err != nil {
+				c.logger.Info(logkeys.Message, "flushing notifications", logkeys.Error, err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush immediately notifies any accumulated changes.
+func (c *Coalescer) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	declarations := take(c.declarations)
+	sets := take(c.sets)
+	ids := take(c.ids)
+	c.mu.Unlock()
+
+	if len(declarations) < 1 && len(sets) < 1 && len(ids) < 1 {
+		return nil
+	}
+	c.logger.Debug(
+		logkeys.Message, "flushing notifications",
+		logkeys.DeclarationCount, len(declarations),
+		"set_count", len(sets),
+		logkeys.GenericCount, len(ids),
+	)
+	return c.next.Changed(ctx, declarations, sets, ids)
+}
@@ -0,0 +1,82 @@
+package coalesce
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type call struct {
+	declarations, sets, ids []string
+}
+
+type testNotifier struct {
+	mu    sync.Mutex
+	calls []call
+	done  chan struct{}
+}
+
+func (n *testNotifier) Changed(_ context.Context, declarations []string, sets []string, ids []string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls = append(n.calls, call{declarations, sets, ids})
+	if n.done != nil {
+		close(n.done)
+		n.done = nil
+	}
+	return nil
+}
+
+func TestFlush(t *testing.T) {
+	n := new(testNotifier)
+	c := New(n, time.Hour)
+	ctx := context.Background()
+
+	c.Changed(ctx, []string{"d2"}, nil, nil)
+	c.Changed(ctx, []string{"d1", "d2"}, []string{"s1"}, nil)
+	c.Changed(ctx, nil, []string{"s1"}, []string{"id1"})
+	if len(n.calls) > 0 {
+		t.Fatal("notified before flush")
+	}
+
+	if err := c.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []call{{[]string{"d1", "d2"}, []string{"s1"}, []string{"id1"}}}
+	if !reflect.DeepEqual(n.calls, want) {
+		t.Errorf("have: %v, want: %v", n.calls, want)
+	}
+
+	// nothing accumulated; nothing to notify
+	if err := c.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(n.calls), 1; have != want {
+		t.Errorf("calls: have: %v, want: %v", have, want)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	done := make(chan struct{})
+	n := &testNotifier{done: done}
+	c := New(n, 10*time.Millisecond)
+	ctx := context.Background()
+
+	c.Changed(ctx, nil, nil, []string{"id1"})
+	c.Changed(ctx, nil, nil, []string{"id2"})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for notification")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	want := []call{{nil, nil, []string{"id1", "id2"}}}
+	if !reflect.DeepEqual(n.calls, want) {
+		t.Errorf("have: %v, want: %v", n.calls, want)
+	}
+}
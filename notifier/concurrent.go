@@ -0,0 +1,98 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FailedRequest is a request for IDs that failed with Err.
+type FailedRequest struct {
+	IDs []string
+	Err error
+}
+
+// EnqueueError aggregates the failed requests of an enqueue (or push)
+// that was split into multiple requests.
+type EnqueueError struct {
+	// Requests is the total number of requests attempted.
+	Requests int
+	Failed   []FailedRequest
+}
+
+func (e *EnqueueError) Error() string {
+	if len(e.Failed) < 1 {
+		return "no failed requests"
+	}
+	return fmt.Sprintf("%d of %d requests failed: %v", len(e.Failed), e.Requests, e.Failed[0].Err)
+}
+
+// Unwrap returns the error of the first failed request.
+func (e *EnqueueError) Unwrap() error {
+	if len(e.Failed) < 1 {
+		return nil
+	}
+	return e.Failed[0].Err
+}
+
+// FailedIDs returns the IDs of all failed requests.
+func (e *EnqueueError) FailedIDs() (ids []string) {
+	for _, f := range e.Failed {
+		ids = append(ids, f.IDs...)
+	}
+	return
+}
+
+// chunkEach returns each of ids in its own chunk.
+func chunkEach(ids []string) [][]string {
+	idChunks := make([][]string, len(ids))
+	for i, id := range ids {
+		idChunks[i] = []string{id}
+	}
+	return idChunks
+}
+
+// Concurrently calls fn for each of idChunks using at most concurrency
+// goroutines at a time. Chunks not yet started when ctx is done are
+// not called and fail with the context error.
+// If any calls fail then an [*EnqueueError] is returned.
+func Concurrently(ctx context.Context, concurrency int, idChunks [][]string, fn func(context.Context, []string) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	errs := make([]error, len(idChunks))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range idChunks {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = fn(ctx, idChunks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	e := &EnqueueError{Requests: len(idChunks)}
+	for i, err := range errs {
+		if err != nil {
+			e.Failed = append(e.Failed, FailedRequest{IDs: idChunks[i], Err: err})
+		}
+	}
+	if len(e.Failed) > 0 {
+		return e
+	}
+	return nil
+}
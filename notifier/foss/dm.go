@@ -9,8 +9,13 @@ import (
 )
 
 // EnqueueDMCommand enqueues a DeclarativeManagment command on the MDM server.
+// The command UUID is taken from ctx (see [notifier.WithCommandUUID]) or generated.
 func (m *FossMDM) EnqueueDMCommand(ctx context.Context, ids []string, tokensJSON []byte) error {
-	cmdBytes, err := notifier.MakeCommand(uuid.NewString(), tokensJSON)
+	cmdUUID := notifier.CommandUUID(ctx)
+	if cmdUUID == "" {
+		cmdUUID = uuid.NewString()
+	}
+	cmdBytes, err := notifier.MakeCommand(cmdUUID, tokensJSON)
 	if err != nil {
 		return fmt.Errorf("making command: %w", err)
 	}
@@ -6,16 +6,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/notifier"
 	"github.com/micromdm/nanolib/log"
 	"github.com/micromdm/nanolib/log/ctxlog"
 )
 
-var ErrNoIDsInIDChunk = errors.New("no ids in id chunk")
+var (
+	ErrNoIDsInIDChunk = errors.New("no ids in id chunk")
+	ErrHTTPStatus     = errors.New("unexpected HTTP status")
+)
 
 // Doer executes an HTTP request.
 type Doer interface {
@@ -39,6 +45,9 @@ type FossMDM struct {
 
 	enqURL  *url.URL // "base" URL for enqueueing commands
 	pushURL *url.URL // "base" URL for sending APNs pushes
+
+	concurrency int           // maximum number of concurrent requests
+	timeout     time.Duration // per-request timeout
 }
 
 type Option func(*FossMDM) error
@@ -81,6 +90,26 @@ func WithPush(ref string) Option {
 	}
 }
 
+// WithConcurrency sets the maximum number of concurrent enqueue or push requests.
+func WithConcurrency(n int) Option {
+	return func(m *FossMDM) error {
+		if n < 1 {
+			return errors.New("concurrency must be positive")
+		}
+		m.concurrency = n
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout of each enqueue or push request.
+// Zero means no timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(m *FossMDM) error {
+		m.timeout = timeout
+		return nil
+	}
+}
+
 // 30 is a conservative estimate for a reasonable number of URL
 // parameters in a request path considering typical limitations.
 const defaultMaxIDs = 30
@@ -92,7 +121,8 @@ func NewFossMDM(enqRef, apiKey string, opts ...Option) (*FossMDM, error) {
 		client: http.DefaultClient,
 		logger: log.NopLogger,
 
-		max: defaultMaxIDs,
+		max:         defaultMaxIDs,
+		concurrency: 1,
 
 		user:      "nanomdm",
 		apiKey:    apiKey,
@@ -142,116 +172,94 @@ func chunk(s []string, n int) (chunks [][]string) {
 }
 
 // Enqueue sends the HTTP request to enqueue rawCommand to ids on the MDM server.
+// Every chunk of ids is attempted. If any of the requests fail or the
+// MDM server responds with a non-2xx status then an [*notifier.EnqueueError] is returned.
 func (m *FossMDM) Enqueue(ctx context.Context, ids []string, rawCommand []byte) error {
 	if m.max == 1 && len(ids) > 1 {
 		// err on the side of caution so that we don't try to enqueue
 		// the same command UUID onto different ids.
 		return errors.New("multiple ids not supported")
 	}
-	buf := bytes.NewBuffer(rawCommand)
 	logger := ctxlog.Logger(ctx, m.logger).With("request", "enqueue")
-	// TODO: perhaps parallelize?
-	for _, idChunk := range chunk(ids, m.max) {
-		if len(idChunk) < 1 {
-			logger.Info(logkeys.Error, ErrNoIDsInIDChunk)
-			continue
-		}
-		idsLogger := logger.With(
-			logkeys.GenericCount, len(idChunk),
-			logkeys.FirstEnrollmentID, idChunk[0],
-		)
+	return m.concurrently(ctx, logger, ids, func(ctx context.Context, logger log.Logger, idChunk []string) error {
 		ref, err := concatURL(m.enqURL, idChunk)
 		if err != nil {
-			idsLogger.Info(
-				logkeys.Message, "creating enqueue URL",
-				logkeys.Error, err,
-			)
-			continue
-		}
-		req, err := http.NewRequestWithContext(ctx, m.enqMethod, ref, buf)
-		if err != nil {
-			idsLogger.Info(
-				logkeys.Message, "creating HTTP request",
-				logkeys.Error, err,
-			)
-			continue
+			return fmt.Errorf("creating enqueue URL: %w", err)
 		}
-		req.SetBasicAuth(m.user, m.apiKey)
-		resp, err := m.client.Do(req)
-		if err != nil {
-			idsLogger.Info(
-				logkeys.Message, "executing HTTP request",
-				logkeys.Error, err,
-			)
-			continue
-		}
-		idsLogger.Debug(
-			logkeys.Message, "enqueue command",
-			"http_status_code", resp.StatusCode,
-			"http_status", resp.Status,
-		)
-		if err = resp.Body.Close(); err != nil {
-			idsLogger.Info(
-				logkeys.Message, "closing body",
-				logkeys.Error, err,
-			)
-		}
-	}
-	return nil
+		return m.do(ctx, logger, m.enqMethod, ref, rawCommand)
+	})
 }
 
 // Push sends the HTTP request to send APNs pushes to ids on the MDM server.
+// Every chunk of ids is attempted. If any of the requests fail or the
+// MDM server responds with a non-2xx status then an [*notifier.EnqueueError] is returned.
 func (m *FossMDM) Push(ctx context.Context, ids []string) error {
 	if m.pushURL == nil {
 		return errors.New("push not configured")
 	}
 	logger := ctxlog.Logger(ctx, m.logger).With("request", "push")
-	// TODO: perhaps parallelize?
-	for _, idChunk := range chunk(ids, m.max) {
+	return m.concurrently(ctx, logger, ids, func(ctx context.Context, logger log.Logger, idChunk []string) error {
+		ref, err := concatURL(m.pushURL, idChunk)
+		if err != nil {
+			return fmt.Errorf("creating push URL: %w", err)
+		}
+		return m.do(ctx, logger, http.MethodGet, ref, nil)
+	})
+}
+
+// concurrently calls fn for each chunk of ids using up to the
+// configured number of concurrent requests.
+func (m *FossMDM) concurrently(ctx context.Context, logger log.Logger, ids []string, fn func(context.Context, log.Logger, []string) error) error {
+	return notifier.Concurrently(ctx, m.concurrency, chunk(ids, m.max), func(ctx context.Context, idChunk []string) error {
 		if len(idChunk) < 1 {
-			logger.Info(logkeys.Error, ErrNoIDsInIDChunk)
-			continue
+			return ErrNoIDsInIDChunk
 		}
 		idsLogger := logger.With(
 			logkeys.GenericCount, len(idChunk),
 			logkeys.FirstEnrollmentID, idChunk[0],
 		)
-		ref, err := concatURL(m.pushURL, idChunk)
+		err := fn(ctx, idsLogger, idChunk)
 		if err != nil {
-			idsLogger.Info(
-				logkeys.Message, "creating push URL",
-				logkeys.Error, err,
-			)
-			continue
+			idsLogger.Info(logkeys.Error, err)
 		}
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref, nil)
-		if err != nil {
-			idsLogger.Info(
-				logkeys.Message, "creating HTTP request",
-				logkeys.Error, err,
-			)
-			continue
-		}
-		req.SetBasicAuth(m.user, m.apiKey)
-		resp, err := m.client.Do(req)
-		if err != nil {
-			idsLogger.Info(
-				logkeys.Message, "executing HTTP request",
-				logkeys.Error, err,
-			)
-			continue
-		}
-		idsLogger.Debug(
-			logkeys.Message, "push",
-			"http_status_code", resp.StatusCode,
-			"http_status", resp.Status,
+		return err
+	})
+}
+
+// do sends a single HTTP request to ref, optionally with body.
+// The request is canceled after the configured timeout.
+func (m *FossMDM) do(ctx context.Context, logger log.Logger, method, ref string, body []byte) error {
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, ref, bodyReader)
+	if err != nil {
+		return fmt.Errorf("creating HTTP request: %w", err)
+	}
+	req.SetBasicAuth(m.user, m.apiKey)
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("executing HTTP request: %w", err)
+	}
+	logger.Debug(
+		logkeys.Message, "HTTP request",
+		"http_status_code", resp.StatusCode,
+		"http_status", resp.Status,
+	)
+	if err = resp.Body.Close(); err != nil {
+		logger.Info(
+			logkeys.Message, "closing body",
+			logkeys.Error, err,
 		)
-		if err = resp.Body.Close(); err != nil {
-			idsLogger.Info(
-				logkeys.Message, "closing body",
-				logkeys.Error, err,
-			)
-		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s", ErrHTTPStatus, resp.Status)
 	}
 	return nil
 }
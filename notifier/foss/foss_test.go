@@ -0,0 +1,127 @@
+package foss
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/notifier"
+)
+
+func TestEnqueue(t *testing.T) {
+	var bodies []string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	m, err := NewFossMDM(srv.URL, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.max = 1 // force a request per ID
+
+	if err = m.Enqueue(context.Background(), []string{"ID1"}, []byte("command")); err != nil {
+		t.Fatal(err)
+	}
+
+	m.max = 2
+	ids := []string{"ID1", "ID2", "ID3"}
+	if err = m.Enqueue(context.Background(), ids, []byte("command")); err != nil {
+		t.Fatal(err)
+	}
+	for i, body := range bodies {
+		if body != "command" {
+			t.Errorf("request %d: body: have: %q, want: %q", i, body, "command")
+		}
+	}
+
+	status = http.StatusInternalServerError
+	err = m.Enqueue(context.Background(), ids, []byte("command"))
+	if !errors.Is(err, ErrHTTPStatus) {
+		t.Fatalf("have: %v, want: %v", err, ErrHTTPStatus)
+	}
+	if !strings.Contains(err.Error(), "2 of 2") {
+		t.Errorf("error does not count failed requests: %v", err)
+	}
+}
+
+func TestEnqueueConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	seen := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		seen[path.Base(r.URL.Path)] = true
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		if path.Base(r.URL.Path) == "ID3" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	m, err := NewFossMDM(srv.URL, "secret", WithMicroMDM(), WithPush(srv.URL), WithConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Push(context.Background(), []string{"ID1", "ID2", "ID3", "ID4", "ID5"})
+
+	var enqErr *notifier.EnqueueError
+	if !errors.As(err, &enqErr) {
+		t.Fatalf("expected enqueue error, have: %v", err)
+	}
+	if have, want := enqErr.Requests, 5; have != want {
+		t.Errorf("requests: have: %v, want: %v", have, want)
+	}
+	if have, want := enqErr.FailedIDs(), []string{"ID3"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("failed IDs: have: %v, want: %v", have, want)
+	}
+	if have, want := len(seen), 5; have != want {
+		t.Errorf("IDs requested: have: %v, want: %v", have, want)
+	}
+	if maxInFlight > 2 {
+		t.Errorf("max in-flight requests: have: %v, want: <= 2", maxInFlight)
+	}
+}
+
+func TestEnqueueTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	m, err := NewFossMDM(srv.URL, "secret", WithTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Enqueue(context.Background(), []string{"ID1"}, []byte("command"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("have: %v, want: %v", err, context.DeadlineExceeded)
+	}
+}
@@ -4,7 +4,9 @@ package notifier
 import (
 	"context"
 	"fmt"
+	"sync"
 
+	"github.com/google/uuid"
 	"github.com/jessepeterson/kmfddm/logkeys"
 	"github.com/jessepeterson/kmfddm/storage"
 
@@ -36,6 +38,30 @@ type Notifier struct {
 	store      EnrollmentIDFinder
 	logger     log.Logger
 	sendTokens bool
+
+	// maximum number of concurrent enqueues when the enqueuer
+	// does not support multi-targeted commands.
+	concurrency int
+
+	// last declarations token sent to each enrollment.
+	// nil if unchanged suppression is not enabled.
+	lastTokens   map[string]string
+	lastTokensMu sync.RWMutex
+
+	mode   string
+	pusher Pusher
+
+	// enrollments with pending commands in hybrid mode.
+	pending   map[string]struct{}
+	pendingMu sync.Mutex
+
+	// records notifications to enrollments; nil if not tracking.
+	tracker storage.TrackedNotificationStorer
+
+	// receives notifications sent to enrollments; nil if not set.
+	sentHook storage.TrackedNotificationStorer
+
+	stats stats
 }
 
 type Option func(n *Notifier)
@@ -46,6 +72,14 @@ func WithLogger(logger log.Logger) Option {
 	}
 }
 
+// WithConcurrency sets the maximum number of commands enqueued at a
+// time when the enqueuer does not support multi-targeted commands.
+func WithConcurrency(concurrency int) Option {
+	return func(n *Notifier) {
+		n.concurrency = concurrency
+	}
+}
+
 func New(enqueuer Enqueuer, store EnrollmentIDFinder, opts ...Option) (*Notifier, error) {
 	if enqueuer == nil || store == nil {
 		panic("enqueuer nor store can be nil")
@@ -55,37 +89,90 @@ func New(enqueuer Enqueuer, store EnrollmentIDFinder, opts ...Option) (*Notifier
 		store:      store,
 		logger:     log.NopLogger,
 		sendTokens: true,
+
+		concurrency: 1,
+
+		mode: ModeEnqueue,
 	}
 	for _, opt := range opts {
 		opt(n)
 	}
+	if !validMode(n.mode) {
+		return nil, fmt.Errorf("invalid notification mode: %q", n.mode)
+	}
+	if n.mode != ModeEnqueue && n.pusher == nil {
+		return nil, fmt.Errorf("notification mode %s requires a pusher", n.mode)
+	}
 	return n, nil
 }
 
 // Change notifies (enqueues the DM command to) enrollments for which the changes apply to.
+// If unchanged suppression is enabled then enrollments whose declarations
+// token has not changed since they were last notified are skipped unless ctx
+// was created with [WithForce].
 func (n *Notifier) Changed(ctx context.Context, declarations []string, sets []string, idsIn []string) error {
 	ids, err := n.store.RetrieveEnrollmentIDs(ctx, declarations, sets, idsIn)
 	if err != nil {
 		return err
 	}
+	// enrollments may be found via multiple declarations or sets; only notify them once
+	ids = unique(ids)
+
+	suppress := n.lastTokens != nil && !Forced(ctx)
+	var tokens map[string]sentTokens
+	var tokensErr error
+	if suppress || n.tracker != nil {
+		// enrollments whose tokens failed to be retrieved are not notified
+		tokens, tokensErr = n.retrieveTokens(ctx, ids)
+		ids = succeeded(ids, tokensErr)
+	}
+	var suppressed int
+	if suppress {
+		total := len(ids)
+		ids = n.changedIDs(ids, tokens)
+		suppressed = total - len(ids)
+		n.stats.suppressed.Add(uint64(suppressed))
+	}
+
 	if len(ids) < 1 {
-		ctxlog.Logger(ctx, n.logger).Debug(logkeys.Message, "no enrollments to notify")
-		return nil
+		ctxlog.Logger(ctx, n.logger).Debug(
+			logkeys.Message, "no enrollments to notify",
+			"suppressed", suppressed,
+		)
+		return tokensErr
+	}
+
+	var pushIDs []string
+	switch n.mode {
+	case ModePush:
+		pushIDs, ids = ids, nil
+	case ModeHybrid:
+		pushIDs, ids = n.splitPending(ids)
 	}
 
 	ctxlog.Logger(ctx, n.logger).Debug(
-		logkeys.Message, "enqueueing command",
-		logkeys.GenericCount, len(ids),
-		logkeys.FirstEnrollmentID, ids[0],
+		logkeys.Message, "notifying",
+		logkeys.GenericCount, len(ids)+len(pushIDs),
+		"enqueue_count", len(ids),
+		"push_count", len(pushIDs),
 		"tokens", n.sendTokens,
+		"suppressed", suppressed,
 	)
 
-	enqueue := func(ids []string) error {
+	pushErr := n.push(ctx, pushIDs)
+	n.stats.pushed.Add(uint64(len(pushIDs) - failedCount(pushIDs, pushErr)))
+
+	var uuids commandUUIDs
+	enqueue := func(ctx context.Context, ids []string) error {
+		cmdUUID := uuid.NewString()
+		ctx = WithCommandUUID(ctx, cmdUUID)
+
 		var tokensJSON []byte
 		var err error
 		if len(ids) == 1 && n.sendTokens {
-			tokensJSON, err = n.store.RetrieveTokensJSON(ctx, ids[0])
-			if err != nil {
+			if t, ok := tokens[ids[0]]; ok {
+				tokensJSON = t.json
+			} else if tokensJSON, err = n.store.RetrieveTokensJSON(ctx, ids[0]); err != nil {
 				return fmt.Errorf("retrieving tokens JSON: %w", err)
 			}
 		}
@@ -94,21 +181,40 @@ func (n *Notifier) Changed(ctx context.Context, declarations []string, sets []st
 			return fmt.Errorf("enqueueing DM command: %w", err)
 		}
 
+		uuids.add(ids, cmdUUID)
 		return nil
 	}
 
-	if !n.enqueuer.SupportsMultiCommands() {
-		// consider making this some kind of worker pool?
-		for i, id := range ids {
-			if err = enqueue([]string{id}); err != nil {
-				return fmt.Errorf("enqueueing command %d/%d: %w", i+1, len(ids), err)
-			}
-		}
-
-		return nil
+	if len(ids) < 1 {
+		// nothing to enqueue
+	} else if !n.enqueuer.SupportsMultiCommands() {
+		// enqueue a separate command for each id
+		err = Concurrently(ctx, n.concurrency, chunkEach(ids), enqueue)
+	} else {
+		err = enqueue(ctx, ids)
 	}
+	if n.pending != nil {
+		n.markPending(succeeded(ids, err))
+	}
+	err = mergeErrors(pushErr, err)
+	notified := append(pushIDs, ids...)
+	n.sent(notified, tokens, err)
+	n.track(ctx, succeeded(notified, err), tokens, &uuids)
+	return mergeErrors(tokensErr, err)
+}
 
-	return enqueue(ids)
+// unique returns s with duplicate strings removed preserving order.
+func unique(s []string) []string {
+	seen := make(map[string]struct{}, len(s))
+	r := make([]string, 0, len(s))
+	for _, v := range s {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		r = append(r, v)
+	}
+	return r
 }
 
 // MakeCommand returns a raw MDM command in plist form using uuid and optionally tokensJSON.
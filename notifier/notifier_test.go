@@ -3,8 +3,14 @@ package notifier
 import (
 	"bytes"
 	"context"
+	"errors"
 	"reflect"
+	"sort"
+	"sync"
 	"testing"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/storage"
 )
 
 type testEnqueuer struct {
@@ -81,3 +87,342 @@ func TestNotifier(t *testing.T) {
 	}
 
 }
+
+type failingEnqueuer struct {
+	mu     sync.Mutex
+	failID string
+	ids    []string
+}
+
+func (e *failingEnqueuer) EnqueueDMCommand(ctx context.Context, ids []string, tokensJSON []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ids = append(e.ids, ids...)
+	if ids[0] == e.failID {
+		return errors.New("enqueue failed")
+	}
+	return nil
+}
+
+func (e *failingEnqueuer) SupportsMultiCommands() bool {
+	return false
+}
+
+func TestNotifierConcurrency(t *testing.T) {
+	e := &failingEnqueuer{failID: "id3"}
+	n, err := New(e, &testStore{}, WithConcurrency(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{"id1", "id2", "id3", "id4", "id5"}
+	err = n.Changed(context.Background(), nil, nil, ids)
+
+	var enqErr *EnqueueError
+	if !errors.As(err, &enqErr) {
+		t.Fatalf("expected enqueue error, have: %v", err)
+	}
+	if have, want := enqErr.FailedIDs(), []string{"id3"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("failed IDs: have: %v, want: %v", have, want)
+	}
+	sort.Strings(e.ids)
+	if !reflect.DeepEqual(e.ids, ids) {
+		t.Errorf("enqueued IDs: have: %v, want: %v", e.ids, ids)
+	}
+
+	// canceled contexts should not enqueue
+	e.ids = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = n.Changed(ctx, nil, nil, ids)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("have: %v, want: %v", err, context.Canceled)
+	}
+	if len(e.ids) > 0 {
+		t.Errorf("enqueued IDs after cancel: %v", e.ids)
+	}
+}
+
+type tokenStore struct {
+	mu        sync.Mutex
+	tokens    map[string]string
+	retrieved int
+	failID    string
+}
+
+func (s *tokenStore) RetrieveTokensJSON(ctx context.Context, enrollmentID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retrieved++
+	if enrollmentID == s.failID {
+		return nil, errors.New("retrieving tokens failed")
+	}
+	return []byte(`{"SyncTokens":{"DeclarationsToken":"` + s.tokens[enrollmentID] + `"}}`), nil
+}
+
+func (s *tokenStore) RetrieveEnrollmentIDs(ctx context.Context, declarations []string, sets []string, ids []string) ([]string, error) {
+	return ids, nil
+}
+
+func TestNotifierSuppression(t *testing.T) {
+	e := &failingEnqueuer{}
+	s := &tokenStore{tokens: map[string]string{"id1": "a", "id2": "a", "id3": "a"}}
+	n, err := New(e, s, WithUnchangedSuppression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	ids := []string{"id1", "id2", "id3"}
+
+	notified := func(t *testing.T, ctx context.Context, want []string) {
+		t.Helper()
+		e.ids = nil
+		if err := n.Changed(ctx, nil, nil, ids); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(e.ids)
+		if !reflect.DeepEqual(e.ids, want) {
+			t.Errorf("notified: have: %v, want: %v", e.ids, want)
+		}
+	}
+
+	notified(t, ctx, ids)
+	notified(t, ctx, nil)
+
+	s.tokens["id2"] = "b"
+	notified(t, ctx, []string{"id2"})
+
+	// forced notifications ignore tokens
+	notified(t, WithForce(ctx), ids)
+
+	// failed notifications are not remembered
+	s.tokens["id3"] = "b"
+	e.failID = "id3"
+	e.ids = nil
+	if err = n.Changed(ctx, nil, nil, ids); err == nil {
+		t.Fatal("expected error")
+	}
+	e.failID = ""
+	notified(t, ctx, []string{"id3"})
+
+	want := Stats{Notified: 8, Suppressed: 9}
+	if have := n.Stats(); have != want {
+		t.Errorf("stats: have: %+v, want: %+v", have, want)
+	}
+}
+
+type testPusher struct {
+	lastIDs []string
+}
+
+func (p *testPusher) Push(ctx context.Context, ids []string) error {
+	p.lastIDs = ids
+	return nil
+}
+
+func TestNotifierPush(t *testing.T) {
+	if _, err := New(new(testEnqueuer), new(testStore), WithPush(ModePush, nil)); err == nil {
+		t.Error("expected error for push mode without pusher")
+	}
+	if _, err := New(new(testEnqueuer), new(testStore), WithPush("invalid", new(testPusher))); err == nil {
+		t.Error("expected error for invalid mode")
+	}
+
+	e := new(testEnqueuer)
+	p := new(testPusher)
+	n, err := New(e, &testStore{tokens: []byte("hello")}, WithPush(ModePush, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Changed(context.Background(), nil, nil, []string{"id1", "id2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.lastIDs != nil {
+		t.Errorf("enqueued in push mode: %v", e.lastIDs)
+	}
+	if have, want := p.lastIDs, []string{"id1", "id2"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("pushed: have: %v, want: %v", have, want)
+	}
+	if have, want := n.Stats(), (Stats{Notified: 2, Pushed: 2}); have != want {
+		t.Errorf("stats: have: %v, want: %v", have, want)
+	}
+}
+
+func TestNotifierHybrid(t *testing.T) {
+	e := new(testEnqueuer)
+	p := new(testPusher)
+	n, err := New(e, &testStore{tokens: []byte("hello")}, WithPush(ModeHybrid, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// first change enqueues a command without tokens
+	if err = n.Changed(ctx, nil, nil, []string{"id1"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := e.lastIDs, []string{"id1"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("enqueued: have: %v, want: %v", have, want)
+	}
+	if len(e.lastTokens) > 0 {
+		t.Errorf("tokens should not be present: %s", e.lastTokens)
+	}
+	if p.lastIDs != nil {
+		t.Errorf("pushed: %v", p.lastIDs)
+	}
+
+	// id1 has a pending command so is only pushed
+	e.lastIDs = nil
+	if err = n.Changed(ctx, nil, nil, []string{"id1", "id2"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := p.lastIDs, []string{"id1"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("pushed: have: %v, want: %v", have, want)
+	}
+	if have, want := e.lastIDs, []string{"id2"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("enqueued: have: %v, want: %v", have, want)
+	}
+
+	// once synced id1 is enqueued again
+	n.Synced("id1")
+	e.lastIDs, p.lastIDs = nil, nil
+	if err = n.Changed(ctx, nil, nil, []string{"id1", "id2"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := p.lastIDs, []string{"id2"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("pushed: have: %v, want: %v", have, want)
+	}
+	if have, want := e.lastIDs, []string{"id1"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("enqueued: have: %v, want: %v", have, want)
+	}
+
+	if have, want := n.Stats(), (Stats{Notified: 5, Pushed: 2}); have != want {
+		t.Errorf("stats: have: %v, want: %v", have, want)
+	}
+}
+
+type trackingStore struct {
+	notifications []storage.TrackedNotification
+}
+
+func (s *trackingStore) StoreTrackedNotifications(_ context.Context, notifications []storage.TrackedNotification) error {
+	s.notifications = append(s.notifications, notifications...)
+	return nil
+}
+
+type uuidEnqueuer struct {
+	testEnqueuer
+	uuids []string
+}
+
+func (e *uuidEnqueuer) EnqueueDMCommand(ctx context.Context, ids []string, tokensJSON []byte) error {
+	e.uuids = append(e.uuids, CommandUUID(ctx))
+	return e.testEnqueuer.EnqueueDMCommand(ctx, ids, tokensJSON)
+}
+
+func TestNotifierTracking(t *testing.T) {
+	e := &uuidEnqueuer{testEnqueuer: testEnqueuer{noMulti: true}}
+	ts := new(trackingStore)
+	s := &tokenStore{tokens: map[string]string{"id1": "t1", "id2": "t2"}}
+	n, err := New(e, s, WithTracking(ts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Changed(context.Background(), nil, nil, []string{"id1", "id2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.notifications) != 2 {
+		t.Fatalf("tracked notifications: have: %d, want: 2", len(ts.notifications))
+	}
+	sort.Slice(ts.notifications, func(i, j int) bool {
+		return ts.notifications[i].EnrollmentID < ts.notifications[j].EnrollmentID
+	})
+	sort.Strings(e.uuids)
+	var uuids []string
+	for i, ntf := range ts.notifications {
+		if have, want := ntf.DeclarationsToken, s.tokens[ntf.EnrollmentID]; have != want {
+			t.Errorf("%d: declarations token: have: %v, want: %v", i, have, want)
+		}
+		if ntf.NotifiedAt.IsZero() {
+			t.Errorf("%d: zero notified at", i)
+		}
+		uuids = append(uuids, ntf.CommandUUID)
+	}
+	sort.Strings(uuids)
+	if !reflect.DeepEqual(uuids, e.uuids) || uuids[0] == uuids[1] {
+		t.Errorf("command UUIDs: have: %v, want distinct: %v", uuids, e.uuids)
+	}
+}
+
+func TestNotifierTokensFailed(t *testing.T) {
+	e := &failingEnqueuer{}
+	ts := new(trackingStore)
+	s := &tokenStore{tokens: map[string]string{"id1": "t1", "id2": "t2", "id3": "t3"}, failID: "id2"}
+	n, err := New(e, s, WithConcurrency(3), WithTracking(ts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Changed(context.Background(), nil, nil, []string{"id1", "id2", "id3"})
+
+	// enrollments whose tokens could not be retrieved are not notified
+	var enqErr *EnqueueError
+	if !errors.As(err, &enqErr) {
+		t.Fatalf("expected enqueue error, have: %v", err)
+	}
+	if have, want := enqErr.FailedIDs(), []string{"id2"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("failed IDs: have: %v, want: %v", have, want)
+	}
+	sort.Strings(e.ids)
+	if have, want := e.ids, []string{"id1", "id3"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("enqueued IDs: have: %v, want: %v", have, want)
+	}
+	if have, want := len(ts.notifications), 2; have != want {
+		t.Errorf("tracked notifications: have: %d, want: %d", have, want)
+	}
+}
+
+func TestNotifierSentHook(t *testing.T) {
+	e := &uuidEnqueuer{}
+	hs := new(trackingStore)
+	s := &tokenStore{tokens: map[string]string{"id1": "t1", "id2": "t2"}}
+	n, err := New(e, s, WithSentHook(hs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Changed(context.Background(), nil, nil, []string{"id1", "id2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(hs.notifications) != 2 {
+		t.Fatalf("sent notifications: have: %d, want: 2", len(hs.notifications))
+	}
+	for i, ntf := range hs.notifications {
+		if have, want := ntf.CommandUUID, e.uuids[0]; have != want {
+			t.Errorf("%d: command UUID: have: %v, want: %v", i, have, want)
+		}
+	}
+	// the hook alone should not need the tokens of enrollments
+	if s.retrieved != 0 {
+		t.Errorf("tokens retrieved: have: %d, want: 0", s.retrieved)
+	}
+}
+
+func TestDeclarationsSynced(t *testing.T) {
+	items := &ddm.DeclarationItems{Declarations: ddm.ManifestDeclarationItems{
+		Activations:    []ddm.ManifestDeclaration{{Identifier: "a", ServerToken: "1"}},
+		Configurations: []ddm.ManifestDeclaration{{Identifier: "c", ServerToken: "2"}},
+	}}
+	for _, test := range []struct {
+		name         string
+		declarations []ddm.DeclarationStatus
+		synced       bool
+	}{
+		{"current", []ddm.DeclarationStatus{{Identifier: "a", ServerToken: "1"}, {Identifier: "c", ServerToken: "2"}}, true},
+		{"extra", []ddm.DeclarationStatus{{Identifier: "a", ServerToken: "1"}, {Identifier: "c", ServerToken: "2"}, {Identifier: "x"}}, true},
+		{"stale", []ddm.DeclarationStatus{{Identifier: "a", ServerToken: "1"}, {Identifier: "c", ServerToken: "1"}}, false},
+		{"missing", []ddm.DeclarationStatus{{Identifier: "a", ServerToken: "1"}}, false},
+	} {
+		if have, want := DeclarationsSynced(items, test.declarations), test.synced; have != want {
+			t.Errorf("%s: have: %v, want: %v", test.name, have, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Pusher sends APNs pushes to enrollments.
+type Pusher interface {
+	// Push sends APNs pushes to ids.
+	Push(ctx context.Context, ids []string) error
+}
+
+// Notification modes.
+const (
+	// ModeEnqueue enqueues a DeclarativeManagement command to notify enrollments.
+	ModeEnqueue = "enqueue"
+
+	// ModePush only sends APNs pushes to notify enrollments.
+	// This assumes enrollments already have a pending DeclarativeManagement
+	// command queued on the MDM server.
+	ModePush = "push"
+
+	// ModeHybrid enqueues a DeclarativeManagement command to enrollments
+	// that have no pending command and sends APNs pushes to those that do.
+	ModeHybrid = "hybrid"
+)
+
+// WithPush configures notification mode with pusher.
+// The mode must be one of [ModeEnqueue], [ModePush], or [ModeHybrid].
+//
+// In hybrid mode enrollments are tracked as having a pending command
+// from when it is enqueued until [Notifier.Synced] is called for them.
+// Tokens are not included in the command so that enrollments are
+// forced to fetch their tokens when they process the command.
+func WithPush(mode string, pusher Pusher) Option {
+	return func(n *Notifier) {
+		n.mode = mode
+		n.pusher = pusher
+		if mode == ModeHybrid {
+			n.pending = make(map[string]struct{})
+			n.sendTokens = false
+		}
+	}
+}
+
+// validMode reports whether mode is a known notification mode.
+func validMode(mode string) bool {
+	switch mode {
+	case ModeEnqueue, ModePush, ModeHybrid:
+		return true
+	}
+	return false
+}
+
+// Synced records that enrollmentID has synchronized its declarations
+// (i.e. processed any pending DeclarativeManagement command).
+// Only used in hybrid mode.
+func (n *Notifier) Synced(enrollmentID string) {
+	if n.pending == nil {
+		return
+	}
+	n.pendingMu.Lock()
+	defer n.pendingMu.Unlock()
+	delete(n.pending, enrollmentID)
+}
+
+// splitPending returns the enrollment IDs in ids that have and do not have pending commands.
+func (n *Notifier) splitPending(ids []string) (pending, notPending []string) {
+	n.pendingMu.Lock()
+	defer n.pendingMu.Unlock()
+	for _, id := range ids {
+		if _, ok := n.pending[id]; ok {
+			pending = append(pending, id)
+		} else {
+			notPending = append(notPending, id)
+		}
+	}
+	return
+}
+
+// markPending records ids as having pending commands.
+func (n *Notifier) markPending(ids []string) {
+	n.pendingMu.Lock()
+	defer n.pendingMu.Unlock()
+	for _, id := range ids {
+		n.pending[id] = struct{}{}
+	}
+}
+
+// push sends APNs pushes to ids.
+func (n *Notifier) push(ctx context.Context, ids []string) error {
+	if len(ids) < 1 {
+		return nil
+	}
+	if err := n.pusher.Push(ctx, ids); err != nil {
+		return fmt.Errorf("pushing: %w", err)
+	}
+	return nil
+}
+
+// failedCount returns the number of ids that failed per err.
+func failedCount(ids []string, err error) int {
+	if err == nil {
+		return 0
+	}
+	var enqErr *EnqueueError
+	if errors.As(err, &enqErr) {
+		return len(enqErr.FailedIDs())
+	}
+	return len(ids)
+}
+
+// mergeErrors combines the errors a and b.
+// If both are [*EnqueueError]s then their failed requests are combined.
+func mergeErrors(a, b error) error {
+	if a == nil {
+		return b
+	} else if b == nil {
+		return a
+	}
+	var aErr, bErr *EnqueueError
+	if errors.As(a, &aErr) && errors.As(b, &bErr) {
+		return &EnqueueError{
+			Requests: aErr.Requests + bErr.Requests,
+			Failed:   append(append([]FailedRequest{}, aErr.Failed...), bErr.Failed...),
+		}
+	}
+	return fmt.Errorf("%w; %v", a, b)
+}
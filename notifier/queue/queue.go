@@ -0,0 +1,249 @@
+// Package queue implements a durable notification queue with retries.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/notifier"
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Notifier notifies enrollments of changes.
+type Notifier interface {
+	Changed(ctx context.Context, declarations []string, sets []string, ids []string) error
+}
+
+// Queue stores notifications as jobs and delivers them to a Notifier in the background.
+// Failed notifications are retried with exponential backoff until
+// the maximum number of attempts is reached at which point they are
+// marked as failed (i.e. "dead-lettered") and kept for inspection or replay.
+// If only some enrollments failed to be notified then only those
+// enrollments are retried. Jobs are claimed from storage before they
+// are attempted so multiple queues may share the same storage.
+type Queue struct {
+	store    storage.NotificationQueueStorage
+	notifier Notifier
+	logger   log.Logger
+
+	interval    time.Duration
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int
+	batch       int
+	lease       time.Duration
+
+	wake chan struct{}
+}
+
+type Option func(*Queue)
+
+func WithLogger(logger log.Logger) Option {
+	return func(q *Queue) {
+		q.logger = logger
+	}
+}
+
+// WithInterval sets how often the queue is checked for due jobs.
+func WithInterval(interval time.Duration) Option {
+	return func(q *Queue) {
+		q.interval = interval
+	}
+}
+
+// WithBackoff sets the delay before the first retry and the maximum delay between retries.
+// The delay doubles after each failed attempt.
+func WithBackoff(backoff, maxBackoff time.Duration) Option {
+	return func(q *Queue) {
+		q.backoff = backoff
+		q.maxBackoff = maxBackoff
+	}
+}
+
+// WithMaxAttempts sets the number of attempts after which a job is marked as failed.
+// Zero means retry forever.
+func WithMaxAttempts(attempts int) Option {
+	return func(q *Queue) {
+		q.maxAttempts = attempts
+	}
+}
+
+// WithLease sets how long jobs are claimed for while they are attempted.
+// A job that is not finished within its lease may be attempted again,
+// possibly by another queue sharing the storage.
+func WithLease(lease time.Duration) Option {
+	return func(q *Queue) {
+		q.lease = lease
+	}
+}
+
+const (
+	DefaultInterval    = 10 * time.Second
+	DefaultBackoff     = 5 * time.Second
+	DefaultMaxBackoff  = time.Hour
+	DefaultMaxAttempts = 10
+	DefaultLease       = 10 * time.Minute
+
+	// maximum number of jobs processed at a time
+	defaultBatch = 100
+)
+
+// New creates a new notification queue.
+func New(store storage.NotificationQueueStorage, notifier Notifier, opts ...Option) *Queue {
+	if store == nil || notifier == nil {
+		panic("store nor notifier can be nil")
+	}
+	q := &Queue{
+		store:    store,
+		notifier: notifier,
+		logger:   log.NopLogger,
+
+		interval:    DefaultInterval,
+		backoff:     DefaultBackoff,
+		maxBackoff:  DefaultMaxBackoff,
+		maxAttempts: DefaultMaxAttempts,
+		batch:       defaultBatch,
+		lease:       DefaultLease,
+
+		wake: make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Changed queues a notification job for the changes to be delivered in the background.
+func (q *Queue) Changed(ctx context.Context, declarations []string, sets []string, ids []string) error {
+	now := time.Now()
+	job := &storage.NotificationJob{
+		ID:            uuid.NewString(),
+		Declarations:  declarations,
+		Sets:          sets,
+		EnrollmentIDs: ids,
+		Force:         notifier.Forced(ctx),
+		NextAttempt:   now,
+		CreatedAt:     now,
+	}
+	if err := q.store.StoreNotificationJob(ctx, job); err != nil {
+		return fmt.Errorf("storing notification job: %w", err)
+	}
+	ctxlog.Logger(ctx, q.logger).Debug(
+		logkeys.Message, "queued notification",
+		logkeys.NotificationJobID, job.ID,
+	)
+	q.Wake()
+	return nil
+}
+
+// Wake signals the background worker to check for due jobs now.
+func (q *Queue) Wake() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes due jobs in the background until ctx is done.
+func (q *Queue) Run(ctx context.Context) error {
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		if err := q.Process(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			q.logger.Info(logkeys.Message, "processing notification queue", logkeys.Error, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// Process claims and attempts to deliver the jobs that are due.
+func (q *Queue) Process(ctx context.Context) error {
+	now := time.Now()
+	jobs, err := q.store.ClaimNotificationJobs(ctx, now, now.Add(q.lease), q.batch)
+	if err != nil {
+		return fmt.Errorf("claiming notification jobs: %w", err)
+	}
+	for i := range jobs {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if err = q.attempt(ctx, &jobs[i]); err != nil {
+			return err
+		}
+	}
+	if len(jobs) >= q.batch {
+		// there may be more due jobs; don't wait for the next interval
+		q.Wake()
+	}
+	return nil
+}
+
+// attempt delivers job to the notifier and then deletes, reschedules, or fails job.
+func (q *Queue) attempt(ctx context.Context, job *storage.NotificationJob) error {
+	logger := q.logger.With(logkeys.NotificationJobID, job.ID)
+	if job.Force {
+		ctx = notifier.WithForce(ctx)
+	}
+	nErr := q.notifier.Changed(ctx, job.Declarations, job.Sets, job.EnrollmentIDs)
+	if nErr == nil {
+		logger.Debug(logkeys.Message, "delivered notification", "attempts", job.Attempts+1)
+		if err := q.store.DeleteNotificationJob(ctx, job.ID); err != nil {
+			return fmt.Errorf("deleting notification job: %w", err)
+		}
+		return nil
+	}
+
+	job.Attempts++
+	job.LastError = nErr.Error()
+	logs := []interface{}{
+		logkeys.Message, "notification attempt",
+		"attempts", job.Attempts,
+		logkeys.Error, nErr,
+	}
+	var enqErr *notifier.EnqueueError
+	if errors.As(nErr, &enqErr) {
+		if ids := enqErr.FailedIDs(); len(ids) > 0 {
+			// only the failed enrollments need to be retried
+			job.Declarations = nil
+			job.Sets = nil
+			job.EnrollmentIDs = ids
+			logs = append(logs, "failed_ids", len(ids))
+		}
+	}
+	if q.maxAttempts > 0 && job.Attempts >= q.maxAttempts {
+		job.Failed = true
+		logs = append(logs, "failed", true)
+	} else {
+		job.NextAttempt = time.Now().Add(q.delay(job.Attempts))
+		logs = append(logs, "next_attempt", job.NextAttempt)
+	}
+	logger.Info(logs...)
+	if err := q.store.StoreNotificationJob(ctx, job); err != nil {
+		return fmt.Errorf("storing notification job: %w", err)
+	}
+	return nil
+}
+
+// delay returns the backoff delay after attempts failed attempts.
+func (q *Queue) delay(attempts int) time.Duration {
+	d := q.backoff
+	for i := 1; i < attempts && d < q.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > q.maxBackoff {
+		d = q.maxBackoff
+	}
+	return d
+}
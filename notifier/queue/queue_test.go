@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/notifier"
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/jessepeterson/kmfddm/storage/inmem"
+
+	"github.com/cespare/xxhash"
+)
+
+type testNotifier struct {
+	err     error
+	lastIDs []string
+	calls   int
+}
+
+func (n *testNotifier) Changed(_ context.Context, _ []string, _ []string, ids []string) error {
+	n.calls++
+	n.lastIDs = ids
+	return n.err
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	store := inmem.New(func() hash.Hash { return xxhash.New() })
+	n := &testNotifier{err: errors.New("MDM server unavailable")}
+	q := New(store, n, WithBackoff(0, 0), WithMaxAttempts(2))
+
+	err := q.Changed(ctx, nil, nil, []string{"ID1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.calls != 0 {
+		t.Fatal("notifier called before processing queue")
+	}
+
+	pending := func(failed bool) []storage.NotificationJob {
+		t.Helper()
+		jobs, err := store.RetrieveNotificationJobs(ctx, storage.NotificationJobFilter{Failed: failed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jobs
+	}
+
+	// first attempt fails and is retried
+	if err = q.Process(ctx); err != nil {
+		t.Fatal(err)
+	}
+	jobs := pending(false)
+	if have, want := len(jobs), 1; have != want {
+		t.Fatalf("pending jobs: have: %v, want: %v", have, want)
+	}
+	if have, want := jobs[0].Attempts, 1; have != want {
+		t.Errorf("attempts: have: %v, want: %v", have, want)
+	}
+	if have, want := jobs[0].LastError, n.err.Error(); have != want {
+		t.Errorf("last error: have: %v, want: %v", have, want)
+	}
+
+	// second attempt fails and is dead-lettered
+	if err = q.Process(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(pending(false)), 0; have != want {
+		t.Fatalf("pending jobs: have: %v, want: %v", have, want)
+	}
+	jobs = pending(true)
+	if have, want := len(jobs), 1; have != want {
+		t.Fatalf("failed jobs: have: %v, want: %v", have, want)
+	}
+
+	// failed jobs are not attempted
+	if err = q.Process(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := n.calls, 2; have != want {
+		t.Errorf("calls: have: %v, want: %v", have, want)
+	}
+
+	// replay and deliver
+	jobs[0].Replay(time.Now())
+	if err = store.StoreNotificationJob(ctx, &jobs[0]); err != nil {
+		t.Fatal(err)
+	}
+	n.err = nil
+	if err = q.Process(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := n.lastIDs, []string{"ID1"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("ids: have: %v, want: %v", have, want)
+	}
+	if have, want := len(pending(false))+len(pending(true)), 0; have != want {
+		t.Errorf("jobs: have: %v, want: %v", have, want)
+	}
+}
+
+func TestQueuePartialFailure(t *testing.T) {
+	ctx := context.Background()
+	store := inmem.New(func() hash.Hash { return xxhash.New() })
+	n := &testNotifier{err: &notifier.EnqueueError{
+		Requests: 2,
+		Failed:   []notifier.FailedRequest{{IDs: []string{"ID2"}, Err: errors.New("MDM server unavailable")}},
+	}}
+	q := New(store, n, WithBackoff(0, 0))
+
+	err := q.Changed(ctx, []string{"D1"}, []string{"S1"}, []string{"ID1", "ID2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Process(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the failed IDs are retried
+	jobs, err := store.RetrieveNotificationJobs(ctx, storage.NotificationJobFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(jobs), 1; have != want {
+		t.Fatalf("pending jobs: have: %v, want: %v", have, want)
+	}
+	if len(jobs[0].Declarations) > 0 || len(jobs[0].Sets) > 0 {
+		t.Errorf("expected no declarations or sets: %v, %v", jobs[0].Declarations, jobs[0].Sets)
+	}
+	if have, want := jobs[0].EnrollmentIDs, []string{"ID2"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("ids: have: %v, want: %v", have, want)
+	}
+
+	n.err = nil
+	if err = q.Process(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := n.lastIDs, []string{"ID2"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("ids: have: %v, want: %v", have, want)
+	}
+}
+
+func TestQueueClaimed(t *testing.T) {
+	ctx := context.Background()
+	store := inmem.New(func() hash.Hash { return xxhash.New() })
+	n := new(testNotifier)
+	q := New(store, n)
+
+	if err := q.Changed(ctx, nil, nil, []string{"ID1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// another instance sharing the storage claims the job
+	now := time.Now()
+	jobs, err := store.ClaimNotificationJobs(ctx, now, now.Add(time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(jobs), 1; have != want {
+		t.Fatalf("claimed jobs: have: %v, want: %v", have, want)
+	}
+	if err = q.Process(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := n.calls, 0; have != want {
+		t.Errorf("calls: have: %v, want: %v", have, want)
+	}
+
+	// storing the job releases the claim
+	if err = store.StoreNotificationJob(ctx, &jobs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Process(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := n.calls, 1; have != want {
+		t.Errorf("calls: have: %v, want: %v", have, want)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	q := &Queue{backoff: time.Second, maxBackoff: 10 * time.Second}
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		50: 10 * time.Second,
+	} {
+		if have := q.delay(attempts); have != want {
+			t.Errorf("attempts %d: have: %v, want: %v", attempts, have, want)
+		}
+	}
+}
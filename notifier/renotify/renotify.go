@@ -0,0 +1,146 @@
+// Package renotify notifies enrollments again that have not synced long after being notified.
+package renotify
+
+import (
+	"context"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/notifier"
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// Notifier notifies enrollments of changes.
+type Notifier interface {
+	Changed(ctx context.Context, declarations []string, sets []string, ids []string) error
+}
+
+const (
+	// DefaultMaxNotifications is the default number of notifications after which an unsynced enrollment is no longer notified.
+	DefaultMaxNotifications = 3
+
+	// maximum number of enrollments notified at a time
+	defaultBatch = 100
+)
+
+// Renotifier periodically notifies enrollments again that were
+// notified but have not synced within a timeout.
+type Renotifier struct {
+	store    storage.UnsyncedNotificationRetriever
+	next     Notifier
+	after    time.Duration
+	interval time.Duration
+	max      int
+	batch    int
+	logger   log.Logger
+}
+
+type Option func(*Renotifier)
+
+func WithLogger(logger log.Logger) Option {
+	return func(r *Renotifier) {
+		r.logger = logger
+	}
+}
+
+// WithInterval sets how often to check for unsynced enrollments.
+// The default is the renotify timeout.
+func WithInterval(interval time.Duration) Option {
+	return func(r *Renotifier) {
+		r.interval = interval
+	}
+}
+
+// WithMaxNotifications sets the number of notifications after which an
+// unsynced enrollment is no longer notified again. Zero means no limit.
+func WithMaxNotifications(max int) Option {
+	return func(r *Renotifier) {
+		r.max = max
+	}
+}
+
+// New creates a new Renotifier that notifies enrollments with next that
+// have not synced within after of being notified.
+func New(store storage.UnsyncedNotificationRetriever, next Notifier, after time.Duration, opts ...Option) *Renotifier {
+	if store == nil || next == nil {
+		panic("nil store or notifier")
+	}
+	r := &Renotifier{
+		store:    store,
+		next:     next,
+		after:    after,
+		interval: after,
+		max:      DefaultMaxNotifications,
+		batch:    defaultBatch,
+		logger:   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run checks for unsynced enrollments every interval until ctx is done.
+func (r *Renotifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Process(ctx); err != nil {
+				r.logger.Info(logkeys.Message, "renotifying", logkeys.Error, err)
+			}
+		}
+	}
+}
+
+// Process notifies the enrollments that have not synced within the timeout.
+// Unsynced enrollments are retrieved and notified in pages until none are left.
+// Notifications are forced so that they are not suppressed (see [notifier.WithForce]).
+func (r *Renotifier) Process(ctx context.Context) error {
+	filter := storage.UnsyncedFilter{
+		NotifiedBefore:   time.Now().Add(-r.after),
+		MaxNotifications: r.max,
+		Limit:            r.batch,
+	}
+	var firstErr error
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		unsynced, err := r.store.RetrieveUnsyncedNotifications(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if len(unsynced) < 1 {
+			break
+		}
+		ids := make([]string, 0, len(unsynced))
+		for _, n := range unsynced {
+			ids = append(ids, n.EnrollmentID)
+		}
+		r.logger.Debug(
+			logkeys.Message, "renotifying unsynced enrollments",
+			logkeys.FirstEnrollmentID, ids[0],
+			logkeys.GenericCount, len(ids),
+		)
+		if err = r.next.Changed(notifier.WithForce(ctx), nil, nil, ids); err != nil {
+			// keep notifying the remaining pages
+			r.logger.Info(logkeys.Message, "renotifying", logkeys.FirstEnrollmentID, ids[0], logkeys.Error, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if len(unsynced) < filter.Limit {
+			break
+		}
+		last := unsynced[len(unsynced)-1]
+		filter.AfterNotifiedAt = last.NotifiedAt
+		filter.AfterEnrollmentID = last.EnrollmentID
+	}
+	return firstErr
+}
@@ -0,0 +1,102 @@
+package renotify
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/notifier"
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+type testStore struct {
+	notifications []storage.TrackedNotification
+}
+
+func (s *testStore) RetrieveUnsyncedNotifications(_ context.Context, filter storage.UnsyncedFilter) ([]storage.TrackedNotification, error) {
+	var r []storage.TrackedNotification
+	for i := range s.notifications {
+		if filter.Match(&s.notifications[i]) {
+			r = append(r, s.notifications[i])
+		}
+	}
+	storage.SortTrackedNotifications(r)
+	if filter.Limit > 0 && len(r) > filter.Limit {
+		r = r[:filter.Limit]
+	}
+	return r, nil
+}
+
+type testNotifier struct {
+	ids    []string
+	calls  int
+	forced bool
+}
+
+func (n *testNotifier) Changed(ctx context.Context, _ []string, _ []string, ids []string) error {
+	n.ids = append(n.ids, ids...)
+	n.calls++
+	n.forced = notifier.Forced(ctx)
+	return nil
+}
+
+func TestProcess(t *testing.T) {
+	now := time.Now()
+	s := &testStore{notifications: []storage.TrackedNotification{
+		{EnrollmentID: "old", NotifiedAt: now.Add(-time.Hour), Notifications: 1},
+		{EnrollmentID: "new", NotifiedAt: now, Notifications: 1},
+		{EnrollmentID: "synced", NotifiedAt: now.Add(-time.Hour), SyncedAt: now},
+		{EnrollmentID: "exhausted", NotifiedAt: now.Add(-time.Hour), Notifications: 3},
+	}}
+	n := new(testNotifier)
+	r := New(s, n, time.Minute, WithMaxNotifications(3))
+
+	if err := r.Process(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := n.ids, []string{"old"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("renotified: have: %v, want: %v", have, want)
+	}
+	if !n.forced {
+		t.Error("renotification not forced")
+	}
+
+	// no limit
+	n = new(testNotifier)
+	r = New(s, n, time.Minute, WithMaxNotifications(0))
+	if err := r.Process(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := n.ids, []string{"exhausted", "old"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("renotified: have: %v, want: %v", have, want)
+	}
+}
+
+func TestProcessPages(t *testing.T) {
+	now := time.Now()
+	s := new(testStore)
+	var want []string
+	for i := 0; i < 250; i++ {
+		id := fmt.Sprintf("enr%03d", i)
+		s.notifications = append(s.notifications, storage.TrackedNotification{
+			EnrollmentID:  id,
+			NotifiedAt:    now.Add(-time.Hour),
+			Notifications: 1,
+		})
+		want = append(want, id)
+	}
+	n := new(testNotifier)
+	r := New(s, n, time.Minute)
+
+	if err := r.Process(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := n.calls, 3; have != want {
+		t.Errorf("notifications: have: %d, want: %d", have, want)
+	}
+	if have := n.ids; !reflect.DeepEqual(have, want) {
+		t.Errorf("renotified: have: %d ids, want: %d ids", len(have), len(want))
+	}
+}
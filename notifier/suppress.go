@@ -0,0 +1,118 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// WithUnchangedSuppression skips notifying enrollments whose declarations
+// token has not changed since the last time they were notified.
+// The last notified tokens are only kept in memory.
+func WithUnchangedSuppression() Option {
+	return func(n *Notifier) {
+		n.lastTokens = make(map[string]string)
+	}
+}
+
+type forceKey struct{}
+
+// WithForce returns a context that causes enrollments to be notified
+// even if their declarations token has not changed.
+func WithForce(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceKey{}, true)
+}
+
+// Forced reports whether ctx was created with [WithForce].
+func Forced(ctx context.Context) bool {
+	v, _ := ctx.Value(forceKey{}).(bool)
+	return v
+}
+
+// Stats are cumulative counts of enrollments notified, suppressed, and pushed.
+type Stats struct {
+	Notified   uint64 `json:"notified"`
+	Suppressed uint64 `json:"suppressed"`
+
+	// Pushed is the number of enrollments notified with only an APNs push.
+	Pushed uint64 `json:"pushed"`
+}
+
+type stats struct {
+	notified   atomic.Uint64
+	suppressed atomic.Uint64
+	pushed     atomic.Uint64
+}
+
+// Stats returns the cumulative counts of enrollments notified, suppressed, and pushed.
+func (n *Notifier) Stats() Stats {
+	return Stats{
+		Notified:   n.stats.notified.Load(),
+		Suppressed: n.stats.suppressed.Load(),
+		Pushed:     n.stats.pushed.Load(),
+	}
+}
+
+// sentTokens are the tokens JSON and its declarations token for an enrollment.
+type sentTokens struct {
+	json  []byte
+	token string
+}
+
+// changedIDs returns the enrollment IDs in ids whose declarations token
+// in tokens differs from the last notified declarations token.
+func (n *Notifier) changedIDs(ids []string, tokens map[string]sentTokens) []string {
+	var changed []string
+	n.lastTokensMu.RLock()
+	defer n.lastTokensMu.RUnlock()
+	for _, id := range ids {
+		if last, ok := n.lastTokens[id]; ok && last == tokens[id].token {
+			continue
+		}
+		changed = append(changed, id)
+	}
+	return changed
+}
+
+// succeeded returns the enrollment IDs in ids that did not fail per err.
+func succeeded(ids []string, err error) []string {
+	if err == nil {
+		return ids
+	}
+	var enqErr *EnqueueError
+	if !errors.As(err, &enqErr) {
+		// assume every enrollment failed
+		return nil
+	}
+	failed := make(map[string]bool)
+	for _, id := range enqErr.FailedIDs() {
+		failed[id] = true
+	}
+	var r []string
+	for _, id := range ids {
+		if !failed[id] {
+			r = append(r, id)
+		}
+	}
+	return r
+}
+
+// sent counts the notified enrollments and remembers their declarations tokens.
+// Enrollments are not counted or remembered if they failed per err.
+func (n *Notifier) sent(ids []string, tokens map[string]sentTokens, err error) {
+	ids = succeeded(ids, err)
+	n.stats.notified.Add(uint64(len(ids)))
+	if tokens == nil {
+		return
+	}
+	if n.lastTokens == nil {
+		return
+	}
+	n.lastTokensMu.Lock()
+	defer n.lastTokensMu.Unlock()
+	for _, id := range ids {
+		if t, ok := tokens[id]; ok {
+			n.lastTokens[id] = t.token
+		}
+	}
+}
@@ -0,0 +1,384 @@
+// Package templated implements enqueueing MDM commands on MDM servers
+// with configurable HTTP API conventions.
+package templated
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/notifier"
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+var (
+	ErrHTTPStatus  = errors.New("unexpected HTTP status")
+	ErrMultipleIDs = errors.New("multiple ids not supported")
+)
+
+// Body formats.
+const (
+	// BodyPlist sends the raw command plist as the request body.
+	BodyPlist = "plist"
+
+	// BodyJSON sends a JSON object with the enrollment IDs, command
+	// UUID, and base64-encoded command plist as the request body.
+	BodyJSON = "json"
+)
+
+// jsonBodyTemplate is the body template of the [BodyJSON] format.
+const jsonBodyTemplate = `{"enrollment_ids":{{json .IDs}},"command_uuid":{{json .CommandUUID}},"payload":{{json (base64 .Command)}}}`
+
+// Auth schemes.
+const (
+	AuthNone   = "none"
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+)
+
+// Doer executes an HTTP request.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Data is the data URL and body templates are executed with.
+type Data struct {
+	// IDs are the enrollment IDs of the request.
+	IDs []string
+
+	// CommandUUID is the UUID of the command.
+	CommandUUID string
+
+	// Command is the raw command plist.
+	Command []byte
+}
+
+// funcs are the functions available to URL and body templates.
+var funcs = template.FuncMap{
+	"join":        strings.Join,
+	"pathEscape":  url.PathEscape,
+	"queryEscape": url.QueryEscape,
+	"base64":      base64.StdEncoding.EncodeToString,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Enqueuer sends requests to enqueue MDM commands on an MDM server.
+// The URL, method, headers, authentication, and body of the requests
+// are configurable.
+type Enqueuer struct {
+	logger log.Logger
+	client Doer
+
+	url    *template.Template
+	method string
+	header http.Header
+
+	auth     string
+	user     string
+	password string // HTTP Basic password or bearer token
+
+	body        *template.Template // nil for the raw command
+	contentType string
+
+	// maximum number of enrollment IDs in a single request.
+	// if set to one this effectively disables multi-command enqueueings.
+	max int
+
+	concurrency int           // maximum number of concurrent requests
+	timeout     time.Duration // per-request timeout
+}
+
+type Option func(*Enqueuer) error
+
+func WithLogger(logger log.Logger) Option {
+	return func(e *Enqueuer) error {
+		e.logger = logger
+		return nil
+	}
+}
+
+// WithClient sets the HTTP client used to send requests.
+func WithClient(client Doer) Option {
+	return func(e *Enqueuer) error {
+		e.client = client
+		return nil
+	}
+}
+
+// WithMethod sets the HTTP method of enqueue requests.
+// The default is POST.
+func WithMethod(method string) Option {
+	return func(e *Enqueuer) error {
+		if method == "" {
+			return errors.New("empty method")
+		}
+		e.method = method
+		return nil
+	}
+}
+
+// WithHeader adds an HTTP header to enqueue requests.
+func WithHeader(key, value string) Option {
+	return func(e *Enqueuer) error {
+		e.header.Add(key, value)
+		return nil
+	}
+}
+
+// WithBasicAuth authenticates enqueue requests with HTTP Basic authentication.
+func WithBasicAuth(user, password string) Option {
+	return func(e *Enqueuer) error {
+		e.auth = AuthBasic
+		e.user = user
+		e.password = password
+		return nil
+	}
+}
+
+// WithBearerToken authenticates enqueue requests with a bearer token.
+func WithBearerToken(token string) Option {
+	return func(e *Enqueuer) error {
+		e.auth = AuthBearer
+		e.password = token
+		return nil
+	}
+}
+
+// WithBody sets the body format of enqueue requests.
+// The format is either [BodyPlist] (the default) or [BodyJSON].
+func WithBody(format string) Option {
+	return func(e *Enqueuer) error {
+		switch format {
+		case BodyPlist:
+			e.body = nil
+			e.contentType = ""
+			return nil
+		case BodyJSON:
+			e.contentType = "application/json"
+			return e.parseBody(jsonBodyTemplate)
+		default:
+			return fmt.Errorf("invalid body format: %s", format)
+		}
+	}
+}
+
+// WithBodyTemplate sets the body of enqueue requests to the text/template tmpl.
+// The template is executed with [Data] and has the functions join,
+// pathEscape, queryEscape, base64, and json available.
+func WithBodyTemplate(tmpl, contentType string) Option {
+	return func(e *Enqueuer) error {
+		e.contentType = contentType
+		return e.parseBody(tmpl)
+	}
+}
+
+func (e *Enqueuer) parseBody(tmpl string) (err error) {
+	e.body, err = template.New("body").Funcs(funcs).Parse(tmpl)
+	if err != nil {
+		err = fmt.Errorf("parsing body template: %w", err)
+	}
+	return
+}
+
+// WithMaxIDs sets the maximum number of enrollment IDs in a single request.
+// Enrollment IDs are split into multiple requests to stay within the limit.
+// One disables multi-targeted commands.
+func WithMaxIDs(n int) Option {
+	return func(e *Enqueuer) error {
+		if n < 1 {
+			return errors.New("maximum IDs must be positive")
+		}
+		e.max = n
+		return nil
+	}
+}
+
+// WithConcurrency sets the maximum number of concurrent enqueue requests.
+func WithConcurrency(n int) Option {
+	return func(e *Enqueuer) error {
+		if n < 1 {
+			return errors.New("concurrency must be positive")
+		}
+		e.concurrency = n
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout of each enqueue request.
+// Zero means no timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(e *Enqueuer) error {
+		e.timeout = timeout
+		return nil
+	}
+}
+
+const defaultMaxIDs = 30
+
+// New creates a new Enqueuer. The request URL is created from the
+// text/template urlTemplate which is executed with [Data] and has the
+// same functions available as body templates. For example:
+//
+//	https://mdm.example.com/v1/enqueue/{{join .IDs ","}}
+func New(urlTemplate string, opts ...Option) (*Enqueuer, error) {
+	e := &Enqueuer{
+		client: http.DefaultClient,
+		logger: log.NopLogger,
+
+		method: http.MethodPost,
+		header: make(http.Header),
+		auth:   AuthNone,
+
+		max:         defaultMaxIDs,
+		concurrency: 1,
+	}
+	var err error
+	e.url, err = template.New("url").Funcs(funcs).Parse(urlTemplate)
+	if err != nil {
+		return e, fmt.Errorf("parsing URL template: %w", err)
+	}
+	for _, opt := range opts {
+		err = opt(e)
+		if err != nil {
+			return e, fmt.Errorf("processing option: %w", err)
+		}
+	}
+	return e, nil
+}
+
+// SupportsMultiCommands reports whether we support multi-targeted commands.
+func (e *Enqueuer) SupportsMultiCommands() bool {
+	return e.max > 1
+}
+
+// EnqueueDMCommand enqueues a DeclarativeManagment command on the MDM server.
+// The command UUID is taken from ctx (see [notifier.WithCommandUUID]) or generated.
+func (e *Enqueuer) EnqueueDMCommand(ctx context.Context, ids []string, tokensJSON []byte) error {
+	cmdUUID := notifier.CommandUUID(ctx)
+	if cmdUUID == "" {
+		cmdUUID = uuid.NewString()
+	}
+	cmdBytes, err := notifier.MakeCommand(cmdUUID, tokensJSON)
+	if err != nil {
+		return fmt.Errorf("making command: %w", err)
+	}
+	return e.Enqueue(ctx, ids, cmdUUID, cmdBytes)
+}
+
+// Enqueue sends the HTTP requests to enqueue rawCommand with cmdUUID to ids.
+// Every chunk of ids is attempted. If any of the requests fail or the
+// MDM server responds with a non-2xx status then an [*notifier.EnqueueError] is returned.
+func (e *Enqueuer) Enqueue(ctx context.Context, ids []string, cmdUUID string, rawCommand []byte) error {
+	if e.max == 1 && len(ids) > 1 {
+		// err on the side of caution so that we don't try to enqueue
+		// the same command UUID onto different ids.
+		return ErrMultipleIDs
+	}
+	logger := ctxlog.Logger(ctx, e.logger).With("request", "enqueue")
+	return notifier.Concurrently(ctx, e.concurrency, chunk(ids, e.max), func(ctx context.Context, idChunk []string) error {
+		idsLogger := logger.With(
+			logkeys.GenericCount, len(idChunk),
+			logkeys.FirstEnrollmentID, idChunk[0],
+		)
+		err := e.do(ctx, idsLogger, &Data{IDs: idChunk, CommandUUID: cmdUUID, Command: rawCommand})
+		if err != nil {
+			idsLogger.Info(logkeys.Error, err)
+		}
+		return err
+	})
+}
+
+func chunk(s []string, n int) (chunks [][]string) {
+	for i := 0; i < len(s); i += n {
+		end := i + n
+		if end > len(s) {
+			end = len(s)
+		}
+		chunks = append(chunks, s[i:end])
+	}
+	return
+}
+
+// request creates the HTTP request for data.
+func (e *Enqueuer) request(ctx context.Context, data *Data) (*http.Request, error) {
+	var buf bytes.Buffer
+	if err := e.url.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("executing URL template: %w", err)
+	}
+	ref := buf.String()
+	body := data.Command
+	if e.body != nil {
+		buf.Reset()
+		if err := e.body.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("executing body template: %w", err)
+		}
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequestWithContext(ctx, e.method, ref, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP request: %w", err)
+	}
+	for k, v := range e.header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	if e.contentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", e.contentType)
+	}
+	switch e.auth {
+	case AuthBasic:
+		req.SetBasicAuth(e.user, e.password)
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+e.password)
+	}
+	return req, nil
+}
+
+// do sends a single HTTP request for data.
+// The request is canceled after the configured timeout.
+func (e *Enqueuer) do(ctx context.Context, logger log.Logger, data *Data) error {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	req, err := e.request(ctx, data)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("executing HTTP request: %w", err)
+	}
+	logger.Debug(
+		logkeys.Message, "HTTP request",
+		"http_status_code", resp.StatusCode,
+		"http_status", resp.Status,
+	)
+	// drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if err = resp.Body.Close(); err != nil {
+		logger.Info(
+			logkeys.Message, "closing body",
+			logkeys.Error, err,
+		)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s", ErrHTTPStatus, resp.Status)
+	}
+	return nil
+}
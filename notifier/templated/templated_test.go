@@ -0,0 +1,198 @@
+package templated
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func testServer(t *testing.T, status int) (*httptest.Server, func() []testRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var reqs []testRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		reqs = append(reqs, testRequest{method: r.Method, path: r.URL.Path, header: r.Header, body: b})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []testRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return reqs
+	}
+}
+
+func TestEnqueuePlist(t *testing.T) {
+	srv, reqs := testServer(t, http.StatusOK)
+
+	e, err := New(
+		srv.URL+`/v1/enqueue/{{join .IDs ","}}`,
+		WithMethod(http.MethodPut),
+		WithBasicAuth("kmfddm", "secret"),
+		WithHeader("X-Test", "test"),
+		WithMaxIDs(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.SupportsMultiCommands() {
+		t.Error("multi-commands not supported")
+	}
+
+	if err = e.Enqueue(context.Background(), []string{"ID1", "ID2", "ID3"}, "UUID", []byte("command")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := reqs()
+	if have, want := len(r), 2; have != want {
+		t.Fatalf("requests: have: %v, want: %v", have, want)
+	}
+	paths := map[string]bool{}
+	for _, req := range r {
+		paths[req.path] = true
+		if have, want := req.method, http.MethodPut; have != want {
+			t.Errorf("method: have: %v, want: %v", have, want)
+		}
+		if have, want := string(req.body), "command"; have != want {
+			t.Errorf("body: have: %v, want: %v", have, want)
+		}
+		if have, want := req.header.Get("X-Test"), "test"; have != want {
+			t.Errorf("header: have: %v, want: %v", have, want)
+		}
+		if have, want := req.header.Get("Authorization"), "Basic "+base64.StdEncoding.EncodeToString([]byte("kmfddm:secret")); have != want {
+			t.Errorf("authorization: have: %v, want: %v", have, want)
+		}
+	}
+	if !paths["/v1/enqueue/ID1,ID2"] || !paths["/v1/enqueue/ID3"] {
+		t.Errorf("paths: have: %v", paths)
+	}
+}
+
+func TestEnqueueJSON(t *testing.T) {
+	srv, reqs := testServer(t, http.StatusCreated)
+
+	e, err := New(
+		srv.URL+"/commands",
+		WithBearerToken("token"),
+		WithBody(BodyJSON),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = e.Enqueue(context.Background(), []string{"ID1", "ID2"}, "UUID", []byte("command")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := reqs()
+	if len(r) != 1 {
+		t.Fatalf("requests: have: %v, want: 1", len(r))
+	}
+	if have, want := r[0].method, http.MethodPost; have != want {
+		t.Errorf("method: have: %v, want: %v", have, want)
+	}
+	if have, want := r[0].header.Get("Authorization"), "Bearer token"; have != want {
+		t.Errorf("authorization: have: %v, want: %v", have, want)
+	}
+	if have, want := r[0].header.Get("Content-Type"), "application/json"; have != want {
+		t.Errorf("content type: have: %v, want: %v", have, want)
+	}
+	var body struct {
+		EnrollmentIDs []string `json:"enrollment_ids"`
+		CommandUUID   string   `json:"command_uuid"`
+		Payload       []byte   `json:"payload"`
+	}
+	if err = json.Unmarshal(r[0].body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := strings.Join(body.EnrollmentIDs, ","), "ID1,ID2"; have != want {
+		t.Errorf("enrollment IDs: have: %v, want: %v", have, want)
+	}
+	if have, want := body.CommandUUID, "UUID"; have != want {
+		t.Errorf("command UUID: have: %v, want: %v", have, want)
+	}
+	if have, want := string(body.Payload), "command"; have != want {
+		t.Errorf("payload: have: %v, want: %v", have, want)
+	}
+}
+
+func TestEnqueueBodyTemplate(t *testing.T) {
+	srv, reqs := testServer(t, http.StatusOK)
+
+	e, err := New(
+		srv.URL+"/devices/{{pathEscape (index .IDs 0)}}/commands",
+		WithMaxIDs(1),
+		WithBodyTemplate(`{"udid":{{json (index .IDs 0)}},"command":{{json (base64 .Command)}}}`, "application/vnd.test+json"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SupportsMultiCommands() {
+		t.Error("multi-commands supported")
+	}
+
+	if err = e.Enqueue(context.Background(), []string{"ID1", "ID2"}, "UUID", []byte("command")); !errors.Is(err, ErrMultipleIDs) {
+		t.Errorf("have: %v, want: %v", err, ErrMultipleIDs)
+	}
+
+	if err = e.Enqueue(context.Background(), []string{"ID 1"}, "UUID", []byte("command")); err != nil {
+		t.Fatal(err)
+	}
+	r := reqs()
+	if len(r) != 1 {
+		t.Fatalf("requests: have: %v, want: 1", len(r))
+	}
+	if have, want := r[0].path, "/devices/ID 1/commands"; have != want {
+		t.Errorf("path: have: %v, want: %v", have, want)
+	}
+	if have, want := r[0].header.Get("Content-Type"), "application/vnd.test+json"; have != want {
+		t.Errorf("content type: have: %v, want: %v", have, want)
+	}
+	want := `{"udid":"ID 1","command":"` + base64.StdEncoding.EncodeToString([]byte("command")) + `"}`
+	if have := string(r[0].body); have != want {
+		t.Errorf("body: have: %v, want: %v", have, want)
+	}
+}
+
+func TestEnqueueStatus(t *testing.T) {
+	srv, _ := testServer(t, http.StatusBadGateway)
+
+	e, err := New(srv.URL, WithMaxIDs(1), WithConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = e.EnqueueDMCommand(context.Background(), []string{"ID1"}, nil)
+	if !errors.Is(err, ErrHTTPStatus) {
+		t.Errorf("have: %v, want: %v", err, ErrHTTPStatus)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	if _, err := New("{{"); err == nil {
+		t.Error("expected URL template error")
+	}
+	if _, err := New("http://localhost", WithBody("xml")); err == nil {
+		t.Error("expected body format error")
+	}
+	if _, err := New("http://localhost", WithMaxIDs(0)); err == nil {
+		t.Error("expected maximum IDs error")
+	}
+}
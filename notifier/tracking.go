@@ -0,0 +1,228 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// WithTracking records the notifications sent to enrollments in store.
+// This allows finding enrollments that were notified but have not yet synced.
+// See also [SyncStatusStorer].
+func WithTracking(store storage.TrackedNotificationStorer) Option {
+	return func(n *Notifier) {
+		n.tracker = store
+	}
+}
+
+// WithSentHook stores the notifications sent to enrollments in hook.
+// Unlike [WithTracking] no tokens are retrieved for the notifications.
+// Their DeclarationsToken is empty unless tracking is also enabled.
+func WithSentHook(hook storage.TrackedNotificationStorer) Option {
+	return func(n *Notifier) {
+		n.sentHook = hook
+	}
+}
+
+type commandUUIDKey struct{}
+
+// WithCommandUUID returns a context that carries the command UUID an
+// [Enqueuer] should use for the DeclarativeManagement command it enqueues.
+func WithCommandUUID(ctx context.Context, uuid string) context.Context {
+	return context.WithValue(ctx, commandUUIDKey{}, uuid)
+}
+
+// CommandUUID returns the command UUID from ctx created with [WithCommandUUID].
+// An empty string is returned if ctx has no command UUID.
+func CommandUUID(ctx context.Context) string {
+	v, _ := ctx.Value(commandUUIDKey{}).(string)
+	return v
+}
+
+// retrieveTokens retrieves the tokens JSON and declarations token of ids.
+// Like enqueueing the tokens are retrieved concurrently for each enrollment.
+// If any retrievals fail then the tokens of the rest are returned
+// along with an [*EnqueueError] of the failed enrollments.
+func (n *Notifier) retrieveTokens(ctx context.Context, ids []string) (map[string]sentTokens, error) {
+	tokens := make(map[string]sentTokens, len(ids))
+	var mu sync.Mutex
+	err := Concurrently(ctx, n.concurrency, chunkEach(ids), func(ctx context.Context, ids []string) error {
+		tokensJSON, err := n.store.RetrieveTokensJSON(ctx, ids[0])
+		if err != nil {
+			return fmt.Errorf("retrieving tokens JSON: %w", err)
+		}
+		var tr ddm.TokensResponse
+		if err = json.Unmarshal(tokensJSON, &tr); err != nil {
+			return fmt.Errorf("unmarshal tokens JSON: %w", err)
+		}
+		mu.Lock()
+		tokens[ids[0]] = sentTokens{json: tokensJSON, token: tr.SyncTokens.DeclarationsToken}
+		mu.Unlock()
+		return nil
+	})
+	return tokens, err
+}
+
+// commandUUIDs records the command UUID enqueued to each enrollment.
+type commandUUIDs struct {
+	mu    sync.Mutex
+	uuids map[string]string
+}
+
+func (c *commandUUIDs) add(ids []string, uuid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.uuids == nil {
+		c.uuids = make(map[string]string)
+	}
+	for _, id := range ids {
+		c.uuids[id] = uuid
+	}
+}
+
+// track records the notifications sent to ids with the tracker and sent hook.
+// Errors are logged rather than returned as the notifications were sent.
+func (n *Notifier) track(ctx context.Context, ids []string, tokens map[string]sentTokens, uuids *commandUUIDs) {
+	if (n.tracker == nil && n.sentHook == nil) || len(ids) < 1 {
+		return
+	}
+	now := time.Now()
+	notifications := make([]storage.TrackedNotification, len(ids))
+	uuids.mu.Lock()
+	for i, id := range ids {
+		notifications[i] = storage.TrackedNotification{
+			EnrollmentID:      id,
+			CommandUUID:       uuids.uuids[id],
+			DeclarationsToken: tokens[id].token,
+			NotifiedAt:        now,
+		}
+	}
+	uuids.mu.Unlock()
+	for _, s := range []struct {
+		store storage.TrackedNotificationStorer
+		msg   string
+	}{
+		{n.tracker, "storing tracked notifications"},
+		{n.sentHook, "storing sent notifications"},
+	} {
+		if s.store == nil {
+			continue
+		}
+		if err := s.store.StoreTrackedNotifications(ctx, notifications); err != nil {
+			ctxlog.Logger(ctx, n.logger).Info(
+				logkeys.Message, s.msg,
+				logkeys.GenericCount, len(ids),
+				logkeys.Error, err,
+			)
+		}
+	}
+}
+
+// SyncStorage is the notification tracking storage used by [SyncStatusStorer].
+type SyncStorage interface {
+	storage.UnsyncedNotificationRetriever
+	storage.NotificationSyncMarker
+}
+
+// SyncStatusStorer stores status reports and marks notified enrollments
+// as synced when a status report shows all of their current declarations.
+type SyncStatusStorer struct {
+	storage.StatusStorer
+	items  storage.DeclarationItemsJSONRetriever
+	store  SyncStorage
+	logger log.Logger
+}
+
+// NewSyncStatusStorer creates a new SyncStatusStorer that stores status
+// reports in next and marks synced enrollments in store. The current
+// declarations of enrollments are retrieved from items which should be
+// the same storage that serves enrollments their declaration items.
+func NewSyncStatusStorer(next storage.StatusStorer, items storage.DeclarationItemsJSONRetriever, store SyncStorage, logger log.Logger) *SyncStatusStorer {
+	if next == nil || items == nil || store == nil {
+		panic("nil storage")
+	}
+	if logger == nil {
+		logger = log.NopLogger
+	}
+	return &SyncStatusStorer{StatusStorer: next, items: items, store: store, logger: logger}
+}
+
+// StoreDeclarationStatus stores status for enrollmentID and marks the
+// enrollment as synced if its status report declarations are current.
+// Errors checking sync are logged rather than returned.
+func (s *SyncStatusStorer) StoreDeclarationStatus(ctx context.Context, enrollmentID string, status *ddm.StatusReport) error {
+	if err := s.StatusStorer.StoreDeclarationStatus(ctx, enrollmentID, status); err != nil {
+		return err
+	}
+	if status == nil || len(status.Declarations) < 1 {
+		return nil
+	}
+	if err := s.markSynced(ctx, enrollmentID, status.Declarations); err != nil {
+		ctxlog.Logger(ctx, s.logger).Info(
+			logkeys.Message, "marking synced",
+			logkeys.EnrollmentID, enrollmentID,
+			logkeys.Error, err,
+		)
+	}
+	return nil
+}
+
+func (s *SyncStatusStorer) markSynced(ctx context.Context, enrollmentID string, declarations []ddm.DeclarationStatus) error {
+	unsynced, err := s.store.RetrieveUnsyncedNotifications(ctx, storage.UnsyncedFilter{EnrollmentIDs: []string{enrollmentID}})
+	if err != nil {
+		return fmt.Errorf("retrieving unsynced notifications: %w", err)
+	} else if len(unsynced) < 1 {
+		return nil
+	}
+	itemsJSON, err := s.items.RetrieveDeclarationItemsJSON(ctx, enrollmentID)
+	if err != nil {
+		return fmt.Errorf("retrieving declaration items: %w", err)
+	}
+	var items ddm.DeclarationItems
+	if err = json.Unmarshal(itemsJSON, &items); err != nil {
+		return fmt.Errorf("unmarshal declaration items: %w", err)
+	}
+	if !DeclarationsSynced(&items, declarations) {
+		return nil
+	}
+	if err = s.store.MarkNotificationSynced(ctx, enrollmentID, time.Now()); err != nil {
+		return fmt.Errorf("marking notification synced: %w", err)
+	}
+	ctxlog.Logger(ctx, s.logger).Debug(
+		logkeys.Message, "enrollment synced",
+		logkeys.EnrollmentID, enrollmentID,
+		logkeys.CommandUUID, unsynced[0].CommandUUID,
+	)
+	return nil
+}
+
+// DeclarationsSynced reports whether every declaration in items is present in
+// the status report declarations with the same server token.
+func DeclarationsSynced(items *ddm.DeclarationItems, declarations []ddm.DeclarationStatus) bool {
+	reported := make(map[string]string, len(declarations))
+	for _, d := range declarations {
+		reported[d.Identifier] = d.ServerToken
+	}
+	for _, manifest := range [][]ddm.ManifestDeclaration{
+		items.Declarations.Activations,
+		items.Declarations.Assets,
+		items.Declarations.Configurations,
+		items.Declarations.Management,
+	} {
+		for _, d := range manifest {
+			if token, ok := reported[d.Identifier]; !ok || token != d.ServerToken {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,21 @@
+package storage
+
+import "context"
+
+// BatchStorage are storage interfaces that change declarations, sets, and enrollment sets.
+type BatchStorage interface {
+	Toucher
+	DeclarationStorer
+	DeclarationDeleter
+	DeclarationCascadeDeleter
+	SetDeclarationStorer
+	SetDeclarationRemover
+	EnrollmentSetStorer
+	EnrollmentSetRemover
+}
+
+type Transactor interface {
+	// InTx calls fn with storage whose changes are made in a single transaction.
+	// The transaction is committed if fn returns nil and rolled back otherwise.
+	InTx(ctx context.Context, fn func(ctx context.Context, store BatchStorage) error) error
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"sort"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+)
+
+// ComplianceCount is a count of enrollments and their IDs.
+type ComplianceCount struct {
+	Count         int      `json:"count"`
+	EnrollmentIDs []string `json:"enrollment_ids,omitempty"`
+}
+
+func (c *ComplianceCount) add(enrollmentIDs ...string) {
+	c.Count += len(enrollmentIDs)
+	c.EnrollmentIDs = append(c.EnrollmentIDs, enrollmentIDs...)
+}
+
+// DeclarationCompliance aggregates the reported status of a declaration
+// across all enrollments that should receive it (per set membership).
+type DeclarationCompliance struct {
+	Identifier  string `json:"identifier"`
+	ServerToken string `json:"server-token"`     // the current server token of the declaration
+	Enrollments int    `json:"enrollment_count"` // count of enrollments that should receive the declaration
+
+	Active   ComplianceCount `json:"active"`
+	Inactive ComplianceCount `json:"inactive"`
+	Valid    ComplianceCount `json:"valid"`
+	Invalid  ComplianceCount `json:"invalid"`
+	Unknown  ComplianceCount `json:"unknown"`
+
+	// Stale are enrollments that reported status for a server token
+	// other than the current server token of the declaration.
+	Stale ComplianceCount `json:"stale"`
+
+	// NoStatus are enrollments that have not reported any status for the declaration.
+	NoStatus ComplianceCount `json:"no_status"`
+}
+
+// Add tallies the reported declaration status of enrollmentID.
+// A nil status means no status has been reported for the declaration.
+func (c *DeclarationCompliance) Add(enrollmentID string, status *ddm.DeclarationStatus) {
+	c.AddGroup([]string{enrollmentID}, status)
+}
+
+// AddGroup tallies enrollmentIDs that all reported the same declaration status.
+// A nil status means no status has been reported for the declaration.
+func (c *DeclarationCompliance) AddGroup(enrollmentIDs []string, status *ddm.DeclarationStatus) {
+	c.Enrollments += len(enrollmentIDs)
+	if status == nil {
+		c.NoStatus.add(enrollmentIDs...)
+		return
+	}
+	if status.Active {
+		c.Active.add(enrollmentIDs...)
+	} else {
+		c.Inactive.add(enrollmentIDs...)
+	}
+	switch status.Valid {
+	case "valid":
+		c.Valid.add(enrollmentIDs...)
+	case "invalid":
+		c.Invalid.add(enrollmentIDs...)
+	default:
+		c.Unknown.add(enrollmentIDs...)
+	}
+	if status.ServerToken != c.ServerToken {
+		c.Stale.add(enrollmentIDs...)
+	}
+}
+
+// SortEnrollmentIDs sorts the enrollment IDs of every category.
+func (c *DeclarationCompliance) SortEnrollmentIDs() {
+	for _, cc := range []*ComplianceCount{&c.Active, &c.Inactive, &c.Valid, &c.Invalid, &c.Unknown, &c.Stale, &c.NoStatus} {
+		sort.Strings(cc.EnrollmentIDs)
+	}
+}
+
+type DeclarationComplianceRetriever interface {
+	// RetrieveDeclarationCompliance aggregates the reported status of declarationID
+	// across all enrollments that should receive it.
+	// If the declaration does not exist then [ErrDeclarationNotFound] should be returned.
+	RetrieveDeclarationCompliance(ctx context.Context, declarationID string) (*DeclarationCompliance, error)
+}
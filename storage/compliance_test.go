@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+)
+
+func TestDeclarationComplianceAdd(t *testing.T) {
+	c := &DeclarationCompliance{Identifier: "a", ServerToken: "1"}
+	c.Add("e1", &ddm.DeclarationStatus{Identifier: "a", Active: true, Valid: "valid", ServerToken: "1"})
+	c.Add("e2", &ddm.DeclarationStatus{Identifier: "a", Active: false, Valid: "unknown", ServerToken: "0"})
+	c.Add("e3", nil)
+
+	want := &DeclarationCompliance{
+		Identifier:  "a",
+		ServerToken: "1",
+		Enrollments: 3,
+		Active:      ComplianceCount{Count: 1, EnrollmentIDs: []string{"e1"}},
+		Inactive:    ComplianceCount{Count: 1, EnrollmentIDs: []string{"e2"}},
+		Valid:       ComplianceCount{Count: 1, EnrollmentIDs: []string{"e1"}},
+		Unknown:     ComplianceCount{Count: 1, EnrollmentIDs: []string{"e2"}},
+		Stale:       ComplianceCount{Count: 1, EnrollmentIDs: []string{"e2"}},
+		NoStatus:    ComplianceCount{Count: 1, EnrollmentIDs: []string{"e3"}},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("have: %+v, want: %+v", c, want)
+	}
+}
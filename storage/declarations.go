@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/jessepeterson/kmfddm/ddm"
@@ -18,13 +20,51 @@ type DeclarationStorer interface {
 	StoreDeclaration(ctx context.Context, d *ddm.Declaration) (bool, error)
 }
 
+// ErrServerTokenMismatch is returned when a stored declaration does not
+// have the expected server token.
+var ErrServerTokenMismatch = errors.New("server token mismatch")
+
+type DeclarationCASStorer interface {
+	// StoreDeclarationCAS stores a declaration only if the stored
+	// declaration has serverToken. If serverToken is empty then the
+	// declaration is only stored if it does not exist.
+	// Otherwise ErrServerTokenMismatch should be returned.
+	// If the declaration is new or has changed true should be returned.
+	StoreDeclarationCAS(ctx context.Context, d *ddm.Declaration, serverToken string) (bool, error)
+}
+
+// ErrDeclarationInUse is the error wrapped by [DeclarationInUseError].
+var ErrDeclarationInUse = errors.New("declaration in use")
+
+// DeclarationInUseError is returned when deleting a declaration that is associated with sets.
+type DeclarationInUseError struct {
+	DeclarationID string
+	Sets          []string
+}
+
+func (e *DeclarationInUseError) Error() string {
+	return fmt.Sprintf("declaration %s is referenced by %d sets", e.DeclarationID, len(e.Sets))
+}
+
+func (e *DeclarationInUseError) Unwrap() error {
+	return ErrDeclarationInUse
+}
+
 type DeclarationDeleter interface {
 	// DeleteDeclaration deletes a declaration.
 	// If the declaration was deleted true should be returned.
-	// Implementations should return an error if the declaration is associated with a set.
+	// Implementations should return a [*DeclarationInUseError] if the declaration is associated with a set.
 	DeleteDeclaration(ctx context.Context, declarationID string) (bool, error)
 }
 
+type DeclarationCascadeDeleter interface {
+	// DeleteDeclarationCascade dissociates a declaration from all sets and deletes it.
+	// If the declaration was deleted true should be returned along
+	// with the sets the declaration was dissociated from.
+	// Implementations are not required to do this atomically.
+	DeleteDeclarationCascade(ctx context.Context, declarationID string) (sets []string, changed bool, err error)
+}
+
 type DeclarationAPIRetriever interface {
 	// RetrieveDeclaration retrieves a declaration from storage.
 	RetrieveDeclaration(ctx context.Context, declarationID string) (*ddm.Declaration, error)
@@ -31,12 +31,15 @@ func newBucketWithTransform(path, name string, transform diskv.TransformFunction
 }
 
 // New creates a new storage backend that uses diskv.
-func New(path string, newHash func() hash.Hash) *Diskv {
+// Options are passed through to the key-value storage backend.
+func New(path string, newHash func() hash.Hash, opts ...kv.Option) *Diskv {
 	return &Diskv{KV: kv.New(
 		newHash,
 		newBucket(path, "declarations"),
 		newBucket(path, "sets"),
 		newBucket(path, "enrollments"),
 		newBucket(path, "status"),
+		newBucket(path, "notifications"),
+		opts...,
 	)}
 }
@@ -30,6 +30,22 @@ func (s *File) StoreDeclaration(_ context.Context, d *ddm.Declaration) (bool, er
 	return s.writeDeclarationFiles(d, false)
 }
 
+// StoreDeclarationCAS stores a declaration only if the stored declaration has serverToken.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) StoreDeclarationCAS(_ context.Context, d *ddm.Declaration, serverToken string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokenBytes, err := os.ReadFile(s.declarationTokenFilename(d.Identifier))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("reading server token: %w", err)
+	}
+	if string(tokenBytes) != serverToken {
+		return false, fmt.Errorf("%w: declaration: %s", storage.ErrServerTokenMismatch, d.Identifier)
+	}
+	return s.writeDeclarationFiles(d, false)
+}
+
 func (s *File) writeDeclarationFiles(d *ddm.Declaration, forceNewSalt bool) (bool, error) {
 	var err error
 	var token string
@@ -170,7 +186,7 @@ func (s *File) DeleteDeclaration(_ context.Context, identifier string) (bool, er
 	if len(sets) > 0 {
 		// try to maintain some semblance of referential integrity by
 		// not preventing deletion if we're with sets.
-		return false, fmt.Errorf("declaration %s contained in %d set(s)", identifier, len(sets))
+		return false, &storage.DeclarationInUseError{DeclarationID: identifier, Sets: sets}
 	}
 	rmFiles := []string{
 		s.declarationFilename(identifier),
@@ -189,6 +205,11 @@ func (s *File) DeleteDeclaration(_ context.Context, identifier string) (bool, er
 	return changed, nil
 }
 
+// DeleteDeclarationCascade is not supported by the file backend.
+func (s *File) DeleteDeclarationCascade(_ context.Context, _ string) ([]string, bool, error) {
+	return nil, false, errors.New("file storage backend does not support cascaded deletes")
+}
+
 // RetrieveDeclarations retrieves a slice of all declaration IDs.
 // See also the storage package for documentation on the storage interfaces.
 func (s *File) RetrieveDeclarations(_ context.Context) ([]string, error) {
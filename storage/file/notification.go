@@ -0,0 +1,41 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+var errNotificationQueue = errors.New("file storage backend does not support the notification queue")
+
+// StoreNotificationJob is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) StoreNotificationJob(_ context.Context, _ *storage.NotificationJob) error {
+	return errNotificationQueue
+}
+
+// RetrieveNotificationJob is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) RetrieveNotificationJob(_ context.Context, _ string) (*storage.NotificationJob, error) {
+	return nil, errNotificationQueue
+}
+
+// RetrieveNotificationJobs is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) RetrieveNotificationJobs(_ context.Context, _ storage.NotificationJobFilter) ([]storage.NotificationJob, error) {
+	return nil, errNotificationQueue
+}
+
+// ClaimNotificationJobs is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) ClaimNotificationJobs(_ context.Context, _, _ time.Time, _ int) ([]storage.NotificationJob, error) {
+	return nil, errNotificationQueue
+}
+
+// DeleteNotificationJob is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) DeleteNotificationJob(_ context.Context, _ string) error {
+	return errNotificationQueue
+}
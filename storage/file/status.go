@@ -468,6 +468,30 @@ func (s *File) RetrieveStatusValues(_ context.Context, enrollmentIDs []string, p
 	return ret, nil
 }
 
+// RetrieveStatusValueHistory is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) RetrieveStatusValueHistory(_ context.Context, _ []string, _ string) (map[string][]storage.StatusValueChange, error) {
+	return nil, fmt.Errorf("%w: not supported by the file storage backend", storage.ErrStatusHistoryDisabled)
+}
+
+// SearchStatusValues is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) SearchStatusValues(_ context.Context, _ storage.StatusValueSearch) (*storage.StatusValueSearchResult, error) {
+	return nil, errors.New("file storage backend does not support status value search")
+}
+
+// RetrieveDeclarationCompliance is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) RetrieveDeclarationCompliance(_ context.Context, _ string) (*storage.DeclarationCompliance, error) {
+	return nil, errors.New("file storage backend does not support declaration compliance")
+}
+
+// RetrieveDeclarationStatusHistory is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) RetrieveDeclarationStatusHistory(_ context.Context, _ []string, _ string) (map[string][]storage.DeclarationStatusEvent, error) {
+	return nil, fmt.Errorf("%w: not supported by the file storage backend", storage.ErrStatusHistoryDisabled)
+}
+
 // RetrieveStatusValues retrieves the status report for an enrollment ID.
 // The file storage backend only supports saving a single (the last) status report.
 // See also the storage package for documentation on the storage interfaces.
@@ -492,5 +516,14 @@ func (s *File) RetrieveStatusReport(ctx context.Context, q storage.StatusReportQ
 			report.Timestamp = fi.ModTime()
 		}
 	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, storage.ErrStatusReportNotFound
+	}
 	return report, err
 }
+
+// RetrieveUnhandledStatusPaths is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) RetrieveUnhandledStatusPaths(_ context.Context) ([]storage.UnhandledStatusPath, error) {
+	return nil, errors.New("file storage backend does not support unhandled status paths")
+}
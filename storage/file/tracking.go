@@ -0,0 +1,29 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+var errNotificationTracking = errors.New("file storage backend does not support notification tracking")
+
+// StoreTrackedNotifications is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) StoreTrackedNotifications(_ context.Context, _ []storage.TrackedNotification) error {
+	return errNotificationTracking
+}
+
+// RetrieveUnsyncedNotifications is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) RetrieveUnsyncedNotifications(_ context.Context, _ storage.UnsyncedFilter) ([]storage.TrackedNotification, error) {
+	return nil, errNotificationTracking
+}
+
+// MarkNotificationSynced is not supported by the file storage backend.
+// See also the storage package for documentation on the storage interfaces.
+func (s *File) MarkNotificationSynced(_ context.Context, _ string, _ time.Time) error {
+	return errNotificationTracking
+}
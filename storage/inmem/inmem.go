@@ -16,12 +16,15 @@ type InMem struct {
 }
 
 // New creates a new in-memory storage backend.
-func New(newHash func() hash.Hash) *InMem {
+// Options are passed through to the key-value storage backend.
+func New(newHash func() hash.Hash, opts ...kv.Option) *InMem {
 	return &InMem{KV: kv.New(
 		newHash,
 		kvtxn.New(kvmap.New()),
 		kvtxn.New(kvmap.New()),
 		kvtxn.New(kvmap.New()),
 		kvtxn.New(kvmap.New()),
+		kvtxn.New(kvmap.New()),
+		opts...,
 	)}
 }
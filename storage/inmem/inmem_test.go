@@ -6,14 +6,16 @@ import (
 	"hash/fnv"
 	"testing"
 
+	"github.com/jessepeterson/kmfddm/storage/kv"
 	"github.com/jessepeterson/kmfddm/test/e2e"
 )
 
 func TestInMem(t *testing.T) {
-	s := New(func() hash.Hash { return fnv.New128() })
+	newHash := func() hash.Hash { return fnv.New128() }
+	s := New(newHash)
 	ctx := context.Background()
 
-	t.Run("TestE2E", func(t *testing.T) {
-		e2e.TestE2E(t, ctx, s)
+	e2e.TestAll(t, ctx, s, func(t *testing.T) e2e.TestStorage {
+		return New(newHash, kv.WithStatusHistory(0))
 	})
 }
@@ -0,0 +1,64 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/micromdm/nanolib/storage/kv"
+)
+
+// RetrieveDeclarationCompliance aggregates the reported status of declarationID
+// across all enrollments that should receive it.
+func (s *KV) RetrieveDeclarationCompliance(ctx context.Context, declarationID string) (*storage.DeclarationCompliance, error) {
+	token, err := s.declarations.Get(ctx, join(keyPfxDcl, declarationID, keyDeclarationServerToken))
+	if errors.Is(err, kv.ErrKeyNotFound) {
+		// wrap kv error in the proper storage error
+		return nil, fmt.Errorf("%w: %v", storage.ErrDeclarationNotFound, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	ids, err := s.RetrieveEnrollmentIDs(ctx, []string{declarationID}, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving enrollment IDs: %w", err)
+	}
+	sort.Strings(ids)
+
+	c := &storage.DeclarationCompliance{
+		Identifier:  declarationID,
+		ServerToken: string(token),
+	}
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			// skip duplicates from multiple sets
+			continue
+		}
+
+		dStatusJSON, err := s.status.Get(ctx, join(keyPfxStaDcl, id, keySfxStaDclJso))
+		if err != nil && !errors.Is(err, kv.ErrKeyNotFound) {
+			return nil, err
+		}
+
+		var dcls []ddm.DeclarationStatus
+		if len(dStatusJSON) > 0 {
+			if err = json.Unmarshal(dStatusJSON, &dcls); err != nil {
+				return nil, fmt.Errorf("unmarshal declaration status for id: %s: %w", id, err)
+			}
+		}
+
+		var status *ddm.DeclarationStatus
+		for j := range dcls {
+			if dcls[j].Identifier == declarationID {
+				status = &dcls[j]
+				break
+			}
+		}
+		c.Add(id, status)
+	}
+	return c, nil
+}
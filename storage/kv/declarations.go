@@ -50,6 +50,19 @@ func decodeTime(s []byte) (time.Time, error) {
 // Note that a storage backend may try to create relations
 // based on the the ddm.IdentifierRefs field.
 func (s *KV) StoreDeclaration(ctx context.Context, d *ddm.Declaration) (changed bool, err error) {
+	return s.storeDeclaration(ctx, d, false, "")
+}
+
+// StoreDeclarationCAS stores a declaration only if the stored declaration has serverToken.
+// See also the storage package for documentation on the storage interfaces.
+func (s *KV) StoreDeclarationCAS(ctx context.Context, d *ddm.Declaration, serverToken string) (bool, error) {
+	return s.storeDeclaration(ctx, d, true, serverToken)
+}
+
+// storeDeclaration stores a declaration. If cas is true then the
+// declaration is only stored if the stored declaration has casToken
+// (or does not exist if casToken is empty).
+func (s *KV) storeDeclaration(ctx context.Context, d *ddm.Declaration, cas bool, casToken string) (changed bool, err error) {
 	err = kv.PerformCRUDBucketTxn(ctx, s.declarations, func(ctx context.Context, b kv.CRUDBucket) error {
 		var touch string
 		now := time.Now()
@@ -79,6 +92,12 @@ func (s *KV) StoreDeclaration(ctx context.Context, d *ddm.Declaration) (changed
 			}
 		}
 
+		if cas {
+			if storedToken := string(dMap[join(keyPfxDcl, d.Identifier, keyDeclarationServerToken)]); found != (casToken != "") || storedToken != casToken {
+				return fmt.Errorf("%w: declaration: %s", storage.ErrServerTokenMismatch, d.Identifier)
+			}
+		}
+
 		// (re-)generate the server token based on our new (or existing) data
 		serverToken := genServerToken(d, touch, encodeTime(created), s.newHash)
 
@@ -147,6 +166,27 @@ func (s *KV) TouchDeclaration(ctx context.Context, declarationID string) error {
 // Implementations should return an error if there are declarations
 // that depend on it or if the declaration is associated with a set.
 func (s *KV) DeleteDeclaration(ctx context.Context, declarationID string) (changed bool, err error) {
+	_, changed, err = s.deleteDeclaration(ctx, declarationID, false)
+	return
+}
+
+// DeleteDeclarationCascade dissociates a declaration from all sets and deletes it.
+// This is not atomic. See [KV.deleteDeclaration].
+func (s *KV) DeleteDeclarationCascade(ctx context.Context, declarationID string) (sets []string, changed bool, err error) {
+	return s.deleteDeclaration(ctx, declarationID, true)
+}
+
+// deleteDeclaration deletes a declaration. If cascade is true then the
+// declaration is first dissociated from any sets. Otherwise an error is
+// returned if the declaration is associated with any sets.
+//
+// The declarations and sets buckets can not share a transaction so the
+// set dissociation is committed separately and is visible to other
+// readers before the declaration is deleted. If deleting the declaration
+// then fails the dissociated sets are restored. This is best-effort: a
+// failure to restore them is included in the returned error.
+func (s *KV) deleteDeclaration(ctx context.Context, declarationID string, cascade bool) (sets []string, changed bool, err error) {
+	var removed []string
 	err = kv.PerformBucketTxn(ctx, s.declarations, func(ctx context.Context, b kv.Bucket) error {
 		// first check if the declaration exists
 		if found, err := b.Has(ctx, join(keyPfxDcl, declarationID, keyDeclarationType)); err != nil {
@@ -155,12 +195,17 @@ func (s *KV) DeleteDeclaration(ctx context.Context, declarationID string) (chang
 			changed = true
 
 			// then check if we're in any sets
-			sets, err := getDeclarationSets(ctx, s.sets, declarationID)
+			sets, err = getDeclarationSets(ctx, s.sets, declarationID)
 			if err != nil {
 				return err
 			}
-			if len(sets) > 0 {
-				return fmt.Errorf("declaration is referenced by %d sets", len(sets))
+			if len(sets) > 0 && !cascade {
+				return &storage.DeclarationInUseError{DeclarationID: declarationID, Sets: sets}
+			} else if len(sets) > 0 {
+				if err = s.removeDeclarationSets(ctx, declarationID, sets); err != nil {
+					return fmt.Errorf("removing declaration from sets: %w", err)
+				}
+				removed = sets
 			}
 		}
 
@@ -174,6 +219,14 @@ func (s *KV) DeleteDeclaration(ctx context.Context, declarationID string) (chang
 			join(keyPfxDcl, declarationID, keyDeclarationPayload),
 		})
 	})
+	if err != nil && len(removed) > 0 {
+		if rErr := s.restoreDeclarationSets(ctx, declarationID, removed); rErr != nil {
+			err = fmt.Errorf("%w; while restoring declaration sets: %v", err, rErr)
+		}
+	}
+	if err != nil {
+		sets = nil
+	}
 	return
 }
 
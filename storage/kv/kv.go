@@ -4,6 +4,7 @@ package kv
 import (
 	"hash"
 	"strings"
+	"sync"
 
 	"github.com/micromdm/nanolib/storage/kv"
 )
@@ -12,24 +13,50 @@ import (
 type KV struct {
 	newHash                                 func() hash.Hash
 	declarations, sets, enrollments, status kv.TxnBucketWithCRUD
+	notifications                           kv.TxnBucketWithCRUD
+
+	// serializes notification job claims with storing and deleting jobs
+	claimMu sync.Mutex
+
+	history       bool
+	historyRetain int
+}
+
+// Option configures the key-value storage backend.
+type Option func(*KV)
+
+// WithStatusHistory turns on recording of status value and declaration
+// status changes. Up to retain changes of each kind are kept per
+// enrollment ID; zero means unlimited.
+func WithStatusHistory(retain uint) Option {
+	return func(s *KV) {
+		s.history = true
+		s.historyRetain = int(retain)
+	}
 }
 
 // New creates a new storage backend that uses key-value stores.
-func New(newHash func() hash.Hash, declarations, sets, enrollments, status kv.TxnBucketWithCRUD) *KV {
+func New(newHash func() hash.Hash, declarations, sets, enrollments, status, notifications kv.TxnBucketWithCRUD, opts ...Option) *KV {
 	if newHash == nil {
 		panic("nil hasher")
 	}
-	if declarations == nil || sets == nil || enrollments == nil || status == nil {
+	if declarations == nil || sets == nil || enrollments == nil || status == nil || notifications == nil {
 		panic("nil bucket")
 	}
 
-	return &KV{
+	s := &KV{
 		newHash:      newHash,
 		declarations: declarations,
 		sets:         sets,
 		enrollments:  enrollments,
 		status:       status,
+
+		notifications: notifications,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 const (
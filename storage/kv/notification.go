@@ -0,0 +1,128 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/micromdm/nanolib/storage/kv"
+)
+
+const (
+	keyPfxNtfJob = "nj"
+
+	keySfxNtfJobJso = "json"
+	keySfxNtfJobClm = "claim"
+)
+
+// StoreNotificationJob creates or replaces the notification job with the ID of job.
+// Any claim of the job is released.
+func (s *KV) StoreNotificationJob(ctx context.Context, job *storage.NotificationJob) error {
+	if job == nil || job.ID == "" {
+		return errors.New("missing notification job ID")
+	}
+	jobJSON, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	s.claimMu.Lock()
+	defer s.claimMu.Unlock()
+	return kv.PerformBucketTxn(ctx, s.notifications, func(ctx context.Context, b kv.Bucket) error {
+		if err := b.Set(ctx, join(keyPfxNtfJob, job.ID, keySfxNtfJobJso), jobJSON); err != nil {
+			return err
+		}
+		return kv.DeleteSlice(ctx, b, []string{join(keyPfxNtfJob, job.ID, keySfxNtfJobClm)})
+	})
+}
+
+// RetrieveNotificationJob retrieves the notification job with id.
+func (s *KV) RetrieveNotificationJob(ctx context.Context, id string) (*storage.NotificationJob, error) {
+	jobJSON, err := s.notifications.Get(ctx, join(keyPfxNtfJob, id, keySfxNtfJobJso))
+	if errors.Is(err, kv.ErrKeyNotFound) {
+		// wrap kv error in the proper storage error
+		return nil, fmt.Errorf("%w: %v", storage.ErrNotificationJobNotFound, err)
+	} else if err != nil {
+		return nil, err
+	}
+	job := new(storage.NotificationJob)
+	if err = json.Unmarshal(jobJSON, job); err != nil {
+		return nil, fmt.Errorf("unmarshal notification job: %s: %w", id, err)
+	}
+	return job, nil
+}
+
+// RetrieveNotificationJobs retrieves the notification jobs selected by filter.
+func (s *KV) RetrieveNotificationJobs(ctx context.Context, filter storage.NotificationJobFilter) ([]storage.NotificationJob, error) {
+	var jobs []storage.NotificationJob
+	for _, k := range kv.AllKeysPrefix(ctx, s.notifications, keyPfxNtfJob+keySep) {
+		if !strings.HasSuffix(k, keySep+keySfxNtfJobJso) {
+			continue
+		}
+		jobJSON, err := s.notifications.Get(ctx, k)
+		if errors.Is(err, kv.ErrKeyNotFound) {
+			// deleted since we listed the keys
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var job storage.NotificationJob
+		if err = json.Unmarshal(jobJSON, &job); err != nil {
+			return nil, fmt.Errorf("unmarshal notification job: %s: %w", k, err)
+		}
+		if filter.Match(&job) {
+			jobs = append(jobs, job)
+		}
+	}
+	storage.SortNotificationJobs(jobs)
+	if filter.Limit > 0 && len(jobs) > filter.Limit {
+		jobs = jobs[:filter.Limit]
+	}
+	return jobs, nil
+}
+
+// ClaimNotificationJobs claims and returns up to limit due pending notification jobs.
+// Claims are only atomic between callers of s in the same process.
+// Key-value storage can not be shared by multiple instances to claim jobs.
+func (s *KV) ClaimNotificationJobs(ctx context.Context, now, claimedUntil time.Time, limit int) ([]storage.NotificationJob, error) {
+	s.claimMu.Lock()
+	defer s.claimMu.Unlock()
+	jobs, err := s.RetrieveNotificationJobs(ctx, storage.NotificationJobFilter{DueBy: now})
+	if err != nil {
+		return nil, err
+	}
+	var claimed []storage.NotificationJob
+	for _, job := range jobs {
+		if limit > 0 && len(claimed) >= limit {
+			break
+		}
+		claimKey := join(keyPfxNtfJob, job.ID, keySfxNtfJobClm)
+		until, err := s.notifications.Get(ctx, claimKey)
+		if err == nil {
+			if t, err := decodeTime(until); err == nil && t.After(now) {
+				// claimed by someone else
+				continue
+			}
+		} else if !errors.Is(err, kv.ErrKeyNotFound) {
+			return nil, err
+		}
+		if err = s.notifications.Set(ctx, claimKey, encodeTime(claimedUntil)); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, job)
+	}
+	return claimed, nil
+}
+
+// DeleteNotificationJob deletes the notification job with id.
+func (s *KV) DeleteNotificationJob(ctx context.Context, id string) error {
+	s.claimMu.Lock()
+	defer s.claimMu.Unlock()
+	return kv.DeleteSlice(ctx, s.notifications, []string{
+		join(keyPfxNtfJob, id, keySfxNtfJobJso),
+		join(keyPfxNtfJob, id, keySfxNtfJobClm),
+	})
+}
@@ -0,0 +1,52 @@
+package kv
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/micromdm/nanolib/storage/kv"
+)
+
+// SearchStatusValues finds enrollments that reported a status value matching q.
+// Only status values stored with the reverse index key layout are searched.
+func (s *KV) SearchStatusValues(ctx context.Context, q storage.StatusValueSearch) (*storage.StatusValueSearchResult, error) {
+	if err := q.Valid(); err != nil {
+		return nil, err
+	}
+
+	// keys are of the form: prefix.<path hash>.<value hash>.<enrollment ID>
+	pfx := join(keyPfxStaVIx, s.pathHash(q.Path)) + keySep
+	found := make(map[string]struct{})
+	for _, k := range kv.AllKeysPrefix(ctx, s.status, pfx) {
+		parts := strings.SplitN(k[len(pfx):], keySep, 2)
+		if len(parts) != 2 {
+			continue
+		}
+		id := parts[1]
+		if _, ok := found[id]; ok || id <= q.After {
+			continue
+		}
+		value, err := s.status.Get(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		if q.Match(string(value)) {
+			found[id] = struct{}{}
+		}
+	}
+
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	r := &storage.StatusValueSearchResult{EnrollmentIDs: ids}
+	if len(ids) > q.Limit {
+		r.EnrollmentIDs = ids[:q.Limit]
+		r.Next = ids[q.Limit-1]
+	}
+	return r, nil
+}
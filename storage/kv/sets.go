@@ -114,6 +114,45 @@ func (s *KV) RemoveSetDeclaration(ctx context.Context, setName, declarationID st
 	return
 }
 
+// removeDeclarationSets dissociates declarationID from sets in a single transaction.
+func (s *KV) removeDeclarationSets(ctx context.Context, declarationID string, sets []string) error {
+	return kv.PerformBucketTxn(ctx, s.sets, func(ctx context.Context, b kv.Bucket) error {
+		for _, setName := range sets {
+			if err := kv.DeleteSlice(ctx, b, []string{
+				join(keyPfxSetDcl, setName, declarationID),
+				join(keyPfxDclSet, declarationID, setName),
+			}); err != nil {
+				return err
+			}
+			others, err := getSetDeclarations(ctx, b, setName)
+			if err != nil {
+				return err
+			}
+			// if no others were found, then delete this set from the list of sets
+			if len(others) < 1 {
+				if err = b.Delete(ctx, join(keyPfxSet, setName)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// restoreDeclarationSets associates declarationID with sets in a single transaction.
+// It undoes removeDeclarationSets.
+func (s *KV) restoreDeclarationSets(ctx context.Context, declarationID string, sets []string) error {
+	return kv.PerformCRUDBucketTxn(ctx, s.sets, func(ctx context.Context, b kv.CRUDBucket) error {
+		m := make(map[string][]byte)
+		for _, setName := range sets {
+			m[join(keyPfxSetDcl, setName, declarationID)] = []byte(valueSet)
+			m[join(keyPfxDclSet, declarationID, setName)] = []byte(valueSet)
+			m[join(keyPfxSet, setName)] = []byte(valueSet)
+		}
+		return kv.SetMap(ctx, b, m)
+	})
+}
+
 // RetrieveSets returns the list of all sets.
 func (s *KV) RetrieveSets(ctx context.Context) (setNames []string, err error) {
 	pfx := keyPfxSet + keySep
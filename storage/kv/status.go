@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jessepeterson/kmfddm/ddm"
@@ -18,6 +20,10 @@ const (
 	keyPfxStaDcl = "ds"
 	keyPfxStaVal = "vs"
 	keyPfxStaErr = "es"
+	keyPfxStaHst = "hs"
+	keyPfxStaDHs = "hd"
+	keyPfxStaVIx = "vx"
+	keyPfxStaUnh = "us"
 
 	keySfxStaEnrIdx = "index"
 
@@ -41,6 +47,23 @@ const (
 	keySfxStaErrPth = "path"
 	keySfxStaErrTS  = "ts"
 	keySfxStaErrID  = "id"
+
+	keySfxStaHstIdx = "index"
+	keySfxStaHstCur = "cur"
+	keySfxStaHstPth = "pt"
+	keySfxStaHstOld = "ov"
+	keySfxStaHstNew = "nv"
+	keySfxStaHstID  = "id"
+	keySfxStaHstTS  = "ts"
+
+	keySfxStaDHsIdx = "index"
+	keySfxStaDHsJso = "json"
+
+	keySfxStaUnhPth = "pt"
+	keySfxStaUnhCnt = "ct"
+	keySfxStaUnhFst = "fs"
+	keySfxStaUnhLst = "ls"
+	keySfxStaUnhEnr = "id"
 )
 
 func fromTime(t time.Time) []byte {
@@ -83,6 +106,13 @@ func (s *KV) StoreDeclarationStatus(ctx context.Context, enrollmentID string, st
 				return fmt.Errorf("marshal declaration status: %w", err)
 			}
 
+			if s.history {
+				err = s.storeDeclarationStatusHistory(ctx, b, enrollmentID, status.ID, status.Declarations, now)
+				if err != nil {
+					return fmt.Errorf("storing declaration status history: %w", err)
+				}
+			}
+
 			err = kv.SetMap(ctx, b, map[string][]byte{
 				join(keyPfxStaDcl, enrollmentID, keySfxStaDclJso): dStatusJSON,
 				join(keyPfxStaDcl, enrollmentID, keySfxStaDclIdx): []byte(strconv.Itoa(idx)),
@@ -122,11 +152,18 @@ func (s *KV) StoreDeclarationStatus(ctx context.Context, enrollmentID string, st
 		return err
 	}
 	// write the status values
-	err = kv.PerformCRUDBucketTxn(ctx, s.status, func(ctx context.Context, b kv.CRUDBucket) error {
+	err = kv.PerformBucketTxn(ctx, s.status, func(ctx context.Context, b kv.Bucket) error {
+		pks := make(map[string]struct{}, len(status.Values))
+		paths := make(map[string]struct{})
 		for _, value := range status.Values {
-			pkHash := s.newHash()
-			pkHash.Write([]byte(value.Path + value.ContainerType + value.ValueType + string(value.Value)))
-			pk := fmt.Sprintf("%x", pkHash.Sum(nil))
+			pks[s.statusValuePK(value)] = struct{}{}
+			paths[value.Path] = struct{}{}
+		}
+		if err := s.removeStaleStatusValues(ctx, b, enrollmentID, paths, pks); err != nil {
+			return fmt.Errorf("removing stale status values: %w", err)
+		}
+		for _, value := range status.Values {
+			pk := s.statusValuePK(value)
 			err = kv.SetMap(ctx, b, map[string][]byte{
 				join(keyPfxStaVal, enrollmentID, keySfxStaValPth, pk): []byte(value.Path),
 				join(keyPfxStaVal, enrollmentID, keySfxStaValCty, pk): []byte(value.ContainerType),
@@ -138,6 +175,12 @@ func (s *KV) StoreDeclarationStatus(ctx context.Context, enrollmentID string, st
 				return err
 			}
 
+			// write the reverse index for searching by path and value
+			err = b.Set(ctx, join(keyPfxStaVIx, s.pathHash(value.Path), pk, enrollmentID), value.Value)
+			if err != nil {
+				return err
+			}
+
 			if status.ID != "" {
 				err = b.Set(ctx, join(keyPfxStaVal, enrollmentID, keySfxStaValID, pk), []byte(status.ID))
 				if err != nil {
@@ -155,6 +198,16 @@ func (s *KV) StoreDeclarationStatus(ctx context.Context, enrollmentID string, st
 	if err != nil {
 		return err
 	}
+	// write the status value history
+	err = s.storeStatusValueHistory(ctx, enrollmentID, status.ID, status.Values, now)
+	if err != nil {
+		return fmt.Errorf("storing status value history: %w", err)
+	}
+	// write the unhandled status paths
+	err = s.storeUnhandledStatusPaths(ctx, enrollmentID, status.Unhandled, now)
+	if err != nil {
+		return fmt.Errorf("storing unhandled status paths: %w", err)
+	}
 	// write the status errors
 	return kv.PerformCRUDBucketTxn(ctx, s.status, func(ctx context.Context, b kv.CRUDBucket) error {
 		for _, statusError := range status.Errors {
@@ -190,6 +243,209 @@ func (s *KV) StoreDeclarationStatus(ctx context.Context, enrollmentID string, st
 	})
 }
 
+// statusValuePK returns the key of value.
+func (s *KV) statusValuePK(value ddm.StatusValue) string {
+	pkHash := s.newHash()
+	pkHash.Write([]byte(value.Path + value.ContainerType + value.ValueType + string(value.Value)))
+	return fmt.Sprintf("%x", pkHash.Sum(nil))
+}
+
+// removeStaleStatusValues removes the stored status values (and their
+// reverse index keys) of enrollmentID at paths whose keys are not in pks.
+// A status report contains every value of the paths it reports so
+// these values have been replaced.
+func (s *KV) removeStaleStatusValues(ctx context.Context, b kv.Bucket, enrollmentID string, paths, pks map[string]struct{}) error {
+	pfx := join(keyPfxStaVal, enrollmentID, keySfxStaValPth) + keySep
+	for _, k := range kv.AllKeysPrefix(ctx, b, pfx) {
+		pk := k[len(pfx):]
+		if _, ok := pks[pk]; ok {
+			continue
+		}
+		path, err := b.Get(ctx, k)
+		if err != nil {
+			return err
+		}
+		if _, ok := paths[string(path)]; !ok {
+			continue
+		}
+		if err = kv.DeleteSlice(ctx, b, []string{
+			join(keyPfxStaVal, enrollmentID, keySfxStaValPth, pk),
+			join(keyPfxStaVal, enrollmentID, keySfxStaValCty, pk),
+			join(keyPfxStaVal, enrollmentID, keySfxStaValTyp, pk),
+			join(keyPfxStaVal, enrollmentID, keySfxStaValVal, pk),
+			join(keyPfxStaVal, enrollmentID, keySfxStaValTS, pk),
+			join(keyPfxStaVal, enrollmentID, keySfxStaValID, pk),
+			join(keyPfxStaVIx, s.pathHash(string(path)), pk, enrollmentID),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeStatusValueHistory records changes to the values at each status path.
+// The current value of each path is kept to detect changes. Does nothing
+// unless status history is enabled.
+func (s *KV) storeStatusValueHistory(ctx context.Context, enrollmentID, statusID string, values []ddm.StatusValue, now time.Time) error {
+	if !s.history || len(values) < 1 {
+		return nil
+	}
+	paths, pathValues := storage.StatusPathValues(values)
+	return kv.PerformCRUDBucketTxn(ctx, s.status, func(ctx context.Context, b kv.CRUDBucket) error {
+		for _, path := range paths {
+			curKey := join(keyPfxStaHst, enrollmentID, keySfxStaHstCur, s.pathHash(path))
+
+			newValue := pathValues[path]
+			oldValue, err := b.Get(ctx, curKey)
+			if err != nil && !errors.Is(err, kv.ErrKeyNotFound) {
+				return err
+			}
+			seen := err == nil
+			if seen && string(oldValue) == newValue {
+				// no change
+				continue
+			}
+			if err = b.Set(ctx, curKey, []byte(newValue)); err != nil {
+				return err
+			}
+
+			idx, err := bumpIdx(ctx, b, join(keyPfxStaHst, enrollmentID, keySfxStaHstIdx))
+			if err != nil {
+				return fmt.Errorf("bumping index for status history: %w", err)
+			}
+
+			pfx := join(keyPfxStaHst, enrollmentID, strconv.Itoa(idx))
+			err = kv.SetMap(ctx, b, map[string][]byte{
+				join(pfx, keySfxStaHstPth): []byte(path),
+				join(pfx, keySfxStaHstNew): []byte(newValue),
+				join(pfx, keySfxStaHstTS):  fromTime(now),
+			})
+			if err != nil {
+				return err
+			}
+
+			// the history index may have been reset so clear out
+			// any optional values that are not being set.
+			if seen {
+				err = b.Set(ctx, join(pfx, keySfxStaHstOld), oldValue)
+			} else {
+				err = b.Delete(ctx, join(pfx, keySfxStaHstOld))
+			}
+			if err != nil {
+				return err
+			}
+			if statusID != "" {
+				err = b.Set(ctx, join(pfx, keySfxStaHstID), []byte(statusID))
+			} else {
+				err = b.Delete(ctx, join(pfx, keySfxStaHstID))
+			}
+			if err != nil {
+				return err
+			}
+
+			if s.historyRetain > 0 && idx >= s.historyRetain {
+				// remove the oldest change we're no longer retaining
+				pfx = join(keyPfxStaHst, enrollmentID, strconv.Itoa(idx-s.historyRetain))
+				err = kv.DeleteSlice(ctx, b, []string{
+					join(pfx, keySfxStaHstPth),
+					join(pfx, keySfxStaHstOld),
+					join(pfx, keySfxStaHstNew),
+					join(pfx, keySfxStaHstID),
+					join(pfx, keySfxStaHstTS),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// storeDeclarationStatusHistory records declarations whose status changed
+// from the previously stored declaration status as events.
+func (s *KV) storeDeclarationStatusHistory(ctx context.Context, b kv.CRUDBucket, enrollmentID, statusID string, declarations []ddm.DeclarationStatus, now time.Time) error {
+	var prev []ddm.DeclarationStatus
+	prevJSON, err := b.Get(ctx, join(keyPfxStaDcl, enrollmentID, keySfxStaDclJso))
+	if err != nil && !errors.Is(err, kv.ErrKeyNotFound) {
+		return err
+	} else if err == nil && len(prevJSON) > 0 {
+		if err = json.Unmarshal(prevJSON, &prev); err != nil {
+			return fmt.Errorf("unmarshal declaration status: %w", err)
+		}
+	}
+
+	for _, ds := range storage.ChangedDeclarationStatus(prev, declarations) {
+		eventJSON, err := json.Marshal(&storage.DeclarationStatusEvent{
+			Identifier:  ds.Identifier,
+			Active:      ds.Active,
+			Valid:       ds.Valid,
+			ServerToken: ds.ServerToken,
+			Timestamp:   now,
+			StatusID:    statusID,
+		})
+		if err != nil {
+			return fmt.Errorf("marshal declaration status event: %w", err)
+		}
+
+		idx, err := bumpIdx(ctx, b, join(keyPfxStaDHs, enrollmentID, keySfxStaDHsIdx))
+		if err != nil {
+			return fmt.Errorf("bumping index for declaration status history: %w", err)
+		}
+
+		err = b.Set(ctx, join(keyPfxStaDHs, enrollmentID, strconv.Itoa(idx), keySfxStaDHsJso), eventJSON)
+		if err != nil {
+			return err
+		}
+
+		if s.historyRetain > 0 && idx >= s.historyRetain {
+			// remove the oldest event we're no longer retaining
+			err = b.Delete(ctx, join(keyPfxStaDHs, enrollmentID, strconv.Itoa(idx-s.historyRetain), keySfxStaDHsJso))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// storeUnhandledStatusPaths counts the unhandled status paths and
+// records when they were first and last seen.
+func (s *KV) storeUnhandledStatusPaths(ctx context.Context, enrollmentID string, paths []string, now time.Time) error {
+	if len(paths) < 1 {
+		return nil
+	}
+	return kv.PerformCRUDBucketTxn(ctx, s.status, func(ctx context.Context, b kv.CRUDBucket) error {
+		for _, path := range paths {
+			pfx := join(keyPfxStaUnh, s.pathHash(path))
+			ct, err := bumpIdx(ctx, b, join(pfx, keySfxStaUnhCnt))
+			if err != nil {
+				return fmt.Errorf("bumping unhandled path count: %w", err)
+			}
+			m := map[string][]byte{
+				join(pfx, keySfxStaUnhLst): fromTime(now),
+				join(pfx, keySfxStaUnhEnr): []byte(enrollmentID),
+			}
+			if ct == 0 {
+				// first time we've seen this path
+				m[join(pfx, keySfxStaUnhPth)] = []byte(path)
+				m[join(pfx, keySfxStaUnhFst)] = fromTime(now)
+			}
+			if err = kv.SetMap(ctx, b, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// pathHash returns the hex-encoded hash of a status path for use in keys.
+func (s *KV) pathHash(path string) string {
+	h := s.newHash()
+	h.Write([]byte(path))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 // bumpIdx reads the value at key in b, increments it by one, and writes it back out to b.
 // The value should be a string representation of an integer or is assumed to be 0.
 // The returned int is same as is written back out to b.
@@ -392,6 +648,149 @@ func (s *KV) RetrieveStatusValues(ctx context.Context, enrollmentIDs []string, p
 	return r, nil
 }
 
+// RetrieveStatusValueHistory retrieves the recorded status value changes for enrollmentIDs.
+func (s *KV) RetrieveStatusValueHistory(ctx context.Context, enrollmentIDs []string, path string) (map[string][]storage.StatusValueChange, error) {
+	if !s.history {
+		return nil, storage.ErrStatusHistoryDisabled
+	}
+	r := make(map[string][]storage.StatusValueChange)
+	for _, id := range enrollmentIDs {
+		idx, err := retrIdx(ctx, s.status, join(keyPfxStaHst, id, keySfxStaHstIdx))
+		if err != nil {
+			return nil, err
+		}
+
+		var start int
+		if s.historyRetain > 0 && idx >= s.historyRetain {
+			start = idx - s.historyRetain + 1
+		}
+
+		var changes []storage.StatusValueChange
+		for i := start; i <= idx; i++ {
+			pfx := join(keyPfxStaHst, id, strconv.Itoa(i))
+			hMap, err := kv.GetMap(ctx, s.status, []string{
+				join(pfx, keySfxStaHstPth),
+				join(pfx, keySfxStaHstNew),
+				join(pfx, keySfxStaHstTS),
+			})
+			if errors.Is(err, kv.ErrKeyNotFound) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("retrieving nth status change: %d for id: %s: %w", i, id, err)
+			}
+
+			change := storage.StatusValueChange{
+				Path:     string(hMap[join(pfx, keySfxStaHstPth)]),
+				NewValue: string(hMap[join(pfx, keySfxStaHstNew)]),
+			}
+			if path != "" && change.Path != path {
+				continue
+			}
+
+			change.Timestamp, err = toTime(hMap[join(pfx, keySfxStaHstTS)])
+			if err != nil {
+				return nil, fmt.Errorf("retrieving nth status change: %d for id: %s: %w", i, id, err)
+			}
+
+			// retrieve the optional old value and status ID
+			if oldValue, err := s.status.Get(ctx, join(pfx, keySfxStaHstOld)); err != nil && !errors.Is(err, kv.ErrKeyNotFound) {
+				return nil, err
+			} else if err == nil {
+				change.OldValue = string(oldValue)
+			}
+			if statusID, err := s.status.Get(ctx, join(pfx, keySfxStaHstID)); err != nil && !errors.Is(err, kv.ErrKeyNotFound) {
+				return nil, err
+			} else if err == nil {
+				change.StatusID = string(statusID)
+			}
+
+			changes = append(changes, change)
+		}
+
+		if len(changes) > 0 {
+			r[id] = changes
+		}
+	}
+	return r, nil
+}
+
+// RetrieveDeclarationStatusHistory retrieves the recorded declaration status changes for enrollmentIDs.
+func (s *KV) RetrieveDeclarationStatusHistory(ctx context.Context, enrollmentIDs []string, declarationID string) (map[string][]storage.DeclarationStatusEvent, error) {
+	if !s.history {
+		return nil, storage.ErrStatusHistoryDisabled
+	}
+	r := make(map[string][]storage.DeclarationStatusEvent)
+	for _, id := range enrollmentIDs {
+		idx, err := retrIdx(ctx, s.status, join(keyPfxStaDHs, id, keySfxStaDHsIdx))
+		if err != nil {
+			return nil, err
+		}
+
+		var start int
+		if s.historyRetain > 0 && idx >= s.historyRetain {
+			start = idx - s.historyRetain + 1
+		}
+
+		var events []storage.DeclarationStatusEvent
+		for i := start; i <= idx; i++ {
+			eventJSON, err := s.status.Get(ctx, join(keyPfxStaDHs, id, strconv.Itoa(i), keySfxStaDHsJso))
+			if errors.Is(err, kv.ErrKeyNotFound) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("retrieving nth declaration status event: %d for id: %s: %w", i, id, err)
+			}
+
+			var event storage.DeclarationStatusEvent
+			if err = json.Unmarshal(eventJSON, &event); err != nil {
+				return nil, fmt.Errorf("retrieving nth declaration status event: %d for id: %s: %w", i, id, err)
+			}
+			if declarationID != "" && event.Identifier != declarationID {
+				continue
+			}
+
+			events = append(events, event)
+		}
+
+		if len(events) > 0 {
+			r[id] = events
+		}
+	}
+	return r, nil
+}
+
+// RetrieveUnhandledStatusPaths retrieves the unhandled status report paths sorted by path.
+func (s *KV) RetrieveUnhandledStatusPaths(ctx context.Context) ([]storage.UnhandledStatusPath, error) {
+	var paths []storage.UnhandledStatusPath
+	for _, k := range kv.AllKeysPrefix(ctx, s.status, keyPfxStaUnh+keySep) {
+		if !strings.HasSuffix(k, keySep+keySfxStaUnhPth) {
+			continue
+		}
+		pfx := strings.TrimSuffix(k, keySep+keySfxStaUnhPth)
+		uMap, err := kv.GetMap(ctx, s.status, []string{
+			join(pfx, keySfxStaUnhPth),
+			join(pfx, keySfxStaUnhCnt),
+			join(pfx, keySfxStaUnhFst),
+			join(pfx, keySfxStaUnhLst),
+			join(pfx, keySfxStaUnhEnr),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("retrieving unhandled status path: %w", err)
+		}
+		u := storage.UnhandledStatusPath{
+			Path:         string(uMap[join(pfx, keySfxStaUnhPth)]),
+			EnrollmentID: string(uMap[join(pfx, keySfxStaUnhEnr)]),
+		}
+		// the stored count is zero-based
+		ct, _ := strconv.ParseInt(string(uMap[join(pfx, keySfxStaUnhCnt)]), 10, 64)
+		u.Count = ct + 1
+		u.FirstSeen, _ = toTime(uMap[join(pfx, keySfxStaUnhFst)])
+		u.LastSeen, _ = toTime(uMap[join(pfx, keySfxStaUnhLst)])
+		paths = append(paths, u)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Path < paths[j].Path })
+	return paths, nil
+}
+
 // RetrieveStatusReport retrieves an enrollment's raw status report that matches q.
 func (s *KV) RetrieveStatusReport(ctx context.Context, q storage.StatusReportQuery) (*storage.StoredStatusReport, error) {
 	if q.EnrollmentID == "" {
@@ -422,7 +821,9 @@ func (s *KV) RetrieveStatusReport(ctx context.Context, q storage.StatusReportQue
 		join(pfx, keySfxStaRawRaw),
 		join(pfx, keySfxStaRawTS),
 	})
-	if err != nil {
+	if errors.Is(err, kv.ErrKeyNotFound) {
+		return nil, fmt.Errorf("%w: %v", storage.ErrStatusReportNotFound, err)
+	} else if err != nil {
 		return nil, err
 	}
 	statusID, err := s.status.Get(ctx, join(pfx, keySfxStaRawID))
@@ -0,0 +1,121 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/micromdm/nanolib/storage/kv"
+)
+
+const (
+	keyPfxNtfTrk = "nt"
+
+	keySfxNtfTrkJso = "json"
+)
+
+// retrieveTrackedNotification retrieves the tracked notification of enrollmentID.
+// A nil notification is returned if there is none.
+func (s *KV) retrieveTrackedNotification(ctx context.Context, enrollmentID string) (*storage.TrackedNotification, error) {
+	ntfJSON, err := s.notifications.Get(ctx, join(keyPfxNtfTrk, enrollmentID, keySfxNtfTrkJso))
+	if errors.Is(err, kv.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ntf := new(storage.TrackedNotification)
+	if err = json.Unmarshal(ntfJSON, ntf); err != nil {
+		return nil, fmt.Errorf("unmarshal tracked notification: %s: %w", enrollmentID, err)
+	}
+	return ntf, nil
+}
+
+func (s *KV) storeTrackedNotification(ctx context.Context, ntf *storage.TrackedNotification) error {
+	ntfJSON, err := json.Marshal(ntf)
+	if err != nil {
+		return err
+	}
+	return s.notifications.Set(ctx, join(keyPfxNtfTrk, ntf.EnrollmentID, keySfxNtfTrkJso), ntfJSON)
+}
+
+// StoreTrackedNotifications records notifications sent to enrollments.
+func (s *KV) StoreTrackedNotifications(ctx context.Context, notifications []storage.TrackedNotification) error {
+	for _, ntf := range notifications {
+		if ntf.EnrollmentID == "" {
+			return errors.New("missing enrollment ID")
+		}
+		prev, err := s.retrieveTrackedNotification(ctx, ntf.EnrollmentID)
+		if err != nil {
+			return err
+		}
+		ntf.Notifications = 1
+		ntf.SyncedAt = time.Time{}
+		if prev != nil {
+			if !prev.Synced() {
+				ntf.Notifications = prev.Notifications + 1
+			}
+			if ntf.CommandUUID == "" {
+				ntf.CommandUUID = prev.CommandUUID
+			}
+		}
+		if err = s.storeTrackedNotification(ctx, &ntf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetrieveUnsyncedNotifications retrieves the unsynced notifications selected by filter.
+func (s *KV) RetrieveUnsyncedNotifications(ctx context.Context, filter storage.UnsyncedFilter) ([]storage.TrackedNotification, error) {
+	var notifications []storage.TrackedNotification
+	if len(filter.EnrollmentIDs) > 0 {
+		for _, id := range filter.EnrollmentIDs {
+			ntf, err := s.retrieveTrackedNotification(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if ntf != nil && filter.Match(ntf) {
+				notifications = append(notifications, *ntf)
+			}
+		}
+	} else {
+		for _, k := range kv.AllKeysPrefix(ctx, s.notifications, keyPfxNtfTrk+keySep) {
+			if !strings.HasSuffix(k, keySep+keySfxNtfTrkJso) {
+				continue
+			}
+			ntfJSON, err := s.notifications.Get(ctx, k)
+			if errors.Is(err, kv.ErrKeyNotFound) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			var ntf storage.TrackedNotification
+			if err = json.Unmarshal(ntfJSON, &ntf); err != nil {
+				return nil, fmt.Errorf("unmarshal tracked notification: %s: %w", k, err)
+			}
+			if filter.Match(&ntf) {
+				notifications = append(notifications, ntf)
+			}
+		}
+	}
+	storage.SortTrackedNotifications(notifications)
+	if filter.Limit > 0 && len(notifications) > filter.Limit {
+		notifications = notifications[:filter.Limit]
+	}
+	return notifications, nil
+}
+
+// MarkNotificationSynced marks the notification of enrollmentID as synced at time at.
+func (s *KV) MarkNotificationSynced(ctx context.Context, enrollmentID string, at time.Time) error {
+	ntf, err := s.retrieveTrackedNotification(ctx, enrollmentID)
+	if err != nil || ntf == nil || ntf.Synced() {
+		return err
+	}
+	ntf.SyncedAt = at
+	ntf.Notifications = 0
+	return s.storeTrackedNotification(ctx, ntf)
+}
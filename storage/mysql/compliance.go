@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+// RetrieveDeclarationCompliance aggregates the reported status of declarationID
+// across all enrollments that should receive it.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) RetrieveDeclarationCompliance(ctx context.Context, declarationID string) (*storage.DeclarationCompliance, error) {
+	c := &storage.DeclarationCompliance{Identifier: declarationID}
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT server_token FROM declarations WHERE identifier = ? LIMIT 1;`,
+		declarationID,
+	).Scan(&c.ServerToken)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %v", storage.ErrDeclarationNotFound, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// group the enrollments by their reported status of the declaration
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    sd.enrollment_id IS NOT NULL AS is_reported,
+    COALESCE(sd.active, FALSE) AS is_active,
+    COALESCE(sd.valid, '') AS valid_status,
+    COALESCE(sd.server_token, '') AS reported_token,
+    JSON_ARRAYAGG(e.enrollment_id)
+FROM
+    (
+        SELECT DISTINCT
+            es.enrollment_id
+        FROM
+            enrollment_sets es
+            INNER JOIN set_declarations sds
+                ON sds.set_name = es.set_name
+        WHERE
+            sds.declaration_identifier = ?
+    ) e
+    LEFT JOIN status_declarations sd
+        ON sd.enrollment_id = e.enrollment_id AND
+           sd.declaration_identifier = ?
+GROUP BY
+    is_reported,
+    is_active,
+    valid_status,
+    reported_token;`,
+		declarationID,
+		declarationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reported bool
+	var idsJSON []byte
+	for rows.Next() {
+		var ds ddm.DeclarationStatus
+		err = rows.Scan(&reported, &ds.Active, &ds.Valid, &ds.ServerToken, &idsJSON)
+		if err != nil {
+			break
+		}
+		var ids []string
+		if err = json.Unmarshal(idsJSON, &ids); err != nil {
+			err = fmt.Errorf("unmarshal enrollment IDs: %w", err)
+			break
+		}
+		if reported {
+			c.AddGroup(ids, &ds)
+		} else {
+			c.AddGroup(ids, nil)
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	c.SortEnrollmentIDs()
+	return c, err
+}
@@ -14,7 +14,7 @@ import (
 // StoreDeclaration stores a declaration and returns whether it changed or not.
 // See also the storage package for documentation on the storage interfaces.
 func (s *MySQLStorage) StoreDeclaration(ctx context.Context, d *ddm.Declaration) (bool, error) {
-	result, err := s.db.ExecContext(
+	result, err := s.conn.ExecContext(
 		ctx,
 		`
 INSERT INTO declarations
@@ -36,6 +36,30 @@ UPDATE
 	return resultChangedRows(result)
 }
 
+// StoreDeclarationCAS stores a declaration only if the stored declaration has serverToken.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) StoreDeclarationCAS(ctx context.Context, d *ddm.Declaration, serverToken string) (changed bool, err error) {
+	err = s.inTx(ctx, func(ctx context.Context, tx dbtx) error {
+		var storedToken string
+		err := tx.QueryRowContext(
+			ctx,
+			`SELECT server_token FROM declarations WHERE identifier = ? FOR UPDATE;`,
+			d.Identifier,
+		).Scan(&storedToken)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if storedToken != serverToken {
+			return fmt.Errorf("%w: declaration: %s", storage.ErrServerTokenMismatch, d.Identifier)
+		}
+		txs := *s
+		txs.conn = tx
+		changed, err = txs.StoreDeclaration(ctx, d)
+		return err
+	})
+	return
+}
+
 // RetrieveDeclaration retrieves a declaration.
 // See also the storage package for documentation on the storage interfaces.
 func (s *MySQLStorage) RetrieveDeclaration(ctx context.Context, declarationID string) (*ddm.Declaration, error) {
@@ -70,15 +94,67 @@ func (s *MySQLStorage) RetrieveDeclarationModTime(ctx context.Context, declarati
 // DeleteDeclaration deletes a declaration and returns whether it was deleted or already existed.
 // See also the storage package for documentation on the storage interfaces.
 func (s *MySQLStorage) DeleteDeclaration(ctx context.Context, declarationID string) (bool, error) {
-	result, err := s.db.ExecContext(
-		ctx,
-		`DELETE FROM declarations WHERE identifier = ?;`,
-		declarationID,
-	)
+	_, changed, err := s.deleteDeclaration(ctx, declarationID, false)
+	return changed, err
+}
+
+// DeleteDeclarationCascade dissociates a declaration from all sets and deletes it.
+// This is done atomically in a single transaction.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) DeleteDeclarationCascade(ctx context.Context, declarationID string) ([]string, bool, error) {
+	return s.deleteDeclaration(ctx, declarationID, true)
+}
+
+// deleteDeclaration deletes a declaration in a transaction. If cascade
+// is true then the declaration is first dissociated from any sets.
+// Otherwise an error is returned if the declaration is associated with any sets.
+func (s *MySQLStorage) deleteDeclaration(ctx context.Context, declarationID string, cascade bool) (sets []string, changed bool, err error) {
+	err = s.inTx(ctx, func(ctx context.Context, tx dbtx) error {
+		rows, err := tx.QueryContext(
+			ctx,
+			`SELECT set_name FROM set_declarations WHERE declaration_identifier = ? FOR UPDATE;`,
+			declarationID,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var setName string
+			if err = rows.Scan(&setName); err != nil {
+				return err
+			}
+			sets = append(sets, setName)
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if len(sets) > 0 && !cascade {
+			return &storage.DeclarationInUseError{DeclarationID: declarationID, Sets: sets}
+		} else if len(sets) > 0 {
+			if _, err = tx.ExecContext(
+				ctx,
+				`DELETE FROM set_declarations WHERE declaration_identifier = ?;`,
+				declarationID,
+			); err != nil {
+				return err
+			}
+		}
+		result, err := tx.ExecContext(
+			ctx,
+			`DELETE FROM declarations WHERE identifier = ?;`,
+			declarationID,
+		)
+		if err != nil {
+			return err
+		}
+		changed, err = resultChangedRows(result)
+		return err
+	})
 	if err != nil {
-		return false, err
+		sets = nil
 	}
-	return resultChangedRows(result)
+	return
 }
 
 // RetrieveDeclarationSets returns the list of sets a declaration is a part of.
@@ -103,7 +179,7 @@ func (s *MySQLStorage) RetrieveDeclarations(ctx context.Context) ([]string, erro
 // TouchDeclaration updates a declaration's "touch count" which makes a new server token.
 // See also the storage package for documentation on the storage interfaces.
 func (s *MySQLStorage) TouchDeclaration(ctx context.Context, declarationID string) error {
-	result, err := s.db.ExecContext(
+	result, err := s.conn.ExecContext(
 		ctx,
 		`
 UPDATE
@@ -19,7 +19,7 @@ func (s *MySQLStorage) RetrieveEnrollmentSets(ctx context.Context, enrollmentID
 // StoreEnrollmentSet creates the association between an enrollment and a set.
 // See also the storage package for documentation on the storage interfaces.
 func (s *MySQLStorage) StoreEnrollmentSet(ctx context.Context, enrollmentID, setName string) (bool, error) {
-	result, err := s.db.ExecContext(
+	result, err := s.conn.ExecContext(
 		ctx, `
 INSERT INTO enrollment_sets
     (enrollment_id, set_name)
@@ -40,7 +40,7 @@ UPDATE
 // RemoveEnrollmentSet removes the association between an enrollment and a set.
 // See also the storage package for documentation on the storage interfaces.
 func (s *MySQLStorage) RemoveEnrollmentSet(ctx context.Context, enrollmentID, setName string) (bool, error) {
-	result, err := s.db.ExecContext(
+	result, err := s.conn.ExecContext(
 		ctx, `
 DELETE FROM enrollment_sets
 WHERE
@@ -8,7 +8,10 @@ import (
 	"hash"
 	"time"
 
+	"github.com/jessepeterson/kmfddm/storage"
 	"github.com/jessepeterson/kmfddm/storage/mysql/sqlc"
+
+	"github.com/micromdm/nanolib/log"
 )
 
 const mysqlTimeFormat = "2006-01-02 15:04:05"
@@ -25,11 +28,15 @@ const mysqlTimeFormat = "2006-01-02 15:04:05"
 // MySQLStorage implements a MySQL storage backend.
 type MySQLStorage struct {
 	db      *sql.DB
+	conn    dbtx // db or the transaction of InTx
 	q       *sqlc.Queries
 	newHash func() hash.Hash
 	errDel  uint
 	stsDel  uint
 	noSts   bool
+	history bool
+	histDel uint
+	logger  log.Logger
 }
 
 type config struct {
@@ -39,8 +46,11 @@ type config struct {
 	errDel          uint
 	stsDel          uint
 	noSts           bool
+	history         bool
+	histDel         uint
 	connMaxLifetime time.Duration
 	connMaxIdleTime time.Duration
+	logger          log.Logger
 }
 
 type Option func(*config)
@@ -90,6 +100,16 @@ func WithoutStatusReports() Option {
 	}
 }
 
+// WithStatusHistory turns on recording of status value and declaration
+// status changes. Up to count change rows of each kind are kept per
+// enrollment ID; zero means unlimited.
+func WithStatusHistory(count uint) Option {
+	return func(c *config) {
+		c.history = true
+		c.histDel = count
+	}
+}
+
 // WithConnMaxLifetime sets the maximum amount of time a connection may be
 // reused. It should be shorter than the shortest idle timeout in the network
 // path to the database. A non-positive value keeps connections forever.
@@ -108,6 +128,13 @@ func WithConnMaxIdleTime(d time.Duration) Option {
 	}
 }
 
+// WithLogger sets the logger for errors that do not fail storage operations.
+func WithLogger(logger log.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
 // New creates and initializes a new MySQL storage backend.
 // New attempts to Ping the database after opening to verify connectivity.
 func New(newHash func() hash.Hash, opts ...Option) (*MySQLStorage, error) {
@@ -116,6 +143,7 @@ func New(newHash func() hash.Hash, opts ...Option) (*MySQLStorage, error) {
 	}
 	cfg := config{
 		driver: "mysql",
+		logger: log.NopLogger,
 	}
 	for _, opt := range opts {
 		opt(&cfg)
@@ -138,14 +166,25 @@ func New(newHash func() hash.Hash, opts ...Option) (*MySQLStorage, error) {
 	}
 	return &MySQLStorage{
 		db:      cfg.db,
+		conn:    cfg.db,
 		q:       sqlc.New(cfg.db),
 		newHash: newHash,
 		errDel:  cfg.errDel,
 		stsDel:  cfg.stsDel,
 		noSts:   cfg.noSts,
+		history: cfg.history,
+		histDel: cfg.histDel,
+		logger:  cfg.logger,
 	}, nil
 }
 
+// dbtx executes queries on either a database or a transaction.
+type dbtx interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}
+
 // resultChangedRows tries to tell us if if the record changed. Note that
 // MySQL has an odd special case for result rows when INSERT INTO ... ON
 // DUPLICATE KEY is used. The manual states 0 is returned for no change,
@@ -200,3 +239,29 @@ func tx(ctx context.Context, db *sql.DB, q *sqlc.Queries, g func(ctx context.Con
 	}
 	return nil
 }
+
+// inTx calls g with the transaction of InTx if s is in one.
+// Otherwise g is called in a new transaction.
+func (s *MySQLStorage) inTx(ctx context.Context, g func(ctx context.Context, tx dbtx) error) error {
+	if _, ok := s.conn.(*sql.Tx); ok {
+		return g(ctx, s.conn)
+	}
+	return tx(ctx, s.db, s.q, func(ctx context.Context, tx *sql.Tx, _ *sqlc.Queries) error {
+		return g(ctx, tx)
+	})
+}
+
+// InTx calls fn with a copy of s whose changes are made in a single transaction.
+// If s is already in a transaction then fn is called with s.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) InTx(ctx context.Context, fn func(ctx context.Context, store storage.BatchStorage) error) error {
+	if _, ok := s.conn.(*sql.Tx); ok {
+		return fn(ctx, s)
+	}
+	return tx(ctx, s.db, s.q, func(ctx context.Context, tx *sql.Tx, qtx *sqlc.Queries) error {
+		txs := *s
+		txs.conn = tx
+		txs.q = qtx
+		return fn(ctx, &txs)
+	})
+}
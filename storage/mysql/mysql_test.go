@@ -24,8 +24,27 @@ func TestMySQL(t *testing.T) {
 	}
 	ctx := context.Background()
 
-	t.Run("TestE2E", func(t *testing.T) {
-		e2e.TestE2E(t, ctx, storage)
+	e2e.TestAll(t, ctx, storage, func(t *testing.T) e2e.TestStorage {
+		storage, err := New(func() hash.Hash { return fnv.New128() }, WithDSN(testDSN), WithStatusHistory(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return storage
 	})
+}
 
+func TestTruncatePath(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		max  int
+		want string
+	}{
+		{".StatusItems.a", 20, ".StatusItems.a"},
+		{".StatusItems.abc", 14, ".StatusItems.a"},
+		{".StatusItems.äöü", 15, ".StatusItems.äö"},
+	} {
+		if have := truncatePath(test.path, test.max); have != test.want {
+			t.Errorf("%s: have: %q, want: %q", test.path, have, test.want)
+		}
+	}
 }
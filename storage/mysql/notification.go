@@ -0,0 +1,220 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/jessepeterson/kmfddm/storage/mysql/sqlc"
+)
+
+// jsonStrings marshals s for storage in a JSON column. A nil s is stored as NULL.
+func jsonStrings(s []string) (interface{}, error) {
+	if s == nil {
+		return nil, nil
+	}
+	return json.Marshal(s)
+}
+
+// StoreNotificationJob creates or replaces the notification job with the ID of job.
+// Any claim of the job is released.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) StoreNotificationJob(ctx context.Context, job *storage.NotificationJob) error {
+	if job == nil || job.ID == "" {
+		return errors.New("missing notification job ID")
+	}
+	var args [3]interface{}
+	var err error
+	for i, v := range [][]string{job.Declarations, job.Sets, job.EnrollmentIDs} {
+		if args[i], err = jsonStrings(v); err != nil {
+			return err
+		}
+	}
+	_, err = s.db.ExecContext(
+		ctx, `
+INSERT INTO notification_jobs
+    (id, declarations, sets, enrollment_ids, force_notify, attempts, last_error, next_attempt, failed, created_at)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    declarations = new.declarations,
+    sets = new.sets,
+    enrollment_ids = new.enrollment_ids,
+    force_notify = new.force_notify,
+    attempts = new.attempts,
+    last_error = new.last_error,
+    next_attempt = new.next_attempt,
+    failed = new.failed,
+    claimed_until = NULL;`,
+		job.ID,
+		args[0],
+		args[1],
+		args[2],
+		job.Force,
+		job.Attempts,
+		sql.NullString{String: job.LastError, Valid: job.LastError != ""},
+		job.NextAttempt.UTC().Format(mysqlTimeFormat),
+		job.Failed,
+		job.CreatedAt.UTC().Format(mysqlTimeFormat),
+	)
+	return err
+}
+
+const notificationJobSelect = `
+SELECT
+    id,
+    declarations,
+    sets,
+    enrollment_ids,
+    force_notify,
+    attempts,
+    last_error,
+    next_attempt,
+    failed,
+    created_at
+FROM
+    notification_jobs
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanNotificationJob(row rowScanner) (*storage.NotificationJob, error) {
+	job := new(storage.NotificationJob)
+	var lists [3][]byte
+	var lastError sql.NullString
+	var nextAttempt, createdAt string
+	err := row.Scan(
+		&job.ID,
+		&lists[0],
+		&lists[1],
+		&lists[2],
+		&job.Force,
+		&job.Attempts,
+		&lastError,
+		&nextAttempt,
+		&job.Failed,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range []*[]string{&job.Declarations, &job.Sets, &job.EnrollmentIDs} {
+		if len(lists[i]) < 1 {
+			continue
+		}
+		if err = json.Unmarshal(lists[i], v); err != nil {
+			return nil, fmt.Errorf("unmarshal notification job %s: %w", job.ID, err)
+		}
+	}
+	job.LastError = lastError.String
+	job.NextAttempt, _ = time.Parse(mysqlTimeFormat, nextAttempt)
+	job.CreatedAt, _ = time.Parse(mysqlTimeFormat, createdAt)
+	return job, nil
+}
+
+// RetrieveNotificationJob retrieves the notification job with id.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) RetrieveNotificationJob(ctx context.Context, id string) (*storage.NotificationJob, error) {
+	job, err := scanNotificationJob(s.db.QueryRowContext(
+		ctx,
+		notificationJobSelect+`WHERE id = ?;`,
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %v", storage.ErrNotificationJobNotFound, err)
+	}
+	return job, err
+}
+
+// RetrieveNotificationJobs retrieves the notification jobs selected by filter.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) RetrieveNotificationJobs(ctx context.Context, filter storage.NotificationJobFilter) ([]storage.NotificationJob, error) {
+	q := notificationJobSelect + `WHERE failed = ?`
+	args := []interface{}{filter.Failed}
+	if !filter.DueBy.IsZero() {
+		q += ` AND next_attempt <= ?`
+		args = append(args, filter.DueBy.UTC().Format(mysqlTimeFormat))
+	}
+	q += ` ORDER BY created_at, id`
+	if filter.Limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, q+`;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []storage.NotificationJob
+	for rows.Next() {
+		job, err := scanNotificationJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// ClaimNotificationJobs claims and returns up to limit due pending notification jobs.
+// Jobs locked by another transaction claiming them are skipped.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) ClaimNotificationJobs(ctx context.Context, now, claimedUntil time.Time, limit int) ([]storage.NotificationJob, error) {
+	var jobs []storage.NotificationJob
+	err := tx(ctx, s.db, s.q, func(ctx context.Context, tx *sql.Tx, _ *sqlc.Queries) error {
+		nowStr := now.UTC().Format(mysqlTimeFormat)
+		q := notificationJobSelect + `WHERE failed = FALSE AND next_attempt <= ? AND (claimed_until IS NULL OR claimed_until <= ?) ORDER BY created_at, id`
+		args := []interface{}{nowStr, nowStr}
+		if limit > 0 {
+			q += ` LIMIT ?`
+			args = append(args, limit)
+		}
+		rows, err := tx.QueryContext(ctx, q+` FOR UPDATE SKIP LOCKED;`, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			job, err := scanNotificationJob(rows)
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, *job)
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if len(jobs) < 1 {
+			return nil
+		}
+		args = []interface{}{claimedUntil.UTC().Format(mysqlTimeFormat)}
+		for _, job := range jobs {
+			args = append(args, job.ID)
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			`UPDATE notification_jobs SET claimed_until = ? WHERE id IN (`+strings.Repeat(", ?", len(jobs))[2:]+`);`,
+			args...,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// DeleteNotificationJob deletes the notification job with id.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) DeleteNotificationJob(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM notification_jobs WHERE id = ?;`, id)
+	return err
+}
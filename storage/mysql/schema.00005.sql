@@ -0,0 +1,28 @@
+CREATE TABLE status_value_history (
+    enrollment_id   VARCHAR(128) NOT NULL,
+
+    path      VARCHAR(255) NOT NULL,
+    old_value TEXT NULL, -- NULL when the path was first seen
+    new_value TEXT NOT NULL,
+
+    status_id VARCHAR(255) NULL,
+    row_count INT DEFAULT 0 NOT NULL,
+
+    INDEX (enrollment_id, path),
+    INDEX (enrollment_id, row_count),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE status_value_current (
+    enrollment_id   VARCHAR(128) NOT NULL,
+
+    path  VARCHAR(255) NOT NULL,
+    value TEXT NOT NULL,
+
+    PRIMARY KEY (enrollment_id, path),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
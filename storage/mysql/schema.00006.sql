@@ -0,0 +1,17 @@
+CREATE TABLE status_declaration_history (
+    enrollment_id          VARCHAR(128) NOT NULL,
+    declaration_identifier VARCHAR(255) NOT NULL,
+
+    active       BOOLEAN NOT NULL,
+    valid        VARCHAR(255) NOT NULL,
+    server_token VARCHAR(255) NOT NULL,
+
+    status_id VARCHAR(255) NULL,
+    row_count INT DEFAULT 0 NOT NULL,
+
+    INDEX (enrollment_id, declaration_identifier),
+    INDEX (enrollment_id, row_count),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
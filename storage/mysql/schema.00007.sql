@@ -0,0 +1 @@
+ALTER TABLE status_values ADD INDEX (path, value);
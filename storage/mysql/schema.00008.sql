@@ -0,0 +1,11 @@
+CREATE TABLE status_unhandled (
+    path VARCHAR(255) NOT NULL,
+
+    count         BIGINT DEFAULT 1 NOT NULL,
+    enrollment_id VARCHAR(128) NOT NULL,
+
+    PRIMARY KEY (path),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
@@ -0,0 +1,18 @@
+CREATE TABLE notification_jobs (
+    id VARCHAR(64) NOT NULL,
+
+    declarations   JSON NULL,
+    sets           JSON NULL,
+    enrollment_ids JSON NULL,
+
+    attempts     INT DEFAULT 0 NOT NULL,
+    last_error   TEXT NULL,
+    next_attempt TIMESTAMP NOT NULL,
+    failed       BOOLEAN DEFAULT FALSE NOT NULL,
+
+    PRIMARY KEY (id),
+    INDEX (failed, next_attempt),
+
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE notification_jobs ADD COLUMN force_notify BOOLEAN DEFAULT FALSE NOT NULL AFTER enrollment_ids;
@@ -0,0 +1,15 @@
+CREATE TABLE notification_tracking (
+    enrollment_id VARCHAR(128) NOT NULL,
+
+    command_uuid       VARCHAR(64) NULL,
+    declarations_token VARCHAR(64) NULL,
+    notified_at        TIMESTAMP NOT NULL,
+    notifications      INT DEFAULT 1 NOT NULL,
+    synced_at          TIMESTAMP NULL,
+
+    PRIMARY KEY (enrollment_id),
+    INDEX (synced_at, notified_at),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
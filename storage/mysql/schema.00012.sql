@@ -0,0 +1 @@
+ALTER TABLE notification_jobs ADD COLUMN claimed_until TIMESTAMP NULL AFTER failed;
@@ -90,6 +90,7 @@ CREATE TABLE status_values (
     INDEX (enrollment_id),
     INDEX (path),
     INDEX (enrollment_id, path),
+    INDEX (path, value), -- for searching enrollments by status value
 
     -- beware: we can get close to the maximum index size if our columns are too large
     UNIQUE (enrollment_id, path, container_type, value_type, value),
@@ -135,3 +136,100 @@ CREATE TABLE status_reports (
     INDEX (created_at),
     INDEX (enrollment_id, row_count)
 );
+
+CREATE TABLE status_value_history (
+    enrollment_id   VARCHAR(128) NOT NULL,
+
+    path      VARCHAR(255) NOT NULL,
+    old_value TEXT NULL, -- NULL when the path was first seen
+    new_value TEXT NOT NULL,
+
+    status_id VARCHAR(255) NULL,
+    row_count INT DEFAULT 0 NOT NULL,
+
+    INDEX (enrollment_id, path),
+    INDEX (enrollment_id, row_count),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE status_value_current (
+    enrollment_id   VARCHAR(128) NOT NULL,
+
+    path  VARCHAR(255) NOT NULL,
+    value TEXT NOT NULL,
+
+    PRIMARY KEY (enrollment_id, path),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE status_declaration_history (
+    enrollment_id          VARCHAR(128) NOT NULL,
+    declaration_identifier VARCHAR(255) NOT NULL,
+
+    active       BOOLEAN NOT NULL,
+    valid        VARCHAR(255) NOT NULL,
+    server_token VARCHAR(255) NOT NULL,
+
+    status_id VARCHAR(255) NULL,
+    row_count INT DEFAULT 0 NOT NULL,
+
+    INDEX (enrollment_id, declaration_identifier),
+    INDEX (enrollment_id, row_count),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE status_unhandled (
+    path VARCHAR(255) NOT NULL,
+
+    count         BIGINT DEFAULT 1 NOT NULL,
+    enrollment_id VARCHAR(128) NOT NULL,
+
+    PRIMARY KEY (path),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE notification_jobs (
+    id VARCHAR(64) NOT NULL,
+
+    declarations   JSON NULL,
+    sets           JSON NULL,
+    enrollment_ids JSON NULL,
+    force_notify   BOOLEAN DEFAULT FALSE NOT NULL,
+
+    attempts     INT DEFAULT 0 NOT NULL,
+    last_error   TEXT NULL,
+    next_attempt TIMESTAMP NOT NULL,
+    failed       BOOLEAN DEFAULT FALSE NOT NULL,
+
+    claimed_until TIMESTAMP NULL,
+
+    PRIMARY KEY (id),
+    INDEX (failed, next_attempt),
+
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE notification_tracking (
+    enrollment_id VARCHAR(128) NOT NULL,
+
+    command_uuid       VARCHAR(64) NULL,
+    declarations_token VARCHAR(64) NULL,
+    notified_at        TIMESTAMP NOT NULL,
+    notifications      INT DEFAULT 1 NOT NULL,
+    synced_at          TIMESTAMP NULL,
+
+    PRIMARY KEY (enrollment_id),
+    INDEX (synced_at, notified_at),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP NOT NULL
+);
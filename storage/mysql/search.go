@@ -0,0 +1,117 @@
+package mysql
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+// likeEscaper escapes the wildcard characters of SQL LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchStatusValues finds enrollments that reported a status value matching q.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) SearchStatusValues(ctx context.Context, q storage.StatusValueSearch) (*storage.StatusValueSearchResult, error) {
+	if err := q.Valid(); err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{q.Path, q.After}
+	var valueCond string
+	switch q.Operator {
+	case storage.SearchOpEqual:
+		valueCond = `AND value = ?`
+		args = append(args, q.Value)
+	case storage.SearchOpPrefix:
+		valueCond = `AND value LIKE ?`
+		args = append(args, likeEscaper.Replace(q.Value)+"%")
+	case storage.SearchOpLessThan:
+		valueCond = `AND value_type = 'number' AND CAST(value AS DOUBLE) < ?`
+		args = append(args, q.Value)
+	case storage.SearchOpLessThanEqual:
+		valueCond = `AND value_type = 'number' AND CAST(value AS DOUBLE) <= ?`
+		args = append(args, q.Value)
+	case storage.SearchOpGreaterThan:
+		valueCond = `AND value_type = 'number' AND CAST(value AS DOUBLE) > ?`
+		args = append(args, q.Value)
+	case storage.SearchOpGreaterThanEqual:
+		valueCond = `AND value_type = 'number' AND CAST(value AS DOUBLE) >= ?`
+		args = append(args, q.Value)
+	default:
+		// version comparisons are matched below
+		return s.searchStatusValuesMatch(ctx, q)
+	}
+	// fetch one more than the limit to know if there's another page
+	args = append(args, q.Limit+1)
+	ids, err := s.singleStringColumn(
+		ctx, `
+SELECT DISTINCT
+    enrollment_id
+FROM
+    status_values
+WHERE
+    path = ? AND
+    enrollment_id > ? `+valueCond+`
+ORDER BY
+    enrollment_id
+LIMIT ?;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return searchResult(ids, q.Limit), nil
+}
+
+// searchStatusValuesMatch finds enrollments using the matching of q
+// against every value at the path.
+func (s *MySQLStorage) searchStatusValuesMatch(ctx context.Context, q storage.StatusValueSearch) (*storage.StatusValueSearchResult, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    enrollment_id,
+    value
+FROM
+    status_values
+WHERE
+    path = ? AND
+    enrollment_id > ?
+ORDER BY
+    enrollment_id;`,
+		q.Path,
+		q.After,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	var id, value string
+	for rows.Next() && len(ids) <= q.Limit {
+		if err = rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		if (len(ids) < 1 || ids[len(ids)-1] != id) && q.Match(value) {
+			ids = append(ids, id)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return searchResult(ids, q.Limit), nil
+}
+
+// searchResult creates a page of search results from sorted ids.
+// If there are more ids than limit then the next page is set.
+func searchResult(ids []string, limit int) *storage.StatusValueSearchResult {
+	r := &storage.StatusValueSearchResult{EnrollmentIDs: ids}
+	if len(ids) > limit {
+		r.EnrollmentIDs = ids[:limit]
+		r.Next = ids[limit-1]
+	}
+	if r.EnrollmentIDs == nil {
+		r.EnrollmentIDs = []string{}
+	}
+	return r
+}
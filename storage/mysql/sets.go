@@ -17,7 +17,7 @@ func (s *MySQLStorage) RetrieveSetDeclarations(ctx context.Context, setName stri
 // StoreSetDeclaration creates the association between a declaration and a set.
 // See also the storage package for documentation on the storage interfaces.
 func (s *MySQLStorage) StoreSetDeclaration(ctx context.Context, setName, declarationID string) (bool, error) {
-	result, err := s.db.ExecContext(
+	result, err := s.conn.ExecContext(
 		ctx, `
 INSERT INTO set_declarations
     (declaration_identifier, set_name)
@@ -38,7 +38,7 @@ UPDATE
 // RemoveSetDeclaration removes the association between a declaration and a set.
 // See also the storage package for documentation on the storage interfaces.
 func (s *MySQLStorage) RemoveSetDeclaration(ctx context.Context, setName, declarationID string) (bool, error) {
-	result, err := s.db.ExecContext(
+	result, err := s.conn.ExecContext(
 		ctx, `
 DELETE FROM set_declarations
 WHERE
@@ -8,10 +8,14 @@ import (
 	"fmt"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/logkeys"
 	"github.com/jessepeterson/kmfddm/storage"
 	"github.com/jessepeterson/kmfddm/storage/mysql/sqlc"
+
+	"github.com/micromdm/nanolib/log/ctxlog"
 )
 
 // storeStatusDeclarations will completely remove and replace the set of declaration status for an enrollmentID with declarations.
@@ -22,6 +26,12 @@ func (s *MySQLStorage) storeStatusDeclarations(ctx context.Context, enrollmentID
 		return nil
 	}
 	return tx(ctx, s.db, s.q, func(ctx context.Context, tx *sql.Tx, qtx *sqlc.Queries) error {
+		if s.history {
+			err := s.storeDeclarationStatusHistory(ctx, tx, enrollmentID, statusID, declarations)
+			if err != nil {
+				return fmt.Errorf("storing declaration status history: %w", err)
+			}
+		}
 		err := qtx.RemoveDeclarationStatus(ctx, enrollmentID)
 		if err != nil {
 			return err
@@ -48,10 +58,23 @@ func (s *MySQLStorage) storeStatusDeclarations(ctx context.Context, enrollmentID
 	})
 }
 
+// storeStatusValues stores the status values of enrollmentID.
+// A status report contains every value of the paths it reports so the
+// stored values of those paths that are not in values are removed.
 func (s *MySQLStorage) storeStatusValues(ctx context.Context, enrollmentID, statusID string, values []ddm.StatusValue) error {
 	if len(values) < 1 {
 		return nil
 	}
+	var paths []interface{}
+	seen := make(map[string]struct{})
+	var keys []interface{}
+	for _, v := range values {
+		if _, ok := seen[v.Path]; !ok {
+			seen[v.Path] = struct{}{}
+			paths = append(paths, v.Path)
+		}
+		keys = append(keys, v.Path, v.ContainerType, v.ValueType, v.Value)
+	}
 	argSQL := strings.Repeat(", (?, ?, ?, ?, ?, ?)", len(values))[2:]
 	const argLen = 6
 	args := make([]interface{}, len(values)*argLen)
@@ -66,8 +89,22 @@ func (s *MySQLStorage) storeStatusValues(ctx context.Context, enrollmentID, stat
 			Valid:  len(statusID) > 0,
 		}
 	}
-	_, err := s.db.ExecContext(
-		ctx, `
+	return tx(ctx, s.db, s.q, func(ctx context.Context, tx *sql.Tx, _ *sqlc.Queries) error {
+		_, err := tx.ExecContext(
+			ctx, `
+DELETE FROM
+    status_values
+WHERE
+    enrollment_id = ? AND
+    path IN (`+strings.Repeat(", ?", len(paths))[2:]+`) AND
+    (path, container_type, value_type, value) NOT IN (`+strings.Repeat(", (?, ?, ?, ?)", len(values))[2:]+`);`,
+			append(append([]interface{}{enrollmentID}, paths...), keys...)...,
+		)
+		if err != nil {
+			return fmt.Errorf("removing replaced status values: %w", err)
+		}
+		_, err = tx.ExecContext(
+			ctx, `
 INSERT INTO status_values
     (
         enrollment_id,
@@ -83,11 +120,254 @@ ON DUPLICATE KEY
 UPDATE
     updated_at = CURRENT_TIMESTAMP,
     status_id = new.status_id;`,
+			args...,
+		)
+		return err
+	})
+}
+
+// storeDeclarationStatusHistory records declarations whose status changed
+// from the currently stored declaration status as events.
+// Must be called in tx before the declaration status is replaced.
+func (s *MySQLStorage) storeDeclarationStatusHistory(ctx context.Context, tx *sql.Tx, enrollmentID, statusID string, declarations []ddm.DeclarationStatus) error {
+	rows, err := tx.QueryContext(
+		ctx, `
+SELECT
+    declaration_identifier,
+    active,
+    valid,
+    server_token
+FROM
+    status_declarations
+WHERE
+    enrollment_id = ?
+FOR UPDATE;`,
+		enrollmentID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var prev []ddm.DeclarationStatus
+	for rows.Next() {
+		var ds ddm.DeclarationStatus
+		if err = rows.Scan(&ds.Identifier, &ds.Active, &ds.Valid, &ds.ServerToken); err != nil {
+			return err
+		}
+		prev = append(prev, ds)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	changed := storage.ChangedDeclarationStatus(prev, declarations)
+	if len(changed) < 1 {
+		return nil
+	}
+
+	// row_count is zero for the most recent event
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE status_declaration_history SET row_count = row_count + ? WHERE enrollment_id = ?;`,
+		len(changed),
+		enrollmentID,
+	)
+	if err != nil {
+		return err
+	}
+
+	const argLen = 7
+	args := make([]interface{}, len(changed)*argLen)
+	for i, ds := range changed {
+		args[i*argLen] = enrollmentID
+		args[i*argLen+1] = ds.Identifier
+		args[i*argLen+2] = ds.Active
+		args[i*argLen+3] = ds.Valid
+		args[i*argLen+4] = ds.ServerToken
+		args[i*argLen+5] = sql.NullString{
+			String: statusID,
+			Valid:  len(statusID) > 0,
+		}
+		args[i*argLen+6] = len(changed) - 1 - i
+	}
+	_, err = tx.ExecContext(
+		ctx, `
+INSERT INTO status_declaration_history
+    (
+        enrollment_id,
+        declaration_identifier,
+        active,
+        valid,
+        server_token,
+        status_id,
+        row_count
+    )
+VALUES
+    `+strings.Repeat(", (?, ?, ?, ?, ?, ?, ?)", len(changed))[2:]+`;`,
 		args...,
 	)
+	if err != nil {
+		return err
+	}
+
+	if s.histDel > 0 {
+		_, err = tx.ExecContext(
+			ctx,
+			`DELETE FROM status_declaration_history WHERE enrollment_id = ? AND row_count >= ?`,
+			enrollmentID,
+			s.histDel,
+		)
+	}
 	return err
 }
 
+// statusValueChange is a change of value at a status path.
+type statusValueChange struct {
+	path     string
+	oldValue sql.NullString
+	newValue string
+}
+
+// storeStatusValueHistory records changes to the values at each status path.
+// The current value of each path is kept to detect changes. Does nothing
+// unless status history is enabled.
+func (s *MySQLStorage) storeStatusValueHistory(ctx context.Context, enrollmentID, statusID string, values []ddm.StatusValue) error {
+	if !s.history || len(values) < 1 {
+		return nil
+	}
+	paths, pathValues := storage.StatusPathValues(values)
+	return tx(ctx, s.db, s.q, func(ctx context.Context, tx *sql.Tx, _ *sqlc.Queries) error {
+		args := make([]interface{}, len(paths)+1)
+		args[0] = enrollmentID
+		for i, path := range paths {
+			args[i+1] = path
+		}
+		rows, err := tx.QueryContext(
+			ctx, `
+SELECT
+    path,
+    value
+FROM
+    status_value_current
+WHERE
+    enrollment_id = ? AND
+    path IN (`+strings.Repeat(", ?", len(paths))[2:]+`)
+FOR UPDATE;`,
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		current := make(map[string]string)
+		var path, value string
+		for rows.Next() {
+			if err = rows.Scan(&path, &value); err != nil {
+				return err
+			}
+			current[path] = value
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		var changes []statusValueChange
+		for _, path := range paths {
+			oldValue, ok := current[path]
+			if ok && oldValue == pathValues[path] {
+				continue
+			}
+			changes = append(changes, statusValueChange{
+				path:     path,
+				oldValue: sql.NullString{String: oldValue, Valid: ok},
+				newValue: pathValues[path],
+			})
+		}
+		if len(changes) < 1 {
+			return nil
+		}
+
+		// row_count is zero for the most recent change
+		_, err = tx.ExecContext(
+			ctx,
+			`UPDATE status_value_history SET row_count = row_count + ? WHERE enrollment_id = ?;`,
+			len(changes),
+			enrollmentID,
+		)
+		if err != nil {
+			return err
+		}
+
+		const argLen = 6
+		args = make([]interface{}, len(changes)*argLen)
+		for i, c := range changes {
+			args[i*argLen] = enrollmentID
+			args[i*argLen+1] = c.path
+			args[i*argLen+2] = c.oldValue
+			args[i*argLen+3] = c.newValue
+			args[i*argLen+4] = sql.NullString{
+				String: statusID,
+				Valid:  len(statusID) > 0,
+			}
+			args[i*argLen+5] = len(changes) - 1 - i
+		}
+		_, err = tx.ExecContext(
+			ctx, `
+INSERT INTO status_value_history
+    (
+        enrollment_id,
+        path,
+        old_value,
+        new_value,
+        status_id,
+        row_count
+    )
+VALUES
+    `+strings.Repeat(", (?, ?, ?, ?, ?, ?)", len(changes))[2:]+`;`,
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+
+		const curArgLen = 3
+		args = make([]interface{}, len(changes)*curArgLen)
+		for i, c := range changes {
+			args[i*curArgLen] = enrollmentID
+			args[i*curArgLen+1] = c.path
+			args[i*curArgLen+2] = c.newValue
+		}
+		_, err = tx.ExecContext(
+			ctx, `
+INSERT INTO status_value_current
+    (
+        enrollment_id,
+        path,
+        value
+    )
+VALUES
+    `+strings.Repeat(", (?, ?, ?)", len(changes))[2:]+` as new
+ON DUPLICATE KEY
+UPDATE
+    value = new.value;`,
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+
+		if s.histDel > 0 {
+			_, err = tx.ExecContext(
+				ctx,
+				`DELETE FROM status_value_history WHERE enrollment_id = ? AND row_count >= ?`,
+				enrollmentID,
+				s.histDel,
+			)
+		}
+		return err
+	})
+}
+
 func (s *MySQLStorage) storeStatusErrors(ctx context.Context, enrollmentID, statusID string, errors []ddm.StatusError) error {
 	if len(errors) < 1 {
 		return nil
@@ -219,13 +499,97 @@ func (s *MySQLStorage) StoreDeclarationStatus(ctx context.Context, enrollmentID
 	if err != nil {
 		return fmt.Errorf("storing status values: %w", err)
 	}
+	err = s.storeStatusValueHistory(ctx, enrollmentID, status.ID, status.Values)
+	if err != nil {
+		return fmt.Errorf("storing status value history: %w", err)
+	}
 	err = s.storeStatusErrors(ctx, enrollmentID, status.ID, status.Errors)
 	if err != nil {
 		return fmt.Errorf("storing status errors: %w", err)
 	}
+	// the status is stored; recording the unhandled paths is only informational
+	err = s.storeUnhandledStatusPaths(ctx, enrollmentID, status.Unhandled)
+	if err != nil {
+		ctxlog.Logger(ctx, s.logger).Info(
+			logkeys.Message, "storing unhandled status paths",
+			logkeys.EnrollmentID, enrollmentID,
+			logkeys.Error, err,
+		)
+	}
 	return nil
 }
 
+// maxUnhandledPathLen is the maximum length of an unhandled status path.
+// Longer paths are truncated to fit the primary key column.
+const maxUnhandledPathLen = 255
+
+// truncatePath truncates path to at most max characters.
+func truncatePath(path string, max int) string {
+	if utf8.RuneCountInString(path) <= max {
+		return path
+	}
+	return string([]rune(path)[:max])
+}
+
+func (s *MySQLStorage) storeUnhandledStatusPaths(ctx context.Context, enrollmentID string, paths []string) error {
+	if len(paths) < 1 {
+		return nil
+	}
+	argSQL := strings.Repeat(", (?, ?)", len(paths))[2:]
+	args := make([]interface{}, 0, len(paths)*2)
+	for _, path := range paths {
+		args = append(args, truncatePath(path, maxUnhandledPathLen), enrollmentID)
+	}
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO status_unhandled
+    (path, enrollment_id)
+VALUES
+    `+argSQL+` AS new
+ON DUPLICATE KEY
+UPDATE
+    count = status_unhandled.count + 1,
+    enrollment_id = new.enrollment_id,
+    updated_at = CURRENT_TIMESTAMP;`,
+		args...,
+	)
+	return err
+}
+
+// RetrieveUnhandledStatusPaths retrieves the unhandled status report paths sorted by path.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) RetrieveUnhandledStatusPaths(ctx context.Context) ([]storage.UnhandledStatusPath, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    path,
+    count,
+    enrollment_id,
+    created_at,
+    updated_at
+FROM
+    status_unhandled
+ORDER BY
+    path;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []storage.UnhandledStatusPath
+	for rows.Next() {
+		var u storage.UnhandledStatusPath
+		var firstSeen, lastSeen string
+		if err = rows.Scan(&u.Path, &u.Count, &u.EnrollmentID, &firstSeen, &lastSeen); err != nil {
+			return nil, err
+		}
+		u.FirstSeen, _ = time.Parse(mysqlTimeFormat, firstSeen)
+		u.LastSeen, _ = time.Parse(mysqlTimeFormat, lastSeen)
+		paths = append(paths, u)
+	}
+	return paths, rows.Err()
+}
+
 // RetrieveDeclarationStatus retrieves the status of declarations for enrollmentIDs.
 // See also the storage package for documentation on the storage interfaces.
 func (s *MySQLStorage) RetrieveDeclarationStatus(ctx context.Context, enrollmentIDs []string) (map[string][]ddm.DeclarationQueryStatus, error) {
@@ -369,6 +733,143 @@ ORDER BY
 	return resp, err
 }
 
+// RetrieveStatusValueHistory retrieves the recorded status value changes for enrollmentIDs.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) RetrieveStatusValueHistory(ctx context.Context, enrollmentIDs []string, path string) (map[string][]storage.StatusValueChange, error) {
+	if !s.history {
+		return nil, storage.ErrStatusHistoryDisabled
+	}
+	if len(enrollmentIDs) < 1 {
+		return nil, errors.New("no enrollment IDs provided")
+	}
+	idSQL := strings.Repeat(", ?", len(enrollmentIDs))[2:]
+	args := make([]interface{}, len(enrollmentIDs))
+	for i, id := range enrollmentIDs {
+		args[i] = id
+	}
+	pathCond := ""
+	if path != "" {
+		args = append(args, path)
+		pathCond = `AND path = ?`
+	}
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    enrollment_id,
+    path,
+    old_value,
+    new_value,
+    status_id,
+    created_at
+FROM
+    status_value_history
+WHERE
+    enrollment_id IN (`+idSQL+`) `+pathCond+`
+ORDER BY
+    enrollment_id, row_count DESC;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	resp := make(map[string][]storage.StatusValueChange)
+	var id string
+	for rows.Next() {
+		change := storage.StatusValueChange{}
+		var dbTimestamp string
+		var oldValue, statusID sql.NullString
+		err = rows.Scan(
+			&id,
+			&change.Path,
+			&oldValue,
+			&change.NewValue,
+			&statusID,
+			&dbTimestamp,
+		)
+		if err != nil {
+			break
+		}
+		change.OldValue = oldValue.String
+		change.StatusID = statusID.String
+		change.Timestamp, _ = time.Parse(mysqlTimeFormat, dbTimestamp)
+		resp[id] = append(resp[id], change)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	return resp, err
+}
+
+// RetrieveDeclarationStatusHistory retrieves the recorded declaration status changes for enrollmentIDs.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) RetrieveDeclarationStatusHistory(ctx context.Context, enrollmentIDs []string, declarationID string) (map[string][]storage.DeclarationStatusEvent, error) {
+	if !s.history {
+		return nil, storage.ErrStatusHistoryDisabled
+	}
+	if len(enrollmentIDs) < 1 {
+		return nil, errors.New("no enrollment IDs provided")
+	}
+	idSQL := strings.Repeat(", ?", len(enrollmentIDs))[2:]
+	args := make([]interface{}, len(enrollmentIDs))
+	for i, id := range enrollmentIDs {
+		args[i] = id
+	}
+	declarationCond := ""
+	if declarationID != "" {
+		args = append(args, declarationID)
+		declarationCond = `AND declaration_identifier = ?`
+	}
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    enrollment_id,
+    declaration_identifier,
+    active,
+    valid,
+    server_token,
+    status_id,
+    created_at
+FROM
+    status_declaration_history
+WHERE
+    enrollment_id IN (`+idSQL+`) `+declarationCond+`
+ORDER BY
+    enrollment_id, row_count DESC;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	resp := make(map[string][]storage.DeclarationStatusEvent)
+	var id string
+	for rows.Next() {
+		event := storage.DeclarationStatusEvent{}
+		var dbTimestamp string
+		var statusID sql.NullString
+		err = rows.Scan(
+			&id,
+			&event.Identifier,
+			&event.Active,
+			&event.Valid,
+			&event.ServerToken,
+			&statusID,
+			&dbTimestamp,
+		)
+		if err != nil {
+			break
+		}
+		event.StatusID = statusID.String
+		event.Timestamp, _ = time.Parse(mysqlTimeFormat, dbTimestamp)
+		resp[id] = append(resp[id], event)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	return resp, err
+}
+
 // RetrieveStatusValues retrieves the status report for an enrollment ID.
 // The search can be filtered with properties on q.
 // See also the storage package for documentation on the storage interfaces.
@@ -414,7 +915,9 @@ LIMIT 1;`,
 		&report.Index,
 		&report.Raw,
 	)
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.ErrStatusReportNotFound
+	} else if err != nil {
 		return report, err
 	}
 	report.Timestamp, _ = time.Parse(mysqlTimeFormat, dbTimestamp)
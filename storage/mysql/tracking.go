@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+// StoreTrackedNotifications records notifications sent to enrollments.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) StoreTrackedNotifications(ctx context.Context, notifications []storage.TrackedNotification) error {
+	if len(notifications) < 1 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(notifications)*4)
+	for _, ntf := range notifications {
+		if ntf.EnrollmentID == "" {
+			return errors.New("missing enrollment ID")
+		}
+		args = append(
+			args,
+			ntf.EnrollmentID,
+			sql.NullString{String: ntf.CommandUUID, Valid: ntf.CommandUUID != ""},
+			sql.NullString{String: ntf.DeclarationsToken, Valid: ntf.DeclarationsToken != ""},
+			ntf.NotifiedAt.UTC().Format(mysqlTimeFormat),
+		)
+	}
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO notification_tracking
+    (enrollment_id, command_uuid, declarations_token, notified_at)
+VALUES
+    `+strings.Repeat(", (?, ?, ?, ?)", len(notifications))[2:]+` AS new
+ON DUPLICATE KEY
+UPDATE
+    notifications = IF(notification_tracking.synced_at IS NULL, notification_tracking.notifications + 1, 1),
+    command_uuid = COALESCE(new.command_uuid, notification_tracking.command_uuid),
+    declarations_token = new.declarations_token,
+    notified_at = new.notified_at,
+    synced_at = NULL;`,
+		args...,
+	)
+	return err
+}
+
+// RetrieveUnsyncedNotifications retrieves the unsynced notifications selected by filter.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) RetrieveUnsyncedNotifications(ctx context.Context, filter storage.UnsyncedFilter) ([]storage.TrackedNotification, error) {
+	q := `
+SELECT
+    enrollment_id,
+    command_uuid,
+    declarations_token,
+    notified_at,
+    notifications
+FROM
+    notification_tracking
+WHERE
+    synced_at IS NULL`
+	var args []interface{}
+	if len(filter.EnrollmentIDs) > 0 {
+		q += ` AND enrollment_id IN (` + strings.Repeat(", ?", len(filter.EnrollmentIDs))[2:] + `)`
+		for _, id := range filter.EnrollmentIDs {
+			args = append(args, id)
+		}
+	}
+	if !filter.NotifiedBefore.IsZero() {
+		q += ` AND notified_at < ?`
+		args = append(args, filter.NotifiedBefore.UTC().Format(mysqlTimeFormat))
+	}
+	if filter.MaxNotifications > 0 {
+		q += ` AND notifications < ?`
+		args = append(args, filter.MaxNotifications)
+	}
+	if filter.AfterEnrollmentID != "" {
+		after := filter.AfterNotifiedAt.UTC().Format(mysqlTimeFormat)
+		q += ` AND (notified_at > ? OR (notified_at = ? AND enrollment_id > ?))`
+		args = append(args, after, after, filter.AfterEnrollmentID)
+	}
+	q += ` ORDER BY notified_at, enrollment_id`
+	if filter.Limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, q+`;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var notifications []storage.TrackedNotification
+	for rows.Next() {
+		var ntf storage.TrackedNotification
+		var cmdUUID, token sql.NullString
+		var notifiedAt string
+		if err = rows.Scan(&ntf.EnrollmentID, &cmdUUID, &token, &notifiedAt, &ntf.Notifications); err != nil {
+			return nil, err
+		}
+		ntf.CommandUUID = cmdUUID.String
+		ntf.DeclarationsToken = token.String
+		ntf.NotifiedAt, _ = time.Parse(mysqlTimeFormat, notifiedAt)
+		notifications = append(notifications, ntf)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkNotificationSynced marks the notification of enrollmentID as synced at time at.
+// See also the storage package for documentation on the storage interfaces.
+func (s *MySQLStorage) MarkNotificationSynced(ctx context.Context, enrollmentID string, at time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx, `
+UPDATE
+    notification_tracking
+SET
+    synced_at = ?,
+    notifications = 0
+WHERE
+    enrollment_id = ? AND
+    synced_at IS NULL;`,
+		at.UTC().Format(mysqlTimeFormat),
+		enrollmentID,
+	)
+	return err
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrNotificationJobNotFound = errors.New("notification job not found")
+
+// NotificationJob is a queued notification of changed declarations, sets, or enrollments.
+type NotificationJob struct {
+	ID            string   `json:"id"`
+	Declarations  []string `json:"declarations,omitempty"`
+	Sets          []string `json:"sets,omitempty"`
+	EnrollmentIDs []string `json:"enrollment_ids,omitempty"`
+
+	// Force notifies enrollments even if their declarations token is unchanged.
+	Force bool `json:"force,omitempty"`
+
+	// Attempts is the number of failed attempts to notify.
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+
+	// NextAttempt is the earliest time to attempt to notify again.
+	NextAttempt time.Time `json:"next_attempt"`
+
+	// Failed indicates the job has exhausted its attempts (i.e. it has
+	// been "dead-lettered") and will not be attempted again unless replayed.
+	Failed bool `json:"failed"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Replay resets j so that it is attempted again as of now.
+func (j *NotificationJob) Replay(now time.Time) {
+	j.Attempts = 0
+	j.LastError = ""
+	j.Failed = false
+	j.NextAttempt = now
+}
+
+// NotificationJobFilter selects notification jobs.
+type NotificationJobFilter struct {
+	// Failed selects failed jobs instead of pending jobs.
+	Failed bool
+
+	// DueBy, if not zero, selects only jobs whose next attempt is at or before DueBy.
+	DueBy time.Time
+
+	// Limit is the maximum number of jobs to select. Zero means no limit.
+	Limit int
+}
+
+// Match reports whether j is selected by f (not considering the limit).
+func (f NotificationJobFilter) Match(j *NotificationJob) bool {
+	if j.Failed != f.Failed {
+		return false
+	}
+	return f.DueBy.IsZero() || !j.NextAttempt.After(f.DueBy)
+}
+
+// SortNotificationJobs sorts jobs by creation time then by ID.
+func SortNotificationJobs(jobs []NotificationJob) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+}
+
+type NotificationJobStorer interface {
+	// StoreNotificationJob creates or replaces the notification job with the ID of job.
+	StoreNotificationJob(ctx context.Context, job *NotificationJob) error
+}
+
+type NotificationJobRetriever interface {
+	// RetrieveNotificationJob retrieves the notification job with id.
+	// If the job does not exist then [ErrNotificationJobNotFound] should be returned.
+	RetrieveNotificationJob(ctx context.Context, id string) (*NotificationJob, error)
+
+	// RetrieveNotificationJobs retrieves the notification jobs selected by filter.
+	// Jobs are returned sorted by creation time.
+	RetrieveNotificationJobs(ctx context.Context, filter NotificationJobFilter) ([]NotificationJob, error)
+}
+
+type NotificationJobClaimer interface {
+	// ClaimNotificationJobs claims and returns up to limit pending jobs
+	// that are due by now and are not claimed (or whose claim expired by now).
+	// The jobs stay claimed until claimedUntil so that other callers,
+	// such as other instances sharing the storage, do not also claim them.
+	// Storing or deleting a job releases its claim.
+	// Jobs are returned sorted by creation time. Zero limit means no limit.
+	ClaimNotificationJobs(ctx context.Context, now, claimedUntil time.Time, limit int) ([]NotificationJob, error)
+}
+
+type NotificationJobDeleter interface {
+	// DeleteNotificationJob deletes the notification job with id.
+	// Deleting a job that does not exist is not an error.
+	DeleteNotificationJob(ctx context.Context, id string) error
+}
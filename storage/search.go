@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Status value search operators.
+const (
+	SearchOpEqual  = "eq"
+	SearchOpPrefix = "prefix"
+
+	// numeric comparisons
+	SearchOpLessThan         = "lt"
+	SearchOpLessThanEqual    = "le"
+	SearchOpGreaterThan      = "gt"
+	SearchOpGreaterThanEqual = "ge"
+
+	// semantic version comparisons of dotted numeric versions (e.g. "14.4.1")
+	SearchOpSemverLessThan         = "semver_lt"
+	SearchOpSemverLessThanEqual    = "semver_le"
+	SearchOpSemverGreaterThan      = "semver_gt"
+	SearchOpSemverGreaterThanEqual = "semver_ge"
+)
+
+var ErrInvalidSearch = errors.New("invalid search")
+
+// StatusValueSearch specifies search criteria for finding enrollments by their reported status values.
+type StatusValueSearch struct {
+	Path     string // exact status value path
+	Operator string // one of the SearchOp constants
+	Value    string
+
+	// After is the enrollment ID to start searching after for pagination.
+	After string
+	// Limit is the maximum number of enrollment IDs to return.
+	Limit int
+}
+
+// Valid performs basic sanity checks for searching status values.
+func (q StatusValueSearch) Valid() error {
+	if q.Path == "" {
+		return fmt.Errorf("%w: missing path", ErrInvalidSearch)
+	}
+	if q.Limit < 1 {
+		return fmt.Errorf("%w: limit must be positive", ErrInvalidSearch)
+	}
+	switch q.Operator {
+	case SearchOpEqual, SearchOpPrefix:
+	case SearchOpLessThan, SearchOpLessThanEqual, SearchOpGreaterThan, SearchOpGreaterThanEqual:
+		if _, err := strconv.ParseFloat(q.Value, 64); err != nil {
+			return fmt.Errorf("%w: numeric value: %v", ErrInvalidSearch, err)
+		}
+	case SearchOpSemverLessThan, SearchOpSemverLessThanEqual, SearchOpSemverGreaterThan, SearchOpSemverGreaterThanEqual:
+		if _, err := parseVersion(q.Value); err != nil {
+			return fmt.Errorf("%w: version value: %v", ErrInvalidSearch, err)
+		}
+	default:
+		return fmt.Errorf("%w: unknown operator: %q", ErrInvalidSearch, q.Operator)
+	}
+	return nil
+}
+
+// Match reports whether the status value v matches the search operator and value.
+// Values which can not be parsed for numeric or version comparison do not match.
+func (q StatusValueSearch) Match(v string) bool {
+	var cmp int
+	switch q.Operator {
+	case SearchOpEqual:
+		return v == q.Value
+	case SearchOpPrefix:
+		return strings.HasPrefix(v, q.Value)
+	case SearchOpLessThan, SearchOpLessThanEqual, SearchOpGreaterThan, SearchOpGreaterThanEqual:
+		a, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return false
+		}
+		b, err := strconv.ParseFloat(q.Value, 64)
+		if err != nil {
+			return false
+		}
+		if a < b {
+			cmp = -1
+		} else if a > b {
+			cmp = 1
+		}
+	case SearchOpSemverLessThan, SearchOpSemverLessThanEqual, SearchOpSemverGreaterThan, SearchOpSemverGreaterThanEqual:
+		var err error
+		if cmp, err = compareVersions(v, q.Value); err != nil {
+			return false
+		}
+	default:
+		return false
+	}
+	switch q.Operator {
+	case SearchOpLessThan, SearchOpSemverLessThan:
+		return cmp < 0
+	case SearchOpLessThanEqual, SearchOpSemverLessThanEqual:
+		return cmp <= 0
+	case SearchOpGreaterThan, SearchOpSemverGreaterThan:
+		return cmp > 0
+	case SearchOpGreaterThanEqual, SearchOpSemverGreaterThanEqual:
+		return cmp >= 0
+	}
+	return false
+}
+
+// parseVersion parses a dotted numeric version string like "14.4.1".
+func parseVersion(s string) ([]int, error) {
+	parts := strings.Split(s, ".")
+	v := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version: %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// compareVersions compares the dotted numeric version strings a and b.
+// Missing components are treated as zero (i.e. "14.4" equals "14.4.0").
+// The result is -1 if a < b, 0 if a == b, and +1 if a > b.
+func compareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x < y {
+			return -1, nil
+		} else if x > y {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// StatusValueSearchResult contains a page of enrollment IDs found by a status value search.
+type StatusValueSearchResult struct {
+	EnrollmentIDs []string `json:"enrollment_ids"`
+
+	// Next is the enrollment ID to search after for the next page of results.
+	// It is empty if there are no more results.
+	Next string `json:"next,omitempty"`
+}
+
+type StatusValueSearcher interface {
+	// SearchStatusValues finds enrollments that reported a status value matching q.
+	// Enrollment IDs are returned in sorted order.
+	SearchStatusValues(ctx context.Context, q StatusValueSearch) (*StatusValueSearchResult, error)
+}
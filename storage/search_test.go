@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStatusValueSearchMatch(t *testing.T) {
+	for _, tc := range []struct {
+		op    string
+		value string
+		v     string
+		match bool
+	}{
+		{SearchOpEqual, "22E261", "22E261", true},
+		{SearchOpEqual, "22E261", "22E26", false},
+		{SearchOpPrefix, "22E", "22E261", true},
+		{SearchOpPrefix, "23", "22E261", false},
+		{SearchOpLessThan, "10", "9.5", true},
+		{SearchOpGreaterThanEqual, "10", "10", true},
+		{SearchOpGreaterThan, "10", "abc", false},
+		{SearchOpSemverGreaterThanEqual, "14.4", "14.10", true},
+		{SearchOpSemverGreaterThanEqual, "14.4", "14.4.0", true},
+		{SearchOpSemverLessThan, "14.4", "14.3.1", true},
+		{SearchOpSemverLessThan, "14.4", "14.4", false},
+		{SearchOpSemverGreaterThan, "14", "14.0.1", true},
+		{SearchOpSemverLessThanEqual, "14", "beta", false},
+	} {
+		q := StatusValueSearch{Path: ".p", Operator: tc.op, Value: tc.value, Limit: 1}
+		if err := q.Valid(); err != nil {
+			t.Fatal(err)
+		}
+		if have, want := q.Match(tc.v), tc.match; have != want {
+			t.Errorf("%s %s %s: have: %v, want: %v", tc.v, tc.op, tc.value, have, want)
+		}
+	}
+}
+
+func TestStatusValueSearchValid(t *testing.T) {
+	for _, q := range []StatusValueSearch{
+		{Operator: SearchOpEqual, Limit: 1},
+		{Path: ".p", Operator: "unknown", Limit: 1},
+		{Path: ".p", Operator: SearchOpEqual},
+		{Path: ".p", Operator: SearchOpLessThan, Value: "abc", Limit: 1},
+		{Path: ".p", Operator: SearchOpSemverLessThan, Value: "14.x", Limit: 1},
+	} {
+		if err := q.Valid(); !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("%v: expected invalid search error, got: %v", q, err)
+		}
+	}
+}
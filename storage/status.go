@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -10,6 +11,10 @@ import (
 
 var (
 	ErrStatusReportNotFound = errors.New("status report not found")
+
+	// ErrStatusHistoryDisabled is returned when retrieving status
+	// history from storage that does not record it.
+	ErrStatusHistoryDisabled = errors.New("status history not enabled")
 )
 
 type StatusError struct {
@@ -26,6 +31,45 @@ type StatusValue struct {
 	StatusID  string    `json:"status_id,omitempty"`
 }
 
+// StatusValueChange is a recorded transition of the value at a status path.
+type StatusValueChange struct {
+	Path      string    `json:"path"`
+	OldValue  string    `json:"old_value,omitempty"` // empty when the path was first seen
+	NewValue  string    `json:"new_value"`
+	Timestamp time.Time `json:"timestamp"`
+	StatusID  string    `json:"status_id,omitempty"`
+}
+
+// DeclarationStatusEvent is a recorded change of the status of a declaration.
+// It contains the declaration status as of the change.
+type DeclarationStatusEvent struct {
+	Identifier  string    `json:"identifier"`
+	Active      bool      `json:"active"`
+	Valid       string    `json:"valid"`
+	ServerToken string    `json:"server-token"`
+	Timestamp   time.Time `json:"timestamp"`
+	StatusID    string    `json:"status_id,omitempty"`
+}
+
+// DeclarationStatusFlaps summarizes how often a declaration's validity has changed.
+type DeclarationStatusFlaps struct {
+	Identifier string `json:"identifier"`
+	Flips      int    `json:"flips"`    // count of changes between "valid" and "invalid"
+	Flapping   bool   `json:"flapping"` // set if Flips met the flapping threshold
+}
+
+// UnhandledStatusPath summarizes a status report path that was not
+// handled (i.e. not parsed) when status reports were received.
+type UnhandledStatusPath struct {
+	Path      string    `json:"path"`
+	Count     int64     `json:"count"` // count of status reports containing this path
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// EnrollmentID is a sample enrollment that sent this path.
+	// Specifically the enrollment that most recently sent it.
+	EnrollmentID string `json:"enrollment_id"`
+}
+
 // StoredStatusReport represents a stored status report by StoreDeclarationStatus.
 type StoredStatusReport struct {
 	Raw       []byte    // the raw JSON bytes of the status report
@@ -72,6 +116,98 @@ type StatusValuesRetriever interface {
 	RetrieveStatusValues(ctx context.Context, enrollmentIDs []string, pathPrefix string) (map[string][]StatusValue, error)
 }
 
+type StatusValueHistoryRetriever interface {
+	// RetrieveStatusValueHistory retrieves the recorded status value changes for enrollmentIDs.
+	// Changes are returned oldest first. If path is not empty only changes to that exact path are returned.
+	RetrieveStatusValueHistory(ctx context.Context, enrollmentIDs []string, path string) (map[string][]StatusValueChange, error)
+}
+
+type DeclarationStatusHistoryRetriever interface {
+	// RetrieveDeclarationStatusHistory retrieves the recorded declaration status changes for enrollmentIDs.
+	// Events are returned oldest first. If declarationID is not empty only events for that declaration are returned.
+	RetrieveDeclarationStatusHistory(ctx context.Context, enrollmentIDs []string, declarationID string) (map[string][]DeclarationStatusEvent, error)
+}
+
+type UnhandledStatusRetriever interface {
+	// RetrieveUnhandledStatusPaths retrieves the unhandled status report paths sorted by path.
+	RetrieveUnhandledStatusPaths(ctx context.Context) ([]UnhandledStatusPath, error)
+}
+
 type StatusReportRetriever interface {
 	RetrieveStatusReport(ctx context.Context, q StatusReportQuery) (*StoredStatusReport, error)
 }
+
+// StatusPathValues groups values by path for recording status value history.
+// A single string value is returned per path. Values contained in an array
+// are combined into a single JSON array of strings. Paths are returned in
+// the order they first appear in values.
+func StatusPathValues(values []ddm.StatusValue) ([]string, map[string]string) {
+	var paths []string
+	arrays := make(map[string][]string)
+	pathValues := make(map[string]string)
+	for _, v := range values {
+		if _, ok := pathValues[v.Path]; !ok {
+			paths = append(paths, v.Path)
+			pathValues[v.Path] = ""
+		}
+		if v.ContainerType == "array" {
+			arrays[v.Path] = append(arrays[v.Path], string(v.Value))
+		} else {
+			pathValues[v.Path] = string(v.Value)
+		}
+	}
+	for path, a := range arrays {
+		b, _ := json.Marshal(a)
+		pathValues[path] = string(b)
+	}
+	return paths, pathValues
+}
+
+// ChangedDeclarationStatus returns the declaration status in cur that
+// are not in prev or differ from prev in their active, valid, or server
+// token fields. Declaration status are matched by identifier.
+func ChangedDeclarationStatus(prev, cur []ddm.DeclarationStatus) []ddm.DeclarationStatus {
+	prevMap := make(map[string]ddm.DeclarationStatus)
+	for _, ds := range prev {
+		prevMap[ds.Identifier] = ds
+	}
+	var changed []ddm.DeclarationStatus
+	for _, ds := range cur {
+		p, ok := prevMap[ds.Identifier]
+		if ok && p.Active == ds.Active && p.Valid == ds.Valid && p.ServerToken == ds.ServerToken {
+			continue
+		}
+		changed = append(changed, ds)
+	}
+	return changed
+}
+
+// FlappingDeclarations counts the changes between "valid" and "invalid"
+// for each declaration in events. Events must be ordered oldest first.
+// Declarations with at least threshold changes are flagged as flapping.
+// Declarations are returned in the order they first appear in events.
+func FlappingDeclarations(events []DeclarationStatusEvent, threshold int) []DeclarationStatusFlaps {
+	var flaps []DeclarationStatusFlaps
+	idx := make(map[string]int)
+	last := make(map[string]string)
+	for _, e := range events {
+		i, ok := idx[e.Identifier]
+		if !ok {
+			i = len(flaps)
+			idx[e.Identifier] = i
+			flaps = append(flaps, DeclarationStatusFlaps{Identifier: e.Identifier})
+		}
+		if e.Valid != "valid" && e.Valid != "invalid" {
+			// e.g. "unknown"
+			continue
+		}
+		if prev, ok := last[e.Identifier]; ok && prev != e.Valid {
+			flaps[i].Flips++
+		}
+		last[e.Identifier] = e.Valid
+	}
+	for i := range flaps {
+		flaps[i].Flapping = threshold > 0 && flaps[i].Flips >= threshold
+	}
+	return flaps
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+)
+
+func TestStatusPathValues(t *testing.T) {
+	paths, values := StatusPathValues([]ddm.StatusValue{
+		{Path: ".a", ContainerType: "object", ValueType: "string", Value: []byte("hello")},
+		{Path: ".b", ContainerType: "array", ValueType: "string", Value: []byte("x")},
+		{Path: ".c", ContainerType: "object", ValueType: "number", Value: []byte("14.4")},
+		{Path: ".b", ContainerType: "array", ValueType: "string", Value: []byte("y")},
+	})
+
+	if have, want := len(paths), 3; have != want {
+		t.Fatalf("paths: have: %v, want: %v", have, want)
+	}
+	for i, want := range []string{".a", ".b", ".c"} {
+		if have := paths[i]; have != want {
+			t.Errorf("path %d: have: %v, want: %v", i, have, want)
+		}
+	}
+
+	for path, want := range map[string]string{
+		".a": "hello",
+		".b": `["x","y"]`,
+		".c": "14.4",
+	} {
+		if have := values[path]; have != want {
+			t.Errorf("value %s: have: %v, want: %v", path, have, want)
+		}
+	}
+}
+
+func TestChangedDeclarationStatus(t *testing.T) {
+	prev := []ddm.DeclarationStatus{
+		{Identifier: "a", Active: true, Valid: "valid", ServerToken: "1"},
+		{Identifier: "b", Active: true, Valid: "valid", ServerToken: "1"},
+		{Identifier: "c", Active: true, Valid: "valid", ServerToken: "1"},
+	}
+	cur := []ddm.DeclarationStatus{
+		{Identifier: "a", Active: true, Valid: "valid", ServerToken: "1"},
+		{Identifier: "b", Active: true, Valid: "invalid", ServerToken: "1"},
+		{Identifier: "c", Active: true, Valid: "valid", ServerToken: "2"},
+		{Identifier: "d", Active: false, Valid: "unknown", ServerToken: "1"},
+	}
+
+	var have []string
+	for _, ds := range ChangedDeclarationStatus(prev, cur) {
+		have = append(have, ds.Identifier)
+	}
+	if want := []string{"b", "c", "d"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have: %v, want: %v", have, want)
+	}
+}
+
+func TestFlappingDeclarations(t *testing.T) {
+	var events []DeclarationStatusEvent
+	for _, v := range []string{"unknown", "valid", "invalid", "unknown", "valid", "invalid"} {
+		events = append(events, DeclarationStatusEvent{Identifier: "a", Valid: v})
+	}
+	events = append(events, DeclarationStatusEvent{Identifier: "b", Valid: "valid"})
+
+	want := []DeclarationStatusFlaps{
+		{Identifier: "a", Flips: 3, Flapping: true},
+		{Identifier: "b"},
+	}
+	if have := FlappingDeclarations(events, 3); !reflect.DeepEqual(have, want) {
+		t.Errorf("have: %v, want: %v", have, want)
+	}
+
+	want[0].Flapping = false
+	if have := FlappingDeclarations(events, 4); !reflect.DeepEqual(have, want) {
+		t.Errorf("have: %v, want: %v", have, want)
+	}
+}
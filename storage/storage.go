@@ -5,7 +5,9 @@ package storage
 type DeclarationAPIStorage interface {
 	Toucher
 	DeclarationStorer
+	DeclarationCASStorer
 	DeclarationDeleter
+	DeclarationCascadeDeleter
 	DeclarationAPIRetriever
 	DeclarationsRetriever
 }
@@ -30,5 +32,25 @@ type StatusAPIStorage interface {
 	StatusDeclarationsRetriever
 	StatusErrorsRetriever
 	StatusValuesRetriever
+	StatusValueHistoryRetriever
+	DeclarationStatusHistoryRetriever
+	DeclarationComplianceRetriever
+	StatusValueSearcher
+	UnhandledStatusRetriever
 	StatusReportRetriever
 }
+
+// NotificationQueueStorage are storage interfaces related to the notification queue.
+type NotificationQueueStorage interface {
+	NotificationJobStorer
+	NotificationJobRetriever
+	NotificationJobClaimer
+	NotificationJobDeleter
+}
+
+// NotificationTrackingStorage are storage interfaces related to tracking whether notified enrollments synced.
+type NotificationTrackingStorage interface {
+	TrackedNotificationStorer
+	UnsyncedNotificationRetriever
+	NotificationSyncMarker
+}
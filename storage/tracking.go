@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// TrackedNotification is the most recent notification sent to an enrollment.
+type TrackedNotification struct {
+	EnrollmentID string `json:"enrollment_id"`
+
+	// CommandUUID is the UUID of the last enqueued DeclarativeManagement command.
+	// Notifications that only sent an APNs push keep the previous command UUID.
+	CommandUUID string `json:"command_uuid,omitempty"`
+
+	// DeclarationsToken is the declarations token of the enrollment when notified.
+	DeclarationsToken string `json:"declarations_token,omitempty"`
+
+	NotifiedAt time.Time `json:"notified_at"`
+
+	// Notifications is the number of notifications since the enrollment last synced.
+	Notifications int `json:"notifications"`
+
+	// SyncedAt is when a status report from the enrollment showed its current declarations.
+	// It is zero if the enrollment has not synced since it was last notified.
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// Synced reports whether the enrollment has synced since it was notified.
+func (n *TrackedNotification) Synced() bool {
+	return !n.SyncedAt.IsZero()
+}
+
+// UnsyncedFilter selects notified enrollments that have not synced.
+type UnsyncedFilter struct {
+	// EnrollmentIDs, if not empty, selects only these enrollments.
+	EnrollmentIDs []string
+
+	// NotifiedBefore, if not zero, selects only enrollments last notified before NotifiedBefore.
+	NotifiedBefore time.Time
+
+	// MaxNotifications, if not zero, selects only enrollments notified fewer than MaxNotifications times.
+	MaxNotifications int
+
+	// AfterNotifiedAt and AfterEnrollmentID, if AfterEnrollmentID is not
+	// empty, select only notifications sorted after the notification with
+	// them. This pages through notifications using the last notification
+	// of the previous page.
+	AfterNotifiedAt   time.Time
+	AfterEnrollmentID string
+
+	// Limit is the maximum number of notifications to select. Zero means no limit.
+	Limit int
+}
+
+// Match reports whether n is selected by f (not considering the limit).
+func (f UnsyncedFilter) Match(n *TrackedNotification) bool {
+	if n.Synced() {
+		return false
+	}
+	if !f.NotifiedBefore.IsZero() && !n.NotifiedAt.Before(f.NotifiedBefore) {
+		return false
+	}
+	if f.MaxNotifications > 0 && n.Notifications >= f.MaxNotifications {
+		return false
+	}
+	if f.AfterEnrollmentID != "" && !trackedNotificationLess(f.AfterNotifiedAt, f.AfterEnrollmentID, n.NotifiedAt, n.EnrollmentID) {
+		return false
+	}
+	if len(f.EnrollmentIDs) < 1 {
+		return true
+	}
+	for _, id := range f.EnrollmentIDs {
+		if id == n.EnrollmentID {
+			return true
+		}
+	}
+	return false
+}
+
+// trackedNotificationLess reports whether the notification at t1 of id1
+// sorts before the notification at t2 of id2.
+func trackedNotificationLess(t1 time.Time, id1 string, t2 time.Time, id2 string) bool {
+	if !t1.Equal(t2) {
+		return t1.Before(t2)
+	}
+	return id1 < id2
+}
+
+// SortTrackedNotifications sorts notifications by notification time then by enrollment ID.
+func SortTrackedNotifications(notifications []TrackedNotification) {
+	sort.Slice(notifications, func(i, j int) bool {
+		return trackedNotificationLess(notifications[i].NotifiedAt, notifications[i].EnrollmentID, notifications[j].NotifiedAt, notifications[j].EnrollmentID)
+	})
+}
+
+type TrackedNotificationStorer interface {
+	// StoreTrackedNotifications records notifications sent to enrollments.
+	// Any previous notification of each enrollment is replaced and it is
+	// considered unsynced. The Notifications count is incremented if the
+	// previous notification was unsynced or set to one otherwise.
+	// An empty CommandUUID keeps the previous command UUID.
+	StoreTrackedNotifications(ctx context.Context, notifications []TrackedNotification) error
+}
+
+type UnsyncedNotificationRetriever interface {
+	// RetrieveUnsyncedNotifications retrieves the unsynced notifications selected by filter.
+	// Notifications are returned sorted by notification time.
+	RetrieveUnsyncedNotifications(ctx context.Context, filter UnsyncedFilter) ([]TrackedNotification, error)
+}
+
+type NotificationSyncMarker interface {
+	// MarkNotificationSynced marks the notification of enrollmentID as synced at time at.
+	// Marking an enrollment without an unsynced notification is not an error.
+	MarkNotificationSynced(ctx context.Context, enrollmentID string, at time.Time) error
+}
@@ -0,0 +1,92 @@
+// Package tenant supports running multiple isolated KMFDDM tenants in one server.
+// Each tenant has its own storage, notifier, and API credentials.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+var (
+	ErrTenantNotFound  = errors.New("tenant not found")
+	ErrTenantAmbiguous = errors.New("enrollment claimed by multiple tenants")
+)
+
+type contextKey struct{}
+
+// NewContext returns a new context with the tenant name attached.
+func NewContext(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, contextKey{}, name)
+}
+
+// FromContext returns the tenant name attached to ctx.
+// An empty string is returned if no tenant is attached.
+func FromContext(ctx context.Context) string {
+	name, _ := ctx.Value(contextKey{}).(string)
+	return name
+}
+
+// EnrollmentResolver finds the tenant an enrollment belongs to.
+type EnrollmentResolver interface {
+	// ResolveEnrollment returns the tenant name for enrollmentID.
+	// If no tenant is found then [ErrTenantNotFound] should be returned.
+	// If more than one tenant is found then [ErrTenantAmbiguous] should be returned.
+	ResolveEnrollment(ctx context.Context, enrollmentID string) (string, error)
+}
+
+type tenantStore struct {
+	name  string
+	store storage.EnrollmentSetsRetriever
+}
+
+// SetResolver resolves enrollments to tenants by set membership.
+// An enrollment belongs to the tenant that has any sets associated
+// with the enrollment. Enrollments with sets in more than one tenant
+// do not resolve so that one tenant can not claim the enrollments of
+// another tenant.
+type SetResolver struct {
+	tenants []tenantStore
+	def     string
+}
+
+// NewSetResolver creates a new set membership tenant resolver.
+// Enrollments not found in any tenant resolve to the tenant named
+// def. If def is empty then those enrollments do not resolve.
+func NewSetResolver(def string) *SetResolver {
+	return &SetResolver{def: def}
+}
+
+// Add adds a tenant named name that uses store for set lookups.
+func (r *SetResolver) Add(name string, store storage.EnrollmentSetsRetriever) {
+	if store == nil {
+		panic("nil store")
+	}
+	r.tenants = append(r.tenants, tenantStore{name: name, store: store})
+}
+
+// ResolveEnrollment returns the tenant name for enrollmentID.
+func (r *SetResolver) ResolveEnrollment(ctx context.Context, enrollmentID string) (string, error) {
+	var names []string
+	for _, t := range r.tenants {
+		sets, err := t.store.RetrieveEnrollmentSets(ctx, enrollmentID)
+		if err != nil {
+			return "", fmt.Errorf("retrieving enrollment sets for tenant %s: %w", t.name, err)
+		}
+		if len(sets) > 0 {
+			names = append(names, t.name)
+		}
+	}
+	if len(names) > 1 {
+		return "", fmt.Errorf("%w: %s", ErrTenantAmbiguous, strings.Join(names, ", "))
+	} else if len(names) == 1 {
+		return names[0], nil
+	}
+	if r.def == "" {
+		return "", ErrTenantNotFound
+	}
+	return r.def, nil
+}
@@ -0,0 +1,69 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type testSetStore map[string][]string
+
+func (s testSetStore) RetrieveEnrollmentSets(_ context.Context, enrollmentID string) ([]string, error) {
+	return s[enrollmentID], nil
+}
+
+func TestSetResolver(t *testing.T) {
+	ctx := context.Background()
+
+	r := NewSetResolver("")
+	r.Add("a", testSetStore{"id1": {"set1"}})
+	r.Add("b", testSetStore{"id1": {"set2"}, "id2": {"set2"}, "id4": {"set2"}})
+	r.Add("c", testSetStore{"id4": {"set3"}})
+
+	for _, test := range []struct {
+		id     string
+		tenant string
+	}{
+		{"id2", "b"},
+	} {
+		tenant, err := r.ResolveEnrollment(ctx, test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := tenant, test.tenant; have != want {
+			t.Errorf("%s: have: %q, want: %q", test.id, have, want)
+		}
+	}
+
+	// enrollments claimed by multiple tenants do not resolve
+	for _, id := range []string{"id1", "id4"} {
+		if _, err := r.ResolveEnrollment(ctx, id); !errors.Is(err, ErrTenantAmbiguous) {
+			t.Errorf("%s: expected ambiguous tenant, got: %v", id, err)
+		}
+	}
+
+	_, err := r.ResolveEnrollment(ctx, "id3")
+	if !errors.Is(err, ErrTenantNotFound) {
+		t.Errorf("expected tenant not found, got: %v", err)
+	}
+
+	r.def = "b"
+	tenant, err := r.ResolveEnrollment(ctx, "id3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := tenant, "b"; have != want {
+		t.Errorf("have: %q, want: %q", have, want)
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if have := FromContext(ctx); have != "" {
+		t.Errorf("expected empty tenant, have: %q", have)
+	}
+	ctx = NewContext(ctx, "a")
+	if have, want := FromContext(ctx), "a"; have != want {
+		t.Errorf("have: %q, want: %q", have, want)
+	}
+}
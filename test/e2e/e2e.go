@@ -40,6 +40,7 @@ type TestStorage interface {
 	storage.EnrollmentIDRetriever
 	DDMStorage
 	storage.StatusStorer
+	storage.NotificationTrackingStorage
 }
 
 var emptyDI = &ddm.DeclarationItems{
@@ -181,7 +182,7 @@ func TestE2E(t *testing.T, _ context.Context, storage TestStorage) {
 
 		// attempt deletion of the declaration (should fail after set assoc, above)
 		resp = doReq(mux, "DELETE", "/v1/declarations/"+testID1, nil)
-		expectHTTP(t, resp, 500)
+		expectHTTP(t, resp, 409)
 		expectNotifierSlice(t, n, false, nil)
 
 		// retreive list of declarations (should still be present)
@@ -366,3 +367,41 @@ func TestE2E(t *testing.T, _ context.Context, storage TestStorage) {
 		testStatus(t, mux, n)
 	})
 }
+
+// TestAll runs all end-to-end tests against storage.
+// TestStatusHistory runs against the storage returned by newHistoryStorage
+// which should have status history enabled. It is skipped if
+// newHistoryStorage is nil.
+func TestAll(t *testing.T, ctx context.Context, storage TestStorage, newHistoryStorage func(t *testing.T) TestStorage) {
+	for _, test := range []struct {
+		name string
+		fn   func(*testing.T, context.Context, TestStorage)
+	}{
+		{"TestE2E", TestE2E},
+		{"TestCompliance", TestCompliance},
+		{"TestStatusSearch", TestStatusSearch},
+		{"TestStatusUnhandled", TestStatusUnhandled},
+		{"TestNotificationQueue", TestNotificationQueue},
+		{"TestNotificationTracking", TestNotificationTracking},
+		{"TestDryRun", TestDryRun},
+		{"TestDeclarationCascade", TestDeclarationCascade},
+		{"TestBatch", TestBatch},
+		{"TestState", TestState},
+		{"TestETag", TestETag},
+		{"TestDDMETag", TestDDMETag},
+		{"TestAuthorization", TestAuthorization},
+		{"TestCheckIn", TestCheckIn},
+	} {
+		fn := test.fn
+		t.Run(test.name, func(t *testing.T) {
+			fn(t, ctx, storage)
+		})
+	}
+
+	t.Run("TestStatusHistory", func(t *testing.T) {
+		if newHistoryStorage == nil {
+			t.Skip("no storage with status history")
+		}
+		TestStatusHistory(t, ctx, newHistoryStorage(t))
+	})
+}
@@ -0,0 +1,144 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	"github.com/micromdm/nanolib/log"
+)
+
+// TestAuthorization tests the roles and set prefix scopes of API credentials.
+func TestAuthorization(t *testing.T, _ context.Context, store TestStorage) {
+	n := &captureNotifier{store: store}
+
+	mux := flow.New()
+	api.HandleAPIv1("/v1", mux, log.NopLogger, store, n)
+	h := api.CredentialsHandler(mux, "kmfddm", "kmfddm", []*api.Credential{
+		{Name: "admin", APIKey: "admin-key", Role: api.RoleAdmin},
+		{Name: "reader", APIKey: "reader-key", Role: api.RoleReadOnly},
+		{Name: "status", APIKey: "status-key", Role: api.RoleStatusReader},
+		{Name: "editor", APIKey: "editor-key", Role: api.RoleDeclarationEditor},
+		{Name: "scoped", APIKey: "scoped-key", Role: api.RoleDeclarationEditor, SetPrefixes: []string{"golang_test_auth_team_"}},
+	})
+
+	do := func(key, method, target string, body []byte) *http.Response {
+		req, _ := http.NewRequest(method, target, nil)
+		req.SetBasicAuth("kmfddm", key)
+		return doReqHeader(h, method, target, req.Header, body)
+	}
+
+	const (
+		ownSet     = "golang_test_auth_team_a"
+		otherSet   = "golang_test_auth_other"
+		ownDecl    = "com.example.auth.own"
+		shared     = "com.example.auth.shared"
+		unassigned = "com.example.auth.unassigned"
+		ownEnr     = "golang_test_auth_enrollment_a"
+		otherEnr   = "golang_test_auth_enrollment_b"
+	)
+
+	// authentication
+	expectHTTP(t, doReq(h, "GET", "/v1/declarations", nil), 401)
+	expectHTTP(t, do("wrong-key", "GET", "/v1/declarations", nil), 401)
+
+	// roles
+	expectHTTP(t, do("status-key", "GET", "/v1/declarations", nil), 403)
+	expectHTTP(t, do("status-key", "GET", "/v1/status-errors/golang_test_auth_enrollment", nil), 200)
+	expectHTTP(t, do("reader-key", "GET", "/v1/declarations", nil), 200)
+	expectHTTP(t, do("reader-key", "PUT", "/v1/declarations", testStateDecl(shared, "a")), 403)
+	expectHTTP(t, do("editor-key", "PUT", "/v1/declarations", testStateDecl(shared, "a")), 204)
+	expectHTTP(t, do("editor-key", "PUT", "/v1/set-declarations/"+otherSet+"?declaration="+shared, nil), 204)
+	expectHTTP(t, do("editor-key", "POST", "/v1/notify?set="+otherSet, nil), 403)
+	expectHTTP(t, do("admin-key", "POST", "/v1/notify?set="+otherSet, nil), 204)
+
+	// set prefix scopes
+	create, err := json.Marshal(&api.BatchRequest{Operations: []api.BatchOperation{
+		{Op: api.BatchPutDeclaration, Declaration: testStateDecl(ownDecl, "a")},
+		{Op: api.BatchPutSetDeclaration, Set: ownSet, DeclarationID: ownDecl},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHTTP(t, do("scoped-key", "POST", "/v1/batch", create), 200)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/declarations", testStateDecl(ownDecl, "b")), 204)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/set-declarations/"+otherSet+"?declaration="+ownDecl, nil), 403)
+	expectHTTP(t, do("scoped-key", "POST", "/v1/declarations/"+ownDecl+"/touch", nil), 204)
+
+	// declarations in sets out of scope can't be changed
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/declarations", testStateDecl(shared, "b")), 403)
+	expectHTTP(t, do("scoped-key", "DELETE", "/v1/declarations/"+shared, nil), 403)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/set-declarations/"+ownSet+"?declaration="+shared, nil), 403)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/enrollment-sets/golang_test_auth_enrollment?set="+otherSet, nil), 403)
+	expectHTTP(t, do("scoped-key", "POST", "/v1/notify?set="+ownSet, nil), 403)
+	expectHTTP(t, do("scoped-key", "GET", "/v1/set-declarations/"+otherSet, nil), 200)
+
+	// existing declarations in no sets are in no scope
+	expectHTTP(t, do("admin-key", "PUT", "/v1/declarations", testStateDecl(unassigned, "a")), 204)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/declarations", testStateDecl(unassigned, "b")), 403)
+	expectHTTP(t, do("scoped-key", "DELETE", "/v1/declarations/"+unassigned, nil), 403)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/set-declarations/"+ownSet+"?declaration="+unassigned, nil), 403)
+
+	// enrollments in sets out of scope can't be changed
+	expectHTTP(t, do("admin-key", "PUT", "/v1/enrollment-sets/"+otherEnr+"?set="+otherSet, nil), 204)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/enrollment-sets/"+otherEnr+"?set="+ownSet, nil), 403)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/enrollment-sets/"+ownEnr+"?set="+ownSet, nil), 204)
+
+	batch, err := json.Marshal(&api.BatchRequest{Operations: []api.BatchOperation{
+		{Op: api.BatchPutSetDeclaration, Set: ownSet, DeclarationID: ownDecl},
+		{Op: api.BatchTouchDeclaration, DeclarationID: shared},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHTTP(t, do("scoped-key", "POST", "/v1/batch", batch), 403)
+
+	enrBatch, err := json.Marshal(&api.BatchRequest{Operations: []api.BatchOperation{
+		{Op: api.BatchPutEnrollmentSet, Set: ownSet, EnrollmentID: otherEnr},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHTTP(t, do("scoped-key", "POST", "/v1/batch", enrBatch), 403)
+
+	// trailing data must not bypass scopes
+	expectHTTP(t, do("scoped-key", "POST", "/v1/batch", append(batch, []byte(" x")...)), 400)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/declarations", []byte("{")), 400)
+
+	state, err := json.Marshal(&api.State{
+		Declarations: []json.RawMessage{testStateDecl(ownDecl, "a")},
+		Sets:         map[string][]string{ownSet: {ownDecl}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/state", state), 403)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/state?prefix=golang_test_auth_", state), 403)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/state?prefix=golang_test_auth_team_&plan=1", state), 200)
+	expectHTTP(t, do("scoped-key", "PUT", "/v1/state?prefix=golang_test_auth_team_&plan=1", append(state, []byte(" x")...)), 400)
+	expectHTTP(t, do("admin-key", "POST", "/v1/batch", append(batch, []byte(" x")...)), 400)
+
+	// bodies over the limit are rejected before checking scopes
+	limited := http.MaxBytesHandler(h, 16)
+	doLimited := func(key, method, target string, body []byte) *http.Response {
+		req, _ := http.NewRequest(method, target, nil)
+		req.SetBasicAuth("kmfddm", key)
+		return doReqHeader(limited, method, target, req.Header, body)
+	}
+	expectHTTP(t, doLimited("scoped-key", "POST", "/v1/batch", batch), 413)
+	expectHTTP(t, doLimited("scoped-key", "PUT", "/v1/declarations", testStateDecl(ownDecl, "b")), 413)
+	expectHTTP(t, doLimited("scoped-key", "PUT", "/v1/state?prefix=golang_test_auth_team_&plan=1", state), 413)
+	expectHTTP(t, doLimited("admin-key", "POST", "/v1/batch", batch), 413)
+	expectHTTP(t, doLimited("admin-key", "PUT", "/v1/declarations", testStateDecl(ownDecl, "b")), 413)
+
+	// cleanup
+	expectHTTP(t, do("scoped-key", "DELETE", "/v1/enrollment-sets/"+ownEnr+"?set="+ownSet, nil), 204)
+	expectHTTP(t, do("scoped-key", "DELETE", "/v1/declarations/"+ownDecl+"?cascade=1", nil), 204)
+	expectHTTP(t, do("admin-key", "DELETE", "/v1/enrollment-sets/"+otherEnr+"?set="+otherSet, nil), 204)
+	expectHTTP(t, do("admin-key", "DELETE", "/v1/declarations/"+unassigned, nil), 204)
+	expectHTTP(t, do("admin-key", "DELETE", "/v1/set-declarations/"+otherSet+"?declaration="+shared, nil), 204)
+	expectHTTP(t, do("admin-key", "DELETE", "/v1/declarations/"+shared, nil), 204)
+}
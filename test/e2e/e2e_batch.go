@@ -0,0 +1,144 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	"github.com/micromdm/nanolib/log"
+)
+
+const testBatchDecl = `{
+	"Type": "com.apple.configuration.management.test",
+	"Payload": {
+		"Echo": "batch"
+	},
+	"Identifier": "com.example.batch"
+}`
+
+func decodeBatchResponse(t *testing.T, resp interface{ Decode(interface{}) error }) *api.BatchResponse {
+	t.Helper()
+	r := new(api.BatchResponse)
+	if err := resp.Decode(r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func unique(s []string) (r []string) {
+	seen := make(map[string]bool)
+	for _, v := range s {
+		if !seen[v] {
+			seen[v] = true
+			r = append(r, v)
+		}
+	}
+	return
+}
+
+// TestBatch tests applying batches of operations.
+func TestBatch(t *testing.T, _ context.Context, store TestStorage) {
+	n := &captureNotifier{store: store}
+
+	mux := flow.New()
+	api.HandleAPIv1("/v1", mux, log.NopLogger, store, n)
+
+	const (
+		enrollmentID = "golang_test_enr_BA52"
+		set1         = "golang_test_set_BA50"
+		set2         = "golang_test_set_BA51"
+	)
+
+	body, err := json.Marshal(&api.BatchRequest{Operations: []api.BatchOperation{
+		{Op: api.BatchPutDeclaration, Declaration: json.RawMessage(testBatchDecl)},
+		{Op: api.BatchPutSetDeclaration, Set: set1, DeclarationID: "com.example.batch"},
+		{Op: api.BatchPutEnrollmentSet, EnrollmentID: enrollmentID, Set: set1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := doReq(mux, "POST", "/v1/batch", body)
+	expectHTTP(t, resp, 200)
+	r := decodeBatchResponse(t, json.NewDecoder(resp.Body))
+	if !r.Applied || !r.Notified || len(r.Results) != 3 {
+		t.Fatalf("batch response: %+v", r)
+	}
+	for i, result := range r.Results {
+		if !result.Changed || result.Error != "" {
+			t.Errorf("result %d: %+v", i, result)
+		}
+	}
+	// the enrollment may be resolved from more than one change
+	// but is notified in a single call
+	if have, want := unique(n.getAndClear()), []string{enrollmentID}; !stringSlicesEqual(have, want) {
+		t.Errorf("notified: have: %v, want: %v", have, want)
+	}
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/set-declarations/"+set1, nil), 200, []string{"com.example.batch"})
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/enrollment-sets/"+enrollmentID, nil), 200, []string{set1})
+
+	// invalid operations are not applied
+	body, err = json.Marshal(&api.BatchRequest{Operations: []api.BatchOperation{
+		{Op: api.BatchPutSetDeclaration, Set: set2, DeclarationID: "com.example.batch"},
+		{Op: "invalid"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = doReq(mux, "POST", "/v1/batch", body)
+	expectHTTP(t, resp, 400)
+	r = decodeBatchResponse(t, json.NewDecoder(resp.Body))
+	if r.Applied || r.Results[0].Error != "" || r.Results[1].Error == "" {
+		t.Errorf("batch response: %+v", r)
+	}
+	if n.called {
+		t.Error("notified")
+	}
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/set-declarations/"+set2, nil), 200, nil)
+
+	// a failed operation fails the batch
+	body, err = json.Marshal(&api.BatchRequest{Operations: []api.BatchOperation{
+		{Op: api.BatchPutSetDeclaration, Set: set2, DeclarationID: "com.example.batch"},
+		{Op: api.BatchDeleteDeclaration, DeclarationID: "com.example.batch"},
+		{Op: api.BatchTouchDeclaration, DeclarationID: "com.example.batch"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = doReq(mux, "POST", "/v1/batch", body)
+	expectHTTP(t, resp, 409)
+	r = decodeBatchResponse(t, json.NewDecoder(resp.Body))
+	if r.Results[1].Error == "" || !r.Results[2].Skipped {
+		t.Errorf("batch response: %+v", r)
+	}
+	if r.Atomic {
+		if r.Applied || n.called {
+			t.Errorf("atomic batch applied: %+v", r)
+		}
+		expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/set-declarations/"+set2, nil), 200, nil)
+	} else {
+		if !r.Applied {
+			t.Errorf("non-atomic batch not applied: %+v", r)
+		}
+		expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/set-declarations/"+set2, nil), 200, []string{"com.example.batch"})
+	}
+	n.getAndClear()
+
+	// cleanup
+	body, err = json.Marshal(&api.BatchRequest{Operations: []api.BatchOperation{
+		{Op: api.BatchDeleteDeclaration, DeclarationID: "com.example.batch", Cascade: true},
+		{Op: api.BatchDeleteAllEnrollmentSets, EnrollmentID: enrollmentID},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = doReq(mux, "POST", "/v1/batch", body)
+	expectHTTP(t, resp, 200)
+	r = decodeBatchResponse(t, json.NewDecoder(resp.Body))
+	if !r.Results[0].Changed || !r.Results[1].Changed {
+		t.Errorf("batch response: %+v", r)
+	}
+	expectHTTP(t, doReq(mux, "GET", "/v1/declarations/com.example.batch", nil), 404)
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/enrollment-sets/"+enrollmentID, nil), 200, nil)
+}
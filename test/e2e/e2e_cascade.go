@@ -0,0 +1,78 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	"github.com/micromdm/nanolib/log"
+)
+
+const testCascadeDecl = `{
+	"Type": "com.apple.configuration.management.test",
+	"Payload": {
+		"Echo": "cascade"
+	},
+	"Identifier": "com.example.cascade"
+}`
+
+// TestDeclarationCascade tests deleting declarations that are in sets.
+func TestDeclarationCascade(t *testing.T, _ context.Context, store TestStorage) {
+	n := &captureNotifier{store: store}
+
+	mux := flow.New()
+	api.HandleAPIv1("/v1", mux, log.NopLogger, store, n)
+
+	const (
+		enrollmentID = "golang_test_enr_CA52"
+		set1         = "golang_test_set_CA50"
+		set2         = "golang_test_set_CA51"
+	)
+
+	expectHTTP(t, doReq(mux, "PUT", "/v1/declarations", []byte(testCascadeDecl)), 204)
+	expectHTTP(t, doReq(mux, "PUT", "/v1/set-declarations/"+set1+"?declaration=com.example.cascade", nil), 204)
+	expectHTTP(t, doReq(mux, "PUT", "/v1/set-declarations/"+set2+"?declaration=com.example.cascade", nil), 204)
+	expectHTTP(t, doReq(mux, "PUT", "/v1/enrollment-sets/"+enrollmentID+"?set="+set1, nil), 204)
+	n.getAndClear()
+
+	// deleting without cascade lists the blocking sets
+	resp := doReq(mux, "DELETE", "/v1/declarations/com.example.cascade", nil)
+	expectHTTP(t, resp, 409)
+	var inUse struct {
+		Err  string   `json:"error"`
+		Sets []string `json:"sets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inUse); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(inUse.Sets)
+	if have, want := inUse.Sets, []string{set1, set2}; !stringSlicesEqual(have, want) {
+		t.Errorf("sets: have: %v, want: %v", have, want)
+	}
+	if inUse.Err == "" {
+		t.Error("empty error")
+	}
+	if n.called {
+		t.Error("notified")
+	}
+	expectHTTP(t, doReq(mux, "GET", "/v1/declarations/com.example.cascade", nil), 200)
+
+	// deleting with cascade removes the declaration from its sets and notifies
+	expectHTTP(t, doReq(mux, "DELETE", "/v1/declarations/com.example.cascade?cascade=1", nil), 204)
+	if have, want := n.getAndClear(), []string{enrollmentID}; !stringSlicesEqual(have, want) {
+		t.Errorf("notified: have: %v, want: %v", have, want)
+	}
+	expectHTTP(t, doReq(mux, "GET", "/v1/declarations/com.example.cascade", nil), 404)
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/declaration-sets/com.example.cascade", nil), 200, nil)
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/set-declarations/"+set1, nil), 200, nil)
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/set-declarations/"+set2, nil), 200, nil)
+
+	// deleting again is unchanged and does not notify
+	expectHTTP(t, doReq(mux, "DELETE", "/v1/declarations/com.example.cascade?cascade=1", nil), 304)
+	if n.called {
+		t.Error("notified")
+	}
+}
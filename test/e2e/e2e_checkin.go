@@ -0,0 +1,109 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/http/api"
+	ddmhttp "github.com/jessepeterson/kmfddm/http/ddm"
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/plist"
+)
+
+// TestCheckIn tests handling DeclarativeManagement check-ins directly.
+func TestCheckIn(t *testing.T, _ context.Context, store TestStorage) {
+	n := &captureNotifier{store: store}
+
+	mux := flow.New()
+	api.HandleAPIv1("/v1", mux, log.NopLogger, store, n)
+	handleDDM(mux, log.NopLogger, store)
+	mux.Handle("/checkin", ddmhttp.CheckInHandler(mux, log.NopLogger), "PUT", "POST")
+
+	const (
+		declarationID = "com.example.ddm.checkin"
+		udid          = "golang_test_enr_C4E1"
+		set           = "golang_test_set_C4E0"
+	)
+
+	expectHTTP(t, doReq(mux, "PUT", "/v1/declarations", testStateDecl(declarationID, "a")), 204)
+	expectHTTP(t, doReq(mux, "PUT", "/v1/set-declarations/"+set+"?declaration="+declarationID, nil), 204)
+	expectHTTP(t, doReq(mux, "PUT", "/v1/enrollment-sets/"+udid+"?set="+set, nil), 204)
+
+	checkIn := func(endpoint string, data []byte) []byte {
+		t.Helper()
+		b, err := plist.Marshal(&ddmhttp.CheckIn{
+			MessageType: "DeclarativeManagement",
+			UDID:        udid,
+			Endpoint:    endpoint,
+			Data:        data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	body := func(t *testing.T, checkInBody []byte) []byte {
+		t.Helper()
+		resp := doReq(mux, "PUT", "/checkin", checkInBody)
+		expectHTTP(t, resp, 200)
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// raw check-in plist
+	var tokens ddm.TokensResponse
+	if err := json.Unmarshal(body(t, checkIn("tokens", nil)), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	if tokens.SyncTokens.DeclarationsToken == "" {
+		t.Error("empty declarations token")
+	}
+
+	// NanoMDM-style webhook event
+	event, err := json.Marshal(map[string]interface{}{
+		"topic": "mdm.DeclarativeManagement",
+		"checkin_event": map[string]interface{}{
+			"udid":        udid,
+			"raw_payload": checkIn("declaration-items", nil),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var di ddm.DeclarationItems
+	if err = json.Unmarshal(body(t, event), &di); err != nil {
+		t.Fatal(err)
+	}
+	if di.DeclarationsToken != tokens.SyncTokens.DeclarationsToken {
+		t.Errorf("declarations token: have: %s, want: %s", di.DeclarationsToken, tokens.SyncTokens.DeclarationsToken)
+	}
+
+	if b := body(t, checkIn("declaration/configuration/"+declarationID, nil)); !strings.Contains(string(b), declarationID) {
+		t.Errorf("declaration not in response: %s", b)
+	}
+
+	status := []byte(`{"StatusItems":{"management":{"declarations":{"configurations":[{"active":true,"identifier":"` + declarationID + `","server-token":"x","valid":"valid"}]}}},"Errors":[]}`)
+	body(t, checkIn("status", status))
+	resp := doReq(mux, "GET", "/v1/declaration-status/"+udid, nil)
+	expectHTTP(t, resp, 200)
+	if b, _ := io.ReadAll(resp.Body); !strings.Contains(string(b), declarationID) {
+		t.Errorf("declaration not in status: %s", b)
+	}
+
+	// invalid check-ins
+	expectHTTP(t, doReq(mux, "PUT", "/checkin", checkIn("unknown", nil)), 400)
+	expectHTTP(t, doReq(mux, "PUT", "/checkin", checkIn("status", nil)), 400)
+	expectHTTP(t, doReq(mux, "PUT", "/checkin", []byte(`{"topic":"mdm.Connect"}`)), 400)
+
+	// cleanup
+	expectHTTP(t, doReq(mux, "DELETE", "/v1/enrollment-sets/"+udid+"?set="+set, nil), 204)
+	expectHTTP(t, doReq(mux, "DELETE", "/v1/declarations/"+declarationID+"?cascade=1", nil), 204)
+}
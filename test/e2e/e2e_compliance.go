@@ -0,0 +1,122 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	httpddm "github.com/jessepeterson/kmfddm/http/ddm"
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/micromdm/nanolib/http/trace"
+	"github.com/micromdm/nanolib/log"
+)
+
+const testComplianceDecl = `{
+	"Type": "com.apple.configuration.management.test",
+	"Payload": {
+		"Echo": "compliance"
+	},
+	"Identifier": "com.example.compliance"
+}`
+
+func complianceStatusReport(valid, serverToken string) []byte {
+	return []byte(`{
+  "StatusItems" : {
+    "management" : {
+      "declarations" : {
+        "activations" : [],
+        "configurations" : [
+          {
+            "active" : true,
+            "identifier" : "com.example.compliance",
+            "valid" : "` + valid + `",
+            "server-token" : "` + serverToken + `"
+          }
+        ],
+        "assets" : [],
+        "management" : []
+      }
+    }
+  },
+  "Errors" : []
+}`)
+}
+
+// TestCompliance tests the aggregation of declaration status across enrollments.
+func TestCompliance(t *testing.T, _ context.Context, store TestStorage) {
+	flowMux := flow.New()
+	logger := log.NopLogger
+	n := &captureNotifier{store: store}
+	api.HandleAPIv1("/v1", flowMux, logger, store, n)
+	handleDDM(flowMux, logger, store)
+
+	var mux http.Handler = flowMux
+	mux = trace.NewTraceLoggingHandler(mux, logger.With("handler", "log"), func(*http.Request) string { return "go_test_trace_id" })
+
+	resp := doReq(mux, "GET", "/v1/declarations/com.example.compliance/compliance", nil)
+	expectHTTP(t, resp, 404)
+
+	resp = doReq(mux, "PUT", "/v1/declarations", []byte(testComplianceDecl))
+	expectHTTP(t, resp, 204)
+
+	resp = doReq(mux, "PUT", "/v1/set-declarations/golang_test_set_C0E1A3B2?declaration=com.example.compliance", nil)
+	expectHTTP(t, resp, 204)
+
+	const (
+		enrValid   = "golang_test_enr_C0A1"
+		enrInvalid = "golang_test_enr_C0A2"
+		enrStale   = "golang_test_enr_C0A3"
+		enrNone    = "golang_test_enr_C0A4"
+	)
+	for _, id := range []string{enrValid, enrInvalid, enrStale, enrNone} {
+		resp = doReq(mux, "PUT", "/v1/enrollment-sets/"+id+"?set=golang_test_set_C0E1A3B2", nil)
+		expectHTTP(t, resp, 204)
+	}
+
+	resp = doReq(mux, "GET", "/v1/declarations/com.example.compliance", nil)
+	expectHTTP(t, resp, 200)
+	d := &TestDeclaration{}
+	if err := json.NewDecoder(resp.Body).Decode(d); err != nil {
+		t.Fatal(err)
+	}
+
+	enrHdr := make(http.Header)
+	for id, report := range map[string][]byte{
+		enrValid:   complianceStatusReport("valid", d.ServerToken),
+		enrInvalid: complianceStatusReport("invalid", d.ServerToken),
+		enrStale:   complianceStatusReport("unknown", "stale_token"),
+	} {
+		enrHdr.Set(httpddm.EnrollmentIDHeader, id)
+		resp = doReqHeader(mux, "PUT", "/status", enrHdr, report)
+		expectHTTP(t, resp, 200)
+	}
+
+	resp = doReq(mux, "GET", "/v1/declarations/com.example.compliance/compliance", nil)
+	expectHTTP(t, resp, 200)
+	c := new(storage.DeclarationCompliance)
+	if err := json.NewDecoder(resp.Body).Decode(c); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(ids ...string) storage.ComplianceCount {
+		return storage.ComplianceCount{Count: len(ids), EnrollmentIDs: ids}
+	}
+	want := &storage.DeclarationCompliance{
+		Identifier:  "com.example.compliance",
+		ServerToken: d.ServerToken,
+		Enrollments: 4,
+		Active:      ids(enrValid, enrInvalid, enrStale),
+		Valid:       ids(enrValid),
+		Invalid:     ids(enrInvalid),
+		Unknown:     ids(enrStale),
+		Stale:       ids(enrStale),
+		NoStatus:    ids(enrNone),
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("have: %+v, want: %+v", c, want)
+	}
+}
@@ -0,0 +1,59 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	"github.com/micromdm/nanolib/log"
+)
+
+// TestDDMETag tests conditional requests on the DDM endpoints.
+func TestDDMETag(t *testing.T, _ context.Context, store TestStorage) {
+	n := &captureNotifier{store: store}
+
+	mux := flow.New()
+	api.HandleAPIv1("/v1", mux, log.NopLogger, store, n)
+	handleDDM(mux, log.NopLogger, store)
+
+	const (
+		declarationID = "com.example.ddm.etag"
+		enrollmentID  = "golang_test_enr_DE52"
+		set           = "golang_test_set_DE50"
+	)
+	enrHdr := http.Header{"X-Enrollment-ID": {enrollmentID}}
+	ifNoneMatch := func(etag string) http.Header {
+		return http.Header{"X-Enrollment-ID": {enrollmentID}, "If-None-Match": {etag}}
+	}
+
+	expectHTTP(t, doReq(mux, "PUT", "/v1/declarations", testStateDecl(declarationID, "a")), 204)
+	expectHTTP(t, doReq(mux, "PUT", "/v1/set-declarations/"+set+"?declaration="+declarationID, nil), 204)
+	expectHTTP(t, doReq(mux, "PUT", "/v1/enrollment-sets/"+enrollmentID+"?set="+set, nil), 204)
+
+	etags := make(map[string]string)
+	for _, path := range []string{"/tokens", "/declaration-items", "/declaration/configuration/" + declarationID} {
+		resp := doReqHeader(mux, "GET", path, enrHdr, nil)
+		expectHTTP(t, resp, 200)
+		etags[path] = resp.Header.Get("ETag")
+		if etags[path] == "" {
+			t.Fatalf("%s: empty ETag", path)
+		}
+		expectHTTP(t, doReqHeader(mux, "GET", path, ifNoneMatch(etags[path]), nil), 304)
+	}
+
+	// matching any ETag does not hide missing declarations
+	expectHTTP(t, doReqHeader(mux, "GET", "/declaration/configuration/com.example.ddm.etag.missing", ifNoneMatch("*"), nil), 404)
+	expectHTTP(t, doReqHeader(mux, "GET", "/declaration/activation/"+declarationID, ifNoneMatch("*"), nil), 404)
+
+	// changing the declaration changes the ETags
+	expectHTTP(t, doReq(mux, "PUT", "/v1/declarations", testStateDecl(declarationID, "b")), 204)
+	for path, etag := range etags {
+		expectHTTP(t, doReqHeader(mux, "GET", path, ifNoneMatch(etag), nil), 200)
+	}
+
+	// cleanup
+	expectHTTP(t, doReq(mux, "DELETE", "/v1/enrollment-sets/"+enrollmentID+"?set="+set, nil), 204)
+	expectHTTP(t, doReq(mux, "DELETE", "/v1/declarations/"+declarationID+"?cascade=1", nil), 204)
+}
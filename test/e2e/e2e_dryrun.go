@@ -0,0 +1,130 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	"github.com/micromdm/nanolib/log"
+)
+
+const testDryRunDecl = `{
+	"Type": "com.apple.configuration.management.test",
+	"Payload": {
+		"Echo": "dryrun"
+	},
+	"Identifier": "com.example.dryrun"
+}`
+
+const testDryRunDeclChanged = `{
+	"Type": "com.apple.configuration.management.test",
+	"Payload": {
+		"Echo": "dryrun changed"
+	},
+	"Identifier": "com.example.dryrun"
+}`
+
+func decodeDryRun(t *testing.T, resp *http.Response) *api.DryRun {
+	t.Helper()
+	expectHTTP(t, resp, 200)
+	r := new(api.DryRun)
+	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// TestDryRun tests simulating changes with the dryrun query parameter.
+func TestDryRun(t *testing.T, _ context.Context, store TestStorage) {
+	n := &captureNotifier{store: store}
+
+	mux := flow.New()
+	api.HandleAPIv1("/v1", mux, log.NopLogger, store, n)
+
+	const (
+		enrollmentID = "golang_test_enr_D7A1"
+		setName      = "golang_test_set_D7A0"
+	)
+
+	expectNotCalled := func(t *testing.T) {
+		t.Helper()
+		if n.called {
+			t.Error("notifier called during dry run")
+		}
+		n.getAndClear()
+	}
+
+	// a new declaration would change but affects no enrollments yet
+	r := decodeDryRun(t, doReq(mux, "PUT", "/v1/declarations?dryrun=1", []byte(testDryRunDecl)))
+	expectNotCalled(t)
+	if !r.Changed || !r.Notify || len(r.EnrollmentIDs) != 0 {
+		t.Errorf("new declaration: have: %+v", r)
+	}
+	expectHTTP(t, doReq(mux, "GET", "/v1/declarations/com.example.dryrun", nil), 404)
+
+	expectHTTP(t, doReq(mux, "PUT", "/v1/declarations", []byte(testDryRunDecl)), 204)
+	expectHTTP(t, doReq(mux, "PUT", "/v1/set-declarations/"+setName+"?declaration=com.example.dryrun", nil), 204)
+	n.getAndClear()
+
+	// associating the set would add the declaration
+	r = decodeDryRun(t, doReq(mux, "PUT", "/v1/enrollment-sets/"+enrollmentID+"?set="+setName+"&dryrun=1", nil))
+	expectNotCalled(t)
+	if !r.Changed || !stringSlicesEqual([]string{enrollmentID}, r.EnrollmentIDs) {
+		t.Errorf("enrollment set: have: %+v", r)
+	}
+	if c := r.Changes[enrollmentID]; len(c) != 1 || c[0].Identifier != "com.example.dryrun" || c[0].Change != api.DeclarationAdded || c[0].After == "" {
+		t.Errorf("enrollment set changes: have: %+v", r.Changes)
+	}
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/enrollment-sets/"+enrollmentID, nil), 200, []string{})
+
+	expectHTTP(t, doReq(mux, "PUT", "/v1/enrollment-sets/"+enrollmentID+"?set="+setName, nil), 204)
+	n.getAndClear()
+
+	// re-associating is unchanged
+	r = decodeDryRun(t, doReq(mux, "PUT", "/v1/enrollment-sets/"+enrollmentID+"?set="+setName+"&dryrun=1", nil))
+	if r.Changed || r.Notify || len(r.Changes) != 0 {
+		t.Errorf("enrollment set unchanged: have: %+v", r)
+	}
+
+	// an unchanged declaration
+	r = decodeDryRun(t, doReq(mux, "PUT", "/v1/declarations?dryrun=1", []byte(testDryRunDecl)))
+	if r.Changed || r.Notify || !stringSlicesEqual([]string{enrollmentID}, r.EnrollmentIDs) {
+		t.Errorf("unchanged declaration: have: %+v", r)
+	}
+
+	// a changed declaration, without notifying
+	r = decodeDryRun(t, doReq(mux, "PUT", "/v1/declarations?dryrun=1&nonotify=1", []byte(testDryRunDeclChanged)))
+	expectNotCalled(t)
+	if !r.Changed || r.Notify {
+		t.Errorf("changed declaration: have: %+v", r)
+	}
+	if c := r.Changes[enrollmentID]; len(c) != 1 || c[0].Change != api.DeclarationChanged || c[0].Before == "" {
+		t.Errorf("changed declaration changes: have: %+v", r.Changes)
+	}
+
+	// removing the declaration from the set would remove it from the enrollment
+	r = decodeDryRun(t, doReq(mux, "DELETE", "/v1/set-declarations/"+setName+"?declaration=com.example.dryrun&dryrun=1", nil))
+	expectNotCalled(t)
+	if c := r.Changes[enrollmentID]; !r.Changed || len(c) != 1 || c[0].Change != api.DeclarationRemoved {
+		t.Errorf("set declaration removal: have: %+v", r)
+	}
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/set-declarations/"+setName, nil), 200, []string{"com.example.dryrun"})
+
+	// removing all sets
+	r = decodeDryRun(t, doReq(mux, "DELETE", "/v1/enrollment-sets-all/sets/"+enrollmentID+"?dryrun=1", nil))
+	expectNotCalled(t)
+	if c := r.Changes[enrollmentID]; !r.Changed || len(c) != 1 || c[0].Change != api.DeclarationRemoved {
+		t.Errorf("all enrollment sets removal: have: %+v", r)
+	}
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/enrollment-sets/"+enrollmentID, nil), 200, []string{setName})
+
+	// notify resolves the enrollment IDs only
+	r = decodeDryRun(t, doReq(mux, "POST", "/v1/notify?set="+setName+"&id="+enrollmentID+"&dryrun=1", nil))
+	expectNotCalled(t)
+	if !r.Notify || !stringSlicesEqual([]string{enrollmentID}, r.EnrollmentIDs) {
+		t.Errorf("notify: have: %+v", r)
+	}
+}
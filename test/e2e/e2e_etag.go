@@ -0,0 +1,67 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	"github.com/micromdm/nanolib/log"
+)
+
+// TestETag tests conditional requests on declarations and sets.
+func TestETag(t *testing.T, _ context.Context, store TestStorage) {
+	n := &captureNotifier{store: store}
+
+	mux := flow.New()
+	api.HandleAPIv1("/v1", mux, log.NopLogger, store, n)
+
+	const (
+		declarationID = "com.example.etag"
+		set           = "golang_test_set_E7A0"
+	)
+	decl := func(echo string) []byte {
+		return []byte(testStateDecl(declarationID, echo))
+	}
+
+	// If-None-Match: * only creates
+	expectHTTP(t, doReqHeader(mux, "PUT", "/v1/declarations", http.Header{"If-None-Match": {"*"}}, decl("a")), 204)
+	expectHTTP(t, doReqHeader(mux, "PUT", "/v1/declarations", http.Header{"If-None-Match": {"*"}}, decl("b")), 412)
+
+	resp := doReq(mux, "GET", "/v1/declarations/"+declarationID, nil)
+	expectHTTP(t, resp, 200)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("empty ETag")
+	}
+	expectHTTP(t, doReqHeader(mux, "GET", "/v1/declarations/"+declarationID, http.Header{"If-None-Match": {etag}}, nil), 304)
+
+	// a stale ETag conflicts
+	expectHTTP(t, doReqHeader(mux, "PUT", "/v1/declarations", http.Header{"If-Match": {etag}}, decl("b")), 204)
+	expectHTTP(t, doReqHeader(mux, "PUT", "/v1/declarations", http.Header{"If-Match": {etag}}, decl("c")), 412)
+	expectHTTP(t, doReqHeader(mux, "DELETE", "/v1/declarations/"+declarationID, http.Header{"If-Match": {etag}}, nil), 412)
+
+	resp = doReq(mux, "GET", "/v1/declarations/"+declarationID, nil)
+	expectHTTP(t, resp, 200)
+	if resp.Header.Get("ETag") == etag {
+		t.Error("ETag unchanged")
+	}
+	etag = resp.Header.Get("ETag")
+
+	// sets
+	expectHTTP(t, doReqHeader(mux, "PUT", "/v1/set-declarations/"+set+"?declaration="+declarationID, http.Header{"If-Match": {"*"}}, nil), 412)
+	expectHTTP(t, doReqHeader(mux, "PUT", "/v1/set-declarations/"+set+"?declaration="+declarationID, http.Header{"If-None-Match": {"*"}}, nil), 204)
+	resp = doReq(mux, "GET", "/v1/set-declarations/"+set, nil)
+	expectHTTP(t, resp, 200)
+	setETag := resp.Header.Get("ETag")
+	if setETag == "" {
+		t.Fatal("empty set ETag")
+	}
+	expectHTTP(t, doReqHeader(mux, "GET", "/v1/set-declarations/"+set, http.Header{"If-None-Match": {setETag}}, nil), 304)
+	expectHTTP(t, doReqHeader(mux, "DELETE", "/v1/set-declarations/"+set+"?declaration="+declarationID, http.Header{"If-Match": {`"stale"`}}, nil), 412)
+	expectHTTP(t, doReqHeader(mux, "DELETE", "/v1/set-declarations/"+set+"?declaration="+declarationID, http.Header{"If-Match": {setETag}}, nil), 204)
+
+	// cleanup
+	expectHTTP(t, doReqHeader(mux, "DELETE", "/v1/declarations/"+declarationID, http.Header{"If-Match": {etag}}, nil), 204)
+}
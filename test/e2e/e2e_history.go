@@ -0,0 +1,180 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	httpddm "github.com/jessepeterson/kmfddm/http/ddm"
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/micromdm/nanolib/http/trace"
+	"github.com/micromdm/nanolib/log"
+)
+
+const testHistoryEnrollmentID = "golang_test_enr_2F1C6B09A8D4"
+
+func historyStatusReport(version string) []byte {
+	return []byte(`{
+  "StatusItems" : {
+    "device" : {
+      "operating-system" : {
+        "family" : "macOS",
+        "version" : "` + version + `"
+      }
+    }
+  },
+  "Errors" : []
+}`)
+}
+
+func historyDeclarationStatusReport(valid string) []byte {
+	return []byte(`{
+  "StatusItems" : {
+    "management" : {
+      "declarations" : {
+        "activations" : [],
+        "configurations" : [
+          {
+            "active" : true,
+            "identifier" : "com.example.history",
+            "valid" : "` + valid + `",
+            "server-token" : "2b1c0b9b6f2a8e41"
+          }
+        ],
+        "assets" : [],
+        "management" : []
+      }
+    }
+  },
+  "Errors" : []
+}`)
+}
+
+// TestStatusHistory tests the recording of status value and declaration status changes.
+// The storage backend must have status value history enabled with
+// unlimited retention.
+func TestStatusHistory(t *testing.T, _ context.Context, store TestStorage) {
+	flowMux := flow.New()
+	logger := log.NopLogger
+	api.HandleAPIv1("/v1", flowMux, logger, store, &captureNotifier{store: store})
+	handleDDM(flowMux, logger, store)
+
+	var mux http.Handler = flowMux
+	mux = trace.NewTraceLoggingHandler(mux, logger.With("handler", "log"), func(*http.Request) string { return "go_test_trace_id" })
+
+	enrHdr := make(http.Header)
+	enrHdr.Set(httpddm.EnrollmentIDHeader, testHistoryEnrollmentID)
+
+	for _, version := range []string{"14.3", "14.3", "14.4"} {
+		resp := doReqHeader(mux, "PUT", "/status", enrHdr, historyStatusReport(version))
+		expectHTTP(t, resp, 200)
+	}
+
+	const versionPath = ".StatusItems.device.operating-system.version"
+
+	t.Run("path", func(t *testing.T) {
+		resp := doReq(mux, "GET", "/v1/status-history/"+testHistoryEnrollmentID+"?path="+versionPath, nil)
+		expectHTTP(t, resp, 200)
+
+		want := map[string][]statusValueChange{
+			testHistoryEnrollmentID: {
+				{Path: versionPath, NewValue: "14.3"},
+				{Path: versionPath, OldValue: "14.3", NewValue: "14.4"},
+			},
+		}
+
+		if have := decodeStatusValueChanges(t, resp); !reflect.DeepEqual(have, want) {
+			t.Errorf("have: %v, want: %v", have, want)
+		}
+	})
+
+	t.Run("all", func(t *testing.T) {
+		resp := doReq(mux, "GET", "/v1/status-history/"+testHistoryEnrollmentID, nil)
+		expectHTTP(t, resp, 200)
+
+		// the family path should only have been recorded once
+		if have, want := len(decodeStatusValueChanges(t, resp)[testHistoryEnrollmentID]), 3; have != want {
+			t.Errorf("changes: have: %v, want: %v", have, want)
+		}
+	})
+
+	for _, valid := range []string{"unknown", "valid", "valid", "invalid", "valid", "invalid"} {
+		resp := doReqHeader(mux, "PUT", "/status", enrHdr, historyDeclarationStatusReport(valid))
+		expectHTTP(t, resp, 200)
+	}
+
+	t.Run("declaration-status", func(t *testing.T) {
+		resp := doReq(mux, "GET", "/v1/declaration-status-history/"+testHistoryEnrollmentID+"?declaration=com.example.history", nil)
+		expectHTTP(t, resp, 200)
+
+		events := make(map[string][]storage.DeclarationStatusEvent)
+		if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+			t.Fatal(err)
+		}
+
+		var have []string
+		for _, e := range events[testHistoryEnrollmentID] {
+			if e.Timestamp.IsZero() {
+				t.Errorf("invalid timestamp: %v", e.Timestamp)
+			}
+			have = append(have, e.Valid)
+		}
+
+		// the repeated "valid" should not have been recorded
+		if want := []string{"unknown", "valid", "invalid", "valid", "invalid"}; !reflect.DeepEqual(have, want) {
+			t.Errorf("have: %v, want: %v", have, want)
+		}
+	})
+
+	t.Run("declaration-status-flapping", func(t *testing.T) {
+		resp := doReq(mux, "GET", "/v1/declaration-status-flapping/"+testHistoryEnrollmentID, nil)
+		expectHTTP(t, resp, 200)
+
+		flaps := make(map[string][]storage.DeclarationStatusFlaps)
+		if err := json.NewDecoder(resp.Body).Decode(&flaps); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string][]storage.DeclarationStatusFlaps{
+			testHistoryEnrollmentID: {{Identifier: "com.example.history", Flips: 3, Flapping: true}},
+		}
+		if !reflect.DeepEqual(flaps, want) {
+			t.Errorf("have: %v, want: %v", flaps, want)
+		}
+	})
+}
+
+// statusValueChange is a status value change with transient fields removed.
+type statusValueChange struct {
+	Path     string
+	OldValue string
+	NewValue string
+}
+
+func decodeStatusValueChanges(t *testing.T, resp *http.Response) map[string][]statusValueChange {
+	t.Helper()
+
+	changes := make(map[string][]storage.StatusValueChange)
+	if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+		t.Fatal(err)
+	}
+
+	r := make(map[string][]statusValueChange)
+	for id, idChanges := range changes {
+		for _, c := range idChanges {
+			if c.Timestamp.IsZero() {
+				t.Errorf("invalid timestamp: %v", c.Timestamp)
+			}
+			r[id] = append(r[id], statusValueChange{
+				Path:     c.Path,
+				OldValue: c.OldValue,
+				NewValue: c.NewValue,
+			})
+		}
+	}
+	return r
+}
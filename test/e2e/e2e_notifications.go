@@ -0,0 +1,170 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	"github.com/jessepeterson/kmfddm/notifier/queue"
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/micromdm/nanolib/http/trace"
+	"github.com/micromdm/nanolib/log"
+)
+
+type failingNotifier struct {
+	captureNotifier
+	err error
+}
+
+func (n *failingNotifier) Changed(ctx context.Context, declarations []string, sets []string, ids []string) error {
+	if n.err != nil {
+		return n.err
+	}
+	return n.captureNotifier.Changed(ctx, declarations, sets, ids)
+}
+
+// TestNotificationQueue tests the durable notification queue and its API.
+func TestNotificationQueue(t *testing.T, ctx context.Context, store TestStorage) {
+	n := &failingNotifier{
+		captureNotifier: captureNotifier{store: store},
+		err:             errors.New("MDM server unavailable"),
+	}
+	q := queue.New(store, n, queue.WithBackoff(0, 0), queue.WithMaxAttempts(1))
+
+	flowMux := flow.New()
+	logger := log.NopLogger
+	api.HandleAPIv1("/v1", flowMux, logger, store, q)
+
+	var mux http.Handler = flowMux
+	mux = trace.NewTraceLoggingHandler(mux, logger.With("handler", "log"), func(*http.Request) string { return "go_test_trace_id" })
+
+	const enrollmentID = "golang_test_enr_0E1F"
+
+	find := func(t *testing.T, failed bool) *storage.NotificationJob {
+		t.Helper()
+		path := "/v1/notifications"
+		if failed {
+			path += "?failed=1"
+		}
+		resp := doReq(mux, "GET", path, nil)
+		expectHTTP(t, resp, 200)
+		var jobs []storage.NotificationJob
+		if err := json.NewDecoder(resp.Body).Decode(&jobs); err != nil {
+			t.Fatal(err)
+		}
+		for i := range jobs {
+			if reflect.DeepEqual(jobs[i].EnrollmentIDs, []string{enrollmentID}) {
+				return &jobs[i]
+			}
+		}
+		return nil
+	}
+
+	resp := doReq(mux, "POST", "/v1/notify?id="+enrollmentID, nil)
+	expectHTTP(t, resp, 204)
+
+	job := find(t, false)
+	if job == nil {
+		t.Fatal("queued notification job not found")
+	}
+	if n.called {
+		t.Error("notifier called before processing queue")
+	}
+
+	if err := q.Process(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if find(t, false) != nil {
+		t.Error("notification job still pending")
+	}
+	job = find(t, true)
+	if job == nil {
+		t.Fatal("failed notification job not found")
+	}
+	if have, want := job.LastError, n.err.Error(); have != want {
+		t.Errorf("last error: have: %v, want: %v", have, want)
+	}
+
+	resp = doReq(mux, "GET", "/v1/notifications/"+job.ID, nil)
+	expectHTTP(t, resp, 200)
+
+	resp = doReq(mux, "POST", "/v1/notifications/"+job.ID+"/replay", nil)
+	expectHTTP(t, resp, 204)
+
+	job = find(t, false)
+	if job == nil {
+		t.Fatal("replayed notification job not pending")
+	}
+	if job.Attempts != 0 || job.Failed {
+		t.Errorf("replayed job not reset: %+v", job)
+	}
+
+	n.err = nil
+	if err := q.Process(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := n.getAndClear(), []string{enrollmentID}; !reflect.DeepEqual(have, want) {
+		t.Errorf("notified: have: %v, want: %v", have, want)
+	}
+
+	resp = doReq(mux, "GET", "/v1/notifications/"+job.ID, nil)
+	expectHTTP(t, resp, 404)
+
+	// queue and then delete a job
+	resp = doReq(mux, "POST", "/v1/notify?id="+enrollmentID, nil)
+	expectHTTP(t, resp, 204)
+	job = find(t, false)
+	if job == nil {
+		t.Fatal("queued notification job not found")
+	}
+	resp = doReq(mux, "DELETE", "/v1/notifications/"+job.ID, nil)
+	expectHTTP(t, resp, 204)
+	if find(t, false) != nil {
+		t.Error("deleted notification job still pending")
+	}
+
+	// queued jobs are only claimed once at a time
+	resp = doReq(mux, "POST", "/v1/notify?id="+enrollmentID, nil)
+	expectHTTP(t, resp, 204)
+	job = find(t, false)
+	if job == nil {
+		t.Fatal("queued notification job not found")
+	}
+	claimed := func(t *testing.T, now time.Time) bool {
+		t.Helper()
+		jobs, err := store.ClaimNotificationJobs(ctx, now, now.Add(time.Hour), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, j := range jobs {
+			if j.ID == job.ID {
+				return true
+			}
+		}
+		return false
+	}
+	now := time.Now().Add(time.Minute)
+	if !claimed(t, now) {
+		t.Fatal("notification job not claimed")
+	}
+	if claimed(t, now) {
+		t.Error("notification job claimed twice")
+	}
+	if !claimed(t, now.Add(2*time.Hour)) {
+		t.Error("notification job with expired claim not claimed")
+	}
+	if err := store.StoreNotificationJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if !claimed(t, now) {
+		t.Error("stored notification job not claimed")
+	}
+	resp = doReq(mux, "DELETE", "/v1/notifications/"+job.ID, nil)
+	expectHTTP(t, resp, 204)
+}
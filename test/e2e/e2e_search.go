@@ -0,0 +1,140 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	httpddm "github.com/jessepeterson/kmfddm/http/ddm"
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/micromdm/nanolib/http/trace"
+	"github.com/micromdm/nanolib/log"
+)
+
+func searchStatusReport(version, build string) []byte {
+	return []byte(`{
+  "StatusItems" : {
+    "device" : {
+      "operating-system" : {
+        "build-version" : "` + build + `",
+        "version" : "` + version + `"
+      }
+    }
+  },
+  "Errors" : []
+}`)
+}
+
+// TestStatusSearch tests searching for enrollments by status values.
+func TestStatusSearch(t *testing.T, _ context.Context, store TestStorage) {
+	flowMux := flow.New()
+	logger := log.NopLogger
+	api.HandleAPIv1("/v1", flowMux, logger, store, &captureNotifier{store: store})
+	handleDDM(flowMux, logger, store)
+
+	var mux http.Handler = flowMux
+	mux = trace.NewTraceLoggingHandler(mux, logger.With("handler", "log"), func(*http.Request) string { return "go_test_trace_id" })
+
+	enrHdr := make(http.Header)
+	for id, report := range map[string][]byte{
+		"golang_test_enr_5EA1": searchStatusReport("9013.3.1", "G0TEST1"),
+		"golang_test_enr_5EA2": searchStatusReport("9014.4", "G1TEST2"),
+		"golang_test_enr_5EA3": searchStatusReport("9014.10", "G1TEST3"),
+	} {
+		enrHdr.Set(httpddm.EnrollmentIDHeader, id)
+		resp := doReqHeader(mux, "PUT", "/status", enrHdr, report)
+		expectHTTP(t, resp, 200)
+	}
+
+	search := func(t *testing.T, path, op, value, after, limit string) *storage.StatusValueSearchResult {
+		t.Helper()
+		q := url.Values{}
+		q.Set("path", path)
+		q.Set("op", op)
+		q.Set("value", value)
+		if after != "" {
+			q.Set("after", after)
+		}
+		if limit != "" {
+			q.Set("limit", limit)
+		}
+		resp := doReq(mux, "GET", "/v1/status-search?"+q.Encode(), nil)
+		expectHTTP(t, resp, 200)
+		r := new(storage.StatusValueSearchResult)
+		if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	const (
+		versionPath = ".StatusItems.device.operating-system.version"
+		buildPath   = ".StatusItems.device.operating-system.build-version"
+	)
+
+	for _, tc := range []struct {
+		name  string
+		path  string
+		op    string
+		value string
+		want  []string
+	}{
+		{"eq", buildPath, "eq", "G0TEST1", []string{"golang_test_enr_5EA1"}},
+		{"prefix", buildPath, "prefix", "G1TEST", []string{"golang_test_enr_5EA2", "golang_test_enr_5EA3"}},
+		{"semver", versionPath, "semver_ge", "9014.4", []string{"golang_test_enr_5EA2", "golang_test_enr_5EA3"}},
+		{"none", buildPath, "eq", "G9TEST9", []string{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := search(t, tc.path, tc.op, tc.value, "", "")
+			if have, want := r.EnrollmentIDs, tc.want; !reflect.DeepEqual(have, want) {
+				t.Errorf("have: %v, want: %v", have, want)
+			}
+			if r.Next != "" {
+				t.Errorf("unexpected next page: %s", r.Next)
+			}
+		})
+	}
+
+	t.Run("paginate", func(t *testing.T) {
+		r := search(t, versionPath, "semver_ge", "9013", "", "2")
+		if have, want := r.EnrollmentIDs, []string{"golang_test_enr_5EA1", "golang_test_enr_5EA2"}; !reflect.DeepEqual(have, want) {
+			t.Errorf("have: %v, want: %v", have, want)
+		}
+		if have, want := r.Next, "golang_test_enr_5EA2"; have != want {
+			t.Fatalf("next: have: %v, want: %v", have, want)
+		}
+
+		r = search(t, versionPath, "semver_ge", "9013", r.Next, "2")
+		if have, want := r.EnrollmentIDs, []string{"golang_test_enr_5EA3"}; !reflect.DeepEqual(have, want) {
+			t.Errorf("have: %v, want: %v", have, want)
+		}
+		if r.Next != "" {
+			t.Errorf("unexpected next page: %s", r.Next)
+		}
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		enrHdr.Set(httpddm.EnrollmentIDHeader, "golang_test_enr_5EA1")
+		expectHTTP(t, doReqHeader(mux, "PUT", "/status", enrHdr, searchStatusReport("9015.1", "G0TEST1")), 200)
+
+		// the replaced value is no longer found
+		r := search(t, versionPath, "eq", "9013.3.1", "", "")
+		if have, want := r.EnrollmentIDs, []string{}; !reflect.DeepEqual(have, want) {
+			t.Errorf("have: %v, want: %v", have, want)
+		}
+		r = search(t, versionPath, "eq", "9015.1", "", "")
+		if have, want := r.EnrollmentIDs, []string{"golang_test_enr_5EA1"}; !reflect.DeepEqual(have, want) {
+			t.Errorf("have: %v, want: %v", have, want)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		resp := doReq(mux, "GET", "/v1/status-search?path="+versionPath+"&op=semver_ge&value=abc", nil)
+		expectHTTP(t, resp, 400)
+	})
+}
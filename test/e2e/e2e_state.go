@@ -0,0 +1,150 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	"github.com/micromdm/nanolib/log"
+)
+
+func testStateDecl(identifier, echo string) json.RawMessage {
+	return json.RawMessage(`{
+	"Type": "com.apple.configuration.management.test",
+	"Payload": {
+		"Echo": "` + echo + `"
+	},
+	"Identifier": "` + identifier + `"
+}`)
+}
+
+func putState(t *testing.T, mux *flow.Mux, query string, state *api.State, status int) *api.StateResponse {
+	t.Helper()
+	body, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := doReq(mux, "PUT", "/v1/state"+query, body)
+	expectHTTP(t, resp, status)
+	r := new(api.StateResponse)
+	if status == 200 {
+		if err = json.NewDecoder(resp.Body).Decode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+// TestState tests syncing the desired state of declarations and sets.
+func TestState(t *testing.T, _ context.Context, store TestStorage) {
+	n := &captureNotifier{store: store}
+
+	mux := flow.New()
+	api.HandleAPIv1("/v1", mux, log.NopLogger, store, n)
+
+	const (
+		prefix       = "golang_test_state_"
+		enrollmentID = "golang_test_enr_ST52"
+		set1         = prefix + "ST50"
+		other        = "golang_test_set_ST51"
+		query        = "?prefix=" + prefix
+	)
+
+	// a declaration that is also in a set outside of the prefix
+	expectHTTP(t, doReq(mux, "PUT", "/v1/declarations", testStateDecl("com.example.state.c", "c")), 204)
+	expectHTTP(t, doReq(mux, "PUT", "/v1/set-declarations/"+set1+"?declaration=com.example.state.c", nil), 204)
+	expectHTTP(t, doReq(mux, "PUT", "/v1/set-declarations/"+other+"?declaration=com.example.state.c", nil), 204)
+	expectHTTP(t, doReq(mux, "PUT", "/v1/enrollment-sets/"+enrollmentID+"?set="+set1, nil), 204)
+	n.getAndClear()
+
+	state := &api.State{
+		Declarations: []json.RawMessage{
+			testStateDecl("com.example.state.a", "a"),
+			testStateDecl("com.example.state.b", "b"),
+		},
+		Sets: map[string][]string{set1: {"com.example.state.a", "com.example.state.b"}},
+	}
+
+	// planning does not apply
+	r := putState(t, mux, query+"&plan=1", state, 200)
+	if !r.Plan || r.Applied {
+		t.Errorf("state response: %+v", r)
+	}
+	if have, want := r.DeclarationsAdded, []string{"com.example.state.a", "com.example.state.b"}; !stringSlicesEqual(have, want) {
+		t.Errorf("declarations added: have: %v, want: %v", have, want)
+	}
+	if have, want := r.SetDeclarationsAdded[set1], []string{"com.example.state.a", "com.example.state.b"}; !stringSlicesEqual(have, want) {
+		t.Errorf("set declarations added: have: %v, want: %v", have, want)
+	}
+	if have, want := r.SetDeclarationsRemoved[set1], []string{"com.example.state.c"}; !stringSlicesEqual(have, want) {
+		t.Errorf("set declarations removed: have: %v, want: %v", have, want)
+	}
+	if len(r.DeclarationsRemoved) > 0 {
+		t.Errorf("declarations removed: %v", r.DeclarationsRemoved)
+	}
+	if n.called {
+		t.Error("notified")
+	}
+	expectHTTP(t, doReq(mux, "GET", "/v1/declarations/com.example.state.a", nil), 404)
+
+	// applying changes the sets and notifies
+	r = putState(t, mux, query, state, 200)
+	if r.Plan || !r.Applied || !r.Notified {
+		t.Errorf("state response: %+v", r)
+	}
+	if have, want := unique(n.getAndClear()), []string{enrollmentID}; !stringSlicesEqual(have, want) {
+		t.Errorf("notified: have: %v, want: %v", have, want)
+	}
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/set-declarations/"+set1, nil), 200, []string{"com.example.state.a", "com.example.state.b"})
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/set-declarations/"+other, nil), 200, []string{"com.example.state.c"})
+	expectHTTP(t, doReq(mux, "GET", "/v1/declarations/com.example.state.c", nil), 200)
+
+	// applying again changes nothing
+	r = putState(t, mux, query, state, 200)
+	if len(r.DeclarationsAdded) > 0 || len(r.SetDeclarationsAdded) > 0 || r.Notified {
+		t.Errorf("state response: %+v", r)
+	}
+
+	// updates and removals
+	state = &api.State{
+		Declarations: []json.RawMessage{testStateDecl("com.example.state.a", "a2")},
+		Sets:         map[string][]string{set1: {"com.example.state.a"}},
+	}
+	r = putState(t, mux, query, state, 200)
+	if have, want := r.DeclarationsUpdated, []string{"com.example.state.a"}; !stringSlicesEqual(have, want) {
+		t.Errorf("declarations updated: have: %v, want: %v", have, want)
+	}
+	if have, want := r.DeclarationsRemoved, []string{"com.example.state.b"}; !stringSlicesEqual(have, want) {
+		t.Errorf("declarations removed: have: %v, want: %v", have, want)
+	}
+	if have, want := unique(n.getAndClear()), []string{enrollmentID}; !stringSlicesEqual(have, want) {
+		t.Errorf("notified: have: %v, want: %v", have, want)
+	}
+	expectHTTP(t, doReq(mux, "GET", "/v1/declarations/com.example.state.b", nil), 404)
+	expectHTTPStringSlice(t, doReq(mux, "GET", "/v1/set-declarations/"+set1, nil), 200, []string{"com.example.state.a"})
+
+	// sets must have the prefix
+	putState(t, mux, query, &api.State{Sets: map[string][]string{other: nil}}, 400)
+
+	// set declarations must be in the state
+	putState(t, mux, query, &api.State{Sets: map[string][]string{set1: {"com.example.state.c"}}}, 400)
+
+	// declarations also in sets outside of the prefix can't be updated
+	putState(t, mux, query, &api.State{
+		Declarations: []json.RawMessage{testStateDecl("com.example.state.c", "c2")},
+		Sets:         map[string][]string{set1: {"com.example.state.c"}},
+	}, 400)
+
+	// an empty complete state requires confirmation
+	putState(t, mux, "", &api.State{}, 400)
+
+	// cleanup
+	r = putState(t, mux, query, &api.State{}, 200)
+	if have, want := r.DeclarationsRemoved, []string{"com.example.state.a"}; !stringSlicesEqual(have, want) {
+		t.Errorf("declarations removed: have: %v, want: %v", have, want)
+	}
+	expectHTTP(t, doReq(mux, "DELETE", "/v1/declarations/com.example.state.c?cascade=1", nil), 204)
+	expectHTTP(t, doReq(mux, "DELETE", "/v1/enrollment-sets/"+enrollmentID+"?set="+set1, nil), 204)
+}
@@ -45,6 +45,11 @@ func testStatus(t *testing.T, mux http.Handler, n *captureNotifier) {
 	resp := doReqHeader(mux, "PUT", "/status", enrHdr, statusBytes)
 	expectHTTP(t, resp, 200)
 
+	// status history is not enabled for this storage
+
+	expectHTTP(t, doReq(mux, "GET", "/v1/status-history/golang_test_enr_87C029C236E0", nil), 404)
+	expectHTTP(t, doReq(mux, "GET", "/v1/declaration-status-history/golang_test_enr_87C029C236E0", nil), 404)
+
 	// test declaration status values
 
 	resp = doReq(mux, "GET", "/v1/status-values/golang_test_enr_87C029C236E0", nil)
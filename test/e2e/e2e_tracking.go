@@ -0,0 +1,165 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	httpddm "github.com/jessepeterson/kmfddm/http/ddm"
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/notifier"
+	"github.com/micromdm/nanolib/http/trace"
+	"github.com/micromdm/nanolib/log"
+)
+
+const testTrackingDecl = `{
+	"Type": "com.apple.configuration.management.test",
+	"Payload": {
+		"Echo": "tracking"
+	},
+	"Identifier": "com.example.tracking"
+}`
+
+func trackingStatusReport(serverToken string) []byte {
+	return []byte(strings.Replace(string(complianceStatusReport("valid", serverToken)), "com.example.compliance", "com.example.tracking", 1))
+}
+
+// uuidEnqueuer captures the command UUIDs of enqueued commands.
+type uuidEnqueuer struct {
+	uuids []string
+}
+
+func (e *uuidEnqueuer) EnqueueDMCommand(ctx context.Context, _ []string, _ []byte) error {
+	e.uuids = append(e.uuids, notifier.CommandUUID(ctx))
+	return nil
+}
+
+func (e *uuidEnqueuer) SupportsMultiCommands() bool {
+	return true
+}
+
+type unsyncedResponse struct {
+	EnrollmentID  string `json:"enrollment_id"`
+	CommandUUID   string `json:"command_uuid"`
+	Notifications int    `json:"notifications"`
+	AgeSeconds    *int64 `json:"age_seconds"`
+}
+
+// TestNotificationTracking tests tracking notified enrollments until they sync.
+func TestNotificationTracking(t *testing.T, _ context.Context, store TestStorage) {
+	e := new(uuidEnqueuer)
+	n, err := notifier.New(e, store, notifier.WithTracking(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flowMux := flow.New()
+	logger := log.NopLogger
+	api.HandleAPIv1("/v1", flowMux, logger, store, n)
+	flowMux.Handle(
+		"/status",
+		httpddm.StatusReportHandler(notifier.NewSyncStatusStorer(store, store, store, logger), logger.With(logkeys.Handler, "status")),
+		"PUT",
+	)
+
+	var mux http.Handler = flowMux
+	mux = trace.NewTraceLoggingHandler(mux, logger.With("handler", "log"), func(*http.Request) string { return "go_test_trace_id" })
+
+	const enrollmentID = "golang_test_enr_7AC1"
+
+	unsynced := func(t *testing.T) *unsyncedResponse {
+		t.Helper()
+		resp := doReq(mux, "GET", "/v1/unsynced?id="+enrollmentID, nil)
+		expectHTTP(t, resp, 200)
+		var r []unsyncedResponse
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		for i := range r {
+			if r[i].EnrollmentID == enrollmentID {
+				return &r[i]
+			}
+		}
+		return nil
+	}
+
+	resp := doReq(mux, "PUT", "/v1/declarations", []byte(testTrackingDecl))
+	expectHTTP(t, resp, 204)
+
+	resp = doReq(mux, "PUT", "/v1/set-declarations/golang_test_set_7AC0?declaration=com.example.tracking", nil)
+	expectHTTP(t, resp, 204)
+
+	resp = doReq(mux, "PUT", "/v1/enrollment-sets/"+enrollmentID+"?set=golang_test_set_7AC0", nil)
+	expectHTTP(t, resp, 204)
+
+	u := unsynced(t)
+	if u == nil {
+		t.Fatal("notified enrollment not unsynced")
+	}
+	if len(e.uuids) < 1 {
+		t.Fatal("no command enqueued")
+	}
+	if have, want := u.CommandUUID, e.uuids[len(e.uuids)-1]; have != want {
+		t.Errorf("command UUID: have: %v, want: %v", have, want)
+	}
+	if u.AgeSeconds == nil {
+		t.Error("missing age")
+	}
+
+	// notifying again before syncing increments the count
+	count := u.Notifications
+	resp = doReq(mux, "POST", "/v1/notify?id="+enrollmentID, nil)
+	expectHTTP(t, resp, 204)
+	if u = unsynced(t); u == nil {
+		t.Fatal("notified enrollment not unsynced")
+	}
+	if have, want := u.Notifications, count+1; have != want {
+		t.Errorf("notifications: have: %v, want: %v", have, want)
+	}
+
+	resp = doReq(mux, "GET", "/v1/unsynced?id="+enrollmentID+"&older_than=1h", nil)
+	expectHTTP(t, resp, 200)
+	var r []unsyncedResponse
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 0 {
+		t.Errorf("unsynced older than 1h: have: %v, want: none", r)
+	}
+
+	resp = doReq(mux, "GET", "/v1/declarations/com.example.tracking", nil)
+	expectHTTP(t, resp, 200)
+	d := &TestDeclaration{}
+	if err = json.NewDecoder(resp.Body).Decode(d); err != nil {
+		t.Fatal(err)
+	}
+
+	enrHdr := make(http.Header)
+	enrHdr.Set(httpddm.EnrollmentIDHeader, enrollmentID)
+
+	// a stale server token does not sync
+	resp = doReqHeader(mux, "PUT", "/status", enrHdr, trackingStatusReport("stale_token"))
+	expectHTTP(t, resp, 200)
+	if unsynced(t) == nil {
+		t.Error("enrollment synced with stale server token")
+	}
+
+	resp = doReqHeader(mux, "PUT", "/status", enrHdr, trackingStatusReport(d.ServerToken))
+	expectHTTP(t, resp, 200)
+	if u = unsynced(t); u != nil {
+		t.Errorf("enrollment not synced: %+v", u)
+	}
+
+	// renotifying a synced enrollment starts the count over
+	resp = doReq(mux, "POST", "/v1/notify?id="+enrollmentID, nil)
+	expectHTTP(t, resp, 204)
+	if u = unsynced(t); u == nil {
+		t.Fatal("notified enrollment not unsynced")
+	} else if u.Notifications != 1 {
+		t.Errorf("notifications: have: %v, want: 1", u.Notifications)
+	}
+}
@@ -0,0 +1,86 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/alexedwards/flow"
+	"github.com/jessepeterson/kmfddm/http/api"
+	httpddm "github.com/jessepeterson/kmfddm/http/ddm"
+	"github.com/jessepeterson/kmfddm/storage"
+	"github.com/micromdm/nanolib/http/trace"
+	"github.com/micromdm/nanolib/log"
+)
+
+// TestStatusUnhandled tests tracking of unhandled status report paths.
+func TestStatusUnhandled(t *testing.T, _ context.Context, store TestStorage) {
+	flowMux := flow.New()
+	logger := log.NopLogger
+	api.HandleAPIv1("/v1", flowMux, logger, store, &captureNotifier{store: store})
+	handleDDM(flowMux, logger, store)
+
+	var mux http.Handler = flowMux
+	mux = trace.NewTraceLoggingHandler(mux, logger.With("handler", "log"), func(*http.Request) string { return "go_test_trace_id" })
+
+	const path = ".GolangTestUnhandled"
+
+	unhandled := func(t *testing.T) *storage.UnhandledStatusPath {
+		t.Helper()
+		resp := doReq(mux, "GET", "/v1/status-unhandled", nil)
+		expectHTTP(t, resp, 200)
+		var paths []storage.UnhandledStatusPath
+		if err := json.NewDecoder(resp.Body).Decode(&paths); err != nil {
+			t.Fatal(err)
+		}
+		for i := range paths {
+			if paths[i].Path == path {
+				return &paths[i]
+			}
+		}
+		return nil
+	}
+
+	report := []byte(`{"StatusItems":{},"Errors":[],"GolangTestUnhandled":{"a":1}}`)
+
+	enrHdr := make(http.Header)
+	enrHdr.Set(httpddm.EnrollmentIDHeader, "golang_test_enr_0A11")
+	resp := doReqHeader(mux, "PUT", "/status", enrHdr, report)
+	expectHTTP(t, resp, 200)
+
+	first := unhandled(t)
+	if first == nil {
+		t.Fatalf("unhandled path not found: %s", path)
+	}
+	if first.Count < 1 {
+		t.Errorf("count: have: %v, want: >=1", first.Count)
+	}
+	if have, want := first.EnrollmentID, "golang_test_enr_0A11"; have != want {
+		t.Errorf("enrollment ID: have: %v, want: %v", have, want)
+	}
+	if first.FirstSeen.IsZero() || first.LastSeen.IsZero() {
+		t.Error("expected first and last seen times")
+	}
+
+	enrHdr.Set(httpddm.EnrollmentIDHeader, "golang_test_enr_0A12")
+	resp = doReqHeader(mux, "PUT", "/status", enrHdr, report)
+	expectHTTP(t, resp, 200)
+
+	second := unhandled(t)
+	if second == nil {
+		t.Fatalf("unhandled path not found: %s", path)
+	}
+	if have, want := second.Count, first.Count+1; have != want {
+		t.Errorf("count: have: %v, want: %v", have, want)
+	}
+	if have, want := second.EnrollmentID, "golang_test_enr_0A12"; have != want {
+		t.Errorf("enrollment ID: have: %v, want: %v", have, want)
+	}
+	if !second.FirstSeen.Equal(first.FirstSeen) {
+		t.Errorf("first seen: have: %v, want: %v", second.FirstSeen, first.FirstSeen)
+	}
+	if second.LastSeen.Before(first.LastSeen) {
+		t.Errorf("last seen: %v before %v", second.LastSeen, first.LastSeen)
+	}
+}
@@ -1,6 +1,10 @@
 #!/usr/bin/env python3
 
 """
+Deprecated: use `kmfddmctl sync` instead, which syncs the same directory
+layout in a single batch request. This script will be removed in a
+future release.
+
 KMFDDM sync tool. This tool synchronizes declarations and sets from
 a directory and uploads them to a KMFDDM server. It tries to be smart
 about only notifying the changed items (declarations, sets) and only
@@ -22,6 +26,10 @@ For example a directory might look like this:
 Here each of the two JSON files will be treated as declarations and the
 txt file will apply each declaration (one per line) to the "default"
 set.
+
+This tool only adds (or explicitly dissociates) items. To also remove
+declarations and associations that are absent from a directory see the
+server-side `PUT /v1/state` endpoint.
 """
 
 import os
@@ -32,6 +40,7 @@ from urllib.parse import urlencode
 import argparse
 import base64
 import re
+import sys
 
 ssl._create_default_https_context = ssl._create_stdlib_context
 
@@ -236,4 +245,8 @@ if __name__ == "__main__":
     )
     args = parser.parse_args()
 
+    print(
+        "syncdir.py is deprecated and will be removed; use `kmfddmctl sync` instead",
+        file=sys.stderr,
+    )
     sync_dir(args.dir, args.apibaseurl, args.user, args.key)
@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// PreviousStatusRetriever retrieves the previously stored status of enrollments.
+type PreviousStatusRetriever interface {
+	storage.StatusDeclarationsRetriever
+	storage.StatusReportRetriever
+}
+
+// StatusStorer stores status reports and sends events about them.
+type StatusStorer struct {
+	storage.StatusStorer
+	prev PreviousStatusRetriever
+	hook *Webhook
+}
+
+// NewStatusStorer creates a new StatusStorer that stores status reports
+// in next and sends events with hook. The previous declaration status
+// and status report of enrollments are retrieved from prev to detect
+// changes and first contact. Note first contact can only be detected
+// if status reports are stored.
+func NewStatusStorer(next storage.StatusStorer, prev PreviousStatusRetriever, hook *Webhook) *StatusStorer {
+	if next == nil || prev == nil || hook == nil {
+		panic("nil storage or webhook")
+	}
+	return &StatusStorer{StatusStorer: next, prev: prev, hook: hook}
+}
+
+// StoreDeclarationStatus stores status for enrollmentID and sends events for it.
+// Errors retrieving the previous status are logged and the events
+// that depend on it are not sent.
+func (s *StatusStorer) StoreDeclarationStatus(ctx context.Context, enrollmentID string, status *ddm.StatusReport) error {
+	logger := ctxlog.Logger(ctx, s.hook.logger)
+	prev, prevErr := s.prev.RetrieveDeclarationStatus(ctx, []string{enrollmentID})
+	if prevErr != nil {
+		logger.Info(
+			logkeys.Message, "retrieving previous declaration status",
+			logkeys.EnrollmentID, enrollmentID,
+			logkeys.Error, prevErr,
+		)
+	}
+	index := 0
+	_, reportErr := s.prev.RetrieveStatusReport(ctx, storage.StatusReportQuery{EnrollmentID: enrollmentID, Index: &index})
+	firstSeen := errors.Is(reportErr, storage.ErrStatusReportNotFound)
+	if reportErr != nil && !firstSeen {
+		logger.Info(
+			logkeys.Message, "retrieving previous status report",
+			logkeys.EnrollmentID, enrollmentID,
+			logkeys.Error, reportErr,
+		)
+	}
+	if err := s.StatusStorer.StoreDeclarationStatus(ctx, enrollmentID, status); err != nil {
+		return err
+	}
+	if status == nil {
+		return nil
+	}
+
+	received := NewEvent(EventStatusReceived, enrollmentID)
+	received.StatusID = status.ID
+	received.DeclarationCount = len(status.Declarations)
+	received.ErrorCount = len(status.Errors)
+	events := []*Event{received}
+
+	if firstSeen {
+		e := NewEvent(EventEnrollmentFirstSeen, enrollmentID)
+		e.StatusID = status.ID
+		events = append(events, e)
+	}
+
+	for i := range status.Errors {
+		e := NewEvent(EventStatusError, enrollmentID)
+		e.StatusID = status.ID
+		e.Error = &StatusError{Path: status.Errors[i].Path}
+		if json.Valid(status.Errors[i].ErrorJSON) {
+			e.Error.Error = status.Errors[i].ErrorJSON
+		}
+		events = append(events, e)
+	}
+
+	if prevErr == nil {
+		wasInvalid := make(map[string]bool)
+		for _, d := range prev[enrollmentID] {
+			wasInvalid[d.Identifier] = d.Valid == "invalid"
+		}
+		for i := range status.Declarations {
+			d := status.Declarations[i]
+			if d.Valid != "invalid" || wasInvalid[d.Identifier] {
+				continue
+			}
+			e := NewEvent(EventDeclarationInvalid, enrollmentID)
+			e.StatusID = status.ID
+			e.Declaration = &d
+			events = append(events, e)
+		}
+	}
+
+	s.hook.Send(ctx, events...)
+	return nil
+}
+
+// TrackedNotificationStorer sends events for notifications sent to enrollments.
+// See [notifier.WithSentHook].
+type TrackedNotificationStorer struct {
+	hook *Webhook
+}
+
+// NewTrackedNotificationStorer creates a new TrackedNotificationStorer
+// that sends events with hook.
+func NewTrackedNotificationStorer(hook *Webhook) *TrackedNotificationStorer {
+	if hook == nil {
+		panic("nil webhook")
+	}
+	return &TrackedNotificationStorer{hook: hook}
+}
+
+// StoreTrackedNotifications sends a notification sent event for each
+// of notifications. The notifications are not otherwise stored.
+func (s *TrackedNotificationStorer) StoreTrackedNotifications(ctx context.Context, notifications []storage.TrackedNotification) error {
+	events := make([]*Event, len(notifications))
+	for i, n := range notifications {
+		events[i] = NewEvent(EventNotificationSent, n.EnrollmentID)
+		events[i].CommandUUID = n.CommandUUID
+	}
+	s.hook.Send(ctx, events...)
+	return nil
+}
@@ -0,0 +1,544 @@
+// Package webhook delivers KMFDDM events to subscribed HTTP endpoints.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/logkeys"
+	"github.com/jessepeterson/kmfddm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Event types.
+const (
+	// EventStatusReceived is sent for every status report stored.
+	EventStatusReceived = "status.received"
+
+	// EventStatusError is sent for each error in a status report.
+	EventStatusError = "status.error"
+
+	// EventDeclarationInvalid is sent when a status report shows a
+	// declaration as invalid that was not previously invalid.
+	EventDeclarationInvalid = "declaration.invalid"
+
+	// EventEnrollmentFirstSeen is sent for the first status report of
+	// an enrollment, i.e. when no status report of it was stored before.
+	EventEnrollmentFirstSeen = "enrollment.first_seen"
+
+	// EventNotificationSent is sent for each enrollment notified.
+	EventNotificationSent = "notification.sent"
+)
+
+var eventTypes = map[string]struct{}{
+	EventStatusReceived:      {},
+	EventStatusError:         {},
+	EventDeclarationInvalid:  {},
+	EventEnrollmentFirstSeen: {},
+	EventNotificationSent:    {},
+}
+
+// SignatureHeader is the HTTP header containing the hex-encoded
+// HMAC-SHA256 of the request body, prefixed with "sha256=".
+const SignatureHeader = "X-KMFDDM-Signature"
+
+var ErrHTTPStatus = errors.New("unexpected HTTP status")
+
+// StatusError is an error from a status report.
+type StatusError struct {
+	Path  string          `json:"path"`
+	Error json.RawMessage `json:"error,omitempty"`
+}
+
+// Event is delivered as the JSON body of webhook requests.
+type Event struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	CreatedAt    time.Time `json:"created_at"`
+	Tenant       string    `json:"tenant,omitempty"`
+	EnrollmentID string    `json:"enrollment_id"`
+
+	// StatusID is the ID of the status report of status events.
+	StatusID string `json:"status_id,omitempty"`
+
+	// DeclarationCount and ErrorCount are the number of declarations
+	// and errors in the status report of status received events.
+	DeclarationCount int `json:"declaration_count,omitempty"`
+	ErrorCount       int `json:"error_count,omitempty"`
+
+	Declaration *ddm.DeclarationStatus `json:"declaration,omitempty"`
+	Error       *StatusError           `json:"error,omitempty"`
+
+	// CommandUUID is the UUID of the enqueued command of notification sent events.
+	CommandUUID string `json:"command_uuid,omitempty"`
+}
+
+// NewEvent creates a new event of eventType for enrollmentID.
+func NewEvent(eventType, enrollmentID string) *Event {
+	return &Event{
+		ID:           uuid.NewString(),
+		Type:         eventType,
+		CreatedAt:    time.Now().UTC(),
+		EnrollmentID: enrollmentID,
+	}
+}
+
+// Subscription selects events to deliver to an HTTP endpoint.
+type Subscription struct {
+	URL string `json:"url"`
+
+	// Secret, if not empty, is used to sign the request body.
+	// See [SignatureHeader].
+	Secret string `json:"secret"`
+
+	// Events, if not empty, selects only these event types.
+	Events []string `json:"events"`
+
+	// Sets, if not empty, selects only events of enrollments in these sets.
+	Sets []string `json:"sets"`
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether s selects event for an enrollment in sets.
+func (s *Subscription) match(event *Event, sets []string) bool {
+	if len(s.Events) > 0 && !contains(s.Events, event.Type) {
+		return false
+	}
+	if len(s.Sets) < 1 {
+		return true
+	}
+	for _, set := range sets {
+		if contains(s.Sets, set) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks s for errors.
+func (s *Subscription) Validate() error {
+	if s.URL == "" {
+		return errors.New("empty URL")
+	}
+	for _, t := range s.Events {
+		if _, ok := eventTypes[t]; !ok {
+			return fmt.Errorf("invalid event type: %s", t)
+		}
+	}
+	return nil
+}
+
+// Doer executes an HTTP request.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// delivery is an event to be delivered to a subscription.
+type delivery struct {
+	sub      *Subscription
+	event    *Event
+	body     []byte
+	attempts int
+}
+
+const (
+	DefaultConcurrency = 4
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = 5 * time.Second
+	DefaultMaxBackoff  = 5 * time.Minute
+
+	defaultQueueSize = 1000
+)
+
+// Webhook delivers events to matching subscriptions in the background.
+// Failed deliveries are retried with exponential backoff until the
+// maximum number of attempts is reached. Deliveries are queued in
+// memory only. New events are dropped if the queue is full; retries
+// wait for room in the queue instead. See [Webhook.Close] for draining
+// the queue on shutdown. Dropped events are logged and counted
+// (see [Webhook.Dropped]).
+type Webhook struct {
+	subs   []Subscription
+	sets   storage.EnrollmentSetsRetriever
+	client Doer
+	logger log.Logger
+	tenant string
+
+	timeout     time.Duration
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int
+
+	queue chan *delivery
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	closing bool
+	retries map[*delivery]*time.Timer
+
+	// pending counts the deliveries that are queued, being attempted or waiting to be retried
+	pending sync.WaitGroup
+	stop    chan struct{} // closed when draining gives up
+	dropped atomic.Uint64
+}
+
+type Option func(*Webhook)
+
+func WithLogger(logger log.Logger) Option {
+	return func(w *Webhook) {
+		w.logger = logger
+	}
+}
+
+// WithClient sets the HTTP client used to deliver events.
+func WithClient(client Doer) Option {
+	return func(w *Webhook) {
+		w.client = client
+	}
+}
+
+// WithTenant includes the tenant name in events.
+func WithTenant(name string) Option {
+	return func(w *Webhook) {
+		w.tenant = name
+	}
+}
+
+// WithTimeout sets the timeout of each delivery request.
+func WithTimeout(timeout time.Duration) Option {
+	return func(w *Webhook) {
+		w.timeout = timeout
+	}
+}
+
+// WithBackoff sets the delay before the first retry and the maximum delay between retries.
+// The delay doubles after each failed attempt.
+func WithBackoff(backoff, maxBackoff time.Duration) Option {
+	return func(w *Webhook) {
+		w.backoff = backoff
+		w.maxBackoff = maxBackoff
+	}
+}
+
+// WithMaxAttempts sets the number of delivery attempts of each event.
+func WithMaxAttempts(attempts int) Option {
+	return func(w *Webhook) {
+		w.maxAttempts = attempts
+	}
+}
+
+// WithQueueSize sets the number of deliveries that can be queued.
+func WithQueueSize(size int) Option {
+	return func(w *Webhook) {
+		w.queue = make(chan *delivery, size)
+	}
+}
+
+// New creates a new Webhook delivering events to subs.
+// The sets of enrollments are retrieved from sets for subscriptions
+// that filter on sets.
+func New(subs []Subscription, sets storage.EnrollmentSetsRetriever, opts ...Option) (*Webhook, error) {
+	if sets == nil {
+		panic("nil store")
+	}
+	for i := range subs {
+		if err := subs[i].Validate(); err != nil {
+			return nil, fmt.Errorf("subscription %d: %w", i, err)
+		}
+	}
+	w := &Webhook{
+		subs:        subs,
+		sets:        sets,
+		client:      http.DefaultClient,
+		logger:      log.NopLogger,
+		timeout:     30 * time.Second,
+		backoff:     DefaultBackoff,
+		maxBackoff:  DefaultMaxBackoff,
+		maxAttempts: DefaultMaxAttempts,
+		queue:       make(chan *delivery, defaultQueueSize),
+		retries:     make(map[*delivery]*time.Timer),
+		stop:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w, nil
+}
+
+// needSets reports whether any subscription filters on sets.
+func (w *Webhook) needSets() bool {
+	for _, s := range w.subs {
+		if len(s.Sets) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Send queues events for delivery to matching subscriptions.
+// Errors are logged rather than returned.
+func (w *Webhook) Send(ctx context.Context, events ...*Event) {
+	logger := ctxlog.Logger(ctx, w.logger)
+	needSets := w.needSets()
+	setsCache := make(map[string][]string)
+	for _, event := range events {
+		event.Tenant = w.tenant
+		var sets []string
+		if needSets {
+			var ok bool
+			if sets, ok = setsCache[event.EnrollmentID]; !ok {
+				var err error
+				if sets, err = w.sets.RetrieveEnrollmentSets(ctx, event.EnrollmentID); err != nil {
+					logger.Info(
+						logkeys.Message, "retrieving enrollment sets",
+						logkeys.EnrollmentID, event.EnrollmentID,
+						logkeys.Error, err,
+					)
+				}
+				setsCache[event.EnrollmentID] = sets
+			}
+		}
+		var body []byte
+		for i := range w.subs {
+			sub := &w.subs[i]
+			if !sub.match(event, sets) {
+				continue
+			}
+			if body == nil {
+				var err error
+				if body, err = json.Marshal(event); err != nil {
+					logger.Info(logkeys.Message, "marshal event", "event_id", event.ID, logkeys.Error, err)
+					break
+				}
+			}
+			w.enqueue(logger, &delivery{sub: sub, event: event, body: body})
+		}
+	}
+}
+
+// drop logs and counts the dropped delivery d.
+func (w *Webhook) drop(logger log.Logger, d *delivery, reason string) {
+	w.dropped.Add(1)
+	logger.Info(
+		logkeys.Message, "dropping event",
+		"reason", reason,
+		"event_id", d.event.ID,
+		"event_type", d.event.Type,
+		"url", d.sub.URL,
+	)
+}
+
+// Dropped returns the number of deliveries dropped or given up on so far.
+func (w *Webhook) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// enqueue queues d without blocking.
+// The delivery is dropped if the queue is full or w is closing.
+func (w *Webhook) enqueue(logger log.Logger, d *delivery) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closing {
+		w.drop(logger, d, "webhook closed")
+		return
+	}
+	w.pending.Add(1)
+	select {
+	case w.queue <- d:
+	default:
+		w.pending.Done()
+		w.drop(logger, d, "webhook queue full")
+	}
+}
+
+// requeue queues the pending delivery d for a retry.
+// It waits for room in the queue until ctx is done or draining gives up.
+func (w *Webhook) requeue(ctx context.Context, logger log.Logger, d *delivery) {
+	select {
+	case w.queue <- d:
+	case <-ctx.Done():
+		w.pending.Done()
+		w.drop(logger, d, "webhook stopped")
+	case <-w.stop:
+		w.pending.Done()
+		w.drop(logger, d, "webhook stopped")
+	}
+}
+
+// Run delivers queued events with up to concurrency workers until ctx
+// is done. To deliver the queued events before stopping see [Webhook.Close].
+func (w *Webhook) Run(ctx context.Context, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for i := 0; i < concurrency; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-w.stop:
+					return
+				case d := <-w.queue:
+					w.attempt(ctx, d)
+				}
+			}
+		}()
+	}
+	w.wg.Wait()
+}
+
+// Close stops accepting new events and waits for the pending
+// deliveries until ctx is done. While closing failed deliveries are
+// retried right away rather than after the backoff delay. Deliveries
+// still pending when ctx is done are dropped.
+// Run must still be running for the pending deliveries to be delivered.
+func (w *Webhook) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closing {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closing = true
+	for d, t := range w.retries {
+		if t.Stop() {
+			go w.requeue(context.Background(), w.logger, d)
+		}
+		delete(w.retries, d)
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	close(w.stop)
+	// workers stop taking deliveries: drop what is left in the queue
+	for {
+		select {
+		case d := <-w.queue:
+			w.pending.Done()
+			w.drop(w.logger, d, "webhook stopped")
+		default:
+			return fmt.Errorf("draining webhook queue: %w", ctx.Err())
+		}
+	}
+}
+
+// delay returns the backoff delay after attempts failed attempts.
+func (w *Webhook) delay(attempts int) time.Duration {
+	d := w.backoff
+	for i := 1; i < attempts && d < w.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > w.maxBackoff {
+		d = w.maxBackoff
+	}
+	return d
+}
+
+// attempt delivers d and schedules a retry if it fails.
+func (w *Webhook) attempt(ctx context.Context, d *delivery) {
+	d.attempts++
+	logger := w.logger.With(
+		"event_id", d.event.ID,
+		"event_type", d.event.Type,
+		"url", d.sub.URL,
+		"attempts", d.attempts,
+	)
+	err := w.deliver(ctx, d)
+	if err == nil {
+		logger.Debug(logkeys.Message, "delivered event")
+		w.pending.Done()
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if d.attempts >= w.maxAttempts {
+		logger.Info(logkeys.Message, "delivering event; giving up", logkeys.Error, err)
+		w.pending.Done()
+		w.dropped.Add(1)
+		return
+	}
+	if w.closing {
+		logger.Info(logkeys.Message, "delivering event", "retry_in", "0s", logkeys.Error, err)
+		go w.requeue(ctx, logger, d)
+		return
+	}
+	delay := w.delay(d.attempts)
+	logger.Info(logkeys.Message, "delivering event", "retry_in", delay.String(), logkeys.Error, err)
+	w.retries[d] = time.AfterFunc(delay, func() {
+		w.mu.Lock()
+		delete(w.retries, d)
+		w.mu.Unlock()
+		w.requeue(ctx, logger, d)
+	})
+}
+
+// Sign returns the signature header value of body using secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver sends a single HTTP request for d.
+func (w *Webhook) deliver(ctx context.Context, d *delivery) error {
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.sub.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return fmt.Errorf("creating HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-KMFDDM-Event", d.event.Type)
+	req.Header.Set("X-KMFDDM-Delivery", d.event.ID)
+	if d.sub.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(d.sub.Secret, d.body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("executing HTTP request: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s", ErrHTTPStatus, resp.Status)
+	}
+	return nil
+}
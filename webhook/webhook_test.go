@@ -0,0 +1,338 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/kmfddm/ddm"
+	"github.com/jessepeterson/kmfddm/storage"
+)
+
+type testSets map[string][]string
+
+func (s testSets) RetrieveEnrollmentSets(_ context.Context, enrollmentID string) ([]string, error) {
+	return s[enrollmentID], nil
+}
+
+// testStatus stores the declaration status of the last status report.
+type testStatus map[string][]ddm.DeclarationQueryStatus
+
+func (s testStatus) StoreDeclarationStatus(_ context.Context, enrollmentID string, status *ddm.StatusReport) error {
+	var r []ddm.DeclarationQueryStatus
+	for _, d := range status.Declarations {
+		r = append(r, ddm.DeclarationQueryStatus{DeclarationStatus: d})
+	}
+	s[enrollmentID] = r
+	return nil
+}
+
+func (s testStatus) RetrieveDeclarationStatus(_ context.Context, enrollmentIDs []string) (map[string][]ddm.DeclarationQueryStatus, error) {
+	r := make(map[string][]ddm.DeclarationQueryStatus)
+	for _, id := range enrollmentIDs {
+		if d, ok := s[id]; ok && len(d) > 0 {
+			r[id] = d
+		}
+	}
+	return r, nil
+}
+
+func (s testStatus) RetrieveStatusReport(_ context.Context, q storage.StatusReportQuery) (*storage.StoredStatusReport, error) {
+	if _, ok := s[q.EnrollmentID]; !ok {
+		return nil, storage.ErrStatusReportNotFound
+	}
+	return &storage.StoredStatusReport{}, nil
+}
+
+type received struct {
+	event     Event
+	signature string
+}
+
+// testReceiver records the events it receives.
+// It fails the first failures requests.
+type testReceiver struct {
+	mu       sync.Mutex
+	events   []received
+	failures int
+	wake     chan struct{}
+}
+
+func (r *testReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if req.Header.Get(SignatureHeader) != "" && req.Header.Get(SignatureHeader) != Sign("secret", body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	r.events = append(r.events, received{event: e, signature: req.Header.Get(SignatureHeader)})
+	r.wake <- struct{}{}
+}
+
+func (r *testReceiver) wait(t *testing.T, n int) []received {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.wake:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d of %d", i+1, n)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events
+}
+
+func newTestWebhook(t *testing.T, subs []Subscription, sets testSets) *Webhook {
+	t.Helper()
+	w, err := New(subs, sets, WithBackoff(time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go w.Run(ctx, 1)
+	return w
+}
+
+func TestWebhookFilterAndSign(t *testing.T) {
+	r := &testReceiver{wake: make(chan struct{}, 10), failures: 2}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	w := newTestWebhook(t, []Subscription{
+		{URL: srv.URL, Secret: "secret", Events: []string{EventStatusError}, Sets: []string{"set1"}},
+	}, testSets{"ID1": {"set1"}, "ID2": {"set2"}})
+
+	w.Send(
+		context.Background(),
+		NewEvent(EventStatusReceived, "ID1"),
+		NewEvent(EventStatusError, "ID2"),
+		NewEvent(EventStatusError, "ID1"),
+	)
+
+	events := r.wait(t, 1)
+	if len(events) != 1 {
+		t.Fatalf("events: have: %v, want: 1", len(events))
+	}
+	if have, want := events[0].event.Type, EventStatusError; have != want {
+		t.Errorf("event type: have: %v, want: %v", have, want)
+	}
+	if have, want := events[0].event.EnrollmentID, "ID1"; have != want {
+		t.Errorf("enrollment ID: have: %v, want: %v", have, want)
+	}
+	if events[0].signature == "" {
+		t.Error("missing signature")
+	}
+}
+
+func TestWebhookInvalidSubscription(t *testing.T) {
+	if _, err := New([]Subscription{{URL: "http://localhost", Events: []string{"invalid"}}}, testSets{}); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := New([]Subscription{{}}, testSets{}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestWebhookDelay(t *testing.T) {
+	w, err := New(nil, testSets{}, WithBackoff(time.Second, 5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		if have := w.delay(attempts); have != want {
+			t.Errorf("attempts %d: have: %v, want: %v", attempts, have, want)
+		}
+	}
+}
+
+func TestStatusStorer(t *testing.T) {
+	r := &testReceiver{wake: make(chan struct{}, 10)}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	w := newTestWebhook(t, []Subscription{{URL: srv.URL}}, testSets{})
+	status := make(testStatus)
+	s := NewStatusStorer(status, status, w)
+
+	report := &ddm.StatusReport{
+		ID: "status1",
+		Declarations: []ddm.DeclarationStatus{
+			{Identifier: "com.example.valid", Valid: "valid"},
+			{Identifier: "com.example.invalid", Valid: "invalid"},
+		},
+		Errors: []ddm.StatusError{
+			{Path: "StatusItems.test", ErrorJSON: []byte(`{"code":"Error.Test"}`)},
+		},
+	}
+	if err := s.StoreDeclarationStatus(context.Background(), "ID1", report); err != nil {
+		t.Fatal(err)
+	}
+
+	events := r.wait(t, 4)
+	var types []string
+	for _, e := range events {
+		types = append(types, e.event.Type)
+		if have, want := e.event.StatusID, "status1"; have != want {
+			t.Errorf("status ID: have: %v, want: %v", have, want)
+		}
+		switch e.event.Type {
+		case EventDeclarationInvalid:
+			if e.event.Declaration == nil || e.event.Declaration.Identifier != "com.example.invalid" {
+				t.Errorf("invalid declaration: have: %+v", e.event.Declaration)
+			}
+		case EventStatusError:
+			if e.event.Error == nil || string(e.event.Error.Error) != `{"code":"Error.Test"}` {
+				t.Errorf("status error: have: %+v", e.event.Error)
+			}
+		}
+	}
+	sort.Strings(types)
+	want := []string{EventDeclarationInvalid, EventEnrollmentFirstSeen, EventStatusError, EventStatusReceived}
+	if have := types; !equal(have, want) {
+		t.Errorf("event types: have: %v, want: %v", have, want)
+	}
+
+	// an already invalid declaration of a known enrollment sends no events other than received
+	report.ID = "status2"
+	report.Errors = nil
+	if err := s.StoreDeclarationStatus(context.Background(), "ID1", report); err != nil {
+		t.Fatal(err)
+	}
+	events = r.wait(t, 1)
+	if have, want := events[len(events)-1].event.Type, EventStatusReceived; have != want || len(events) != 5 {
+		t.Errorf("event type: have: %v (%d events), want: %v", have, len(events), want)
+	}
+}
+
+func TestStatusStorerFirstSeen(t *testing.T) {
+	r := &testReceiver{wake: make(chan struct{}, 10)}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	w := newTestWebhook(t, []Subscription{{URL: srv.URL, Events: []string{EventEnrollmentFirstSeen, EventStatusReceived}}}, testSets{})
+	status := make(testStatus)
+	s := NewStatusStorer(status, status, w)
+
+	// status reports without declarations
+	for _, id := range []string{"status1", "status2"} {
+		if err := s.StoreDeclarationStatus(context.Background(), "ID1", &ddm.StatusReport{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := r.wait(t, 3)
+	var firstSeen int
+	for _, e := range events {
+		if e.event.Type == EventEnrollmentFirstSeen {
+			firstSeen++
+		}
+	}
+	if firstSeen != 1 {
+		t.Errorf("first seen events: have: %d, want: 1", firstSeen)
+	}
+}
+
+func TestWebhookCloseDrains(t *testing.T) {
+	r := &testReceiver{wake: make(chan struct{}, 10), failures: 1}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// a retry would not happen before the test times out
+	w, err := New([]Subscription{{URL: srv.URL}}, testSets{}, WithBackoff(time.Hour, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx, 1)
+
+	w.Send(context.Background(), NewEvent(EventStatusReceived, "ID1"), NewEvent(EventStatusReceived, "ID2"))
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer closeCancel()
+	if err := w.Close(closeCtx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(r.wait(t, 2)), 2; have != want {
+		t.Errorf("events: have: %d, want: %d", have, want)
+	}
+	if have := w.Dropped(); have != 0 {
+		t.Errorf("dropped: have: %d, want: 0", have)
+	}
+
+	// closed webhooks drop events
+	w.Send(context.Background(), NewEvent(EventStatusReceived, "ID3"))
+	if have, want := w.Dropped(), uint64(1); have != want {
+		t.Errorf("dropped: have: %d, want: %d", have, want)
+	}
+}
+
+func TestWebhookDropped(t *testing.T) {
+	w, err := New([]Subscription{{URL: "http://localhost"}}, testSets{}, WithQueueSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not running: the queue is full after the first event
+	w.Send(context.Background(), NewEvent(EventStatusReceived, "ID1"), NewEvent(EventStatusReceived, "ID2"))
+	if have, want := w.Dropped(), uint64(1); have != want {
+		t.Errorf("dropped: have: %d, want: %d", have, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.Close(ctx); err == nil {
+		t.Error("expected error")
+	}
+	if have, want := w.Dropped(), uint64(2); have != want {
+		t.Errorf("dropped: have: %d, want: %d", have, want)
+	}
+}
+
+func TestTrackedNotificationStorer(t *testing.T) {
+	r := &testReceiver{wake: make(chan struct{}, 10)}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	w := newTestWebhook(t, []Subscription{{URL: srv.URL, Events: []string{EventNotificationSent}}}, testSets{})
+	s := NewTrackedNotificationStorer(w)
+	err := s.StoreTrackedNotifications(context.Background(), []storage.TrackedNotification{
+		{EnrollmentID: "ID1", CommandUUID: "UUID1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := r.wait(t, 1)
+	if have, want := events[0].event.CommandUUID, "UUID1"; have != want {
+		t.Errorf("command UUID: have: %v, want: %v", have, want)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}